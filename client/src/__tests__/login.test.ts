@@ -0,0 +1,109 @@
+import apiClient from '../api/Api';
+import {
+    getOIDCStatus,
+    getOIDCStatusSuccess,
+    processLogin,
+    processLoginFailure,
+    processLoginOTPRequired,
+    processLoginRequest,
+    processOIDCOTP,
+} from '../actions/login';
+import { addErrorToast } from '../actions/toasts';
+import reducer from '../reducers/login';
+import { HTTP_STATUS } from '../helpers/constants';
+
+jest.mock('../api/Api', () => ({
+    __esModule: true,
+    default: { login: jest.fn(), loginOIDCOTP: jest.fn(), getOIDCStatus: jest.fn() },
+}));
+
+const mockedLogin = apiClient.login as jest.Mock;
+const mockedLoginOIDCOTP = apiClient.loginOIDCOTP as jest.Mock;
+const mockedGetOIDCStatus = apiClient.getOIDCStatus as jest.Mock;
+
+const newResponseError = (status: number) => Object.assign(new Error(`login | error | ${status}`), { status });
+
+describe('processLogin', () => {
+    afterEach(() => {
+        mockedLogin.mockReset();
+    });
+
+    test('asks for the code', async () => {
+        mockedLogin.mockRejectedValue(newResponseError(HTTP_STATUS.UNAUTHORIZED));
+        const dispatch = jest.fn();
+
+        await processLogin({ name: 'name', password: 'password' })(dispatch);
+
+        expect(dispatch.mock.calls).toStrictEqual([[processLoginRequest()], [processLoginOTPRequired()]]);
+
+        const state = reducer(undefined, processLoginOTPRequired());
+        expect(state.login.isOTPRequired).toBe(true);
+        expect(state.login.processingLogin).toBe(false);
+    });
+
+    test('reports an invalid code', async () => {
+        const error = newResponseError(403);
+        mockedLogin.mockRejectedValue(error);
+        const dispatch = jest.fn();
+
+        const values = { name: 'name', password: 'password', otp: '123456' };
+        await processLogin(values)(dispatch);
+
+        expect(mockedLogin).toHaveBeenCalledWith(values);
+        expect(dispatch.mock.calls).toStrictEqual([
+            [processLoginRequest()],
+            [addErrorToast({ error })],
+            [processLoginFailure()],
+        ]);
+    });
+
+    test('does not ask for the code again', async () => {
+        const error = newResponseError(HTTP_STATUS.UNAUTHORIZED);
+        mockedLogin.mockRejectedValue(error);
+        const dispatch = jest.fn();
+
+        await processLogin({ name: 'name', password: 'password', otp: '000000' })(dispatch);
+
+        expect(dispatch).toHaveBeenCalledWith(addErrorToast({ error }));
+        expect(dispatch).not.toHaveBeenCalledWith(processLoginOTPRequired());
+    });
+});
+
+describe('processOIDCOTP', () => {
+    afterEach(() => {
+        mockedLoginOIDCOTP.mockReset();
+    });
+
+    test('reports an invalid code', async () => {
+        const error = newResponseError(403);
+        mockedLoginOIDCOTP.mockRejectedValue(error);
+        const dispatch = jest.fn();
+
+        const values = { ticket: 'ticket', otp: '123456' };
+        await processOIDCOTP(values)(dispatch);
+
+        expect(mockedLoginOIDCOTP).toHaveBeenCalledWith(values);
+        expect(dispatch.mock.calls).toStrictEqual([
+            [processLoginRequest()],
+            [addErrorToast({ error })],
+            [processLoginFailure()],
+        ]);
+    });
+});
+
+describe('getOIDCStatus', () => {
+    afterEach(() => {
+        mockedGetOIDCStatus.mockReset();
+    });
+
+    test('enabled', async () => {
+        mockedGetOIDCStatus.mockResolvedValue({ enabled: true });
+        const dispatch = jest.fn();
+
+        await getOIDCStatus()(dispatch);
+
+        const action = getOIDCStatusSuccess({ isOIDCEnabled: true });
+        expect(dispatch.mock.calls).toStrictEqual([[action]]);
+        expect(reducer(undefined, action).login.isOIDCEnabled).toBe(true);
+    });
+});
@@ -22,6 +22,8 @@ import {
     FORM_NAME,
     MANUAL_UPDATE_LINK,
     DISABLE_PROTECTION_TIMINGS,
+    OVERRIDE_ACTIONS,
+    TEMPORARY_OVERRIDE_TTL,
 } from '../helpers/constants';
 import { areEqualVersions } from '../helpers/version';
 import { getTlsStatus } from './encryption';
@@ -763,3 +765,23 @@ export const toggleBlockingForClient = (type: any, domain: any, client: any) =>
 
     return toggleBlocking(type, domain, baseRule, baseUnblocking);
 };
+
+export const addTemporaryOverrideRequest = createAction('ADD_TEMPORARY_OVERRIDE_REQUEST');
+export const addTemporaryOverrideFailure = createAction('ADD_TEMPORARY_OVERRIDE_FAILURE');
+export const addTemporaryOverrideSuccess = createAction('ADD_TEMPORARY_OVERRIDE_SUCCESS');
+
+export const addTemporaryOverride = (type: string, domain: string) => async (dispatch: any) => {
+    dispatch(addTemporaryOverrideRequest());
+    try {
+        const override = await apiClient.addFilteringOverride({
+            domain,
+            action: type === BLOCK_ACTIONS.BLOCK ? OVERRIDE_ACTIONS.BLOCK : OVERRIDE_ACTIONS.ALLOW,
+            ttl: TEMPORARY_OVERRIDE_TTL,
+        });
+        dispatch(addTemporaryOverrideSuccess(override));
+        dispatch(addSuccessToast(i18next.t('override_added_toast', { domain })));
+    } catch (error) {
+        dispatch(addErrorToast({ error }));
+        dispatch(addTemporaryOverrideFailure());
+    }
+};
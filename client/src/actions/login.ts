@@ -2,22 +2,57 @@ import { createAction } from 'redux-actions';
 
 import apiClient from '../api/Api';
 import { addErrorToast } from './toasts';
-import { HTML_PAGES } from '../helpers/constants';
+import { HTML_PAGES, HTTP_STATUS } from '../helpers/constants';
 
 export const processLoginRequest = createAction('PROCESS_LOGIN_REQUEST');
 export const processLoginFailure = createAction('PROCESS_LOGIN_FAILURE');
 export const processLoginSuccess = createAction('PROCESS_LOGIN_SUCCESS');
+export const processLoginOTPRequired = createAction('PROCESS_LOGIN_OTP_REQUIRED');
+
+const redirectToDashboard = () => {
+    const dashboardUrl = window.location.origin + window.location.pathname.replace(HTML_PAGES.LOGIN, HTML_PAGES.MAIN);
+    window.location.replace(dashboardUrl);
+};
 
 export const processLogin = (values: any) => async (dispatch: any) => {
     dispatch(processLoginRequest());
     try {
         await apiClient.login(values);
-        const dashboardUrl =
-            window.location.origin + window.location.pathname.replace(HTML_PAGES.LOGIN, HTML_PAGES.MAIN);
-        window.location.replace(dashboardUrl);
+        redirectToDashboard();
+        dispatch(processLoginSuccess());
+    } catch (error) {
+        // The user has the two-factor authentication enabled, so ask for the
+        // code instead of showing an error.
+        if (error.status === HTTP_STATUS.UNAUTHORIZED && !values.otp) {
+            dispatch(processLoginOTPRequired());
+            return;
+        }
+
+        dispatch(addErrorToast({ error }));
+        dispatch(processLoginFailure());
+    }
+};
+
+export const processOIDCOTP = (values: { ticket: string; otp: string }) => async (dispatch: any) => {
+    dispatch(processLoginRequest());
+    try {
+        await apiClient.loginOIDCOTP(values);
+        redirectToDashboard();
         dispatch(processLoginSuccess());
     } catch (error) {
         dispatch(addErrorToast({ error }));
         dispatch(processLoginFailure());
     }
 };
+
+export const getOIDCStatusSuccess = createAction('GET_OIDC_STATUS_SUCCESS');
+
+export const getOIDCStatus = () => async (dispatch: any) => {
+    try {
+        const { enabled } = await apiClient.getOIDCStatus();
+        dispatch(getOIDCStatusSuccess({ isOIDCEnabled: enabled }));
+    } catch (error) {
+        // Don't show the single sign-on button.
+        console.error(`Error getting the oidc status: ${error.message}`);
+    }
+};
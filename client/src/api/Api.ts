@@ -39,7 +39,12 @@ class Api {
                     return false;
                 }
 
-                throw new Error(`${errorPath} | ${error.response.data} | ${error.response.status}`);
+                const responseError: Error & { status?: number } = new Error(
+                    `${errorPath} | ${error.response.data} | ${error.response.status}`,
+                );
+                responseError.status = error.response.status;
+
+                throw responseError;
             }
 
             throw new Error(`${errorPath} | ${error.message || error}`);
@@ -100,6 +105,8 @@ class Api {
 
     FILTERING_CHECK_HOST = { path: 'filtering/check_host', method: 'GET' };
 
+    FILTERING_ADD_OVERRIDE = { path: 'filtering/overrides/add', method: 'POST' };
+
     getFilteringStatus() {
         const { path, method } = this.FILTERING_STATUS;
 
@@ -164,6 +171,15 @@ class Api {
         return this.makeRequest(url, method);
     }
 
+    addFilteringOverride(config: any) {
+        const { path, method } = this.FILTERING_ADD_OVERRIDE;
+        const parameters = {
+            data: config,
+        };
+
+        return this.makeRequest(path, method, parameters);
+    }
+
     // Parental
     PARENTAL_STATUS = { path: 'parental/status', method: 'GET' };
 
@@ -621,6 +637,10 @@ class Api {
     // Login
     LOGIN = { path: 'login', method: 'POST' };
 
+    LOGIN_OIDC_STATUS = { path: 'login/oidc/status', method: 'GET' };
+
+    LOGIN_OIDC_OTP = { path: 'login/oidc/otp', method: 'POST' };
+
     login(data: any) {
         const { path, method } = this.LOGIN;
         const config = {
@@ -629,6 +649,20 @@ class Api {
         return this.makeRequest(path, method, config);
     }
 
+    getOIDCStatus() {
+        const { path, method } = this.LOGIN_OIDC_STATUS;
+
+        return this.makeRequest(path, method);
+    }
+
+    loginOIDCOTP(data: any) {
+        const { path, method } = this.LOGIN_OIDC_OTP;
+        const config = {
+            data,
+        };
+        return this.makeRequest(path, method, config);
+    }
+
     // Profile
     GET_PROFILE = { path: 'profile', method: 'GET' };
 
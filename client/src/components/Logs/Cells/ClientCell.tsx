@@ -9,7 +9,7 @@ import { Link, useHistory } from 'react-router-dom';
 import { checkFiltered, getBlockingClientName } from '../../../helpers/helpers';
 import { BLOCK_ACTIONS } from '../../../helpers/constants';
 
-import { toggleBlocking, toggleBlockingForClient } from '../../../actions';
+import { addTemporaryOverride, toggleBlocking, toggleBlockingForClient } from '../../../actions';
 
 import IconTooltip from './IconTooltip';
 
@@ -102,6 +102,7 @@ const ClientCell = ({ client, client_id, client_info, domain, reason }: ClientCe
         );
 
         const blockingForClientKey = isFiltered ? 'unblock_for_this_client_only' : 'block_for_this_client_only';
+        const temporaryBlockingKey = isFiltered ? 'unblock_for_hour' : 'block_for_hour';
         const clientNameBlockingFor = getBlockingClientName(clients, client);
 
         const onClick = async () => {
@@ -116,6 +117,13 @@ const ClientCell = ({ client, client_id, client_info, domain, reason }: ClientCe
                 onClick,
                 className: isFiltered ? 'bg--green' : 'bg--danger',
             },
+            {
+                name: temporaryBlockingKey,
+                onClick: async () => {
+                    await dispatch(addTemporaryOverride(buttonType, domain));
+                    setOptionsOpened(false);
+                },
+            },
             {
                 name: blockingForClientKey,
                 onClick: () => {
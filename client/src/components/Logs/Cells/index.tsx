@@ -25,7 +25,7 @@ import {
 } from '../../../helpers/constants';
 import { getSourceData } from '../../../helpers/trackers/trackers';
 
-import { toggleBlocking, toggleBlockingForClient } from '../../../actions';
+import { addTemporaryOverride, toggleBlocking, toggleBlockingForClient } from '../../../actions';
 
 import DateCell from './DateCell';
 
@@ -176,12 +176,17 @@ const Row = memo(
             );
 
             const blockingForClientKey = isFiltered ? 'unblock_for_this_client_only' : 'block_for_this_client_only';
+            const temporaryBlockingKey = isFiltered ? 'unblock_for_hour' : 'block_for_hour';
             const clientNameBlockingFor = getBlockingClientName(clients, client);
 
             const onBlockingForClientClick = () => {
                 dispatch(toggleBlockingForClient(buttonType, domain, clientNameBlockingFor));
             };
 
+            const onTemporaryBlockingClick = () => {
+                dispatch(addTemporaryOverride(buttonType, domain));
+            };
+
             const onBlockingClientClick = async () => {
                 if (window.confirm(confirmMessage)) {
                     await dispatch(
@@ -217,6 +222,14 @@ const Row = memo(
                 </button>
             );
 
+            const temporaryBlockButton = (
+                <button
+                    className="text-center font-weight-bold py-1 button-action--arrow-option"
+                    onClick={onTemporaryBlockingClick}>
+                    {t(temporaryBlockingKey)}
+                </button>
+            );
+
             const blockClientButton = (
                 <button
                     className="text-center font-weight-bold py-1 button-action--arrow-option"
@@ -268,6 +281,7 @@ const Row = memo(
                 validated_with_dnssec: dnssec_enabled ? Boolean(answer_dnssec) : false,
                 original_response: originalResponse?.join('\n'),
                 [BUTTON_PREFIX + buttonType]: blockButton,
+                [BUTTON_PREFIX + temporaryBlockingKey]: temporaryBlockButton,
                 [BUTTON_PREFIX + blockingForClientKey]: blockForClientButton,
                 [BUTTON_PREFIX + blockingClientKey]: blockClientButton,
             };
@@ -43,6 +43,10 @@ export const HTML_PAGES = {
     MAIN: '/',
 };
 
+export const HTTP_STATUS = {
+    UNAUTHORIZED: 401,
+};
+
 export const STATS_NAMES = {
     avg_processing_time: 'average_processing_time',
     blocked_filtering: 'Blocked by filters',
@@ -230,6 +234,7 @@ export const FILTERED_STATUS = {
     FILTERED_SAFE_SEARCH: 'FilteredSafeSearch',
     FILTERED_SAFE_BROWSING: 'FilteredSafeBrowsing',
     FILTERED_PARENTAL: 'FilteredParental',
+    RATELIMITED: 'Ratelimited',
 };
 
 export const RESPONSE_FILTER = {
@@ -273,6 +278,10 @@ export const RESPONSE_FILTER = {
         QUERY: 'safe_search',
         LABEL: 'safe_search',
     },
+    RATELIMITED: {
+        QUERY: 'ratelimited',
+        LABEL: 'ratelimited',
+    },
 };
 
 export const RESPONSE_FILTER_QUERIES = Object.values(RESPONSE_FILTER).reduce(
@@ -339,6 +348,10 @@ export const FILTERED_STATUS_TO_META_MAP = {
         LABEL: RESPONSE_FILTER.BLOCKED_ADULT_WEBSITES.LABEL,
         COLOR: QUERY_STATUS_COLORS.YELLOW,
     },
+    [FILTERED_STATUS.RATELIMITED]: {
+        LABEL: RESPONSE_FILTER.RATELIMITED.LABEL,
+        COLOR: QUERY_STATUS_COLORS.WHITE,
+    },
 };
 
 export const DEFAULT_TIME_FORMAT = 'HH:mm:ss';
@@ -373,6 +386,7 @@ export const SPECIAL_FILTER_ID = {
     PARENTAL: -3,
     SAFE_BROWSING: -4,
     SAFE_SEARCH: -5,
+    OVERRIDE: -6,
 };
 
 export const BLOCK_ACTIONS = {
@@ -380,6 +394,13 @@ export const BLOCK_ACTIONS = {
     UNBLOCK: 'unblock',
 };
 
+export const OVERRIDE_ACTIONS = {
+    ALLOW: 'allow',
+    BLOCK: 'block',
+};
+
+export const TEMPORARY_OVERRIDE_TTL = 60 * 60 * 1000;
+
 export const SCHEME_TO_PROTOCOL_MAP = {
     dnscrypt: 'dnscrypt',
     doh: 'dns_over_https',
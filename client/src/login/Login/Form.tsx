@@ -13,51 +13,79 @@ interface LoginFormProps {
     submitting: boolean;
     invalid: boolean;
     processing: boolean;
+    isOTPRequired: boolean;
+    isOIDCTicket: boolean;
     t: (...args: unknown[]) => string;
 }
 
 const Form = (props: LoginFormProps) => {
-    const { handleSubmit, processing, invalid, t } = props;
+    const { handleSubmit, processing, isOTPRequired, isOIDCTicket, invalid, t } = props;
 
     return (
         <form onSubmit={handleSubmit} className="card">
             <div className="card-body p-6">
-                <div className="form__group form__group--settings">
-                    <label className="form__label" htmlFor="username">
-                        <Trans>username_label</Trans>
-                    </label>
+                {!isOIDCTicket && (
+                    <>
+                        <div className="form__group form__group--settings">
+                            <label className="form__label" htmlFor="username">
+                                <Trans>username_label</Trans>
+                            </label>
 
-                    <Field
-                        id="username1"
-                        name="username"
-                        type="text"
-                        className="form-control"
-                        component={renderInputField}
-                        placeholder={t('username_placeholder')}
-                        autoComplete="username"
-                        autocapitalize="none"
-                        disabled={processing}
-                        validate={[validateRequiredValue]}
-                    />
-                </div>
+                            <Field
+                                id="username1"
+                                name="username"
+                                type="text"
+                                className="form-control"
+                                component={renderInputField}
+                                placeholder={t('username_placeholder')}
+                                autoComplete="username"
+                                autocapitalize="none"
+                                disabled={processing}
+                                validate={[validateRequiredValue]}
+                            />
+                        </div>
 
-                <div className="form__group form__group--settings">
-                    <label className="form__label" htmlFor="password">
-                        <Trans>password_label</Trans>
-                    </label>
+                        <div className="form__group form__group--settings">
+                            <label className="form__label" htmlFor="password">
+                                <Trans>password_label</Trans>
+                            </label>
 
-                    <Field
-                        id="password"
-                        name="password"
-                        type="password"
-                        className="form-control"
-                        component={renderInputField}
-                        placeholder={t('password_placeholder')}
-                        autoComplete="current-password"
-                        disabled={processing}
-                        validate={[validateRequiredValue]}
-                    />
-                </div>
+                            <Field
+                                id="password"
+                                name="password"
+                                type="password"
+                                className="form-control"
+                                component={renderInputField}
+                                placeholder={t('password_placeholder')}
+                                autoComplete="current-password"
+                                disabled={processing}
+                                validate={[validateRequiredValue]}
+                            />
+                        </div>
+                    </>
+                )}
+
+                {(isOTPRequired || isOIDCTicket) && (
+                    <div className="form__group form__group--settings">
+                        <label className="form__label" htmlFor="otp">
+                            <Trans>otp_label</Trans>
+                        </label>
+
+                        <Field
+                            id="otp"
+                            name="otp"
+                            type="text"
+                            className="form-control"
+                            component={renderInputField}
+                            placeholder={t('otp_placeholder')}
+                            autoComplete="one-time-code"
+                            autocapitalize="none"
+                            autoFocus
+                            disabled={processing}
+                            validate={[validateRequiredValue]}
+                        />
+                    </div>
+                )}
 
                 <div className="form-footer">
                     <button type="submit" className="btn btn-success btn-block" disabled={processing || invalid}>
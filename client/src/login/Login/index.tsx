@@ -21,8 +21,12 @@ import '../../components/ui/Tabler.css';
 type LoginProps = {
     login: {
         processingLogin: boolean;
+        isOTPRequired: boolean;
+        isOIDCEnabled: boolean;
     };
-    processLogin: (args: { name: string; password: string }) => unknown;
+    processLogin: (args: { name: string; password: string; otp?: string }) => unknown;
+    processOIDCOTP: (args: { ticket: string; otp: string }) => unknown;
+    getOIDCStatus: () => unknown;
 };
 
 type LoginState = {
@@ -34,8 +38,22 @@ class Login extends Component<LoginProps, LoginState> {
         isForgotPasswordVisible: false,
     };
 
-    handleSubmit = ({ username: name, password }: { username: string; password: string }) => {
-        this.props.processLogin({ name, password });
+    // oidcTicket is set by the single sign-on callback when the user still has
+    // to enter the two-factor authentication code.
+    oidcTicket = new URLSearchParams(window.location.search).get('oidc_ticket');
+
+    componentDidMount() {
+        this.props.getOIDCStatus();
+    }
+
+    handleSubmit = ({ username: name, password, otp }: { username: string; password: string; otp?: string }) => {
+        if (this.oidcTicket) {
+            this.props.processOIDCOTP({ ticket: this.oidcTicket, otp });
+
+            return;
+        }
+
+        this.props.processLogin({ name, password, otp });
     };
 
     toggleText = () => {
@@ -45,7 +63,7 @@ class Login extends Component<LoginProps, LoginState> {
     };
 
     render() {
-        const { processingLogin } = this.props.login;
+        const { processingLogin, isOTPRequired, isOIDCEnabled } = this.props.login;
         const { isForgotPasswordVisible } = this.state;
 
         return (
@@ -55,7 +73,18 @@ class Login extends Component<LoginProps, LoginState> {
                         <Logo className="h-6 login__logo" />
                     </div>
 
-                    <Form onSubmit={this.handleSubmit} processing={processingLogin} />
+                    <Form
+                        onSubmit={this.handleSubmit}
+                        processing={processingLogin}
+                        isOTPRequired={isOTPRequired}
+                        isOIDCTicket={!!this.oidcTicket}
+                    />
+
+                    {isOIDCEnabled && !this.oidcTicket && (
+                        <a href="control/login/oidc" className="btn btn-outline-primary btn-block">
+                            <Trans>sign_in_sso</Trans>
+                        </a>
+                    )}
 
                     <div className="login__info">
                         <button type="button" className="btn btn-link login__link" onClick={this.toggleText}>
@@ -17,6 +17,15 @@ const login = handleActions(
             ...state,
             processingLogin: false,
         }),
+        [actions.processLoginOTPRequired.toString()]: (state: any) => ({
+            ...state,
+            processingLogin: false,
+            isOTPRequired: true,
+        }),
+        [actions.getOIDCStatusSuccess.toString()]: (state, { payload }: any) => ({
+            ...state,
+            ...payload,
+        }),
         [actions.processLoginSuccess.toString()]: (state, { payload }: any) => ({
             ...state,
             ...payload,
@@ -25,6 +34,8 @@ const login = handleActions(
     },
     {
         processingLogin: false,
+        isOTPRequired: false,
+        isOIDCEnabled: false,
         email: '',
         password: '',
     },
@@ -0,0 +1,193 @@
+// Package aghproto contains a minimal implementation of the Protocol Buffers
+// wire format, which is enough to encode the messages of the fixed schemas used
+// by AdGuard Home without generated code.
+//
+// See https://protobuf.dev/programming-guides/encoding.
+package aghproto
+
+import (
+	"encoding/binary"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// WireType is the type of a field value in the wire format.
+type WireType uint8
+
+// Wire types.
+const (
+	WireTypeVarint  WireType = 0
+	WireTypeFixed64 WireType = 1
+	WireTypeBytes   WireType = 2
+	WireTypeFixed32 WireType = 5
+)
+
+// ErrTruncated is returned when the data ends in the middle of a field.
+const ErrTruncated errors.Error = "truncated data"
+
+// AppendVarint appends v encoded as a base 128 varint to b.
+func AppendVarint(b []byte, v uint64) (res []byte) {
+	return binary.AppendUvarint(b, v)
+}
+
+// AppendTag appends the tag of the field with the given number and wire type
+// to b.
+func AppendTag(b []byte, num uint32, typ WireType) (res []byte) {
+	return AppendVarint(b, uint64(num)<<3|uint64(typ))
+}
+
+// AppendUint appends the varint field with the given number to b.
+func AppendUint(b []byte, num uint32, v uint64) (res []byte) {
+	b = AppendTag(b, num, WireTypeVarint)
+
+	return AppendVarint(b, v)
+}
+
+// AppendInt appends the varint field of type int64 with the given number to b.
+// Negative values always take ten bytes.
+func AppendInt(b []byte, num uint32, v int64) (res []byte) {
+	return AppendUint(b, num, uint64(v))
+}
+
+// AppendBool appends the boolean field with the given number to b.
+func AppendBool(b []byte, num uint32, v bool) (res []byte) {
+	var u uint64
+	if v {
+		u = 1
+	}
+
+	return AppendUint(b, num, u)
+}
+
+// AppendFixed32 appends the fixed32 field with the given number to b.
+func AppendFixed32(b []byte, num uint32, v uint32) (res []byte) {
+	b = AppendTag(b, num, WireTypeFixed32)
+
+	return binary.LittleEndian.AppendUint32(b, v)
+}
+
+// AppendFixed64 appends the fixed64 field with the given number to b.
+func AppendFixed64(b []byte, num uint32, v uint64) (res []byte) {
+	b = AppendTag(b, num, WireTypeFixed64)
+
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+// AppendBytes appends the length-delimited field with the given number to b.
+// It's also used for embedded messages.
+func AppendBytes(b []byte, num uint32, v []byte) (res []byte) {
+	b = AppendTag(b, num, WireTypeBytes)
+	b = AppendVarint(b, uint64(len(v)))
+
+	return append(b, v...)
+}
+
+// AppendString appends the string field with the given number to b.
+func AppendString(b []byte, num uint32, s string) (res []byte) {
+	b = AppendTag(b, num, WireTypeBytes)
+	b = AppendVarint(b, uint64(len(s)))
+
+	return append(b, s...)
+}
+
+// ConsumeVarint parses a varint from the beginning of b and returns it along
+// with the number of bytes read.
+func ConsumeVarint(b []byte) (v uint64, n int, err error) {
+	v, n = binary.Uvarint(b)
+	if n == 0 {
+		return 0, 0, ErrTruncated
+	} else if n < 0 {
+		return 0, 0, errors.Error("varint overflow")
+	}
+
+	return v, n, nil
+}
+
+// Field is a single parsed field of a message.
+type Field struct {
+	// Bytes is the value of a length-delimited field.  It points into the
+	// parsed data.
+	Bytes []byte
+
+	// Uint is the value of a varint or a fixed-size field.
+	Uint uint64
+
+	// Num is the number of the field.
+	Num uint32
+
+	// Type is the wire type of the field.
+	Type WireType
+}
+
+// ConsumeField parses a field from the beginning of b and returns it along with
+// the number of bytes read.
+func ConsumeField(b []byte) (f *Field, n int, err error) {
+	tag, n, err := ConsumeVarint(b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f = &Field{
+		Num:  uint32(tag >> 3),
+		Type: WireType(tag & 0x7),
+	}
+
+	b = b[n:]
+	switch f.Type {
+	case WireTypeVarint:
+		var l int
+		f.Uint, l, err = ConsumeVarint(b)
+		n += l
+	case WireTypeFixed32:
+		if len(b) < 4 {
+			return nil, 0, ErrTruncated
+		}
+
+		f.Uint = uint64(binary.LittleEndian.Uint32(b))
+		n += 4
+	case WireTypeFixed64:
+		if len(b) < 8 {
+			return nil, 0, ErrTruncated
+		}
+
+		f.Uint = binary.LittleEndian.Uint64(b)
+		n += 8
+	case WireTypeBytes:
+		var size uint64
+		var l int
+		size, l, err = ConsumeVarint(b)
+		if err != nil {
+			break
+		} else if size > uint64(len(b)-l) {
+			return nil, 0, ErrTruncated
+		}
+
+		f.Bytes = b[l : l+int(size)]
+		n += l + int(size)
+	default:
+		return nil, 0, errors.Error("unsupported wire type")
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return f, n, nil
+}
+
+// ParseMessage parses all fields of the message in b.
+func ParseMessage(b []byte) (fields []*Field, err error) {
+	for len(b) > 0 {
+		var f *Field
+		var n int
+		f, n, err = ConsumeField(b)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, f)
+		b = b[n:]
+	}
+
+	return fields, nil
+}
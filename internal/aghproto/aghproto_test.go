@@ -0,0 +1,38 @@
+package aghproto_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghproto"
+)
+
+func TestAppend(t *testing.T) {
+	// See https://protobuf.dev/programming-guides/encoding/#simple.
+	assert.Equal(t, []byte{0x08, 0x96, 0x01}, aghproto.AppendUint(nil, 1, 150))
+	assert.Equal(t, []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}, aghproto.AppendString(nil, 2, "testing"))
+	assert.Equal(t, []byte{0x18, 0x01}, aghproto.AppendBool(nil, 3, true))
+}
+
+func TestParseMessage(t *testing.T) {
+	var b []byte
+	b = aghproto.AppendUint(b, 1, 150)
+	b = aghproto.AppendString(b, 2, "testing")
+	b = aghproto.AppendFixed32(b, 3, 42)
+	b = aghproto.AppendFixed64(b, 4, 43)
+	b = aghproto.AppendInt(b, 5, -1)
+
+	fields, err := aghproto.ParseMessage(b)
+	require.NoError(t, err)
+	require.Len(t, fields, 5)
+
+	assert.Equal(t, &aghproto.Field{Num: 1, Type: aghproto.WireTypeVarint, Uint: 150}, fields[0])
+	assert.Equal(t, &aghproto.Field{Num: 2, Type: aghproto.WireTypeBytes, Bytes: []byte("testing")}, fields[1])
+	assert.Equal(t, &aghproto.Field{Num: 3, Type: aghproto.WireTypeFixed32, Uint: 42}, fields[2])
+	assert.Equal(t, &aghproto.Field{Num: 4, Type: aghproto.WireTypeFixed64, Uint: 43}, fields[3])
+	assert.Equal(t, int64(-1), int64(fields[4].Uint))
+
+	_, err = aghproto.ParseMessage(b[:len(b)-1])
+	assert.ErrorIs(t, err, aghproto.ErrTruncated)
+}
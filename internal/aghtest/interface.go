@@ -11,6 +11,7 @@ import (
 	"github.com/miekg/dns"
 	"github.com/tukimoto/AdGuardHome/internal/aghos"
 	"github.com/tukimoto/AdGuardHome/internal/next/agh"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
 	"github.com/tukimoto/AdGuardHome/internal/rdns"
 	"github.com/tukimoto/AdGuardHome/internal/whois"
 )
@@ -125,6 +126,12 @@ type ClientsContainer struct {
 		id string,
 		boot upstream.Resolver,
 	) (conf *proxy.CustomUpstreamConfig, err error)
+
+	OnCustomCacheByID func(id string) (cacheID string, size int, ok bool)
+
+	OnRatelimitByID func(
+		id string,
+	) (name string, tags []string, p *ratelimit.Policy, ok bool)
 }
 
 // UpstreamConfigByID implements the [dnsforward.ClientsContainer] interface
@@ -136,6 +143,20 @@ func (c *ClientsContainer) UpstreamConfigByID(
 	return c.OnUpstreamConfigByID(id, boot)
 }
 
+// CustomCacheByID implements the [dnsforward.ClientsContainer] interface for
+// *ClientsContainer.
+func (c *ClientsContainer) CustomCacheByID(id string) (cacheID string, size int, ok bool) {
+	return c.OnCustomCacheByID(id)
+}
+
+// RatelimitByID implements the [dnsforward.ClientsContainer] interface for
+// *ClientsContainer.
+func (c *ClientsContainer) RatelimitByID(
+	id string,
+) (name string, tags []string, p *ratelimit.Policy, ok bool) {
+	return c.OnRatelimitByID(id)
+}
+
 // Package filtering
 
 // Resolver is a fake [filtering.Resolver] implementation for tests.
@@ -0,0 +1,301 @@
+// Package audit contains the append-only journal of the configuration changes
+// made through the HTTP API.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+)
+
+// Entry is a single record of the audit journal.
+type Entry struct {
+	// Time is the time when the request has been handled.
+	Time time.Time `json:"time"`
+
+	// RemoteIP is the IP address of the client making the request.
+	RemoteIP netip.Addr `json:"remote_ip"`
+
+	// User is the name of the user making the request.  It's empty if the
+	// authentication isn't required.
+	User string `json:"user"`
+
+	// Method is the HTTP method of the request.
+	Method string `json:"method"`
+
+	// Endpoint is the path of the HTTP API handler.
+	Endpoint string `json:"endpoint"`
+
+	// Changes are the changes of the configuration sections made by the
+	// request.  It's empty if the request hasn't changed the configuration.
+	Changes []*Change `json:"changes"`
+
+	// Status is the HTTP status code of the response.
+	Status int `json:"status"`
+
+	// ID is the sequence number of the entry, which is unique within the
+	// journal.  It's zero for the entries written by the previous versions.
+	ID uint64 `json:"id"`
+}
+
+// maxEntrySize is the maximum size of a single encoded entry.  Entries may be
+// quite large, since the changes of the lists, such as user rules, are recorded
+// in full.
+const maxEntrySize = 16 * 1024 * 1024
+
+// Config is the configuration of the audit journal.
+type Config struct {
+	// HTTPRegister registers the HTTP handlers.  It may be nil.
+	HTTPRegister aghhttp.RegisterFunc
+
+	// Filename is the path to the journal file.  The rotated file has the
+	// ".1" suffix.
+	Filename string
+
+	// RotationIvl is the age of the oldest entry after which the journal file
+	// is rotated.  It must be positive.
+	RotationIvl time.Duration
+}
+
+// Journal is the append-only audit journal of the configuration changes.
+type Journal struct {
+	// mu protects the journal files.
+	mu *sync.Mutex
+
+	httpRegister aghhttp.RegisterFunc
+	done         chan struct{}
+
+	filename    string
+	rotationIvl time.Duration
+
+	// lastID is the ID of the latest entry.  It's protected by mu.
+	lastID uint64
+}
+
+// New creates a new audit journal.  conf must not be nil.
+func New(conf *Config) (j *Journal, err error) {
+	if conf.Filename == "" {
+		return nil, errors.Error("empty filename")
+	} else if conf.RotationIvl <= 0 {
+		return nil, fmt.Errorf("rotation interval: %w", errors.ErrNotPositive)
+	}
+
+	j = &Journal{
+		mu:           &sync.Mutex{},
+		httpRegister: conf.HTTPRegister,
+		done:         make(chan struct{}),
+		filename:     conf.Filename,
+		rotationIvl:  conf.RotationIvl,
+	}
+
+	for _, fn := range []string{j.filename, j.filename + ".1"} {
+		var entries []*Entry
+		entries, err = readEntries(fn)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			j.lastID = max(j.lastID, e.ID)
+		}
+	}
+
+	return j, nil
+}
+
+// Start registers the HTTP handlers and starts the rotation of the journal
+// files.
+func (j *Journal) Start() {
+	if j.httpRegister != nil {
+		j.initWeb()
+	}
+
+	go j.periodicRotate()
+}
+
+// Close stops the rotation of the journal files.
+func (j *Journal) Close() {
+	close(j.done)
+}
+
+// Add sets the ID of e and appends it to the journal.
+func (j *Journal) Add(e *Entry) (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e.ID = j.lastID + 1
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding entry: %w", err)
+	}
+
+	b = append(b, '\n')
+
+	f, err := os.OpenFile(j.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	_, err = f.Write(b)
+	if err != nil {
+		return fmt.Errorf("writing entry: %w", err)
+	}
+
+	j.lastID = e.ID
+
+	return nil
+}
+
+// cursor is the position in the journal used for pagination.  The entries
+// sharing the same time are ordered by their IDs.
+type cursor struct {
+	time time.Time
+	id   uint64
+}
+
+// isAfter returns true if e is at or after c, so it has been returned on the
+// previous pages.  If the ID of c is zero, only the time is compared.
+func (c *cursor) isAfter(e *Entry) (ok bool) {
+	if e.Time.Before(c.time) {
+		return false
+	} else if e.Time.Equal(c.time) && c.id != 0 {
+		return e.ID >= c.id
+	}
+
+	return true
+}
+
+// search returns up to limit entries older than olderThan, newest first.  If
+// olderThan is nil, the newest entries are returned.
+func (j *Journal) search(olderThan *cursor, limit int) (entries []*Entry, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, fn := range []string{j.filename, j.filename + ".1"} {
+		var fileEntries []*Entry
+		fileEntries, err = readEntries(fn)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(fileEntries) - 1; i >= 0; i-- {
+			e := fileEntries[i]
+			if olderThan != nil && olderThan.isAfter(e) {
+				continue
+			}
+
+			entries = append(entries, e)
+			if len(entries) >= limit {
+				return entries, nil
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// readEntries reads all entries from the journal file.  Malformed lines are
+// skipped.
+func readEntries(fn string) (entries []*Entry, err error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, len(data)+1)
+	for s.Scan() {
+		e := &Entry{}
+		decErr := json.Unmarshal(s.Bytes(), e)
+		if decErr != nil {
+			log.Debug("audit: skipping malformed line in %q: %s", fn, decErr)
+
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, s.Err()
+}
+
+// periodicRotate checks the need for rotating the journal files until the
+// journal is closed.
+func (j *Journal) periodicRotate() {
+	defer log.OnPanic("audit: rotating")
+
+	// rotationCheckIvl is the period of time between checking the need for
+	// rotating the journal files.
+	const rotationCheckIvl = 1 * time.Hour
+
+	ticker := time.NewTicker(rotationCheckIvl)
+	defer ticker.Stop()
+
+	for {
+		err := j.checkAndRotate(time.Now())
+		if err != nil {
+			log.Error("audit: rotating: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-j.done:
+			return
+		}
+	}
+}
+
+// checkAndRotate renames the journal file if its oldest entry is older than
+// the rotation interval.  The previously rotated file is overwritten.
+func (j *Journal) checkAndRotate(now time.Time) (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.Open(j.filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("opening journal: %w", err)
+	}
+
+	first := &Entry{}
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, maxEntrySize)
+	if s.Scan() {
+		err = json.Unmarshal(s.Bytes(), first)
+	}
+
+	err = errors.WithDeferred(err, f.Close())
+	if err != nil {
+		return fmt.Errorf("reading oldest entry: %w", err)
+	}
+
+	if first.Time.IsZero() || first.Time.Add(j.rotationIvl).After(now) {
+		return nil
+	}
+
+	err = os.Rename(j.filename, j.filename+".1")
+	if err != nil {
+		return fmt.Errorf("renaming journal: %w", err)
+	}
+
+	log.Debug("audit: rotated %q", j.filename)
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// newTestJournal returns a new journal in a temporary directory.
+func newTestJournal(t *testing.T) (j *Journal) {
+	t.Helper()
+
+	j, err := New(&Config{
+		Filename:    filepath.Join(t.TempDir(), "audit.json"),
+		RotationIvl: 24 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	return j
+}
+
+func TestJournal_search(t *testing.T) {
+	j := newTestJournal(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		err := j.Add(&Entry{
+			Time:     start.Add(time.Duration(i) * time.Hour),
+			RemoteIP: netip.MustParseAddr("192.0.2.1"),
+			User:     "admin",
+			Method:   "POST",
+			Endpoint: "/control/protection",
+			Status:   200,
+		})
+		require.NoError(t, err)
+	}
+
+	entries, err := j.search(nil, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, start.Add(4*time.Hour), entries[0].Time)
+	assert.Equal(t, start.Add(3*time.Hour), entries[1].Time)
+
+	entries, err = j.search(&cursor{time: entries[1].Time, id: entries[1].ID}, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, start.Add(2*time.Hour), entries[0].Time)
+	assert.Equal(t, start, entries[2].Time)
+
+	t.Run("rotated", func(t *testing.T) {
+		require.NoError(t, j.checkAndRotate(start.Add(time.Hour)))
+
+		entries, err = j.search(nil, 10)
+		require.NoError(t, err)
+		assert.Len(t, entries, 5)
+
+		require.NoError(t, j.checkAndRotate(start.Add(48*time.Hour)))
+		require.NoError(t, j.Add(&Entry{Time: start.Add(48 * time.Hour)}))
+
+		entries, err = j.search(nil, 10)
+		require.NoError(t, err)
+		require.Len(t, entries, 6)
+
+		assert.Equal(t, start.Add(48*time.Hour), entries[0].Time)
+	})
+}
+
+func TestJournal_search_sameTime(t *testing.T) {
+	j := newTestJournal(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for range 5 {
+		require.NoError(t, j.Add(&Entry{Time: now}))
+	}
+
+	entries, err := j.search(nil, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, uint64(5), entries[0].ID)
+	assert.Equal(t, uint64(4), entries[1].ID)
+
+	last := entries[1]
+	entries, err = j.search(&cursor{time: last.Time, id: last.ID}, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, uint64(3), entries[0].ID)
+	assert.Equal(t, uint64(1), entries[2].ID)
+
+	// The IDs continue after reopening the journal.
+	reopened, err := New(&Config{
+		Filename:    j.filename,
+		RotationIvl: j.rotationIvl,
+	})
+	require.NoError(t, err)
+
+	e := &Entry{Time: now}
+	require.NoError(t, reopened.Add(e))
+
+	assert.Equal(t, uint64(6), e.ID)
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]any{
+		"dns": map[string]any{
+			"port":          53,
+			"upstream_dns":  []any{"1.1.1.1"},
+			"ratelimit":     20,
+			"blocking_mode": "default",
+		},
+		"removed": true,
+		"same":    "value",
+	}
+
+	after := map[string]any{
+		"dns": map[string]any{
+			"port":          53,
+			"upstream_dns":  []any{"8.8.8.8"},
+			"ratelimit":     20,
+			"blocking_mode": "default",
+			"cache_size":    1024,
+		},
+		"added": 1,
+		"same":  "value",
+	}
+
+	changes := Diff(before, after, 2)
+	assert.Equal(t, []*Change{{
+		Before:  nil,
+		After:   1,
+		Section: "added",
+	}, {
+		Before:  nil,
+		After:   1024,
+		Section: "dns.cache_size",
+	}, {
+		Before:  []any{"1.1.1.1"},
+		After:   []any{"8.8.8.8"},
+		Section: "dns.upstream_dns",
+	}, {
+		Before:  true,
+		After:   nil,
+		Section: "removed",
+	}}, changes)
+
+	changes = Diff(before, after, 1)
+	require.Len(t, changes, 3)
+
+	assert.Equal(t, "dns", changes[1].Section)
+
+	assert.Empty(t, Diff(before, before, 2))
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Change is a change of a single configuration section.
+type Change struct {
+	// Before is the value of the section before the change.  It's nil if the
+	// section has been added.
+	Before any `json:"before"`
+
+	// After is the value of the section after the change.  It's nil if the
+	// section has been removed.
+	After any `json:"after"`
+
+	// Section is the dot-separated path to the changed section, for example
+	// "dns.upstream_dns".
+	Section string `json:"section"`
+}
+
+// Diff returns the changes between the two configuration snapshots, which are
+// the generic representations of the configuration file, such as the ones
+// decoded from YAML.  The nested objects are compared down to depth levels, so
+// that only the changed sections are returned.  The changes are sorted by
+// section.
+func Diff(before, after map[string]any, depth int) (changes []*Change) {
+	changes = appendDiff(changes, "", before, after, depth)
+	slices.SortFunc(changes, func(a, b *Change) (res int) {
+		return strings.Compare(a.Section, b.Section)
+	})
+
+	return changes
+}
+
+// appendDiff appends the changes between the objects to changes and returns
+// the result.
+func appendDiff(
+	changes []*Change,
+	prefix string,
+	before map[string]any,
+	after map[string]any,
+	depth int,
+) (res []*Change) {
+	for k, b := range before {
+		a, ok := after[k]
+		if !ok {
+			changes = append(changes, &Change{Before: b, Section: prefix + k})
+		} else {
+			changes = appendValueDiff(changes, prefix+k, b, a, depth)
+		}
+	}
+
+	for k, a := range after {
+		if _, ok := before[k]; !ok {
+			changes = append(changes, &Change{After: a, Section: prefix + k})
+		}
+	}
+
+	return changes
+}
+
+// appendValueDiff appends the change of the value of the section to changes,
+// if there is one, and returns the result.
+func appendValueDiff(changes []*Change, section string, before, after any, depth int) (res []*Change) {
+	if reflect.DeepEqual(before, after) {
+		return changes
+	}
+
+	bMap, bOK := before.(map[string]any)
+	aMap, aOK := after.(map[string]any)
+	if bOK && aOK && depth > 1 {
+		return appendDiff(changes, section+".", bMap, aMap, depth-1)
+	}
+
+	return append(changes, &Change{
+		Before:  before,
+		After:   after,
+		Section: section,
+	})
+}
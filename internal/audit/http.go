@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+)
+
+// Pagination limits of the GET /control/audit HTTP API.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// entriesJSON is the response for the GET /control/audit HTTP API.
+type entriesJSON struct {
+	// Oldest is the time of the last entry in Entries.  It should be used as
+	// the older_than parameter of the next page request.  It's empty if there
+	// are no more entries.
+	Oldest string `json:"oldest"`
+
+	// OldestID is the ID of the last entry in Entries.  It should be used as
+	// the older_than_id parameter of the next page request, so that the
+	// entries with the same time aren't skipped.
+	OldestID uint64 `json:"oldest_id,omitempty"`
+
+	Entries []*Entry `json:"entries"`
+}
+
+// initWeb registers the HTTP handlers.
+func (j *Journal) initWeb() {
+	j.httpRegister(http.MethodGet, "/control/audit", j.handleAudit)
+}
+
+// handleAudit is the handler for the GET /control/audit HTTP API.
+func (j *Journal) handleAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var olderThan *cursor
+	if v := q.Get("older_than"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "parsing older_than: %s", err)
+
+			return
+		}
+
+		olderThan = &cursor{time: t}
+		if v = q.Get("older_than_id"); v != "" {
+			olderThan.id, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				aghhttp.Error(r, w, http.StatusBadRequest, "parsing older_than_id: %s", err)
+
+				return
+			}
+		}
+	}
+
+	limit := defaultLimit
+	if v := q.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			aghhttp.Error(r, w, http.StatusBadRequest, "limit must be in range 1..%d", maxLimit)
+
+			return
+		}
+	}
+
+	entries, err := j.search(olderThan, limit)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "searching: %s", err)
+
+		return
+	}
+
+	resp := &entriesJSON{
+		Entries: entries,
+	}
+
+	if len(entries) == limit {
+		last := entries[len(entries)-1]
+		resp.Oldest = last.Time.Format(time.RFC3339Nano)
+		resp.OldestID = last.ID
+	}
+
+	if resp.Entries == nil {
+		resp.Entries = []*Entry{}
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
@@ -0,0 +1,276 @@
+package authzone_test
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghtest"
+	"github.com/tukimoto/AdGuardHome/internal/authzone"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testZone is the zone used in tests.
+const testZone = `$TTL 3600
+@	IN	SOA	ns1 hostmaster 1 7200 3600 1209600 300
+@	IN	NS	ns1
+ns1	IN	A	192.0.2.1
+www	IN	A	192.0.2.2
+www	IN	AAAA	2001:db8::2
+alias	IN	CNAME	www
+outside	IN	CNAME	www.example.com.
+loop1	IN	CNAME	loop2
+loop2	IN	CNAME	loop1
+mail	IN	MX	10 www
+*.dyn	IN	A	192.0.2.3
+a.b.c	IN	TXT	"deep"
+sub	IN	NS	ns.sub
+ns.sub	IN	A	192.0.2.4
+`
+
+// newTestContainer returns a container with the given zone files.
+func newTestContainer(
+	t *testing.T,
+	fsys fs.FS,
+	events chan struct{},
+	confs ...*authzone.ZoneConfig,
+) (c *authzone.Container) {
+	t.Helper()
+
+	c, err := authzone.NewContainer(fsys, &aghtest.FSWatcher{
+		OnStart:  func() (_ error) { panic("not implemented") },
+		OnClose:  func() (err error) { return nil },
+		OnEvents: func() (e <-chan struct{}) { return events },
+		OnAdd:    func(_ string) (err error) { return nil },
+	}, confs)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, c.Close)
+
+	return c
+}
+
+// newReq returns a new request for name and qtype.
+func newReq(name string, qtype uint16) (req *dns.Msg) {
+	return (&dns.Msg{}).SetQuestion(name, qtype)
+}
+
+// rrStrings returns the string representations of rrs.
+func rrStrings(rrs []dns.RR) (ss []string) {
+	for _, rr := range rrs {
+		ss = append(ss, strings.ReplaceAll(rr.String(), "\t", " "))
+	}
+
+	return ss
+}
+
+func TestContainer_Resolve(t *testing.T) {
+	c := newTestContainer(t, fstest.MapFS{
+		"lab.zone": &fstest.MapFile{Data: []byte(testZone)},
+	}, nil, &authzone.ZoneConfig{
+		Origin: "lab.example",
+		File:   "lab.zone",
+	})
+
+	testCases := []struct {
+		name       string
+		qname      string
+		wantAnswer []string
+		wantNs     []string
+		wantExtra  []string
+		qtype      uint16
+		wantRcode  int
+		wantAA     bool
+	}{{
+		name:       "a",
+		qname:      "WWW.lab.example.",
+		qtype:      dns.TypeA,
+		wantAnswer: []string{"www.lab.example. 3600 IN A 192.0.2.2"},
+		wantRcode:  dns.RcodeSuccess,
+		wantAA:     true,
+	}, {
+		name:      "nodata",
+		qname:     "www.lab.example.",
+		qtype:     dns.TypeTXT,
+		wantNs:    []string{"lab.example. 300 IN SOA ns1.lab.example. hostmaster.lab.example. 1 7200 3600 1209600 300"},
+		wantRcode: dns.RcodeSuccess,
+		wantAA:    true,
+	}, {
+		name:      "nxdomain",
+		qname:     "none.lab.example.",
+		qtype:     dns.TypeA,
+		wantNs:    []string{"lab.example. 300 IN SOA ns1.lab.example. hostmaster.lab.example. 1 7200 3600 1209600 300"},
+		wantRcode: dns.RcodeNameError,
+		wantAA:    true,
+	}, {
+		name:      "empty_non_terminal",
+		qname:     "b.c.lab.example.",
+		qtype:     dns.TypeA,
+		wantNs:    []string{"lab.example. 300 IN SOA ns1.lab.example. hostmaster.lab.example. 1 7200 3600 1209600 300"},
+		wantRcode: dns.RcodeSuccess,
+		wantAA:    true,
+	}, {
+		name:  "cname_chase",
+		qname: "alias.lab.example.",
+		qtype: dns.TypeA,
+		wantAnswer: []string{
+			"alias.lab.example. 3600 IN CNAME www.lab.example.",
+			"www.lab.example. 3600 IN A 192.0.2.2",
+		},
+		wantRcode: dns.RcodeSuccess,
+		wantAA:    true,
+	}, {
+		name:       "cname_outside",
+		qname:      "outside.lab.example.",
+		qtype:      dns.TypeA,
+		wantAnswer: []string{"outside.lab.example. 3600 IN CNAME www.example.com."},
+		wantRcode:  dns.RcodeSuccess,
+		wantAA:     true,
+	}, {
+		name:  "cname_loop",
+		qname: "loop1.lab.example.",
+		qtype: dns.TypeA,
+		wantAnswer: []string{
+			"loop1.lab.example. 3600 IN CNAME loop2.lab.example.",
+			"loop2.lab.example. 3600 IN CNAME loop1.lab.example.",
+		},
+		wantRcode: dns.RcodeSuccess,
+		wantAA:    true,
+	}, {
+		name:       "wildcard",
+		qname:      "host.dyn.lab.example.",
+		qtype:      dns.TypeA,
+		wantAnswer: []string{"host.dyn.lab.example. 3600 IN A 192.0.2.3"},
+		wantRcode:  dns.RcodeSuccess,
+		wantAA:     true,
+	}, {
+		name:       "mx_additional",
+		qname:      "mail.lab.example.",
+		qtype:      dns.TypeMX,
+		wantAnswer: []string{"mail.lab.example. 3600 IN MX 10 www.lab.example."},
+		wantExtra: []string{
+			"www.lab.example. 3600 IN A 192.0.2.2",
+			"www.lab.example. 3600 IN AAAA 2001:db8::2",
+		},
+		wantRcode: dns.RcodeSuccess,
+		wantAA:    true,
+	}, {
+		name:      "referral",
+		qname:     "host.sub.lab.example.",
+		qtype:     dns.TypeA,
+		wantNs:    []string{"sub.lab.example. 3600 IN NS ns.sub.lab.example."},
+		wantExtra: []string{"ns.sub.lab.example. 3600 IN A 192.0.2.4"},
+		wantRcode: dns.RcodeSuccess,
+		wantAA:    false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := c.Resolve(newReq(tc.qname, tc.qtype))
+			require.NotNil(t, resp)
+
+			assert.Equal(t, tc.wantRcode, resp.Rcode)
+			assert.Equal(t, tc.wantAA, resp.Authoritative)
+			assert.Equal(t, tc.wantAnswer, rrStrings(resp.Answer))
+			assert.Equal(t, tc.wantNs, rrStrings(resp.Ns))
+			assert.Equal(t, tc.wantExtra, rrStrings(resp.Extra))
+		})
+	}
+
+	t.Run("not_authoritative", func(t *testing.T) {
+		assert.Nil(t, c.Resolve(newReq("www.example.com.", dns.TypeA)))
+	})
+}
+
+func TestContainer_reload(t *testing.T) {
+	const zoneFmt = "$ORIGIN lab.example.\n" +
+		"@ 60 IN SOA ns1 hostmaster 1 7200 3600 1209600 300\n" +
+		"www 60 IN A %s\n"
+
+	dir := t.TempDir()
+	writeZone := func(data string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "lab.zone"), []byte(data), 0o644))
+	}
+
+	writeZone(fmt.Sprintf(zoneFmt, "192.0.2.1"))
+
+	events := make(chan struct{})
+	c := newTestContainer(t, os.DirFS(dir), events, &authzone.ZoneConfig{
+		File: "lab.zone",
+	})
+
+	resolveA := func() (a string) {
+		resp := c.Resolve(newReq("www.lab.example.", dns.TypeA))
+		require.NotNil(t, resp)
+		require.Len(t, resp.Answer, 1)
+
+		return resp.Answer[0].(*dns.A).A.String()
+	}
+
+	require.Equal(t, "192.0.2.1", resolveA())
+
+	// An invalid file keeps the previous zones.  Send the event twice to make
+	// sure the first one has been processed.
+	writeZone("www 60 IN A 192.0.2.2\n")
+	events <- struct{}{}
+	events <- struct{}{}
+
+	require.Equal(t, "192.0.2.1", resolveA())
+
+	writeZone(fmt.Sprintf(zoneFmt, "192.0.2.3"))
+	events <- struct{}{}
+
+	require.Eventually(t, func() (ok bool) {
+		return resolveA() == "192.0.2.3"
+	}, 1*time.Second, 10*time.Millisecond)
+}
+
+func TestParse_errors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		data       string
+		origin     string
+		wantErrMsg string
+	}{{
+		name:       "no_soa",
+		data:       "www 60 IN A 192.0.2.1\n",
+		origin:     "lab.example",
+		wantErrMsg: "no soa record",
+	}, {
+		name: "out_of_zone",
+		data: "@ 60 IN SOA ns1 hostmaster 1 2 3 4 5\n" +
+			"www.example.com. 60 IN A 192.0.2.1\n",
+		origin:     "lab.example",
+		wantErrMsg: `record "www.example.com.": out of zone "lab.example."`,
+	}, {
+		name: "cname_and_other",
+		data: "@ 60 IN SOA ns1 hostmaster 1 2 3 4 5\n" +
+			"www 60 IN A 192.0.2.1\n" +
+			"www 60 IN CNAME @\n",
+		origin:     "lab.example",
+		wantErrMsg: `record "www.lab.example.": cname and other data`,
+	}, {
+		name:       "origin_mismatch",
+		data:       "other.example. 60 IN SOA ns1 hostmaster 1 2 3 4 5\n",
+		origin:     "lab.example",
+		wantErrMsg: `soa owner "other.example." doesn't match origin "lab.example."`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := authzone.Parse(strings.NewReader(tc.data), tc.origin, "test.zone")
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
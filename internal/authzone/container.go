@@ -0,0 +1,210 @@
+package authzone
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/aghos"
+)
+
+// ZoneConfig is the configuration of a single zone.
+type ZoneConfig struct {
+	// Origin is the initial origin of the zone file.  It may be empty if the
+	// file only contains absolute names or sets the origin with the $ORIGIN
+	// directive.
+	Origin string `yaml:"origin"`
+
+	// File is the path to the zone file.
+	File string `yaml:"file"`
+}
+
+// containerPrefix is a prefix for logging and wrapping errors in Container's
+// methods.
+const containerPrefix = "authzone"
+
+// Container stores the local authoritative zones and reloads them when their
+// files change.
+type Container struct {
+	// done is closed when the container is closed.
+	done chan struct{}
+
+	// zones are the currently loaded zones sorted by the length of their
+	// origins, longest first.
+	zones atomic.Pointer[[]*Zone]
+
+	// fsys is the file system to read the zone files from.
+	fsys fs.FS
+
+	// watcher tracks the changes in the zone files.
+	watcher aghos.FSWatcher
+
+	// confs are the configurations of the zones.  The paths to the files are
+	// relative to fsys.
+	confs []*ZoneConfig
+}
+
+// NewContainer loads the zones and returns a container reloading them when
+// their files change, as reported by w.  The paths of the files in confs must
+// be valid for fsys.  fsys and w must not be nil.  w must be started by the
+// caller.
+func NewContainer(fsys fs.FS, w aghos.FSWatcher, confs []*ZoneConfig) (c *Container, err error) {
+	defer func() { err = errors.Annotate(err, "%s: %w", containerPrefix) }()
+
+	c = &Container{
+		done:    make(chan struct{}),
+		fsys:    fsys,
+		watcher: w,
+		confs:   confs,
+	}
+
+	err = c.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, conf := range confs {
+		err = w.Add(conf.File)
+		if err != nil {
+			return nil, fmt.Errorf("watching %q: %w", conf.File, err)
+		}
+	}
+
+	go c.handleEvents()
+
+	return c, nil
+}
+
+// Close stops reloading the zones and closes the watcher.  Close must only be
+// called once.
+func (c *Container) Close() (err error) {
+	err = errors.Annotate(c.watcher.Close(), "closing fs watcher: %w")
+
+	// Go on and close the container either way.
+	close(c.done)
+
+	return err
+}
+
+// handleEvents reloads the zones on the file system events.  It is intended to
+// be used as a goroutine.
+func (c *Container) handleEvents() {
+	defer log.OnPanic(fmt.Sprintf("%s: handling events", containerPrefix))
+
+	eventsCh := c.watcher.Events()
+	ok := eventsCh != nil
+	for ok {
+		select {
+		case _, ok = <-eventsCh:
+			if !ok {
+				log.Debug("%s: watcher closed the events channel", containerPrefix)
+
+				continue
+			}
+
+			if err := c.refresh(); err != nil {
+				log.Error("%s: reloading, keeping previous zones: %s", containerPrefix, err)
+			}
+		case _, ok = <-c.done:
+			// Go on.
+		}
+	}
+}
+
+// refresh loads all the zones.  The zones aren't replaced if any of them fails
+// to load.
+func (c *Container) refresh() (err error) {
+	zones := make([]*Zone, 0, len(c.confs))
+	for _, conf := range c.confs {
+		var z *Zone
+		z, err = c.load(conf)
+		if err != nil {
+			return fmt.Errorf("zone file %q: %w", conf.File, err)
+		}
+
+		if slices.ContainsFunc(zones, func(o *Zone) (ok bool) { return o.origin == z.origin }) {
+			return fmt.Errorf("zone file %q: duplicate zone %q", conf.File, z.origin)
+		}
+
+		zones = append(zones, z)
+	}
+
+	slices.SortFunc(zones, func(a, b *Zone) (res int) {
+		return len(b.origin) - len(a.origin)
+	})
+
+	c.zones.Store(&zones)
+
+	log.Debug("%s: loaded %d zones", containerPrefix, len(zones))
+
+	return nil
+}
+
+// load reads and parses the zone file.
+func (c *Container) load(conf *ZoneConfig) (z *Zone, err error) {
+	f, err := c.fsys.Open(conf.File)
+	if err != nil {
+		// Don't wrap the error since it contains the file name.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	return Parse(f, conf.Origin, conf.File)
+}
+
+// zone returns the most specific zone containing name or nil if there is no
+// such zone.  name must be lowercased.
+func (c *Container) zone(name string) (z *Zone) {
+	for _, z = range *c.zones.Load() {
+		if dns.IsSubDomain(z.origin, name) {
+			return z
+		}
+	}
+
+	return nil
+}
+
+// Resolve returns the authoritative response to req or nil if its question
+// isn't within any of the zones.  CNAME records are followed while their
+// targets are within the zones.  req must have a single question.
+func (c *Container) Resolve(req *dns.Msg) (resp *dns.Msg) {
+	q := req.Question[0]
+	if q.Qclass != dns.ClassINET {
+		return nil
+	}
+
+	name := dns.CanonicalName(q.Name)
+	z := c.zone(name)
+	if z == nil {
+		return nil
+	}
+
+	resp = (&dns.Msg{}).SetReply(req)
+	resp.Authoritative = true
+
+	seen := map[string]struct{}{}
+	for range maxChainLen {
+		seen[name] = struct{}{}
+
+		name = z.answer(resp, name, q.Qtype)
+		if name == "" {
+			break
+		} else if _, ok := seen[name]; ok {
+			log.Debug("%s: cname loop at %q", containerPrefix, name)
+
+			break
+		}
+
+		// Only follow the chain within the local zones, the client is
+		// expected to resolve the rest.
+		if z = c.zone(name); z == nil {
+			break
+		}
+	}
+
+	return resp
+}
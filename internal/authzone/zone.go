@@ -0,0 +1,287 @@
+// Package authzone contains the local authoritative DNS zones loaded from the
+// RFC 1035 master files.
+package authzone
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// rrSets are the resource records of a single owner name by their types.
+type rrSets map[uint16][]dns.RR
+
+// Zone is a parsed authoritative zone.
+type Zone struct {
+	// soa is the start of authority record of the zone.
+	soa *dns.SOA
+
+	// names are the record sets of the zone by the lowercased owner names.
+	// The empty non-terminals are present with empty sets.
+	names map[string]rrSets
+
+	// origin is the lowercased FQDN of the zone apex.
+	origin string
+}
+
+// Parse parses a zone from the master file data in r.  origin is the initial
+// origin of the file, it may be empty if the file only contains absolute
+// names or sets the origin itself.  If origin is not empty, it must be the
+// owner of the SOA record.  file is used in error messages.
+func Parse(r io.Reader, origin, file string) (z *Zone, err error) {
+	if origin != "" {
+		origin = dns.CanonicalName(origin)
+	}
+
+	zp := dns.NewZoneParser(r, origin, file)
+
+	var rrs []dns.RR
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+
+	err = zp.Err()
+	if err != nil {
+		return nil, fmt.Errorf("parsing: %w", err)
+	}
+
+	z = &Zone{
+		names: map[string]rrSets{},
+	}
+
+	for _, rr := range rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			if z.soa != nil {
+				return nil, errors.Error("more than one soa record")
+			}
+
+			z.soa = soa
+		}
+	}
+
+	if z.soa == nil {
+		return nil, errors.Error("no soa record")
+	}
+
+	z.origin = dns.CanonicalName(z.soa.Hdr.Name)
+	if origin != "" && origin != z.origin {
+		return nil, fmt.Errorf("soa owner %q doesn't match origin %q", z.origin, origin)
+	}
+
+	for _, rr := range rrs {
+		err = z.add(rr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return z, nil
+}
+
+// add adds rr to the zone along with the empty non-terminals between its owner
+// and the zone apex.
+func (z *Zone) add(rr dns.RR) (err error) {
+	hdr := rr.Header()
+	if hdr.Class != dns.ClassINET {
+		return fmt.Errorf("record %q: unsupported class %s", hdr.Name, dns.Class(hdr.Class))
+	}
+
+	name := dns.CanonicalName(hdr.Name)
+	if !dns.IsSubDomain(z.origin, name) {
+		return fmt.Errorf("record %q: out of zone %q", hdr.Name, z.origin)
+	}
+
+	sets := z.ensure(name)
+
+	t := hdr.Rrtype
+	if t == dns.TypeCNAME && len(sets) > 0 || t != dns.TypeCNAME && len(sets[dns.TypeCNAME]) > 0 {
+		return fmt.Errorf("record %q: cname and other data", hdr.Name)
+	}
+
+	sets[t] = append(sets[t], rr)
+
+	return nil
+}
+
+// ensure returns the record sets of name, adding it and its ancestors within
+// the zone if needed.
+func (z *Zone) ensure(name string) (sets rrSets) {
+	sets, ok := z.names[name]
+	if ok {
+		return sets
+	}
+
+	sets = rrSets{}
+	z.names[name] = sets
+
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		parent := name[off:]
+		if len(parent) < len(z.origin) {
+			break
+		}
+
+		if _, ok = z.names[parent]; ok {
+			break
+		}
+
+		z.names[parent] = rrSets{}
+	}
+
+	return sets
+}
+
+// Origin returns the FQDN of the zone apex.
+func (z *Zone) Origin() (origin string) {
+	return z.origin
+}
+
+// negativeSOA returns the SOA record for the authority section of negative
+// responses.  See RFC 2308.
+func (z *Zone) negativeSOA() (rr dns.RR) {
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+
+	return soa
+}
+
+// delegation returns the NS records of the zone cut at or above name, if any.
+// The zone apex isn't a zone cut.
+func (z *Zone) delegation(name string, qtype uint16) (ns []dns.RR) {
+	// Check from the closest to the apex, since the records below the topmost
+	// zone cut are occluded.
+	labels := dns.Split(name)
+	for i := len(labels) - 1; i >= 0; i-- {
+		cut := name[labels[i]:]
+		if len(cut) <= len(z.origin) {
+			continue
+		} else if cut == name && qtype == dns.TypeDS {
+			// DS records are served by the parent side of the cut.
+			break
+		}
+
+		if ns = z.names[cut][dns.TypeNS]; len(ns) > 0 {
+			return ns
+		}
+	}
+
+	return nil
+}
+
+// closestEncloser returns the longest existing ancestor of name within the
+// zone.  name must be within the zone.
+func (z *Zone) closestEncloser(name string) (ce string) {
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		if _, ok := z.names[name[off:]]; ok {
+			return name[off:]
+		}
+	}
+
+	return z.origin
+}
+
+// maxChainLen is the maximum number of CNAME records followed while answering
+// a single query.
+const maxChainLen = 16
+
+// answer adds the records for name and qtype to resp.  target is the target of
+// the CNAME record for name, if it should be followed.  name must be
+// lowercased and be within the zone.
+func (z *Zone) answer(resp *dns.Msg, name string, qtype uint16) (target string) {
+	if ns := z.delegation(name, qtype); ns != nil {
+		if len(resp.Answer) == 0 {
+			// Refer the client to the child zone.
+			resp.Authoritative = false
+			resp.Ns = append(resp.Ns, ns...)
+			resp.Extra = append(resp.Extra, z.glue(ns)...)
+		}
+
+		return ""
+	}
+
+	sets, ok := z.names[name]
+	synthesize := false
+	if !ok {
+		sets, ok = z.names["*."+z.closestEncloser(name)]
+		synthesize = true
+	}
+
+	if !ok {
+		resp.Rcode = dns.RcodeNameError
+		resp.Ns = append(resp.Ns, z.negativeSOA())
+
+		return ""
+	}
+
+	var rrs []dns.RR
+	switch {
+	case qtype == dns.TypeANY:
+		for _, set := range sets {
+			rrs = append(rrs, set...)
+		}
+	case len(sets[qtype]) > 0:
+		rrs = sets[qtype]
+	case len(sets[dns.TypeCNAME]) > 0:
+		rrs = sets[dns.TypeCNAME]
+		target = dns.CanonicalName(rrs[0].(*dns.CNAME).Target)
+	}
+
+	if len(rrs) == 0 {
+		resp.Ns = append(resp.Ns, z.negativeSOA())
+
+		return ""
+	}
+
+	if synthesize {
+		rrs = withOwner(rrs, resp.Question[0].Name, name)
+	}
+
+	resp.Answer = append(resp.Answer, rrs...)
+	resp.Extra = append(resp.Extra, z.glue(rrs)...)
+
+	return target
+}
+
+// withOwner returns the copies of the wildcard records rrs with the owner set
+// to name.  qname is the name from the question, which is used instead of
+// name if they only differ in case.
+func withOwner(rrs []dns.RR, qname, name string) (res []dns.RR) {
+	if strings.EqualFold(qname, name) {
+		name = qname
+	}
+
+	res = make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		res = append(res, rr)
+	}
+
+	return res
+}
+
+// glue returns the address records of the targets of the NS, MX, and SRV
+// records within rrs, which are present in the zone.
+func (z *Zone) glue(rrs []dns.RR) (addrs []dns.RR) {
+	for _, rr := range rrs {
+		var target string
+		switch rr := rr.(type) {
+		case *dns.NS:
+			target = rr.Ns
+		case *dns.MX:
+			target = rr.Mx
+		case *dns.SRV:
+			target = rr.Target
+		default:
+			continue
+		}
+
+		sets := z.names[dns.CanonicalName(target)]
+		addrs = append(addrs, sets[dns.TypeA]...)
+		addrs = append(addrs, sets[dns.TypeAAAA]...)
+	}
+
+	return addrs
+}
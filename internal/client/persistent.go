@@ -17,7 +17,10 @@ import (
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/google/uuid"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
 	"github.com/tukimoto/AdGuardHome/internal/filtering/safesearch"
+	"github.com/tukimoto/AdGuardHome/internal/odoh"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
 )
 
 // UID is the type for the unique IDs of persistent clients.
@@ -72,6 +75,10 @@ type Persistent struct {
 	// must not be nil after initialization.
 	BlockedServices *filtering.BlockedServices
 
+	// Ratelimit is the custom rate limiting policy of the client.  If it's
+	// nil, the policy of the client's tags or the global one is used.
+	Ratelimit *ratelimit.Policy
+
 	// Name of the persistent client.  Must not be empty.
 	Name string
 
@@ -81,6 +88,10 @@ type Persistent struct {
 	// Upstreams is a list of custom upstream DNS servers for the client.
 	Upstreams []string
 
+	// FilterLists are the IDs of the blocking and allowing filter lists
+	// applied to the client.  It's only used if UseOwnFilterLists is true.
+	FilterLists []rulelist.URLFilterID
+
 	// IPs is a list of IP addresses that identify the client.  The client must
 	// have at least one ID (IP, subnet, MAC, or ClientID).
 	IPs []netip.Addr
@@ -123,6 +134,10 @@ type Persistent struct {
 	// UseOwnBlockedServices specifies whether custom services are blocked.
 	UseOwnBlockedServices bool
 
+	// UseOwnFilterLists specifies whether only FilterLists are applied to the
+	// client instead of the selection of its tags or all filter lists.
+	UseOwnFilterLists bool
+
 	// IgnoreQueryLog specifies whether the client requests are logged.
 	IgnoreQueryLog bool
 
@@ -146,7 +161,7 @@ func (c *Persistent) validate(allTags *container.MapSet[string]) (err error) {
 		return errors.Error("uid required")
 	}
 
-	conf, err := proxy.ParseUpstreamsConfig(c.Upstreams, &upstream.Options{})
+	conf, err := odoh.ParseUpstreamsConfig(c.Upstreams, &upstream.Options{})
 	if err != nil {
 		return fmt.Errorf("invalid upstream servers: %w", err)
 	}
@@ -156,6 +171,11 @@ func (c *Persistent) validate(allTags *container.MapSet[string]) (err error) {
 		log.Error("client: closing upstream config: %s", err)
 	}
 
+	err = c.Ratelimit.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid ratelimit: %w", err)
+	}
+
 	for _, t := range c.Tags {
 		if !allTags.Has(t) {
 			return fmt.Errorf("invalid tag: %q", t)
@@ -301,8 +321,10 @@ func (c *Persistent) ShallowClone() (clone *Persistent) {
 	*clone = *c
 
 	clone.BlockedServices = c.BlockedServices.Clone()
+	clone.Ratelimit = c.Ratelimit.Clone()
 	clone.Tags = slices.Clone(c.Tags)
 	clone.Upstreams = slices.Clone(c.Upstreams)
+	clone.FilterLists = slices.Clone(c.FilterLists)
 
 	clone.IPs = slices.Clone(c.IPs)
 	clone.Subnets = slices.Clone(c.Subnets)
@@ -0,0 +1,388 @@
+// Package dnscache implements the cache of DNS responses which can be saved to
+// a file and restored from it.
+package dnscache
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Entry is a single cached response.
+type Entry struct {
+	// Stored is the time when the response has been cached.
+	Stored time.Time
+
+	// Expire is the time when the response expires.
+	Expire time.Time
+
+	// Host is the fully-qualified name from the question of the response.
+	Host string
+
+	// Upstream is the address of the upstream server which has sent the
+	// response.
+	Upstream string
+
+	// refreshed is the time when the update of the expired response has been
+	// requested last time.  It's protected by the mutex of the cache.
+	refreshed time.Time
+
+	// key is the cache key of the response.  See [Key].
+	key string
+
+	// msg is the packed response.
+	msg []byte
+
+	// QType is the type from the question of the response.
+	QType uint16
+}
+
+// size returns the approximate number of bytes e occupies.
+func (e *Entry) size() (n int) {
+	return len(e.key) + len(e.msg) + len(e.Host) + len(e.Upstream)
+}
+
+// Cache is a cache of DNS responses limited by their total size.  The least
+// recently used responses are evicted first.  It is safe for concurrent use.
+type Cache struct {
+	// mu protects all the fields below.
+	mu *sync.Mutex
+
+	// entries are the elements of lru mapped by the keys of their entries.
+	entries map[string]*list.Element
+
+	// lru contains *Entry values, the most recently used first.
+	lru *list.List
+
+	// size is the current total size of the entries.
+	size int
+
+	// maxSize is the maximum total size of the entries.
+	maxSize int
+}
+
+// New returns a new cache limited by maxSize bytes.  If maxSize is zero, the
+// cache doesn't store anything.
+func New(maxSize int) (c *Cache) {
+	return &Cache{
+		mu:      &sync.Mutex{},
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		maxSize: maxSize,
+	}
+}
+
+// Key returns the cache key for req, which must have exactly one question.
+// The DO, AD, and CD flags of req are taken into account, since they affect
+// the contents of the response.
+func Key(req *dns.Msg) (key string) {
+	q := req.Question[0]
+
+	var flags byte
+	if opt := req.IsEdns0(); opt != nil && opt.Do() {
+		flags |= 1
+	}
+
+	if req.AuthenticatedData {
+		flags |= 1 << 1
+	}
+
+	if req.CheckingDisabled {
+		flags |= 1 << 2
+	}
+
+	return fmt.Sprintf("%s %d %d %d", strings.ToLower(q.Name), q.Qtype, q.Qclass, flags)
+}
+
+// optimisticTTL is the TTL of the records in the expired responses served
+// optimistically, in seconds.  It's also the minimum interval between the
+// updates of such a response.
+const optimisticTTL = 10
+
+// Get returns the response to req cached with key, if there is an unexpired
+// one.  The TTLs of the records are decreased by the time passed since the
+// response has been cached.  If optimistic is true, the expired response is
+// returned as well with the TTLs of the records set to 10 seconds, and refresh
+// tells if the caller should update it.
+func (c *Cache) Get(
+	key string,
+	req *dns.Msg,
+	optimistic bool,
+) (resp *dns.Msg, upstream string, refresh, ok bool) {
+	now := time.Now()
+	e, refresh, ok := c.get(key, now, optimistic)
+	if !ok {
+		return nil, "", false, false
+	}
+
+	resp = &dns.Msg{}
+	err := resp.Unpack(e.msg)
+	if err != nil {
+		// Shouldn't happen, since the message has been packed by us.
+		log.Debug("dnscache: unpacking %q: %s", key, err)
+
+		return nil, "", false, false
+	}
+
+	expired := !now.Before(e.Expire)
+	elapsed := uint32(now.Sub(e.Stored) / time.Second)
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			if h := rr.Header(); h.Rrtype == dns.TypeOPT {
+				continue
+			} else if expired {
+				h.Ttl = optimisticTTL
+			} else {
+				h.Ttl -= min(h.Ttl, elapsed)
+			}
+		}
+	}
+
+	resp.Id = req.Id
+	resp.Question = req.Question
+
+	return resp, e.Upstream, refresh, true
+}
+
+// get returns the entry for key and marks it as recently used.  The expired
+// entry is only returned if optimistic is true, and refresh is true if it
+// hasn't been requested to update recently.
+func (c *Cache) get(key string, now time.Time, optimistic bool) (e *Entry, refresh, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+
+	e = elem.Value.(*Entry)
+	if now.Before(e.Expire) {
+		c.lru.MoveToFront(elem)
+
+		return e, false, true
+	} else if !optimistic {
+		c.removeLocked(elem)
+
+		return nil, false, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	refresh = now.Sub(e.refreshed) >= optimisticTTL*time.Second
+	if refresh {
+		e.refreshed = now
+	}
+
+	return e, refresh, true
+}
+
+// Set caches resp with key if resp is cacheable.  upstream is the address of
+// the upstream server which has sent resp.  The TTLs of the cached records are
+// increased up to minTTL and decreased down to maxTTL, unless those are zero.
+func (c *Cache) Set(key string, resp *dns.Msg, upstream string, minTTL, maxTTL uint32) {
+	ttl, ok := cacheTTL(resp)
+	if !ok {
+		return
+	}
+
+	if minTTL != 0 || maxTTL != 0 {
+		// Don't change the TTLs of the response sent to the client.
+		resp = resp.Copy()
+		overrideTTLs(resp, minTTL, maxTTL)
+		ttl = overrideTTL(ttl, minTTL, maxTTL)
+	}
+
+	msg, err := resp.Pack()
+	if err != nil {
+		log.Debug("dnscache: packing %q: %s", key, err)
+
+		return
+	}
+
+	now := time.Now()
+	q := resp.Question[0]
+	c.add(&Entry{
+		Stored:   now,
+		Expire:   now.Add(time.Duration(ttl) * time.Second),
+		Host:     strings.ToLower(q.Name),
+		Upstream: upstream,
+		key:      key,
+		msg:      msg,
+		QType:    q.Qtype,
+	})
+}
+
+// add adds e to the cache, replacing the entry with the same key, and evicts
+// the least recently used entries if the cache is full.
+func (c *Cache) add(e *Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e.size() > c.maxSize {
+		return
+	}
+
+	if elem, ok := c.entries[e.key]; ok {
+		c.removeLocked(elem)
+	}
+
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size()
+
+	c.shrinkLocked()
+}
+
+// shrinkLocked evicts the least recently used entries until the cache fits
+// into its maximum size.  c.mu is expected to be locked.
+func (c *Cache) shrinkLocked() {
+	for c.size > c.maxSize {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// removeLocked removes elem from the cache.  c.mu is expected to be locked.
+func (c *Cache) removeLocked(elem *list.Element) {
+	e := c.lru.Remove(elem).(*Entry)
+	delete(c.entries, e.key)
+	c.size -= e.size()
+}
+
+// Entries returns the unexpired entries, the most recently used first.
+func (c *Cache) Entries() (entries []*Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entries = make([]*Entry, 0, c.lru.Len())
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*Entry)
+		if now.Before(e.Expire) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+// Evict removes all the responses to the questions about host, which must be
+// a fully-qualified domain name, and returns the number of removed responses.
+func (c *Cache) Evict(host string) (n int) {
+	host = strings.ToLower(host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*Entry).Host == host {
+			c.removeLocked(elem)
+			n++
+		}
+
+		elem = next
+	}
+
+	return n
+}
+
+// Clear removes all the responses.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.size = 0
+}
+
+// Resize sets the maximum total size of the responses and evicts the least
+// recently used ones if they don't fit.
+func (c *Cache) Resize(maxSize int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxSize = maxSize
+	c.shrinkLocked()
+}
+
+// maxSizeValue returns the maximum total size of the responses.
+func (c *Cache) maxSizeValue() (maxSize int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.maxSize
+}
+
+// overrideTTLs sets the TTLs of the records in resp within minTTL and maxTTL,
+// unless those are zero.
+func overrideTTLs(resp *dns.Msg, minTTL, maxTTL uint32) {
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT {
+				h.Ttl = overrideTTL(h.Ttl, minTTL, maxTTL)
+			}
+		}
+	}
+}
+
+// overrideTTL returns ttl within minTTL and maxTTL, unless those are zero.
+func overrideTTL(ttl, minTTL, maxTTL uint32) (res uint32) {
+	if minTTL != 0 {
+		ttl = max(ttl, minTTL)
+	}
+
+	if maxTTL != 0 {
+		ttl = min(ttl, maxTTL)
+	}
+
+	return ttl
+}
+
+// cacheTTL returns the duration for which resp can be cached in seconds.  ok
+// is false if resp can't be cached.
+func cacheTTL(resp *dns.Msg) (ttl uint32, ok bool) {
+	if resp.Truncated || len(resp.Question) != 1 {
+		return 0, false
+	}
+
+	var negative bool
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+		negative = len(resp.Answer) == 0
+	case dns.RcodeNameError:
+		negative = true
+	default:
+		return 0, false
+	}
+
+	ttl = math.MaxUint32
+	var hasSOA bool
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			switch rr := rr.(type) {
+			case *dns.OPT:
+				continue
+			case *dns.SOA:
+				hasSOA = true
+				ttl = min(ttl, rr.Minttl)
+			}
+
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+	}
+
+	if negative && !hasSOA {
+		// RFC 2308 Section 5 forbids caching the negative responses without
+		// the SOA record.
+		return 0, false
+	}
+
+	return ttl, ttl > 0 && ttl != math.MaxUint32
+}
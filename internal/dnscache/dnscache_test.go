@@ -0,0 +1,330 @@
+package dnscache_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/dnscache"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testUpstream is the address of the upstream server used in tests.
+const testUpstream = "1.2.3.4:53"
+
+// newResp returns a response to the A request for host with the given rcode
+// and the answer having ttl, if any.
+func newResp(host string, rcode int, ttl uint32) (req, resp *dns.Msg) {
+	req = (&dns.Msg{}).SetQuestion(host, dns.TypeA)
+	resp = (&dns.Msg{}).SetRcode(req, rcode)
+
+	if rcode == dns.RcodeSuccess {
+		resp.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   host,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			A: net.IP{192, 0, 2, 1},
+		}}
+	}
+
+	return req, resp
+}
+
+func TestCache_Get(t *testing.T) {
+	const host = "www.example."
+
+	soa := &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   "example.",
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Ns:     "ns.example.",
+		Mbox:   "host.example.",
+		Minttl: 300,
+	}
+
+	_, positive := newResp(host, dns.RcodeSuccess, 60)
+	_, zeroTTL := newResp(host, dns.RcodeSuccess, 0)
+	_, servfail := newResp(host, dns.RcodeServerFailure, 0)
+	_, nxdomain := newResp(host, dns.RcodeNameError, 0)
+	_, nxdomainSOA := newResp(host, dns.RcodeNameError, 0)
+	nxdomainSOA.Ns = []dns.RR{soa}
+
+	testCases := []struct {
+		resp    *dns.Msg
+		name    string
+		wantTTL uint32
+		wantHit bool
+	}{{
+		resp:    positive,
+		name:    "positive",
+		wantTTL: 60,
+		wantHit: true,
+	}, {
+		resp:    zeroTTL,
+		name:    "zero_ttl",
+		wantTTL: 0,
+		wantHit: false,
+	}, {
+		resp:    servfail,
+		name:    "servfail",
+		wantTTL: 0,
+		wantHit: false,
+	}, {
+		resp:    nxdomain,
+		name:    "nxdomain_no_soa",
+		wantTTL: 0,
+		wantHit: false,
+	}, {
+		resp:    nxdomainSOA,
+		name:    "nxdomain",
+		wantTTL: 300,
+		wantHit: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dnscache.New(1024)
+
+			req := (&dns.Msg{}).SetQuestion("WWW.example.", dns.TypeA)
+			key := dnscache.Key(req)
+			c.Set(key, tc.resp, testUpstream, 0, 0)
+
+			resp, ups, _, ok := c.Get(key, req, false)
+			require.Equal(t, tc.wantHit, ok)
+
+			if !tc.wantHit {
+				return
+			}
+
+			assert.Equal(t, testUpstream, ups)
+			assert.Equal(t, req.Id, resp.Id)
+			assert.Equal(t, req.Question, resp.Question)
+
+			entries := c.Entries()
+			require.Len(t, entries, 1)
+
+			e := entries[0]
+			assert.Equal(t, host, e.Host)
+			assert.Equal(t, dns.TypeA, e.QType)
+			assert.Equal(t, int64(tc.wantTTL), int64(e.Expire.Sub(e.Stored).Seconds()))
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	req := (&dns.Msg{}).SetQuestion("www.example.", dns.TypeA)
+	plain := dnscache.Key(req)
+
+	req.SetEdns0(dns.DefaultMsgSize, true)
+	withDO := dnscache.Key(req)
+
+	req.AuthenticatedData = true
+	withAD := dnscache.Key(req)
+
+	assert.NotEqual(t, plain, withDO)
+	assert.NotEqual(t, withDO, withAD)
+
+	upper := (&dns.Msg{}).SetQuestion("WWW.EXAMPLE.", dns.TypeA)
+	assert.Equal(t, plain, dnscache.Key(upper))
+}
+
+func TestCache_Evict(t *testing.T) {
+	c := dnscache.New(4096)
+
+	for _, host := range []string{"a.example.", "b.example."} {
+		req, resp := newResp(host, dns.RcodeSuccess, 60)
+		c.Set(dnscache.Key(req), resp, testUpstream, 0, 0)
+
+		req.SetEdns0(dns.DefaultMsgSize, true)
+		c.Set(dnscache.Key(req), resp, testUpstream, 0, 0)
+	}
+
+	require.Len(t, c.Entries(), 4)
+
+	assert.Equal(t, 2, c.Evict("A.example."))
+	assert.Equal(t, 0, c.Evict("a.example."))
+
+	entries := c.Entries()
+	require.Len(t, entries, 2)
+
+	for _, e := range entries {
+		assert.Equal(t, "b.example.", e.Host)
+	}
+}
+
+func TestCache_Set_ttlOverrides(t *testing.T) {
+	testCases := []struct {
+		name    string
+		ttl     uint32
+		minTTL  uint32
+		maxTTL  uint32
+		wantTTL uint32
+	}{{
+		name:    "none",
+		ttl:     60,
+		minTTL:  0,
+		maxTTL:  0,
+		wantTTL: 60,
+	}, {
+		name:    "min",
+		ttl:     60,
+		minTTL:  600,
+		maxTTL:  0,
+		wantTTL: 600,
+	}, {
+		name:    "max",
+		ttl:     60,
+		minTTL:  0,
+		maxTTL:  30,
+		wantTTL: 30,
+	}, {
+		name:    "within",
+		ttl:     60,
+		minTTL:  30,
+		maxTTL:  600,
+		wantTTL: 60,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dnscache.New(4096)
+			req, resp := newResp("www.example.", dns.RcodeSuccess, tc.ttl)
+			key := dnscache.Key(req)
+			c.Set(key, resp, testUpstream, tc.minTTL, tc.maxTTL)
+
+			// The response sent to the client must not change.
+			assert.Equal(t, tc.ttl, resp.Answer[0].Header().Ttl)
+
+			got, _, _, ok := c.Get(key, req, false)
+			require.True(t, ok)
+			require.Len(t, got.Answer, 1)
+
+			assert.Equal(t, tc.wantTTL, got.Answer[0].Header().Ttl)
+
+			entries := c.Entries()
+			require.Len(t, entries, 1)
+
+			assert.Equal(t, tc.wantTTL, uint32(entries[0].Expire.Sub(entries[0].Stored)/time.Second))
+		})
+	}
+}
+
+func TestCache_Get_optimistic(t *testing.T) {
+	req, resp := newResp("www.example.", dns.RcodeSuccess, 1)
+	key := dnscache.Key(req)
+
+	c := dnscache.New(4096)
+	c.Set(key, resp, testUpstream, 0, 0)
+
+	_, _, refresh, ok := c.Get(key, req, true)
+	require.True(t, ok)
+
+	assert.False(t, refresh)
+
+	// Wait for the response to expire.
+	time.Sleep(time.Second)
+
+	got, ups, refresh, ok := c.Get(key, req, true)
+	require.True(t, ok)
+	require.Len(t, got.Answer, 1)
+
+	assert.True(t, refresh)
+	assert.Equal(t, testUpstream, ups)
+	assert.Equal(t, uint32(10), got.Answer[0].Header().Ttl)
+
+	// Only the first caller should update the response.
+	_, _, refresh, ok = c.Get(key, req, true)
+	require.True(t, ok)
+
+	assert.False(t, refresh)
+
+	_, _, _, ok = c.Get(key, req, false)
+	assert.False(t, ok)
+
+	_, _, _, ok = c.Get(key, req, true)
+	assert.False(t, ok)
+}
+
+func TestCache_size(t *testing.T) {
+	reqA, respA := newResp("a.example.", dns.RcodeSuccess, 60)
+	reqB, respB := newResp("b.example.", dns.RcodeSuccess, 60)
+
+	// Only fits a single response.
+	c := dnscache.New(100)
+	c.Set(dnscache.Key(reqA), respA, testUpstream, 0, 0)
+	c.Set(dnscache.Key(reqB), respB, testUpstream, 0, 0)
+
+	_, _, _, ok := c.Get(dnscache.Key(reqA), reqA, false)
+	assert.False(t, ok)
+
+	_, _, _, ok = c.Get(dnscache.Key(reqB), reqB, false)
+	assert.True(t, ok)
+
+	c.Resize(0)
+
+	_, _, _, ok = c.Get(dnscache.Key(reqB), reqB, false)
+	assert.False(t, ok)
+}
+
+func TestGroup_snapshot(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "cache.json")
+
+	const clientID = "client"
+
+	g, err := dnscache.NewGroup(&dnscache.GroupConfig{
+		FilePath: filePath,
+		Size:     4096,
+	})
+	require.NoError(t, err)
+
+	reqA, respA := newResp("a.example.", dns.RcodeSuccess, 3600)
+	reqB, respB := newResp("b.example.", dns.RcodeSuccess, 3600)
+	keyA, keyB := dnscache.Key(reqA), dnscache.Key(reqB)
+
+	g.General().Set(keyA, respA, testUpstream, 0, 0)
+	g.Client(clientID, 1024).Set(keyB, respB, testUpstream, 0, 0)
+
+	g.Start()
+	require.NoError(t, g.Close())
+
+	g, err = dnscache.NewGroup(&dnscache.GroupConfig{
+		FilePath: filePath,
+		Size:     4096,
+	})
+	require.NoError(t, err)
+
+	resp, ups, _, ok := g.General().Get(keyA, reqA, false)
+	require.True(t, ok)
+
+	assert.Equal(t, testUpstream, ups)
+	require.Len(t, resp.Answer, 1)
+	assert.LessOrEqual(t, resp.Answer[0].Header().Ttl, uint32(3600))
+
+	_, _, _, ok = g.General().Get(keyB, reqB, false)
+	assert.False(t, ok)
+
+	_, _, _, ok = g.Client(clientID, 1024).Get(keyB, reqB, false)
+	assert.True(t, ok)
+
+	var ids []string
+	g.Range(func(id string, _ *dnscache.Cache) (cont bool) {
+		ids = append(ids, id)
+
+		return true
+	})
+	assert.Equal(t, []string{"", clientID}, ids)
+}
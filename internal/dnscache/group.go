@@ -0,0 +1,346 @@
+package dnscache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/aghrenameio"
+)
+
+// snapshotVersion is the current version of the snapshot file format.
+const snapshotVersion = 1
+
+// GroupConfig is the configuration structure for Group.
+type GroupConfig struct {
+	// FilePath is the path to the snapshot file.  If empty, the caches aren't
+	// saved.
+	FilePath string
+
+	// SnapshotInterval is the interval between the periodic snapshots.  If
+	// zero, the caches are only saved on close.
+	SnapshotInterval time.Duration
+
+	// Size is the maximum size of the general cache in bytes.
+	Size int
+}
+
+// Group is the general cache along with the caches of the clients having
+// custom upstream servers.  The caches are saved into a single snapshot file
+// periodically and on close.
+type Group struct {
+	// general is the cache used for the requests without a custom cache.
+	general *Cache
+
+	// done is closed when the group is closed.
+	done chan struct{}
+
+	// clientsMu protects clients.
+	clientsMu *sync.Mutex
+
+	// clients are the caches of the clients mapped by their identifiers.
+	clients map[string]*Cache
+
+	// filePath is the path to the snapshot file.
+	filePath string
+
+	// interval is the interval between the periodic snapshots.
+	interval time.Duration
+}
+
+// NewGroup returns a new group of caches restored from the snapshot file, if
+// there is one.  Use [Group.Start] to begin the periodic snapshots.  c must
+// not be nil.
+func NewGroup(c *GroupConfig) (g *Group, err error) {
+	g = &Group{
+		general:   New(c.Size),
+		done:      make(chan struct{}),
+		clientsMu: &sync.Mutex{},
+		clients:   map[string]*Cache{},
+		filePath:  c.FilePath,
+		interval:  c.SnapshotInterval,
+	}
+
+	if g.filePath == "" {
+		return g, nil
+	}
+
+	err = g.load()
+	if err != nil {
+		return nil, fmt.Errorf("dnscache: loading snapshot: %w", err)
+	}
+
+	return g, nil
+}
+
+// General returns the general cache.
+func (g *Group) General() (c *Cache) {
+	return g.general
+}
+
+// Client returns the cache of the client with the given identifier, creating
+// it if necessary.  size is the maximum size of the cache in bytes.
+func (g *Group) Client(id string, size int) (c *Cache) {
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
+	c, ok := g.clients[id]
+	if !ok {
+		c = New(size)
+		g.clients[id] = c
+	} else if c.maxSizeValue() != size {
+		c.Resize(size)
+	}
+
+	return c
+}
+
+// Range calls f for each cache in the group until it returns false.  id is
+// empty for the general cache.
+func (g *Group) Range(f func(id string, c *Cache) (cont bool)) {
+	if !f("", g.general) {
+		return
+	}
+
+	g.clientsMu.Lock()
+	ids := make([]string, 0, len(g.clients))
+	for id := range g.clients {
+		ids = append(ids, id)
+	}
+	g.clientsMu.Unlock()
+
+	slices.Sort(ids)
+	for _, id := range ids {
+		g.clientsMu.Lock()
+		c, ok := g.clients[id]
+		g.clientsMu.Unlock()
+
+		if ok && !f(id, c) {
+			return
+		}
+	}
+}
+
+// Clear removes the responses from all the caches in the group.
+func (g *Group) Clear() {
+	g.Range(func(_ string, c *Cache) (cont bool) {
+		c.Clear()
+
+		return true
+	})
+}
+
+// Start begins saving the snapshots periodically, if configured.
+func (g *Group) Start() {
+	if g.filePath == "" || g.interval <= 0 {
+		return
+	}
+
+	go g.saveLoop()
+}
+
+// saveLoop saves the snapshots until the group is closed.  It's intended to be
+// used as a goroutine.
+func (g *Group) saveLoop() {
+	defer log.OnPanic("dnscache: saving snapshots")
+
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := g.Save()
+			if err != nil {
+				log.Error("dnscache: %s", err)
+			}
+		case <-g.done:
+			return
+		}
+	}
+}
+
+// Close stops the periodic snapshots and saves the final one.
+func (g *Group) Close() (err error) {
+	close(g.done)
+
+	return g.Save()
+}
+
+// snapshot is the contents of the snapshot file.
+type snapshot struct {
+	Caches  []*snapshotCache `json:"caches"`
+	Version int              `json:"version"`
+}
+
+// snapshotCache is a single cache within the snapshot file.
+type snapshotCache struct {
+	// ID is the identifier of the client.  It's empty for the general cache.
+	ID string `json:"id"`
+
+	// Entries are the entries of the cache, the least recently used first.
+	Entries []*snapshotEntry `json:"entries"`
+
+	// MaxSize is the maximum size of the cache in bytes.
+	MaxSize int `json:"max_size"`
+}
+
+// snapshotEntry is a single response within the snapshot file.
+type snapshotEntry struct {
+	Stored   time.Time `json:"stored"`
+	Expire   time.Time `json:"expire"`
+	Key      string    `json:"key"`
+	Upstream string    `json:"upstream,omitempty"`
+	Msg      []byte    `json:"msg"`
+}
+
+// Save writes the snapshot of the unexpired responses into the file.  It does
+// nothing if the file isn't configured.
+func (g *Group) Save() (err error) {
+	if g.filePath == "" {
+		return nil
+	}
+
+	snap := &snapshot{
+		Version: snapshotVersion,
+	}
+
+	g.Range(func(id string, c *Cache) (cont bool) {
+		snap.Caches = append(snap.Caches, newSnapshotCache(id, c))
+
+		return true
+	})
+
+	file, err := aghrenameio.NewPendingFile(g.filePath, 0o600)
+	if err != nil {
+		return fmt.Errorf("saving snapshot: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, file) }()
+
+	err = json.NewEncoder(file).Encode(snap)
+	if err != nil {
+		return fmt.Errorf("saving snapshot: encoding: %w", err)
+	}
+
+	return nil
+}
+
+// newSnapshotCache returns the snapshot of c.
+func newSnapshotCache(id string, c *Cache) (sc *snapshotCache) {
+	entries := c.Entries()
+	sc = &snapshotCache{
+		ID:      id,
+		Entries: make([]*snapshotEntry, 0, len(entries)),
+		MaxSize: c.maxSizeValue(),
+	}
+
+	// Save the least recently used entries first, so that the order is
+	// preserved on loading.
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		sc.Entries = append(sc.Entries, &snapshotEntry{
+			Stored:   e.Stored,
+			Expire:   e.Expire,
+			Key:      e.key,
+			Upstream: e.Upstream,
+			Msg:      e.msg,
+		})
+	}
+
+	return sc
+}
+
+// load restores the caches from the snapshot file.  A missing file isn't an
+// error.
+func (g *Group) load() (err error) {
+	f, err := os.Open(g.filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	snap := &snapshot{}
+	err = json.NewDecoder(f).Decode(snap)
+	if err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	if snap.Version != snapshotVersion {
+		log.Info("dnscache: snapshot version %d is not supported; ignoring", snap.Version)
+
+		return nil
+	}
+
+	now := time.Now()
+	var restored int
+	for _, sc := range snap.Caches {
+		c := g.general
+		if sc.ID != "" {
+			c = g.Client(sc.ID, sc.MaxSize)
+		}
+
+		restored += c.restore(sc.Entries, now)
+	}
+
+	log.Info("dnscache: restored %d responses from snapshot", restored)
+
+	return nil
+}
+
+// restore adds the unexpired entries to c and returns the number of the added
+// ones.
+func (c *Cache) restore(entries []*snapshotEntry, now time.Time) (n int) {
+	for _, se := range entries {
+		if !now.Before(se.Expire) {
+			continue
+		}
+
+		e, err := se.toEntry()
+		if err != nil {
+			log.Debug("dnscache: restoring %q: %s", se.Key, err)
+
+			continue
+		}
+
+		c.add(e)
+		n++
+	}
+
+	return n
+}
+
+// toEntry converts se into a cache entry.
+func (se *snapshotEntry) toEntry() (e *Entry, err error) {
+	msg := &dns.Msg{}
+	err = msg.Unpack(se.Msg)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking: %w", err)
+	} else if len(msg.Question) != 1 {
+		return nil, fmt.Errorf("bad number of questions: %d", len(msg.Question))
+	}
+
+	q := msg.Question[0]
+
+	return &Entry{
+		Stored:   se.Stored,
+		Expire:   se.Expire,
+		Host:     strings.ToLower(q.Name),
+		Upstream: se.Upstream,
+		key:      se.Key,
+		msg:      se.Msg,
+		QType:    q.Qtype,
+	}, nil
+}
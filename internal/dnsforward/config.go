@@ -27,6 +27,7 @@ import (
 	"github.com/tukimoto/AdGuardHome/internal/aghtls"
 	"github.com/tukimoto/AdGuardHome/internal/client"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
 )
 
 // ClientsContainer provides information about preconfigured DNS clients.
@@ -40,6 +41,20 @@ type ClientsContainer interface {
 		id string,
 		boot upstream.Resolver,
 	) (conf *proxy.CustomUpstreamConfig, err error)
+
+	// CustomCacheByID returns the identifier and the size in bytes of the DNS
+	// cache of the custom upstream configuration of the client having id.  ok
+	// is false if there is no such cache.  The identifier is stable across
+	// restarts.  The id is expected to be either a string representation of an
+	// IP address or the ClientID.
+	CustomCacheByID(id string) (cacheID string, size int, ok bool)
+
+	// RatelimitByID returns the name, the tags, and the custom rate limiting
+	// policy of the persistent client having id.  ok is false if there is no
+	// such client.  p is nil if the client has no custom policy.  The id is
+	// expected to be either a string representation of an IP address or the
+	// ClientID.
+	RatelimitByID(id string) (name string, tags []string, p *ratelimit.Policy, ok bool)
 }
 
 // Config represents the DNS filtering configuration of AdGuard Home.  The zero
@@ -71,6 +86,14 @@ type Config struct {
 	// RatelimitWhitelist is the list of whitelisted client IP addresses.
 	RatelimitWhitelist []netip.Addr `yaml:"ratelimit_whitelist"`
 
+	// RatelimitTagPolicies are the rate limiting policies of the persistent
+	// clients having the tags.  The first policy with a tag of the client is
+	// used, unless the client has a custom policy.
+	RatelimitTagPolicies []*RatelimitTagPolicy `yaml:"ratelimit_tag_policies"`
+
+	// ResponseRatelimit is the configuration of the response rate limiting.
+	ResponseRatelimit ResponseRatelimitConfig `yaml:"response_ratelimit"`
+
 	// RefuseAny, if true, refuse ANY requests.
 	RefuseAny bool `yaml:"refuse_any"`
 
@@ -91,6 +114,10 @@ type Config struct {
 	// servers are not responding.
 	FallbackDNS []string `yaml:"fallback_dns"`
 
+	// UpstreamHealth is the configuration of the health monitoring of the
+	// upstream DNS servers.
+	UpstreamHealth UpstreamHealthConfig `yaml:"upstream_health"`
+
 	// UpstreamMode determines the logic through which upstreams will be used.
 	UpstreamMode UpstreamMode `yaml:"upstream_mode"`
 
@@ -146,6 +173,16 @@ type Config struct {
 	// EnableDNSSEC, if true, set AD flag in outcoming DNS request.
 	EnableDNSSEC bool `yaml:"enable_dnssec"`
 
+	// DNSSECValidation, if true, validates the responses locally instead of
+	// trusting the AD flag set by the upstream servers.  The bogus responses
+	// are replaced with SERVFAIL.  It's only used if EnableDNSSEC is true.
+	DNSSECValidation bool `yaml:"dnssec_validation"`
+
+	// DNSSECTrustAnchors are the DS or DNSKEY records of the trusted keys in
+	// the presentation format.  If empty, the DS record of the root zone KSK
+	// is used.
+	DNSSECTrustAnchors []string `yaml:"dnssec_trust_anchors"`
+
 	// EDNSClientSubnet is the settings list for EDNS Client Subnet.
 	EDNSClientSubnet *EDNSClientSubnet `yaml:"edns_client_subnet"`
 
@@ -156,6 +193,10 @@ type Config struct {
 	// HandleDDR, if true, handle DDR requests
 	HandleDDR bool `yaml:"handle_ddr"`
 
+	// ServeODoH, if true, makes the DNS-over-HTTPS endpoint also serve as an
+	// Oblivious DoH target.  The key of the target is generated on start.
+	ServeODoH bool `yaml:"serve_odoh"`
+
 	// IpsetList is the ipset configuration that allows AdGuard Home to add IP
 	// addresses of the specified domain names to an ipset list.  Syntax:
 	//
@@ -310,6 +351,15 @@ const (
 	UpstreamModeLoadBalance UpstreamMode = "load_balance"
 	UpstreamModeParallel    UpstreamMode = "parallel"
 	UpstreamModeFastestAddr UpstreamMode = "fastest_addr"
+
+	// UpstreamModeLatencyWeighted chooses the upstreams randomly with the
+	// probability based on their exponentially decayed round-trip times and
+	// error rates.
+	UpstreamModeLatencyWeighted UpstreamMode = "latency_weighted"
+
+	// UpstreamModeSticky chooses the same upstream for the same client or
+	// EDNS Client Subnet while the upstream is available.
+	UpstreamModeSticky UpstreamMode = "sticky"
 )
 
 // newProxyConfig creates and validates configuration for the main proxy.
@@ -317,12 +367,10 @@ func (s *Server) newProxyConfig() (conf *proxy.Config, err error) {
 	srvConf := s.conf
 	trustedPrefixes := netutil.UnembedPrefixes(srvConf.TrustedProxies)
 
+	// Don't set the rate limiting settings of the proxy, since the server
+	// limits the rates itself, see [Server.processRatelimit].
 	conf = &proxy.Config{
 		HTTP3:                     srvConf.ServeHTTP3,
-		Ratelimit:                 int(srvConf.Ratelimit),
-		RatelimitSubnetLenIPv4:    srvConf.RatelimitSubnetLenIPv4,
-		RatelimitSubnetLenIPv6:    srvConf.RatelimitSubnetLenIPv6,
-		RatelimitWhitelist:        srvConf.RatelimitWhitelist,
 		RefuseAny:                 srvConf.RefuseAny,
 		TrustedProxies:            netutil.SliceSubnetSet(trustedPrefixes),
 		CacheMinTTL:               srvConf.CacheMinTTL,
@@ -378,8 +426,14 @@ func (s *Server) newProxyConfig() (conf *proxy.Config, err error) {
 		conf.DNSCryptResolverCert = c.ResolverCert
 	}
 
+	cacheSize := srvConf.CacheSize
+	if s.usesRespCache() {
+		// The persistent cache replaces the one of the proxy.
+		cacheSize = 0
+	}
+
 	conf, err = prepareCacheConfig(conf,
-		srvConf.CacheSize,
+		cacheSize,
 		srvConf.CacheMinTTL,
 		srvConf.CacheMaxTTL,
 	)
@@ -9,6 +9,7 @@ import (
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/odoh"
 )
 
 // upstreamConfigValidator parses each section of an upstream configuration into
@@ -69,15 +70,15 @@ func newUpstreamConfigValidator(
 		privateUpstreamResults:  map[string]*upstreamResult{},
 	}
 
-	conf, err := proxy.ParseUpstreamsConfig(general, opts)
+	conf, err := odoh.ParseUpstreamsConfig(general, opts)
 	cv.generalParseResults = collectErrResults(general, err)
 	insertConfResults(conf, cv.generalUpstreamResults)
 
-	conf, err = proxy.ParseUpstreamsConfig(fallback, opts)
+	conf, err = odoh.ParseUpstreamsConfig(fallback, opts)
 	cv.fallbackParseResults = collectErrResults(fallback, err)
 	insertConfResults(conf, cv.fallbackUpstreamResults)
 
-	conf, err = proxy.ParseUpstreamsConfig(private, opts)
+	conf, err = odoh.ParseUpstreamsConfig(private, opts)
 	cv.privateParseResults = collectErrResults(private, err)
 	insertConfResults(conf, cv.privateUpstreamResults)
 
@@ -0,0 +1,249 @@
+package dnsforward
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+	"github.com/tukimoto/AdGuardHome/internal/dnscache"
+)
+
+// usesRespCache returns true if the persistent response cache is used instead
+// of the cache of the proxy.  Since the keys of the persistent cache don't
+// contain the client subnets, it's bypassed while EDNS Client Subnet is
+// enabled.
+func (s *Server) usesRespCache() (ok bool) {
+	ecs := s.conf.EDNSClientSubnet
+
+	return s.respCache != nil && (ecs == nil || !ecs.Enabled)
+}
+
+// respCacheFor returns the persistent response cache for the request in pctx,
+// taking the custom upstream configuration of the client into account.  It
+// returns nil if the persistent cache is disabled or bypassed.
+func (s *Server) respCacheFor(pctx *proxy.DNSContext, clientID string) (c *dnscache.Cache) {
+	if !s.usesRespCache() || s.conf.CacheSize == 0 {
+		return nil
+	}
+
+	if pctx.CustomUpstreamConfig == nil {
+		return s.respCache.General()
+	}
+
+	// Use the ClientID first, since it has a higher priority.
+	id := cmp.Or(clientID, pctx.Addr.Addr().String())
+	cacheID, size, ok := s.conf.ClientsContainer.CustomCacheByID(id)
+	if !ok {
+		// Just like the proxy does, use the general cache for the clients
+		// with custom upstreams but without a custom cache.
+		return s.respCache.General()
+	}
+
+	return s.respCache.Client(cacheID, size)
+}
+
+// replyFromCache sets the response in dctx from c, if there is one.  key is
+// the cache key of the original request.  The expired responses are only used
+// with the optimistic caching, and those are updated in the background.
+func (s *Server) replyFromCache(dctx *dnsContext, c *dnscache.Cache, key string) (ok bool) {
+	pctx := dctx.proxyCtx
+	resp, ups, refresh, ok := c.Get(key, pctx.Req, s.conf.CacheOptimistic)
+	if !ok {
+		return false
+	}
+
+	log.Debug("dnsforward: replying from persistent cache")
+
+	if refresh {
+		go s.refreshCached(newRefreshContext(dctx), c, key)
+	}
+
+	// Adjust the EDNS(0) parameters and the size of the response to the
+	// request, just like the proxy does.
+	resp.Extra = slices.DeleteFunc(resp.Extra, isOPT)
+	size := dns.MinMsgSize
+	if opt := pctx.Req.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), opt.Do())
+		size = max(size, int(opt.UDPSize()))
+	}
+
+	if pctx.Proto != proxy.ProtoUDP {
+		size = dns.MaxMsgSize
+	}
+
+	resp.Truncate(size)
+	resp.Compress = true
+
+	pctx.Res = resp
+	pctx.CachedUpstreamAddr = ups
+
+	return true
+}
+
+// newRefreshContext returns a copy of dctx for resolving its request again in
+// the background.
+func newRefreshContext(dctx *dnsContext) (refresh *dnsContext) {
+	pctx := dctx.proxyCtx
+
+	return &dnsContext{
+		proxyCtx: &proxy.DNSContext{
+			Proto:                pctx.Proto,
+			Req:                  pctx.Req.Copy(),
+			Addr:                 pctx.Addr,
+			CustomUpstreamConfig: pctx.CustomUpstreamConfig,
+			IsPrivateClient:      pctx.IsPrivateClient,
+		},
+		clientID: dctx.clientID,
+	}
+}
+
+// refreshCached resolves the request of dctx, the expired response to which
+// has been served optimistically, and updates the response in c with key.  It
+// is intended to be used as a goroutine.
+func (s *Server) refreshCached(dctx *dnsContext, c *dnscache.Cache, key string) {
+	defer log.OnPanic("dnsforward: refreshing cached response")
+
+	if s.resolve(dctx, c, key) == resultCodeError {
+		log.Debug("dnsforward: refreshing cached response for %q: %s", key, dctx.err)
+	}
+}
+
+// cacheEntryJSON is a single entry of the persistent DNS cache.
+type cacheEntryJSON struct {
+	// CachedAt is the time when the response has been cached.
+	CachedAt time.Time `json:"cached_at"`
+
+	// Client is the name of the client the custom cache of which contains the
+	// entry.  It's empty for the general cache.
+	Client string `json:"client"`
+
+	// Name is the domain name from the question of the response.
+	Name string `json:"name"`
+
+	// Type is the type from the question of the response.
+	Type string `json:"type"`
+
+	// Upstream is the address of the upstream server which has sent the
+	// response.
+	Upstream string `json:"upstream"`
+
+	// TTL is the remaining time to live of the entry in seconds.
+	TTL uint32 `json:"ttl"`
+}
+
+// cacheEntriesJSON is the response for the GET /control/cache_entries HTTP
+// API.
+type cacheEntriesJSON struct {
+	Entries []*cacheEntryJSON `json:"entries"`
+}
+
+// defaultCacheEntriesLimit is the default maximum number of entries returned
+// by the GET /control/cache_entries HTTP API.
+const defaultCacheEntriesLimit = 100
+
+// handleCacheEntries is the handler for the GET /control/cache_entries HTTP
+// API.  The optional "search" query parameter filters the entries by the
+// domain name, and the optional "limit" one limits their number.
+func (s *Server) handleCacheEntries(w http.ResponseWriter, r *http.Request) {
+	if s.respCache == nil {
+		aghhttp.Error(r, w, http.StatusNotImplemented, "persistent dns cache is disabled")
+
+		return
+	}
+
+	q := r.URL.Query()
+	search := strings.ToLower(q.Get("search"))
+
+	limit := defaultCacheEntriesLimit
+	if l := q.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			aghhttp.Error(r, w, http.StatusBadRequest, "bad limit %q", l)
+
+			return
+		}
+	}
+
+	now := time.Now()
+	resp := &cacheEntriesJSON{
+		Entries: []*cacheEntryJSON{},
+	}
+
+	s.respCache.Range(func(id string, c *dnscache.Cache) (cont bool) {
+		for _, e := range c.Entries() {
+			if !strings.Contains(e.Host, search) {
+				continue
+			} else if len(resp.Entries) >= limit {
+				return false
+			}
+
+			resp.Entries = append(resp.Entries, &cacheEntryJSON{
+				CachedAt: e.Stored,
+				Client:   id,
+				Name:     e.Host,
+				Type:     dns.Type(e.QType).String(),
+				Upstream: e.Upstream,
+				TTL:      uint32(e.Expire.Sub(now) / time.Second),
+			})
+		}
+
+		return true
+	})
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// cacheEvictJSON is the request for the POST /control/cache_evict HTTP API.
+type cacheEvictJSON struct {
+	// Name is the domain name to evict the responses for.
+	Name string `json:"name"`
+}
+
+// cacheEvictedJSON is the response for the POST /control/cache_evict HTTP API.
+type cacheEvictedJSON struct {
+	// Evicted is the number of the evicted responses.
+	Evicted int `json:"evicted"`
+}
+
+// handleCacheEvict is the handler for the POST /control/cache_evict HTTP API.
+// It removes the responses for a single domain name from all the caches.
+func (s *Server) handleCacheEvict(w http.ResponseWriter, r *http.Request) {
+	if s.respCache == nil {
+		aghhttp.Error(r, w, http.StatusNotImplemented, "persistent dns cache is disabled")
+
+		return
+	}
+
+	req := &cacheEvictJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	if req.Name == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "name is required")
+
+		return
+	}
+
+	name := dns.Fqdn(req.Name)
+	resp := &cacheEvictedJSON{}
+	s.respCache.Range(func(_ string, c *dnscache.Cache) (cont bool) {
+		resp.Evicted += c.Evict(name)
+
+		return true
+	})
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
@@ -0,0 +1,181 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/dnscache"
+)
+
+// newTestCacheResp returns a request for host and a cacheable response to it.
+func newTestCacheResp(host string) (req, resp *dns.Msg) {
+	req = (&dns.Msg{}).SetQuestion(host, dns.TypeA)
+	resp = (&dns.Msg{}).SetReply(req)
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{
+			Name:   host,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		},
+		A: net.IP{192, 0, 2, 1},
+	}}
+
+	return req, resp
+}
+
+func TestServer_HandleCache(t *testing.T) {
+	const (
+		ups      = "1.2.3.4:53"
+		clientID = "client"
+	)
+
+	g, err := dnscache.NewGroup(&dnscache.GroupConfig{
+		Size: 4096,
+	})
+	require.NoError(t, err)
+
+	reqA, respA := newTestCacheResp("a.example.")
+	reqB, respB := newTestCacheResp("b.example.")
+	g.General().Set(dnscache.Key(reqA), respA, ups, 0, 0)
+	g.General().Set(dnscache.Key(reqB), respB, ups, 0, 0)
+	g.Client(clientID, 1024).Set(dnscache.Key(reqA), respA, ups, 0, 0)
+
+	s := &Server{
+		respCache: g,
+	}
+
+	t.Run("entries", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/control/cache_entries?search=a.ex", nil)
+		w := httptest.NewRecorder()
+		s.handleCacheEntries(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &cacheEntriesJSON{}
+		err = json.NewDecoder(w.Body).Decode(resp)
+		require.NoError(t, err)
+		require.Len(t, resp.Entries, 2)
+
+		assert.Equal(t, "", resp.Entries[0].Client)
+		assert.Equal(t, clientID, resp.Entries[1].Client)
+
+		for _, e := range resp.Entries {
+			assert.Equal(t, "a.example.", e.Name)
+			assert.Equal(t, "A", e.Type)
+			assert.Equal(t, ups, e.Upstream)
+		}
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		body := strings.NewReader(`{"name":"A.example"}`)
+		r := httptest.NewRequest(http.MethodPost, "/control/cache_evict", body)
+		w := httptest.NewRecorder()
+		s.handleCacheEvict(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		resp := &cacheEvictedJSON{}
+		err = json.NewDecoder(w.Body).Decode(resp)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, resp.Evicted)
+
+		_, _, _, ok := g.General().Get(dnscache.Key(reqB), reqB, false)
+		assert.True(t, ok)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/control/cache_entries", nil)
+		w := httptest.NewRecorder()
+		(&Server{}).handleCacheEntries(w, r)
+
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+}
+
+func TestReplyFromCache(t *testing.T) {
+	const ups = "1.2.3.4:53"
+
+	c := dnscache.New(4096)
+	req, resp := newTestCacheResp("www.example.")
+	key := dnscache.Key(req)
+	c.Set(key, resp, ups, 0, 0)
+
+	newReq := (&dns.Msg{}).SetQuestion("WWW.example.", dns.TypeA)
+	pctx := &proxy.DNSContext{
+		Proto: proxy.ProtoUDP,
+		Req:   newReq,
+	}
+	dctx := &dnsContext{
+		proxyCtx: pctx,
+	}
+
+	s := &Server{}
+	require.True(t, s.replyFromCache(dctx, c, dnscache.Key(newReq)))
+	require.NotNil(t, pctx.Res)
+
+	assert.Equal(t, newReq.Id, pctx.Res.Id)
+	assert.Equal(t, ups, pctx.CachedUpstreamAddr)
+	assert.Nil(t, pctx.Res.IsEdns0())
+
+	pctx.Res = nil
+	newReq = (&dns.Msg{}).SetQuestion("other.example.", dns.TypeA)
+	pctx.Req = newReq
+	assert.False(t, s.replyFromCache(dctx, c, dnscache.Key(newReq)))
+	assert.Nil(t, pctx.Res)
+}
+
+func TestServer_respCacheFor(t *testing.T) {
+	g, err := dnscache.NewGroup(&dnscache.GroupConfig{
+		Size: 4096,
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		ecs       *EDNSClientSubnet
+		name      string
+		wantCache bool
+	}{{
+		ecs:       nil,
+		name:      "no_ecs",
+		wantCache: true,
+	}, {
+		ecs:       &EDNSClientSubnet{Enabled: false},
+		name:      "ecs_disabled",
+		wantCache: true,
+	}, {
+		ecs:       &EDNSClientSubnet{Enabled: true},
+		name:      "ecs_enabled",
+		wantCache: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{
+				respCache: g,
+				conf: ServerConfig{
+					Config: Config{
+						CacheSize:        4096,
+						EDNSClientSubnet: tc.ecs,
+					},
+				},
+			}
+
+			c := s.respCacheFor(&proxy.DNSContext{}, "")
+			if tc.wantCache {
+				assert.Same(t, g.General(), c)
+			} else {
+				assert.Nil(t, c)
+			}
+
+			assert.Equal(t, tc.wantCache, s.usesRespCache())
+		})
+	}
+}
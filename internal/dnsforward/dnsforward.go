@@ -28,11 +28,19 @@ import (
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/miekg/dns"
 	"github.com/tukimoto/AdGuardHome/internal/aghnet"
+	"github.com/tukimoto/AdGuardHome/internal/authzone"
 	"github.com/tukimoto/AdGuardHome/internal/client"
+	"github.com/tukimoto/AdGuardHome/internal/dnscache"
+	"github.com/tukimoto/AdGuardHome/internal/dnssec"
+	"github.com/tukimoto/AdGuardHome/internal/dnstap"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/metrics"
+	"github.com/tukimoto/AdGuardHome/internal/odoh"
 	"github.com/tukimoto/AdGuardHome/internal/querylog"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
 	"github.com/tukimoto/AdGuardHome/internal/rdns"
 	"github.com/tukimoto/AdGuardHome/internal/stats"
+	"github.com/tukimoto/AdGuardHome/internal/upstreamhealth"
 )
 
 // DefaultTimeout is the default upstream timeout
@@ -120,9 +128,47 @@ type Server struct {
 	// stats is the statistics collector for client's DNS usage data.
 	stats stats.Interface
 
+	// metrics are the metrics of the processed requests.  It may be nil.
+	metrics *metrics.DNS
+
+	// dnstap sends the dnstap messages of the processed requests.  It may be
+	// nil.
+	dnstap *dnstap.Writer
+
+	// localZones are the zones answered authoritatively.  It may be nil.
+	localZones *authzone.Container
+
+	// respCache is the persistent cache of the responses used instead of the
+	// cache of the proxy.  It may be nil.
+	respCache *dnscache.Group
+
+	// upsRequests are the requests being resolved along with the data used
+	// for selecting the upstreams in the modes implemented by AdGuard Home
+	// itself.
+	upsRequests *upstreamRequests
+
+	// upsHealth monitors the health of the upstream servers.  It is nil if
+	// the monitoring is disabled.
+	upsHealth *upstreamhealth.Monitor
+
+	// dnssecValidator validates the responses from the upstream servers.  It
+	// is nil if the local DNSSEC validation is disabled.
+	dnssecValidator *dnssec.Validator
+
+	// odohTarget decrypts the Oblivious DoH queries.  It is nil if serving
+	// ODoH is disabled.
+	odohTarget *odoh.Target
+
 	// access drops disallowed clients.
 	access *accessManager
 
+	// ratelimiter limits the rates of requests of the clients.
+	ratelimiter *ratelimit.Limiter
+
+	// responseLimiter limits the rates of responses, see
+	// [Server.processResponseRatelimit].
+	responseLimiter *ratelimit.Limiter
+
 	// logger is used for logging during server routines.
 	//
 	// TODO(d.kolyshev): Make it never nil.
@@ -207,6 +253,20 @@ type DNSCreateParams struct {
 	Anonymizer  *aghnet.IPMut
 	EtcHosts    *aghnet.HostsContainer
 
+	// Metrics are the metrics of the DNS server.  It may be nil.
+	Metrics *metrics.DNS
+
+	// Dnstap is the writer of the dnstap messages.  It may be nil.
+	Dnstap *dnstap.Writer
+
+	// LocalZones are the zones answered authoritatively before filtering and
+	// forwarding.  It may be nil.
+	LocalZones *authzone.Container
+
+	// Cache is the persistent cache of the responses used instead of the
+	// cache of the proxy.  It may be nil.
+	Cache *dnscache.Group
+
 	// Logger is used as a base logger.  It must not be nil.
 	Logger *slog.Logger
 
@@ -244,6 +304,12 @@ func NewServer(p DNSCreateParams) (s *Server, err error) {
 		dnsFilter:   p.DNSFilter,
 		dhcpServer:  p.DHCPServer,
 		stats:       p.Stats,
+		metrics:     p.Metrics,
+		dnstap:      p.Dnstap,
+		localZones:  p.LocalZones,
+		respCache:   p.Cache,
+		upsRequests: newUpstreamRequests(),
+		ratelimiter: ratelimit.NewLimiter(ratelimit.DefaultLimiterSize),
 		queryLog:    p.QueryLog,
 		privateNets: p.PrivateNets,
 		logger:      p.Logger.With(slogutil.KeyPrefix, "dnsforward"),
@@ -301,6 +367,7 @@ func (s *Server) WriteDiskConfig(c *Config) {
 	sc := s.conf.Config
 	*c = sc
 	c.RatelimitWhitelist = slices.Clone(sc.RatelimitWhitelist)
+	c.RatelimitTagPolicies = slices.Clone(sc.RatelimitTagPolicies)
 	c.BootstrapDNS = slices.Clone(sc.BootstrapDNS)
 	c.FallbackDNS = slices.Clone(sc.FallbackDNS)
 	c.AllowedClients = slices.Clone(sc.AllowedClients)
@@ -460,6 +527,10 @@ func (s *Server) startLocked() error {
 	err := s.dnsProxy.Start(context.Background())
 	if err == nil {
 		s.isRunning = true
+
+		if s.upsHealth != nil {
+			s.upsHealth.Start()
+		}
 	}
 
 	return err
@@ -481,6 +552,13 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 
 	s.initDefaultSettings()
 
+	err = s.conf.validateRatelimit()
+	if err != nil {
+		return fmt.Errorf("checking ratelimit: %w", err)
+	}
+
+	s.responseLimiter = s.conf.ResponseRatelimit.newLimiter()
+
 	err = s.prepareInternalDNS()
 	if err != nil {
 		// Don't wrap the error, because it's informative enough as is.
@@ -494,6 +572,22 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 
 	s.setupDNS64()
 
+	if s.respCache != nil {
+		s.respCache.General().Resize(int(s.conf.CacheSize))
+	}
+
+	err = s.prepareDNSSECValidator()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	err = s.prepareODoHTarget()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
 	s.access, err = newAccessCtx(
 		s.conf.AllowedClients,
 		s.conf.DisallowedClients,
@@ -550,6 +644,21 @@ func (s *Server) prepareUpstreamSettings(boot upstream.Resolver) (err error) {
 		return fmt.Errorf("preparing upstream config: %w", err)
 	}
 
+	if s.metrics != nil {
+		wrapUpstreams(uc, func(u upstream.Upstream) (wrapped upstream.Upstream) {
+			return &metricsUpstream{Upstream: u, metrics: s.metrics}
+		})
+	}
+
+	// Wrap the upstreams with the health monitor after the metrics, so that
+	// the requests to the upstreams out of rotation aren't counted as
+	// upstream errors.
+	s.prepareUpstreamHealth(uc)
+
+	// Selecting upstreams must be the outermost ones, since they replace the
+	// lists of the upstreams.
+	s.ApplyUpstreamMode(uc, s.conf.UpstreamMode)
+
 	s.conf.UpstreamConfig = uc
 
 	return nil
@@ -660,7 +769,7 @@ func (s *Server) setupFallbackDNS() (uc *proxy.UpstreamConfig, err error) {
 		return nil, nil
 	}
 
-	uc, err = proxy.ParseUpstreamsConfig(fallbacks, &upstream.Options{
+	uc, err = odoh.ParseUpstreamsConfig(fallbacks, &upstream.Options{
 		// TODO(s.chzhen):  Investigate if other options are needed.
 		Timeout:    s.conf.UpstreamTimeout,
 		PreferIPv6: s.conf.BootstrapPreferIPv6,
@@ -771,6 +880,11 @@ func (s *Server) stopLocked() {
 	// This will require filtering all the non-critical errors in
 	// [upstream.Upstream] implementations.
 
+	// Stop probing before the upstreams are closed.
+	if s.upsHealth != nil {
+		s.upsHealth.Stop()
+	}
+
 	if s.dnsProxy != nil {
 		// TODO(e.burkov):  Use context properly.
 		err := s.dnsProxy.Shutdown(context.Background())
@@ -35,6 +35,7 @@ import (
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
 	"github.com/tukimoto/AdGuardHome/internal/filtering/hashprefix"
 	"github.com/tukimoto/AdGuardHome/internal/filtering/safesearch"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
 )
 
 func TestMain(m *testing.M) {
@@ -715,6 +716,11 @@ func TestServerCustomClientUpstream(t *testing.T) {
 		) (conf *proxy.CustomUpstreamConfig, err error) {
 			return customUpsConf, nil
 		},
+		OnRatelimitByID: func(
+			_ string,
+		) (name string, tags []string, p *ratelimit.Policy, ok bool) {
+			return "", nil, nil, false
+		},
 	}
 
 	startDeferStop(t, s)
@@ -0,0 +1,142 @@
+package dnsforward
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/dnssec"
+)
+
+// dnssecCacheSize is the maximum number of zones the validated key material of
+// which is cached.
+const dnssecCacheSize = 1000
+
+// prepareDNSSECValidator initializes the local DNSSEC validator if it's
+// enabled.  It assumes s.serverLock is locked or the Server not running.
+func (s *Server) prepareDNSSECValidator() (err error) {
+	if !s.conf.EnableDNSSEC || !s.conf.DNSSECValidation {
+		s.dnssecValidator = nil
+
+		return nil
+	}
+
+	s.dnssecValidator, err = dnssec.New(&dnssec.Config{
+		Exchanger:    &dnssecExchanger{srv: s},
+		TrustAnchors: s.conf.DNSSECTrustAnchors,
+		CacheSize:    dnssecCacheSize,
+	})
+	if err != nil {
+		return fmt.Errorf("preparing dnssec validator: %w", err)
+	}
+
+	return nil
+}
+
+// dnssecExchanger is the [dnssec.Exchanger] sending the requests through the
+// primary DNS proxy of the server.
+type dnssecExchanger struct {
+	srv *Server
+}
+
+// type check
+var _ dnssec.Exchanger = (*dnssecExchanger)(nil)
+
+// Exchange implements the [dnssec.Exchanger] interface for *dnssecExchanger.
+// Each exchange is bounded by the upstream timeout, so ctx is only checked
+// before sending req.
+func (e *dnssecExchanger) Exchange(ctx context.Context, req *dns.Msg) (resp *dns.Msg, err error) {
+	err = ctx.Err()
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return nil, err
+	}
+
+	prx := e.srv.proxy()
+	if prx == nil {
+		return nil, srvClosedErr
+	}
+
+	dctx := &proxy.DNSContext{
+		Proto:           proxy.ProtoUDP,
+		Req:             req,
+		IsPrivateClient: true,
+	}
+
+	err = prx.Resolve(dctx)
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return nil, err
+	}
+
+	return dctx.Res, nil
+}
+
+// setReqDO sets the DO bit in req, so that the upstream servers return the
+// records required for the validation.  hadOPT and hadDO describe the original
+// request and must be passed to [Server.validateResp].
+func setReqDO(req *dns.Msg) (hadOPT, hadDO bool) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+
+		return false, false
+	}
+
+	hadDO = opt.Do()
+	opt.SetDo()
+
+	return true, hadDO
+}
+
+// validateResp validates the response from the upstream servers, replaces the
+// bogus ones with SERVFAIL, and restores the state of the request changed by
+// [setReqDO].
+func (s *Server) validateResp(dctx *dnsContext, hadOPT, hadDO bool) {
+	pctx := dctx.proxyCtx
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.UpstreamTimeout)
+	defer cancel()
+
+	st, err := s.dnssecValidator.Validate(ctx, pctx.Res)
+	if err != nil {
+		log.Debug("dnsforward: dnssec: %s", err)
+	}
+
+	dctx.dnssecStatus = st
+	if st == dnssec.StatusBogus {
+		pctx.Res = s.NewMsgSERVFAIL(pctx.Req)
+	} else {
+		pctx.Res.AuthenticatedData = st == dnssec.StatusSecure
+	}
+
+	dctx.responseAD = pctx.Res.AuthenticatedData
+	if hadDO {
+		return
+	}
+
+	for _, msg := range []*dns.Msg{pctx.Req, pctx.Res} {
+		if !hadOPT {
+			msg.Extra = slices.DeleteFunc(msg.Extra, isOPT)
+		} else if opt := msg.IsEdns0(); opt != nil {
+			opt.SetDo(false)
+		}
+	}
+
+	pctx.Res.Answer = slices.DeleteFunc(pctx.Res.Answer, isDNSSECRR)
+	pctx.Res.Ns = slices.DeleteFunc(pctx.Res.Ns, isDNSSECRR)
+	pctx.Res.Extra = slices.DeleteFunc(pctx.Res.Extra, isDNSSECRR)
+}
+
+// isOPT returns true if rr is an OPT pseudo-record.
+func isOPT(rr dns.RR) (ok bool) {
+	return rr.Header().Rrtype == dns.TypeOPT
+}
+
+// isDNSSECRR returns true if rr is only useful for the DNSSEC-aware clients.
+func isDNSSECRR(rr dns.RR) (ok bool) {
+	return dnssec.IsDNSSECType(rr.Header().Rrtype)
+}
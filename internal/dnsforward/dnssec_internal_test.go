@@ -0,0 +1,137 @@
+package dnsforward
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/dnssec"
+)
+
+// errExchanger is a [dnssec.Exchanger] which always fails.
+type errExchanger struct{}
+
+// type check
+var _ dnssec.Exchanger = errExchanger{}
+
+// Exchange implements the [dnssec.Exchanger] interface for errExchanger.
+func (errExchanger) Exchange(_ context.Context, _ *dns.Msg) (_ *dns.Msg, err error) {
+	return nil, errors.Error("test error")
+}
+
+func TestServer_ValidateResp(t *testing.T) {
+	// Only trust the zone "example.", so that the names outside of it are
+	// insecure without any requests.
+	v, err := dnssec.New(&dnssec.Config{
+		Exchanger: errExchanger{},
+		TrustAnchors: []string{
+			"example. 3600 IN DS 12345 13 2 " +
+				"0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		CacheSize: 1,
+	})
+	require.NoError(t, err)
+
+	s := &Server{
+		dnssecValidator: v,
+	}
+
+	sig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name:   "www.other.",
+			Rrtype: dns.TypeRRSIG,
+			Class:  dns.ClassINET,
+		},
+		TypeCovered: dns.TypeA,
+	}
+
+	testCases := []struct {
+		name      string
+		host      string
+		withOPT   bool
+		withDO    bool
+		wantRcode int
+		wantSt    dnssec.Status
+		wantSig   bool
+	}{{
+		name:      "insecure_no_opt",
+		host:      "www.other.",
+		withOPT:   false,
+		withDO:    false,
+		wantRcode: dns.RcodeSuccess,
+		wantSt:    dnssec.StatusInsecure,
+		wantSig:   false,
+	}, {
+		name:      "insecure_do",
+		host:      "www.other.",
+		withOPT:   true,
+		withDO:    true,
+		wantRcode: dns.RcodeSuccess,
+		wantSt:    dnssec.StatusInsecure,
+		wantSig:   true,
+	}, {
+		name:      "bogus",
+		host:      "www.example.",
+		withOPT:   true,
+		withDO:    false,
+		wantRcode: dns.RcodeServerFailure,
+		wantSt:    dnssec.StatusBogus,
+		wantSig:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := (&dns.Msg{}).SetQuestion(tc.host, dns.TypeA)
+			if tc.withOPT {
+				req.SetEdns0(dns.DefaultMsgSize, tc.withDO)
+			}
+
+			hadOPT, hadDO := setReqDO(req)
+			require.Equal(t, tc.withOPT, hadOPT)
+			require.Equal(t, tc.withDO, hadDO)
+			require.True(t, req.IsEdns0().Do())
+
+			resp := (&dns.Msg{}).SetReply(req)
+			resp.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{
+					Name:   tc.host,
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET,
+				},
+			}, sig}
+			resp.SetEdns0(dns.DefaultMsgSize, true)
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req: req,
+					Res: resp,
+				},
+			}
+
+			s.validateResp(dctx, hadOPT, hadDO)
+			assert.Equal(t, tc.wantSt, dctx.dnssecStatus)
+			assert.False(t, dctx.responseAD)
+
+			res := dctx.proxyCtx.Res
+			require.NotNil(t, res)
+
+			assert.Equal(t, tc.wantRcode, res.Rcode)
+			assert.Equal(t, tc.wantSig, len(res.Answer) == 2)
+
+			opt := req.IsEdns0()
+			if !tc.withOPT {
+				assert.Nil(t, opt)
+
+				return
+			}
+
+			require.NotNil(t, opt)
+
+			assert.Equal(t, tc.withDO, opt.Do())
+		})
+	}
+}
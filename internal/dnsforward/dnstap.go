@@ -0,0 +1,135 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/dnstap"
+)
+
+// tapClient sends the CLIENT_QUERY and CLIENT_RESPONSE dnstap messages for the
+// processed request.  ip is the client's IP address, which is anonymized if
+// needed.
+func (s *Server) tapClient(dctx *dnsContext, ip net.IP) {
+	pctx := dctx.proxyCtx
+
+	addr, _ := netip.AddrFromSlice(ip)
+	clientAddr := netip.AddrPortFrom(addr.Unmap(), pctx.Addr.Port())
+	proto := socketProtocol(pctx)
+	req := packMsg(pctx.Req)
+
+	s.dnstap.Write(&dnstap.Message{
+		QueryTime:      dctx.startTime,
+		QueryAddr:      clientAddr,
+		QueryMessage:   req,
+		Type:           dnstap.MessageTypeClientQuery,
+		SocketProtocol: proto,
+	})
+
+	if pctx.Res == nil {
+		return
+	}
+
+	s.dnstap.Write(&dnstap.Message{
+		QueryTime:       dctx.startTime,
+		ResponseTime:    time.Now(),
+		QueryAddr:       clientAddr,
+		QueryMessage:    req,
+		ResponseMessage: packMsg(pctx.Res),
+		Type:            dnstap.MessageTypeClientResponse,
+		SocketProtocol:  proto,
+	})
+}
+
+// tapForwarder sends the FORWARDER_QUERY and FORWARDER_RESPONSE dnstap
+// messages for the request resolved by an upstream server.  start is the time
+// at which the request has been sent to the upstream.
+func (s *Server) tapForwarder(pctx *proxy.DNSContext, start time.Time) {
+	upsAddr := upstreamAddrPort(pctx.Upstream.Address())
+	req := packMsg(pctx.Req)
+
+	s.dnstap.Write(&dnstap.Message{
+		QueryTime:    start,
+		ResponseAddr: upsAddr,
+		QueryMessage: req,
+		Type:         dnstap.MessageTypeForwarderQuery,
+	})
+
+	s.dnstap.Write(&dnstap.Message{
+		QueryTime:       start,
+		ResponseTime:    start.Add(pctx.QueryDuration),
+		ResponseAddr:    upsAddr,
+		QueryMessage:    req,
+		ResponseMessage: packMsg(pctx.Res),
+		Type:            dnstap.MessageTypeForwarderResponse,
+	})
+}
+
+// packMsg returns the wire format of msg or nil if it can't be packed.
+func packMsg(msg *dns.Msg) (b []byte) {
+	if msg == nil {
+		return nil
+	}
+
+	b, err := msg.Pack()
+	if err != nil {
+		log.Debug("dnsforward: dnstap: packing message: %s", err)
+
+		return nil
+	}
+
+	return b
+}
+
+// socketProtocol returns the dnstap socket protocol of the request.
+func socketProtocol(pctx *proxy.DNSContext) (p dnstap.SocketProtocol) {
+	switch pctx.Proto {
+	case proxy.ProtoUDP:
+		return dnstap.SocketProtocolUDP
+	case proxy.ProtoTCP:
+		return dnstap.SocketProtocolTCP
+	case proxy.ProtoTLS:
+		return dnstap.SocketProtocolDOT
+	case proxy.ProtoHTTPS:
+		return dnstap.SocketProtocolDOH
+	case proxy.ProtoQUIC:
+		return dnstap.SocketProtocolDOQ
+	case proxy.ProtoDNSCrypt:
+		if rw := pctx.DNSCryptResponseWriter; rw != nil {
+			if _, ok := rw.RemoteAddr().(*net.TCPAddr); ok {
+				return dnstap.SocketProtocolDNSCryptTCP
+			}
+		}
+
+		return dnstap.SocketProtocolDNSCryptUDP
+	default:
+		return 0
+	}
+}
+
+// upstreamAddrPort returns the IP address and port of the upstream with the
+// given address, if the address contains an IP address.  Otherwise, it returns
+// an invalid value.
+func upstreamAddrPort(addr string) (ap netip.AddrPort) {
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return netip.AddrPort{}
+		}
+
+		addr = u.Host
+	}
+
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return netip.AddrPort{}
+	}
+
+	return ap
+}
@@ -0,0 +1,42 @@
+package dnsforward
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamAddrPort(t *testing.T) {
+	testCases := []struct {
+		want netip.AddrPort
+		name string
+		addr string
+	}{{
+		want: netip.MustParseAddrPort("1.2.3.4:53"),
+		name: "plain",
+		addr: "1.2.3.4:53",
+	}, {
+		want: netip.MustParseAddrPort("1.2.3.4:53"),
+		name: "udp",
+		addr: "udp://1.2.3.4:53",
+	}, {
+		want: netip.MustParseAddrPort("[2001:db8::1]:853"),
+		name: "tls_ipv6",
+		addr: "tls://[2001:db8::1]:853",
+	}, {
+		want: netip.AddrPort{},
+		name: "hostname",
+		addr: "https://dns.example:443/dns-query",
+	}, {
+		want: netip.AddrPort{},
+		name: "bad",
+		addr: "bad",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, upstreamAddrPort(tc.addr))
+		})
+	}
+}
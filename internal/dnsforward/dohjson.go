@@ -0,0 +1,302 @@
+package dnsforward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+)
+
+// dohJSONContentType is the media type of the JSON DNS API responses.
+const dohJSONContentType = "application/dns-json"
+
+// dohJSONUDPSize is the UDP payload size advertised in the JSON DNS API
+// requests with the DO bit set.
+const dohJSONUDPSize = 4096
+
+// isDoHJSONRequest returns true if r is a request to the JSON DNS API, which
+// is a GET request with the "name" parameter.
+func isDoHJSONRequest(r *http.Request) (ok bool) {
+	return r.Method == http.MethodGet && r.URL.Query().Has("name")
+}
+
+// dohJSONQuestion is a question in the JSON DNS API response.
+type dohJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+// dohJSONRR is a resource record in the JSON DNS API response.
+type dohJSONRR struct {
+	// Name is the owner name of the record.
+	Name string `json:"name"`
+
+	// Data is the data of the record in the presentation format.
+	Data string `json:"data"`
+
+	// TTL is the time to live of the record in seconds.
+	TTL uint32 `json:"TTL"`
+
+	// Type is the type of the record.
+	Type uint16 `json:"type"`
+}
+
+// dohJSONRule is a filtering rule applied to the request.
+type dohJSONRule struct {
+	// Text is the text of the rule.
+	Text string `json:"text"`
+
+	// FilterListID is the ID of the filter list containing the rule.
+	FilterListID rulelist.URLFilterID `json:"filter_list_id"`
+}
+
+// dohJSONFiltering is the result of filtering of the request.
+type dohJSONFiltering struct {
+	// Reason is the reason of the filtering result, the same as in the query
+	// log.
+	Reason string `json:"reason"`
+
+	// ServiceName is the name of the blocked service, if any.
+	ServiceName string `json:"service_name,omitempty"`
+
+	// Rules are the rules applied to the request.
+	Rules []*dohJSONRule `json:"rules,omitempty"`
+
+	// Blocked is true if the request has been blocked.
+	Blocked bool `json:"blocked"`
+}
+
+// dohJSONResponse is the response of the JSON DNS API.  The fields besides
+// Filtering are compatible with the JSON APIs of Google and Cloudflare.
+type dohJSONResponse struct {
+	// Filtering is the result of filtering of the request.
+	Filtering *dohJSONFiltering `json:"Filtering"`
+
+	Question   []*dohJSONQuestion `json:"Question"`
+	Answer     []*dohJSONRR       `json:"Answer,omitempty"`
+	Authority  []*dohJSONRR       `json:"Authority,omitempty"`
+	Additional []*dohJSONRR       `json:"Additional,omitempty"`
+
+	Status int  `json:"Status"`
+	TC     bool `json:"TC"`
+	RD     bool `json:"RD"`
+	RA     bool `json:"RA"`
+	AD     bool `json:"AD"`
+	CD     bool `json:"CD"`
+}
+
+// dohJSONResultKey is the key of the *filtering.Result in the context of the
+// DoH requests made by the JSON DNS API handler.
+type dohJSONResultKey struct{}
+
+// dohJSONResult returns the filtering result to fill for the request r, if it
+// has been made by the JSON DNS API handler.
+func dohJSONResult(r *http.Request) (res *filtering.Result, ok bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	res, ok = r.Context().Value(dohJSONResultKey{}).(*filtering.Result)
+
+	return res, ok
+}
+
+// handleDoHJSON is the handler for the JSON DNS API requests to the DoH
+// endpoint.  The query is processed just like a DoH one, so that the filtering
+// and the ClientID apply to it.
+func (s *Server) handleDoHJSON(w http.ResponseWriter, r *http.Request) {
+	req, err := newDoHJSONRequest(r)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	packed, err := req.Pack()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "packing request: %s", err)
+
+		return
+	}
+
+	res := &filtering.Result{}
+	dohReq := r.Clone(context.WithValue(r.Context(), dohJSONResultKey{}, res))
+	dohReq.Method = http.MethodPost
+	dohReq.URL.RawQuery = ""
+	dohReq.Body = io.NopCloser(bytes.NewReader(packed))
+	dohReq.ContentLength = int64(len(packed))
+	dohReq.Header.Set(httphdr.ContentType, "application/dns-message")
+
+	rw := &bufferResponseWriter{
+		header: http.Header{},
+		code:   http.StatusOK,
+	}
+
+	s.ServeHTTP(rw, dohReq)
+	if rw.code != http.StatusOK {
+		aghhttp.Error(r, w, rw.code, "resolving query: %s", bytes.TrimSpace(rw.body.Bytes()))
+
+		return
+	}
+
+	resp := &dns.Msg{}
+	err = resp.Unpack(rw.body.Bytes())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "unpacking response: %s", err)
+
+		return
+	}
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, dohJSONContentType)
+	h.Set(httphdr.Server, aghhttp.UserAgent())
+
+	err = json.NewEncoder(w).Encode(newDoHJSONResponse(resp, res))
+	if err != nil {
+		log.Debug("dnsforward: writing json dns response: %s", err)
+	}
+}
+
+// newDoHJSONRequest returns the DNS request from the parameters of the JSON
+// DNS API request r.
+func newDoHJSONRequest(r *http.Request) (req *dns.Msg, err error) {
+	q := r.URL.Query()
+
+	name := q.Get("name")
+	if _, ok := dns.IsDomainName(name); !ok || name == "" {
+		return nil, errBadParam("name", name)
+	}
+
+	qtype := dns.TypeA
+	if t := q.Get("type"); t != "" {
+		qtype, err = parseDoHJSONType(t)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return nil, err
+		}
+	}
+
+	do, err := parseDoHJSONFlag(q.Get("do"), "do")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	cd, err := parseDoHJSONFlag(q.Get("cd"), "cd")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	req = (&dns.Msg{}).SetQuestion(dns.Fqdn(name), qtype)
+	req.CheckingDisabled = cd
+	if do {
+		req.SetEdns0(dohJSONUDPSize, true)
+	}
+
+	return req, nil
+}
+
+// parseDoHJSONType parses the value of the "type" parameter, which is either
+// a number or a mnemonic of the type.
+func parseDoHJSONType(s string) (qtype uint16, err error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err == nil {
+		return uint16(n), nil
+	}
+
+	qtype, ok := dns.StringToType[strings.ToUpper(s)]
+	if !ok {
+		return 0, errBadParam("type", s)
+	}
+
+	return qtype, nil
+}
+
+// parseDoHJSONFlag parses the value of the boolean parameter with the given
+// name.  An empty value is false.
+func parseDoHJSONFlag(s, name string) (ok bool, err error) {
+	switch strings.ToLower(s) {
+	case "", "0", "false":
+		return false, nil
+	case "1", "true":
+		return true, nil
+	default:
+		return false, errBadParam(name, s)
+	}
+}
+
+// errBadParam returns an error about the bad value val of the parameter with
+// the given name.
+func errBadParam(name, val string) (err error) {
+	return fmt.Errorf("bad %s parameter %q", name, val)
+}
+
+// newDoHJSONResponse returns the JSON DNS API response for the DNS response
+// resp with the filtering result res.
+func newDoHJSONResponse(resp *dns.Msg, res *filtering.Result) (jsonResp *dohJSONResponse) {
+	jsonResp = &dohJSONResponse{
+		Filtering: &dohJSONFiltering{
+			Reason:      res.Reason.String(),
+			ServiceName: res.ServiceName,
+			Blocked:     res.IsFiltered,
+		},
+		Question:   make([]*dohJSONQuestion, 0, len(resp.Question)),
+		Answer:     newDoHJSONRRs(resp.Answer),
+		Authority:  newDoHJSONRRs(resp.Ns),
+		Additional: newDoHJSONRRs(resp.Extra),
+		Status:     resp.Rcode,
+		TC:         resp.Truncated,
+		RD:         resp.RecursionDesired,
+		RA:         resp.RecursionAvailable,
+		AD:         resp.AuthenticatedData,
+		CD:         resp.CheckingDisabled,
+	}
+
+	for _, q := range resp.Question {
+		jsonResp.Question = append(jsonResp.Question, &dohJSONQuestion{
+			Name: q.Name,
+			Type: q.Qtype,
+		})
+	}
+
+	for _, rule := range res.Rules {
+		jsonResp.Filtering.Rules = append(jsonResp.Filtering.Rules, &dohJSONRule{
+			Text:         rule.Text,
+			FilterListID: rule.FilterListID,
+		})
+	}
+
+	return jsonResp
+}
+
+// newDoHJSONRRs returns the JSON DNS API representation of rrs except for the
+// OPT pseudo-records.
+func newDoHJSONRRs(rrs []dns.RR) (jsonRRs []*dohJSONRR) {
+	for _, rr := range rrs {
+		if isOPT(rr) {
+			continue
+		}
+
+		hdr := rr.Header()
+		jsonRRs = append(jsonRRs, &dohJSONRR{
+			Name: hdr.Name,
+			Data: strings.TrimPrefix(rr.String(), hdr.String()),
+			TTL:  hdr.Ttl,
+			Type: hdr.Rrtype,
+		})
+	}
+
+	return jsonRRs
+}
@@ -0,0 +1,172 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+)
+
+func TestServer_HandleDoHJSON(t *testing.T) {
+	s := createTestServer(t, &filtering.Config{
+		ProtectionEnabled: true,
+		BlockingMode:      filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+		},
+		TLSAllowUnencryptedDoH: true,
+		ServePlainDNS:          true,
+	})
+	startDeferStop(t, s)
+
+	testCases := []struct {
+		wantFiltering *dohJSONFiltering
+		name          string
+		query         string
+		wantData      string
+		wantCode      int
+	}{{
+		wantFiltering: &dohJSONFiltering{
+			Reason: filtering.FilteredBlockList.String(),
+			Rules: []*dohJSONRule{{
+				Text: "||nxdomain.example.org",
+			}},
+			Blocked: true,
+		},
+		name:     "blocked",
+		query:    "name=nxdomain.example.org&type=A",
+		wantData: "0.0.0.0",
+		wantCode: http.StatusOK,
+	}, {
+		wantFiltering: &dohJSONFiltering{
+			Reason: filtering.FilteredBlockList.String(),
+			Rules: []*dohJSONRule{{
+				Text: "127.0.0.1\thost.example.org",
+			}},
+			Blocked: true,
+		},
+		name:     "hosts_rule",
+		query:    "name=host.example.org&type=1&do=1&cd=false",
+		wantData: "127.0.0.1",
+		wantCode: http.StatusOK,
+	}, {
+		wantFiltering: nil,
+		name:          "bad_type",
+		query:         "name=example.org&type=BAD",
+		wantData:      "",
+		wantCode:      http.StatusBadRequest,
+	}, {
+		wantFiltering: nil,
+		name:          "bad_do",
+		query:         "name=example.org&do=2",
+		wantData:      "",
+		wantCode:      http.StatusBadRequest,
+	}, {
+		wantFiltering: nil,
+		name:          "empty_name",
+		query:         "name=",
+		wantData:      "",
+		wantCode:      http.StatusBadRequest,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/dns-query?"+tc.query, nil)
+			w := httptest.NewRecorder()
+			s.handleDoH(w, r)
+
+			require.Equal(t, tc.wantCode, w.Code)
+
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, dohJSONContentType, w.Header().Get("Content-Type"))
+
+			resp := &dohJSONResponse{}
+			err := json.NewDecoder(w.Body).Decode(resp)
+			require.NoError(t, err)
+
+			assert.Equal(t, dns.RcodeSuccess, resp.Status)
+			assert.Equal(t, tc.wantFiltering, resp.Filtering)
+
+			require.Len(t, resp.Question, 1)
+			require.Len(t, resp.Answer, 1)
+
+			assert.Equal(t, resp.Question[0].Name, resp.Answer[0].Name)
+			assert.Equal(t, dns.TypeA, resp.Answer[0].Type)
+			assert.Equal(t, tc.wantData, resp.Answer[0].Data)
+		})
+	}
+}
+
+func TestNewDoHJSONRequest(t *testing.T) {
+	testCases := []struct {
+		name       string
+		query      string
+		wantErrMsg string
+		wantType   uint16
+		wantDO     bool
+		wantCD     bool
+	}{{
+		name:       "defaults",
+		query:      "name=example.org",
+		wantErrMsg: "",
+		wantType:   dns.TypeA,
+		wantDO:     false,
+		wantCD:     false,
+	}, {
+		name:       "mnemonic",
+		query:      "name=example.org&type=aaaa&do=true&cd=1",
+		wantErrMsg: "",
+		wantType:   dns.TypeAAAA,
+		wantDO:     true,
+		wantCD:     true,
+	}, {
+		name:       "number",
+		query:      "name=example.org.&type=65",
+		wantErrMsg: "",
+		wantType:   dns.TypeHTTPS,
+		wantDO:     false,
+		wantCD:     false,
+	}, {
+		name:       "bad_cd",
+		query:      "name=example.org&cd=yes",
+		wantErrMsg: `bad cd parameter "yes"`,
+	}, {
+		name:       "bad_name",
+		query:      "name=a..b",
+		wantErrMsg: `bad name parameter "a..b"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/dns-query?"+tc.query, nil)
+			req, err := newDoHJSONRequest(r)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			require.Len(t, req.Question, 1)
+
+			assert.Equal(t, "example.org.", req.Question[0].Name)
+			assert.Equal(t, tc.wantType, req.Question[0].Qtype)
+			assert.Equal(t, tc.wantCD, req.CheckingDisabled)
+
+			opt := req.IsEdns0()
+			assert.Equal(t, tc.wantDO, opt != nil && opt.Do())
+		})
+	}
+}
@@ -21,6 +21,11 @@ func (s *Server) clientRequestFilteringSettings(dctx *dnsContext) (setts *filter
 		s.conf.FilterHandler(dctx.proxyCtx.Addr.Addr(), dctx.clientID, setts)
 	}
 
+	// Apply the schedule policies after the client settings, since they
+	// depend on the client's name and tags and replace its settings.
+	s.dnsFilter.ApplySchedulePolicies(setts, dctx.startTime)
+	dctx.protectionEnabled = setts.ProtectionEnabled
+
 	return setts
 }
 
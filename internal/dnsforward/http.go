@@ -13,11 +13,13 @@ import (
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/odoh"
 )
 
 // jsonDNSConfig is the JSON representation of the DNS server configuration.
@@ -126,9 +128,11 @@ const (
 	// Deprecated: Use jsonUpstreamModeLoadBalance instead.
 	jsonUpstreamModeEmpty jsonUpstreamMode = ""
 
-	jsonUpstreamModeLoadBalance jsonUpstreamMode = "load_balance"
-	jsonUpstreamModeParallel    jsonUpstreamMode = "parallel"
-	jsonUpstreamModeFastestAddr jsonUpstreamMode = "fastest_addr"
+	jsonUpstreamModeLoadBalance     jsonUpstreamMode = "load_balance"
+	jsonUpstreamModeParallel        jsonUpstreamMode = "parallel"
+	jsonUpstreamModeFastestAddr     jsonUpstreamMode = "fastest_addr"
+	jsonUpstreamModeLatencyWeighted jsonUpstreamMode = "latency_weighted"
+	jsonUpstreamModeSticky          jsonUpstreamMode = "sticky"
 )
 
 func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
@@ -172,6 +176,10 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 		upstreamMode = jsonUpstreamModeParallel
 	case UpstreamModeFastestAddr:
 		upstreamMode = jsonUpstreamModeFastestAddr
+	case UpstreamModeLatencyWeighted:
+		upstreamMode = jsonUpstreamModeLatencyWeighted
+	case UpstreamModeSticky:
+		upstreamMode = jsonUpstreamModeSticky
 	}
 
 	defPTRUps, err := s.defaultLocalPTRUpstreams()
@@ -255,7 +263,9 @@ func (req *jsonDNSConfig) checkUpstreamMode() (err error) {
 		jsonUpstreamModeEmpty,
 		jsonUpstreamModeLoadBalance,
 		jsonUpstreamModeParallel,
-		jsonUpstreamModeFastestAddr:
+		jsonUpstreamModeFastestAddr,
+		jsonUpstreamModeLatencyWeighted,
+		jsonUpstreamModeSticky:
 		return nil
 	default:
 		return fmt.Errorf("upstream_mode: incorrect value %q", um)
@@ -372,7 +382,7 @@ func (req *jsonDNSConfig) validateUpstreamDNSServers(
 	opts := &upstream.Options{}
 
 	if req.Upstreams != nil {
-		uc, err = proxy.ParseUpstreamsConfig(*req.Upstreams, opts)
+		uc, err = odoh.ParseUpstreamsConfig(*req.Upstreams, opts)
 		err = errors.WithDeferred(err, uc.Close())
 		if err != nil {
 			return fmt.Errorf("upstream servers: %w", err)
@@ -392,7 +402,7 @@ func (req *jsonDNSConfig) validateUpstreamDNSServers(
 	}
 
 	if req.Fallbacks != nil {
-		uc, err = proxy.ParseUpstreamsConfig(*req.Fallbacks, opts)
+		uc, err = odoh.ParseUpstreamsConfig(*req.Fallbacks, opts)
 		err = errors.WithDeferred(err, uc.Close())
 		if err != nil {
 			return fmt.Errorf("fallback servers: %w", err)
@@ -534,6 +544,10 @@ func mustParseUpstreamMode(mode jsonUpstreamMode) (um UpstreamMode) {
 		return UpstreamModeParallel
 	case jsonUpstreamModeFastestAddr:
 		return UpstreamModeFastestAddr
+	case jsonUpstreamModeLatencyWeighted:
+		return UpstreamModeLatencyWeighted
+	case jsonUpstreamModeSticky:
+		return UpstreamModeSticky
 	default:
 		// Should never happen, since the value should be validated.
 		panic(fmt.Errorf("unexpected upstream mode: %q", mode))
@@ -643,6 +657,10 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 // handleCacheClear is the handler for the POST /control/cache_clear HTTP API.
 func (s *Server) handleCacheClear(w http.ResponseWriter, _ *http.Request) {
 	s.dnsProxy.ClearCache()
+	if s.respCache != nil {
+		s.respCache.Clear()
+	}
+
 	_, _ = io.WriteString(w, "OK")
 }
 
@@ -714,6 +732,16 @@ func (s *Server) handleDoH(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Header.Get(httphdr.ContentType) == odoh.ContentType {
+		s.handleODoH(w, r)
+
+		return
+	} else if isDoHJSONRequest(r) {
+		s.handleDoHJSON(w, r)
+
+		return
+	}
+
 	s.ServeHTTP(w, r)
 }
 
@@ -731,6 +759,10 @@ func (s *Server) registerHandlers() {
 	s.conf.HTTPRegister(http.MethodPost, "/control/access/set", s.handleAccessSet)
 
 	s.conf.HTTPRegister(http.MethodPost, "/control/cache_clear", s.handleCacheClear)
+	s.conf.HTTPRegister(http.MethodGet, "/control/cache_entries", s.handleCacheEntries)
+	s.conf.HTTPRegister(http.MethodPost, "/control/cache_evict", s.handleCacheEvict)
+
+	s.conf.HTTPRegister(http.MethodGet, "/control/upstreams/status", s.handleUpstreamsStatus)
 
 	// Register both versions, with and without the trailing slash, to
 	// prevent a 301 Moved Permanently redirect when clients request the
@@ -741,6 +773,7 @@ func (s *Server) registerHandlers() {
 	// See also https://github.com/tukimoto/AdGuardHome/issues/2628.
 	s.conf.HTTPRegister("", "/dns-query", s.handleDoH)
 	s.conf.HTTPRegister("", "/dns-query/", s.handleDoH)
+	s.conf.HTTPRegister("", odoh.ConfigsPath, s.handleODoHConfigs)
 
 	webRegistered = true
 }
@@ -344,6 +344,15 @@ func (s *Server) makeResponseREFUSED(req *dns.Msg) *dns.Msg {
 	return s.reply(req, dns.RcodeRefused)
 }
 
+// makeResponseTruncated returns an empty truncated response for req to make
+// the client retry the request over TCP.
+func (s *Server) makeResponseTruncated(req *dns.Msg) (resp *dns.Msg) {
+	resp = s.reply(req, dns.RcodeSuccess)
+	resp.Truncated = true
+
+	return resp
+}
+
 // type check
 var _ proxy.MessageConstructor = (*Server)(nil)
 
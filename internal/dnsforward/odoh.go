@@ -0,0 +1,177 @@
+package dnsforward
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+	"github.com/tukimoto/AdGuardHome/internal/odoh"
+)
+
+// maxODoHMessageSize is the maximum size of the body of an ODoH query.  It's
+// the maximum size of a DNS message along with the encryption overhead.
+const maxODoHMessageSize = dns.MaxMsgSize + 1024
+
+// prepareODoHTarget creates the ODoH target, if it's enabled.  The target is
+// kept across the reconfigurations, so that the clients don't have to refetch
+// its configurations.  It assumes s.serverLock is locked or the Server not
+// running.
+func (s *Server) prepareODoHTarget() (err error) {
+	if !s.conf.ServeODoH {
+		s.odohTarget = nil
+
+		return nil
+	} else if s.odohTarget != nil {
+		return nil
+	}
+
+	s.odohTarget, err = odoh.NewTarget()
+	if err != nil {
+		return fmt.Errorf("preparing odoh target: %w", err)
+	}
+
+	return nil
+}
+
+// odohTargetLocked returns the ODoH target of s, if any.
+func (s *Server) odohTargetLocked() (t *odoh.Target) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	return s.odohTarget
+}
+
+// handleODoHConfigs is the handler for the GET /.well-known/odohconfigs HTTP
+// API.  It responds with the configurations of the ODoH target.
+func (s *Server) handleODoHConfigs(w http.ResponseWriter, r *http.Request) {
+	if !s.conf.TLSAllowUnencryptedDoH && r.TLS == nil {
+		aghhttp.Error(r, w, http.StatusNotFound, "Not Found")
+
+		return
+	}
+
+	t := s.odohTargetLocked()
+	if t == nil {
+		aghhttp.Error(r, w, http.StatusNotFound, "Not Found")
+
+		return
+	} else if r.Method != http.MethodGet {
+		aghhttp.Error(r, w, http.StatusMethodNotAllowed, "only method GET is allowed")
+
+		return
+	}
+
+	w.Header().Set(httphdr.ContentType, "application/octet-stream")
+	_, err := w.Write(t.Configs())
+	if err != nil {
+		log.Debug("dnsforward: writing odoh configs: %s", err)
+	}
+}
+
+// handleODoH handles the ODoH query from the relay.  The decrypted query is
+// processed just like a DoH one, so that the filtering and the client settings
+// apply to it.
+func (s *Server) handleODoH(w http.ResponseWriter, r *http.Request) {
+	t := s.odohTargetLocked()
+	if t == nil {
+		aghhttp.Error(r, w, http.StatusUnsupportedMediaType, "odoh is disabled")
+
+		return
+	} else if r.Method != http.MethodPost {
+		aghhttp.Error(r, w, http.StatusMethodNotAllowed, "only method POST is allowed")
+
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxODoHMessageSize))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading body: %s", err)
+
+		return
+	}
+
+	q, err := t.DecryptQuery(body)
+	if errors.Is(err, odoh.ErrKeyMismatch) {
+		// Make the client refetch the configurations.
+		//
+		// See https://datatracker.ietf.org/doc/html/rfc9230#section-7.
+		aghhttp.Error(r, w, http.StatusUnauthorized, "decrypting query: %s", err)
+
+		return
+	} else if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decrypting query: %s", err)
+
+		return
+	}
+
+	dohReq := r.Clone(r.Context())
+	dohReq.Body = io.NopCloser(bytes.NewReader(q.Msg))
+	dohReq.ContentLength = int64(len(q.Msg))
+	dohReq.Header.Set(httphdr.ContentType, "application/dns-message")
+
+	rw := &bufferResponseWriter{
+		header: http.Header{},
+		code:   http.StatusOK,
+	}
+
+	s.ServeHTTP(rw, dohReq)
+	if rw.code != http.StatusOK {
+		aghhttp.Error(r, w, rw.code, "resolving query: %s", bytes.TrimSpace(rw.body.Bytes()))
+
+		return
+	}
+
+	data, err := q.EncryptResponse(rw.body.Bytes())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encrypting response: %s", err)
+
+		return
+	}
+
+	w.Header().Set(httphdr.ContentType, odoh.ContentType)
+	w.Header().Set(httphdr.CacheControl, "no-cache, no-store")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Debug("dnsforward: writing odoh response: %s", err)
+	}
+}
+
+// bufferResponseWriter is an [http.ResponseWriter] which keeps the response in
+// memory.
+type bufferResponseWriter struct {
+	// header is the header of the response.
+	header http.Header
+
+	// body is the body of the response.
+	body bytes.Buffer
+
+	// code is the status code of the response.
+	code int
+}
+
+// type check
+var _ http.ResponseWriter = (*bufferResponseWriter)(nil)
+
+// Header implements the [http.ResponseWriter] interface for
+// *bufferResponseWriter.
+func (w *bufferResponseWriter) Header() (h http.Header) {
+	return w.header
+}
+
+// Write implements the [http.ResponseWriter] interface for
+// *bufferResponseWriter.
+func (w *bufferResponseWriter) Write(b []byte) (n int, err error) {
+	return w.body.Write(b)
+}
+
+// WriteHeader implements the [http.ResponseWriter] interface for
+// *bufferResponseWriter.
+func (w *bufferResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
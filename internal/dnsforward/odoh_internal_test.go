@@ -0,0 +1,97 @@
+package dnsforward
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/odoh"
+)
+
+func TestServer_HandleODoH(t *testing.T) {
+	s := createTestServer(t, &filtering.Config{
+		ProtectionEnabled: true,
+		BlockingMode:      filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			ServeODoH:        true,
+		},
+		ServePlainDNS: true,
+	})
+	startDeferStop(t, s)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", s.handleDoH)
+	mux.HandleFunc(odoh.ConfigsPath, s.handleODoHConfigs)
+
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	// Use the target itself as the relay, since the target ignores the relay
+	// parameters.
+	addr := "odoh://" + srvURL.Host + "/dns-query?relay=" + srv.URL + "/dns-query"
+	u, err := odoh.NewUpstream(addr, &upstream.Options{RootCAs: roots})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, u.Close)
+
+	testCases := []struct {
+		name   string
+		host   string
+		wantIP net.IP
+	}{{
+		name:   "rewritten",
+		host:   "host.example.org.",
+		wantIP: net.IP{127, 0, 0, 1},
+	}, {
+		name:   "blocked",
+		host:   "nxdomain.example.org.",
+		wantIP: net.IP{0, 0, 0, 0},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, exchErr := u.Exchange(createTestMessage(tc.host))
+			require.NoError(t, exchErr)
+			require.Len(t, resp.Answer, 1)
+
+			a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+			assert.True(t, tc.wantIP.Equal(a.A))
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		s.serverLock.Lock()
+		s.odohTarget = nil
+		s.serverLock.Unlock()
+
+		r := httptest.NewRequest(http.MethodGet, odoh.ConfigsPath, nil)
+		r.TLS = &tls.ConnectionState{}
+		w := httptest.NewRecorder()
+		s.handleODoHConfigs(w, r)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		_, err = u.Exchange(createTestMessage("host.example.org."))
+		assert.Error(t, err)
+	})
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/dnscache"
+	"github.com/tukimoto/AdGuardHome/internal/dnssec"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
 )
 
@@ -55,6 +57,10 @@ type dnsContext struct {
 	// responseAD shows if the response had the AD bit set.
 	responseAD bool
 
+	// dnssecStatus is the outcome of the local DNSSEC validation of the
+	// response, if any.
+	dnssecStatus dnssec.Status
+
 	// isDHCPHost is true if the request for a local domain name and the DHCP is
 	// available for this request.
 	isDHCPHost bool
@@ -89,6 +95,12 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, pctx *proxy.DNSContext) error
 		startTime: time.Now(),
 	}
 
+	if res, ok := dohJSONResult(pctx.HTTPRequest); ok {
+		// The result may be replaced during the processing, so get it after
+		// that.
+		defer func() { *res = *dctx.result }()
+	}
+
 	type modProcessFunc func(ctx *dnsContext) (rc resultCode)
 
 	// Since (*dnsforward.Server).handleDNSRequest(...) is used as
@@ -98,13 +110,16 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, pctx *proxy.DNSContext) error
 	// appropriate handler.
 	mods := []modProcessFunc{
 		s.processInitial,
+		s.processRatelimit,
 		s.processDDRQuery,
+		s.processLocalZones,
 		s.processDHCPHosts,
 		s.processDHCPAddrs,
 		s.processFilteringBeforeRequest,
 		s.processUpstream,
 		s.processFilteringAfterResponse,
 		s.ipset.process,
+		s.processResponseRatelimit,
 		s.processQueryLogsAndStats,
 	}
 	for _, process := range mods {
@@ -305,6 +320,29 @@ func (s *Server) makeDDRResponse(req *dns.Msg) (resp *dns.Msg) {
 	return resp
 }
 
+// processLocalZones responds to the requests for the names within the local
+// authoritative zones.  Such requests are neither filtered nor forwarded.
+func (s *Server) processLocalZones(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing local zones")
+	defer log.Debug("dnsforward: finished processing local zones")
+
+	if s.localZones == nil {
+		return resultCodeSuccess
+	}
+
+	pctx := dctx.proxyCtx
+	resp := s.localZones.Resolve(pctx.Req)
+	if resp == nil {
+		return resultCodeSuccess
+	}
+
+	resp.RecursionAvailable = true
+	resp.Compress = true
+	pctx.Res = resp
+
+	return resultCodeSuccess
+}
+
 // processDHCPHosts respond to A requests if the target hostname is known to
 // the server.  It responds with a mapped IP address if the DNS64 is enabled and
 // the request is for AAAA.
@@ -315,6 +353,10 @@ func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 	defer log.Debug("dnsforward: finished processing dhcp hosts")
 
 	pctx := dctx.proxyCtx
+	if pctx.Res != nil {
+		return resultCodeSuccess
+	}
+
 	req := pctx.Req
 
 	q := &req.Question[0]
@@ -482,8 +524,34 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 
 	s.setCustomUpstream(pctx, dctx.clientID)
 
+	var cacheKey string
+	respCache := s.respCacheFor(pctx, dctx.clientID)
+	if respCache != nil {
+		cacheKey = dnscache.Key(req)
+		if s.replyFromCache(dctx, respCache, cacheKey) {
+			dctx.responseFromUpstream = true
+			dctx.responseAD = pctx.Res.AuthenticatedData
+
+			return resultCodeSuccess
+		}
+	}
+
+	return s.resolve(dctx, respCache, cacheKey)
+}
+
+// resolve passes the request to the upstream servers, handles the response,
+// and caches it into respCache with cacheKey, if respCache isn't nil.
+func (s *Server) resolve(dctx *dnsContext, respCache *dnscache.Cache, cacheKey string) (rc resultCode) {
+	pctx := dctx.proxyCtx
+	req := pctx.Req
+
 	reqWantsDNSSEC := s.setReqAD(req)
 
+	var hadOPT, hadDO bool
+	if s.dnssecValidator != nil {
+		hadOPT, hadDO = setReqDO(req)
+	}
+
 	// Process the request further since it wasn't filtered.
 	prx := s.proxy()
 	if prx == nil {
@@ -492,15 +560,41 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 		return resultCodeError
 	}
 
+	if s.conf.UpstreamMode.isSelecting() {
+		s.upsRequests.add(req, cmp.Or(dctx.clientID, pctx.Addr.Addr().String()))
+		defer s.upsRequests.remove(req)
+	}
+
+	start := time.Now()
 	if dctx.err = prx.Resolve(pctx); dctx.err != nil {
 		return resultCodeError
 	}
 
+	s.upsRequests.setActualUpstream(pctx)
+
+	if s.dnstap != nil && pctx.Upstream != nil {
+		s.tapForwarder(pctx, start)
+	}
+
 	dctx.responseFromUpstream = true
 	dctx.responseAD = pctx.Res.AuthenticatedData
 
+	if s.dnssecValidator != nil {
+		s.validateResp(dctx, hadOPT, hadDO)
+	}
+
 	s.setRespAD(pctx, reqWantsDNSSEC)
 
+	if respCache != nil && pctx.Upstream != nil {
+		respCache.Set(
+			cacheKey,
+			pctx.Res,
+			pctx.Upstream.Address(),
+			s.conf.CacheMinTTL,
+			s.conf.CacheMaxTTL,
+		)
+	}
+
 	return resultCodeSuccess
 }
 
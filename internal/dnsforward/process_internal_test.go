@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/netip"
 	"testing"
+	"testing/fstest"
 
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
@@ -16,6 +17,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tukimoto/AdGuardHome/internal/aghtest"
+	"github.com/tukimoto/AdGuardHome/internal/authzone"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
 )
 
@@ -377,6 +379,81 @@ func createTestDNSFilter(t *testing.T) (f *filtering.DNSFilter) {
 	return f
 }
 
+func TestServer_ProcessLocalZones(t *testing.T) {
+	const zone = "$ORIGIN lab.example.\n" +
+		"@ 3600 IN SOA ns1 hostmaster 1 7200 3600 1209600 300\n" +
+		"www 3600 IN A 192.0.2.1\n"
+
+	zones, err := authzone.NewContainer(fstest.MapFS{
+		"lab.zone": &fstest.MapFile{Data: []byte(zone)},
+	}, &aghtest.FSWatcher{
+		OnStart:  func() (_ error) { panic("not implemented") },
+		OnClose:  func() (err error) { return nil },
+		OnEvents: func() (e <-chan struct{}) { return nil },
+		OnAdd:    func(_ string) (err error) { return nil },
+	}, []*authzone.ZoneConfig{{
+		File: "lab.zone",
+	}})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, zones.Close)
+
+	s := &Server{
+		localZones: zones,
+	}
+
+	testCases := []struct {
+		name      string
+		host      string
+		wantRcode int
+		wantAns   bool
+		wantRes   bool
+	}{{
+		name:      "answer",
+		host:      "www.lab.example.",
+		wantRcode: dns.RcodeSuccess,
+		wantAns:   true,
+		wantRes:   true,
+	}, {
+		name:      "nxdomain",
+		host:      "none.lab.example.",
+		wantRcode: dns.RcodeNameError,
+		wantAns:   false,
+		wantRes:   true,
+	}, {
+		name:      "outside",
+		host:      "www.example.com.",
+		wantRcode: dns.RcodeSuccess,
+		wantAns:   false,
+		wantRes:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req: (&dns.Msg{}).SetQuestion(tc.host, dns.TypeA),
+				},
+			}
+
+			rc := s.processLocalZones(dctx)
+			require.Equal(t, resultCodeSuccess, rc)
+
+			res := dctx.proxyCtx.Res
+			if !tc.wantRes {
+				assert.Nil(t, res)
+
+				return
+			}
+
+			require.NotNil(t, res)
+
+			assert.True(t, res.Authoritative)
+			assert.Equal(t, tc.wantRcode, res.Rcode)
+			assert.Equal(t, tc.wantAns, len(res.Answer) > 0)
+		})
+	}
+}
+
 func TestServer_ProcessDHCPHosts_localRestriction(t *testing.T) {
 	const (
 		localDomainSuffix = "lan"
@@ -0,0 +1,169 @@
+package dnsforward
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
+)
+
+// RatelimitTagPolicy is the rate limiting policy of the persistent clients
+// having a tag.
+type RatelimitTagPolicy struct {
+	// Policy is the rate limiting policy of the clients.
+	Policy ratelimit.Policy `yaml:",inline"`
+
+	// Tag is the tag of the clients.
+	Tag string `yaml:"tag"`
+}
+
+// validateRatelimit returns an error if the rate limiting settings of c are
+// invalid.
+func (c *Config) validateRatelimit() (err error) {
+	if c.Ratelimit != 0 {
+		err = checkInclusion(&c.RatelimitSubnetLenIPv4, 0, netutil.IPv4BitLen)
+		if err != nil {
+			return fmt.Errorf("ratelimit_subnet_len_ipv4 is invalid: %w", err)
+		}
+
+		err = checkInclusion(&c.RatelimitSubnetLenIPv6, 0, netutil.IPv6BitLen)
+		if err != nil {
+			return fmt.Errorf("ratelimit_subnet_len_ipv6 is invalid: %w", err)
+		}
+	}
+
+	err = c.ResponseRatelimit.Validate()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	for i, tp := range c.RatelimitTagPolicies {
+		if tp == nil {
+			return fmt.Errorf("ratelimit_tag_policies: at index %d: %w", i, errors.ErrNoValue)
+		} else if tp.Tag == "" {
+			return fmt.Errorf("ratelimit_tag_policies: at index %d: tag: %w", i, errors.ErrEmptyValue)
+		}
+
+		err = tp.Policy.Validate()
+		if err != nil {
+			return fmt.Errorf("ratelimit_tag_policies: at index %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// ratelimitPolicy returns the rate limiting policy for the request in dctx and
+// the key of its token bucket.  p is nil if the request must not be limited.
+func (s *Server) ratelimitPolicy(dctx *dnsContext) (key string, p *ratelimit.Policy) {
+	pctx := dctx.proxyCtx
+	addr := pctx.Addr.Addr().Unmap()
+	if slices.Contains(s.conf.RatelimitWhitelist, addr) {
+		return "", nil
+	}
+
+	if s.conf.ClientsContainer != nil {
+		// Use the ClientID first, since it has a higher priority.
+		id := cmp.Or(dctx.clientID, addr.String())
+		name, tags, cliPolicy, ok := s.conf.ClientsContainer.RatelimitByID(id)
+		if ok {
+			p = cmp.Or(cliPolicy, s.tagRatelimitPolicy(tags))
+		}
+
+		if p != nil {
+			if p.QPS == 0 {
+				return "", nil
+			}
+
+			// Limit each persistent client separately, even if the policy is
+			// the one of its tag.
+			return "client:" + name, p
+		}
+	}
+
+	// The global limit only applies to plain DNS-over-UDP requests, which are
+	// the ones used for amplification attacks.
+	if s.conf.Ratelimit == 0 || pctx.Proto != proxy.ProtoUDP {
+		return "", nil
+	}
+
+	bits := s.conf.RatelimitSubnetLenIPv4
+	if addr.Is6() {
+		bits = s.conf.RatelimitSubnetLenIPv6
+	}
+
+	return netip.PrefixFrom(addr, bits).Masked().Addr().String(), &ratelimit.Policy{
+		Action: ratelimit.ActionDrop,
+		QPS:    s.conf.Ratelimit,
+	}
+}
+
+// tagRatelimitPolicy returns the first rate limiting policy of a tag from
+// tags.  p is nil if there is none.
+func (s *Server) tagRatelimitPolicy(tags []string) (p *ratelimit.Policy) {
+	for _, tp := range s.conf.RatelimitTagPolicies {
+		if slices.Contains(tags, tp.Tag) {
+			return &tp.Policy
+		}
+	}
+
+	return nil
+}
+
+// processRatelimit limits the rate of requests of the client according to its
+// policy.  The limited requests are answered according to the action of the
+// policy.  Only the first of the requests limited in a row is written to the
+// query log and statistics, so that a flood doesn't flood those as well.
+func (s *Server) processRatelimit(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing ratelimit")
+	defer log.Debug("dnsforward: finished processing ratelimit")
+
+	pctx := dctx.proxyCtx
+	key, p := s.ratelimitPolicy(dctx)
+	if p == nil {
+		return resultCodeSuccess
+	}
+
+	action := cmp.Or(p.Action, ratelimit.ActionDrop)
+	if action == ratelimit.ActionTruncate && pctx.Proto != proxy.ProtoUDP {
+		return resultCodeSuccess
+	}
+
+	limited := s.ratelimiter.Check(key, p, time.Now())
+	if limited == 0 {
+		return resultCodeSuccess
+	}
+
+	log.Debug("dnsforward: request from %s ratelimited with action %s", pctx.Addr, action)
+
+	dctx.result = &filtering.Result{
+		Reason: filtering.Ratelimited,
+	}
+
+	switch action {
+	case ratelimit.ActionRefuse:
+		pctx.Res = s.makeResponseREFUSED(pctx.Req)
+	case ratelimit.ActionTruncate:
+		pctx.Res = s.makeResponseTruncated(pctx.Req)
+	default:
+		// Leave the response empty, so that the request is dropped.
+	}
+
+	if limited == 1 {
+		// Only log the beginning of limiting to not flood the query log and
+		// statistics during an attack.
+		log.Info("dnsforward: ratelimiting requests %q", key)
+		s.processQueryLogsAndStats(dctx)
+	}
+
+	return resultCodeFinish
+}
@@ -0,0 +1,195 @@
+package dnsforward
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghnet"
+	"github.com/tukimoto/AdGuardHome/internal/aghtest"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
+	"github.com/tukimoto/AdGuardHome/internal/stats"
+)
+
+func TestServer_ProcessRatelimit(t *testing.T) {
+	var (
+		iotAddr       = netip.MustParseAddr("192.0.2.1")
+		nasAddr       = netip.MustParseAddr("192.0.2.2")
+		tvAddr        = netip.MustParseAddr("192.0.2.3")
+		unknownAddr   = netip.MustParseAddr("198.51.100.1")
+		whitelistAddr = netip.MustParseAddr("203.0.113.1")
+	)
+
+	type persistent struct {
+		policy *ratelimit.Policy
+		name   string
+		tags   []string
+	}
+
+	clients := map[string]*persistent{
+		iotAddr.String(): {
+			policy: &ratelimit.Policy{Action: ratelimit.ActionRefuse, QPS: 1},
+			name:   "iot",
+		},
+		nasAddr.String(): {
+			policy: &ratelimit.Policy{QPS: 0},
+			name:   "nas",
+		},
+		tvAddr.String(): {
+			name: "tv",
+			tags: []string{"device_other", "device_tv"},
+		},
+	}
+
+	cliCont := &aghtest.ClientsContainer{
+		OnRatelimitByID: func(
+			id string,
+		) (name string, tags []string, p *ratelimit.Policy, ok bool) {
+			c, ok := clients[id]
+			if !ok {
+				return "", nil, nil, false
+			}
+
+			return c.name, c.tags, c.policy, true
+		},
+	}
+
+	testCases := []struct {
+		addr        netip.Addr
+		name        string
+		proto       proxy.Proto
+		wantAction  ratelimit.Action
+		wantLimited bool
+	}{{
+		addr:        iotAddr,
+		name:        "client_policy",
+		proto:       proxy.ProtoTCP,
+		wantAction:  ratelimit.ActionRefuse,
+		wantLimited: true,
+	}, {
+		addr:        nasAddr,
+		name:        "client_unlimited",
+		proto:       proxy.ProtoUDP,
+		wantAction:  "",
+		wantLimited: false,
+	}, {
+		addr:        tvAddr,
+		name:        "tag_policy",
+		proto:       proxy.ProtoUDP,
+		wantAction:  ratelimit.ActionTruncate,
+		wantLimited: true,
+	}, {
+		addr:        tvAddr,
+		name:        "tag_policy_tcp",
+		proto:       proxy.ProtoTCP,
+		wantAction:  "",
+		wantLimited: false,
+	}, {
+		addr:        unknownAddr,
+		name:        "global",
+		proto:       proxy.ProtoUDP,
+		wantAction:  ratelimit.ActionDrop,
+		wantLimited: true,
+	}, {
+		addr:        unknownAddr,
+		name:        "global_tcp",
+		proto:       proxy.ProtoTCP,
+		wantAction:  "",
+		wantLimited: false,
+	}, {
+		addr:        whitelistAddr,
+		name:        "whitelist",
+		proto:       proxy.ProtoUDP,
+		wantAction:  "",
+		wantLimited: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ql := &testQueryLog{}
+			st := &testStats{}
+			s := &Server{
+				logger:      slogutil.NewDiscardLogger(),
+				queryLog:    ql,
+				stats:       st,
+				anonymizer:  aghnet.NewIPMut(nil),
+				ratelimiter: ratelimit.NewLimiter(ratelimit.DefaultLimiterSize),
+				conf: ServerConfig{
+					Config: Config{
+						ClientsContainer:       cliCont,
+						Ratelimit:              1,
+						RatelimitSubnetLenIPv4: 24,
+						RatelimitSubnetLenIPv6: 56,
+						RatelimitWhitelist:     []netip.Addr{whitelistAddr},
+						RatelimitTagPolicies: []*RatelimitTagPolicy{{
+							Policy: ratelimit.Policy{
+								Action: ratelimit.ActionTruncate,
+								QPS:    1,
+							},
+							Tag: "device_tv",
+						}},
+					},
+				},
+			}
+
+			newCtx := func() (dctx *dnsContext) {
+				return &dnsContext{
+					proxyCtx: &proxy.DNSContext{
+						Proto: tc.proto,
+						Req:   createTestMessage("example.org."),
+						Addr:  netip.AddrPortFrom(tc.addr, 53),
+					},
+					result:    &filtering.Result{},
+					startTime: time.Now(),
+				}
+			}
+
+			// Exhaust the limits of all the policies.
+			require.Equal(t, resultCodeSuccess, s.processRatelimit(newCtx()))
+
+			dctx := newCtx()
+			rc := s.processRatelimit(dctx)
+			if !tc.wantLimited {
+				assert.Equal(t, resultCodeSuccess, rc)
+				assert.Nil(t, ql.lastParams)
+
+				return
+			}
+
+			require.Equal(t, resultCodeFinish, rc)
+
+			require.NotNil(t, ql.lastParams)
+			assert.Equal(t, filtering.Ratelimited, ql.lastParams.Result.Reason)
+
+			require.NotNil(t, st.lastEntry)
+			assert.Equal(t, stats.RRatelimited, st.lastEntry.Result)
+
+			res := dctx.proxyCtx.Res
+			switch tc.wantAction {
+			case ratelimit.ActionRefuse:
+				require.NotNil(t, res)
+
+				assert.Equal(t, dns.RcodeRefused, res.Rcode)
+			case ratelimit.ActionTruncate:
+				require.NotNil(t, res)
+
+				assert.True(t, res.Truncated)
+			default:
+				assert.Nil(t, res)
+			}
+
+			// Only the first limited request is logged.
+			ql.lastParams, st.lastEntry = nil, nil
+			require.Equal(t, resultCodeFinish, s.processRatelimit(newCtx()))
+
+			assert.Nil(t, ql.lastParams)
+			assert.Nil(t, st.lastEntry)
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
+)
+
+// ResponseRatelimitConfig is the configuration of the response rate limiting
+// (RRL).  Unlike [Config.Ratelimit], it limits the rate of identical responses
+// sent to a network, which protects the targets of reflection attacks using
+// spoofed source addresses.
+type ResponseRatelimitConfig struct {
+	// ResponsesPerSecond is the number of identical responses per second sent
+	// to a network.  Responses are identical if they have the same question
+	// name, question type, and response code.
+	ResponsesPerSecond uint32 `yaml:"responses_per_second"`
+
+	// Window is the number of seconds over which the rate is averaged, so
+	// that up to Window times ResponsesPerSecond responses may be sent at
+	// once.  Zero means one second.
+	Window uint32 `yaml:"window"`
+
+	// Slip defines that every Slip-th suppressed response is answered with a
+	// small truncated response for plain DNS and with REFUSED for encrypted
+	// protocols, so that the legitimate clients from the network can still
+	// retry.  Zero means that the suppressed responses are always dropped.
+	Slip uint32 `yaml:"slip"`
+
+	// SubnetLenIPv4 is the length of the IPv4 networks to which the responses
+	// are limited.
+	SubnetLenIPv4 int `yaml:"subnet_len_ipv4"`
+
+	// SubnetLenIPv6 is the length of the IPv6 networks to which the responses
+	// are limited.
+	SubnetLenIPv6 int `yaml:"subnet_len_ipv6"`
+
+	// PlainUDP defines if the responses to plain DNS-over-UDP requests are
+	// limited.  Plain DNS-over-TCP responses are never limited, since TCP
+	// requests can't have spoofed source addresses.
+	PlainUDP bool `yaml:"plain_udp"`
+
+	// TableSize is the maximum number of the tracked pairs of networks and
+	// responses.  When it's exceeded, the least recently seen ones are
+	// forgotten.  Zero means [ratelimit.DefaultLimiterSize].
+	TableSize uint32 `yaml:"table_size"`
+
+	// Encrypted defines if the responses to DNS-over-TLS, DNS-over-HTTPS,
+	// DNS-over-QUIC, and DNSCrypt requests are limited.
+	Encrypted bool `yaml:"encrypted"`
+}
+
+// newLimiter returns a new limiter of the responses configured by c.
+func (c *ResponseRatelimitConfig) newLimiter() (l *ratelimit.Limiter) {
+	size := ratelimit.DefaultLimiterSize
+	if c.TableSize != 0 {
+		size = int(c.TableSize)
+	}
+
+	return ratelimit.NewLimiter(size)
+}
+
+// enabled returns true if the responses to requests over proto are limited.
+func (c *ResponseRatelimitConfig) enabled(proto proxy.Proto) (ok bool) {
+	switch proto {
+	case proxy.ProtoUDP:
+		return c.PlainUDP
+	case proxy.ProtoTCP:
+		return false
+	default:
+		return c.Encrypted
+	}
+}
+
+// Validate returns an error if c is enabled and contains invalid values.
+func (c *ResponseRatelimitConfig) Validate() (err error) {
+	if !c.PlainUDP && !c.Encrypted {
+		return nil
+	}
+
+	var errs []error
+	if c.ResponsesPerSecond == 0 {
+		errs = append(errs, fmt.Errorf("responses_per_second: %w", errors.ErrEmptyValue))
+	}
+
+	err = checkInclusion(&c.SubnetLenIPv4, 0, netutil.IPv4BitLen)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("subnet_len_ipv4: %w", err))
+	}
+
+	err = checkInclusion(&c.SubnetLenIPv6, 0, netutil.IPv6BitLen)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("subnet_len_ipv6: %w", err))
+	}
+
+	return errors.Annotate(errors.Join(errs...), "response_ratelimit: %w")
+}
+
+// responseRatelimitKey returns the key of the token bucket of the response
+// from pctx.
+func (c *ResponseRatelimitConfig) responseRatelimitKey(pctx *proxy.DNSContext) (key string) {
+	addr := pctx.Addr.Addr().Unmap()
+	bits := c.SubnetLenIPv4
+	if addr.Is6() {
+		bits = c.SubnetLenIPv6
+	}
+
+	q := pctx.Req.Question[0]
+
+	return strings.Join([]string{
+		netip.PrefixFrom(addr, bits).Masked().String(),
+		strings.ToLower(q.Name),
+		dns.Type(q.Qtype).String(),
+		strconv.Itoa(pctx.Res.Rcode),
+	}, " ")
+}
+
+// processResponseRatelimit suppresses the responses exceeding the response rate
+// limit.  The suppressed responses are dropped or, according to the slip,
+// replaced with small responses, and are written to the query log and
+// statistics.
+func (s *Server) processResponseRatelimit(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing response ratelimit")
+	defer log.Debug("dnsforward: finished processing response ratelimit")
+
+	pctx := dctx.proxyCtx
+	conf := &s.conf.ResponseRatelimit
+	if pctx.Res == nil || !conf.enabled(pctx.Proto) {
+		return resultCodeSuccess
+	}
+
+	addr := pctx.Addr.Addr().Unmap()
+	if slices.Contains(s.conf.RatelimitWhitelist, addr) {
+		return resultCodeSuccess
+	}
+
+	key := conf.responseRatelimitKey(pctx)
+	p := &ratelimit.Policy{
+		QPS:   conf.ResponsesPerSecond,
+		Burst: conf.ResponsesPerSecond * max(conf.Window, 1),
+	}
+
+	limited := s.responseLimiter.Check(key, p, time.Now())
+	if limited == 0 {
+		return resultCodeSuccess
+	} else if limited == 1 {
+		// Only log the beginning of suppression to not flood the log during
+		// an attack.
+		log.Info("dnsforward: rrl: suppressing responses %q", key)
+	}
+
+	dctx.result = &filtering.Result{
+		Reason: filtering.Ratelimited,
+	}
+
+	switch {
+	case conf.Slip == 0 || limited%uint64(conf.Slip) != 0:
+		pctx.Res = nil
+	case pctx.Proto == proxy.ProtoUDP:
+		pctx.Res = s.makeResponseTruncated(pctx.Req)
+	default:
+		pctx.Res = s.makeResponseREFUSED(pctx.Req)
+	}
+
+	return resultCodeSuccess
+}
@@ -0,0 +1,209 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghnet"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
+)
+
+func TestResponseRatelimitConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		conf       *ResponseRatelimitConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       &ResponseRatelimitConfig{},
+		name:       "disabled",
+		wantErrMsg: "",
+	}, {
+		conf: &ResponseRatelimitConfig{
+			ResponsesPerSecond: 10,
+			SubnetLenIPv4:      24,
+			SubnetLenIPv6:      56,
+			PlainUDP:           true,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &ResponseRatelimitConfig{
+			SubnetLenIPv4: 33,
+			SubnetLenIPv6: 56,
+			Encrypted:     true,
+		},
+		name: "invalid",
+		wantErrMsg: "response_ratelimit: responses_per_second: empty value\n" +
+			"subnet_len_ipv4: value 33 greater than max 32",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.Validate())
+		})
+	}
+}
+
+func TestServer_ProcessResponseRatelimit(t *testing.T) {
+	var (
+		victimAddr    = netip.MustParseAddr("192.0.2.1")
+		neighborAddr  = netip.MustParseAddr("192.0.2.2")
+		otherAddr     = netip.MustParseAddr("198.51.100.1")
+		whitelistAddr = netip.MustParseAddr("203.0.113.1")
+	)
+
+	const (
+		qname      = "example.org."
+		otherQName = "example.net."
+	)
+
+	newServer := func(encrypted bool) (s *Server) {
+		return &Server{
+			logger:          slogutil.NewDiscardLogger(),
+			queryLog:        &testQueryLog{},
+			stats:           &testStats{},
+			anonymizer:      aghnet.NewIPMut(nil),
+			responseLimiter: ratelimit.NewLimiter(ratelimit.DefaultLimiterSize),
+			conf: ServerConfig{
+				Config: Config{
+					RatelimitWhitelist: []netip.Addr{whitelistAddr},
+					ResponseRatelimit: ResponseRatelimitConfig{
+						ResponsesPerSecond: 1,
+						Window:             1,
+						Slip:               2,
+						SubnetLenIPv4:      24,
+						SubnetLenIPv6:      56,
+						PlainUDP:           true,
+						Encrypted:          encrypted,
+					},
+				},
+			},
+		}
+	}
+
+	respond := func(
+		t *testing.T,
+		s *Server,
+		proto proxy.Proto,
+		addr netip.Addr,
+		name string,
+	) (dctx *dnsContext) {
+		t.Helper()
+
+		req := createTestMessage(name)
+		dctx = &dnsContext{
+			proxyCtx: &proxy.DNSContext{
+				Proto: proto,
+				Req:   req,
+				Res:   s.reply(req, dns.RcodeSuccess),
+				Addr:  netip.AddrPortFrom(addr, 53),
+			},
+			result:    &filtering.Result{},
+			startTime: time.Now(),
+		}
+
+		require.Equal(t, resultCodeSuccess, s.processResponseRatelimit(dctx))
+
+		return dctx
+	}
+
+	t.Run("slip", func(t *testing.T) {
+		s := newServer(false)
+
+		dctx := respond(t, s, proxy.ProtoUDP, victimAddr, qname)
+		require.NotNil(t, dctx.proxyCtx.Res)
+
+		assert.False(t, dctx.proxyCtx.Res.Truncated)
+		assert.NotEqual(t, filtering.Ratelimited, dctx.result.Reason)
+
+		dctx = respond(t, s, proxy.ProtoUDP, neighborAddr, qname)
+		assert.Nil(t, dctx.proxyCtx.Res)
+		assert.Equal(t, filtering.Ratelimited, dctx.result.Reason)
+
+		dctx = respond(t, s, proxy.ProtoUDP, victimAddr, qname)
+		require.NotNil(t, dctx.proxyCtx.Res)
+
+		assert.True(t, dctx.proxyCtx.Res.Truncated)
+		assert.Empty(t, dctx.proxyCtx.Res.Answer)
+		assert.Equal(t, filtering.Ratelimited, dctx.result.Reason)
+	})
+
+	t.Run("different_tuples", func(t *testing.T) {
+		s := newServer(false)
+
+		dctx := respond(t, s, proxy.ProtoUDP, victimAddr, qname)
+		assert.NotNil(t, dctx.proxyCtx.Res)
+
+		dctx = respond(t, s, proxy.ProtoUDP, victimAddr, otherQName)
+		assert.NotNil(t, dctx.proxyCtx.Res)
+
+		dctx = respond(t, s, proxy.ProtoUDP, otherAddr, qname)
+		assert.NotNil(t, dctx.proxyCtx.Res)
+	})
+
+	t.Run("exempt", func(t *testing.T) {
+		s := newServer(false)
+
+		for _, proto := range []proxy.Proto{
+			proxy.ProtoTCP,
+			proxy.ProtoTLS,
+			proxy.ProtoHTTPS,
+		} {
+			for range 3 {
+				dctx := respond(t, s, proto, victimAddr, qname)
+				assert.NotNil(t, dctx.proxyCtx.Res)
+			}
+		}
+
+		for range 3 {
+			dctx := respond(t, s, proxy.ProtoUDP, whitelistAddr, qname)
+			assert.NotNil(t, dctx.proxyCtx.Res)
+		}
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		s := newServer(true)
+
+		dctx := respond(t, s, proxy.ProtoHTTPS, victimAddr, qname)
+		assert.NotNil(t, dctx.proxyCtx.Res)
+
+		dctx = respond(t, s, proxy.ProtoHTTPS, victimAddr, qname)
+		assert.Nil(t, dctx.proxyCtx.Res)
+
+		dctx = respond(t, s, proxy.ProtoHTTPS, victimAddr, qname)
+		require.NotNil(t, dctx.proxyCtx.Res)
+
+		assert.Equal(t, dns.RcodeRefused, dctx.proxyCtx.Res.Rcode)
+
+		// Plain TCP responses are never limited.
+		dctx = respond(t, s, proxy.ProtoTCP, victimAddr, qname)
+		assert.NotNil(t, dctx.proxyCtx.Res)
+	})
+	t.Run("flood", func(t *testing.T) {
+		const tableSize = 100
+
+		s := newServer(false)
+		s.conf.ResponseRatelimit.TableSize = tableSize
+		s.responseLimiter = s.conf.ResponseRatelimit.newLimiter()
+
+		// Spoofed requests for unique names from many networks must not grow
+		// the table beyond its size.
+		for i := range 10 * tableSize {
+			addr := netip.AddrFrom4([4]byte{10, byte(i >> 8), byte(i), 1})
+			respond(t, s, proxy.ProtoUDP, addr, fmt.Sprintf("%d.example.org.", i))
+
+			require.LessOrEqual(t, s.responseLimiter.Len(), tableSize)
+		}
+
+		assert.Equal(t, tableSize, s.responseLimiter.Len())
+	})
+}
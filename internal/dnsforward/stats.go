@@ -44,6 +44,14 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 	s.serverLock.RLock()
 	defer s.serverLock.RUnlock()
 
+	if s.metrics != nil {
+		s.updateMetrics(dctx, processingTime)
+	}
+
+	if s.dnstap != nil {
+		s.tapClient(dctx, ip)
+	}
+
 	if s.shouldLog(host, qt, cl, ids) {
 		s.logQuery(dctx, ip, processingTime)
 	} else {
@@ -105,6 +113,11 @@ func (s *Server) logQuery(dctx *dnsContext, ip net.IP, processingTime time.Durat
 		ClientIP:          ip,
 		Elapsed:           processingTime,
 		AuthenticatedData: dctx.responseAD,
+		DNSSECStatus:      dctx.dnssecStatus,
+	}
+
+	if dctx.setts != nil {
+		p.SchedulePolicies = dctx.setts.SchedulePolicies
 	}
 
 	switch pctx.Proto {
@@ -151,19 +164,48 @@ func (s *Server) updateStats(dctx *dnsContext, clientIP string, processingTime t
 		e.Client = clientIP
 	}
 
-	switch dctx.result.Reason {
+	e.Result = statsResult(dctx.result)
+	for _, r := range dctx.result.Rules {
+		e.Rules = append(e.Rules, stats.Rule{
+			Text:         r.Text,
+			FilterListID: r.FilterListID,
+		})
+	}
+
+	s.stats.Update(e)
+}
+
+// statsResult returns the statistics result code for the filtering result.
+func statsResult(res *filtering.Result) (r stats.Result) {
+	switch res.Reason {
 	case filtering.FilteredSafeBrowsing:
-		e.Result = stats.RSafeBrowsing
+		return stats.RSafeBrowsing
 	case filtering.FilteredParental:
-		e.Result = stats.RParental
+		return stats.RParental
 	case filtering.FilteredSafeSearch:
-		e.Result = stats.RSafeSearch
+		return stats.RSafeSearch
 	case
 		filtering.FilteredBlockList,
 		filtering.FilteredInvalid,
 		filtering.FilteredBlockedService:
-		e.Result = stats.RFiltered
+		return stats.RFiltered
+	case filtering.Ratelimited:
+		return stats.RRatelimited
+	default:
+		return stats.RNotFiltered
 	}
+}
 
-	s.stats.Update(e)
+// updateMetrics records the request data into the metrics.  s.metrics must not
+// be nil.
+func (s *Server) updateMetrics(dctx *dnsContext, processingTime time.Duration) {
+	pctx := dctx.proxyCtx
+
+	s.metrics.ObserveQuery(statsResult(dctx.result).String(), processingTime)
+
+	if cachedUps := pctx.CachedUpstreamAddr; cachedUps != "" {
+		s.metrics.ObserveUpstream(cachedUps, 0, true)
+	} else if pctx.Upstream != nil {
+		s.metrics.ObserveUpstream(pctx.Upstream.Address(), pctx.QueryDuration, false)
+	}
 }
@@ -194,6 +194,17 @@ func TestServer_ProcessQueryLogsAndStats(t *testing.T) {
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.FilteredParental,
 		wantStatResult: stats.RParental,
+	}, {
+		name:           "success_udp_ratelimited",
+		domain:         domain,
+		proto:          proxy.ProtoUDP,
+		addr:           testClientAddrPort,
+		clientID:       "",
+		wantLogProto:   "",
+		wantStatClient: "1.2.3.4",
+		wantCode:       resultCodeSuccess,
+		reason:         filtering.Ratelimited,
+		wantStatResult: stats.RRatelimited,
 	}}
 
 	ups, err := upstream.AddressToUpstream("1.1.1.1", nil)
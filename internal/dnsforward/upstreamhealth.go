@@ -0,0 +1,184 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+	"github.com/tukimoto/AdGuardHome/internal/upstreamhealth"
+)
+
+// upstreamHealthWindow is the number of the latest exchanges with an upstream
+// used to calculate its success rate and latencies.
+const upstreamHealthWindow = 100
+
+// UpstreamHealthConfig is the configuration of the health monitoring of the
+// upstream servers.
+type UpstreamHealthConfig struct {
+	// ProbeInterval is the interval between the background probes of each
+	// upstream.  If zero, the upstreams are only checked by the actual
+	// requests.
+	ProbeInterval timeutil.Duration `yaml:"probe_interval"`
+
+	// Backoff is the duration for which a failing upstream is taken out of
+	// rotation for the first time.  It's doubled each time the upstream fails
+	// right after returning into rotation.
+	Backoff timeutil.Duration `yaml:"backoff"`
+
+	// MaxBackoff is the maximum duration for which a failing upstream is
+	// taken out of rotation.
+	MaxBackoff timeutil.Duration `yaml:"max_backoff"`
+
+	// FailureThreshold is the number of consecutive failed requests after
+	// which an upstream is taken out of rotation.
+	FailureThreshold int `yaml:"failure_threshold"`
+
+	// Enabled defines if the upstreams should be monitored.
+	Enabled bool `yaml:"enabled"`
+}
+
+// Validate returns an error if c is enabled and contains invalid values.
+func (c *UpstreamHealthConfig) Validate() (err error) {
+	if !c.Enabled {
+		return nil
+	}
+
+	var errs []error
+	if c.ProbeInterval.Duration < 0 {
+		errs = append(errs, fmt.Errorf("probe_interval: negative value %s", c.ProbeInterval))
+	}
+
+	if c.Backoff.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("backoff: must be positive, got %s", c.Backoff))
+	}
+
+	if c.MaxBackoff.Duration < c.Backoff.Duration {
+		errs = append(errs, fmt.Errorf(
+			"max_backoff: must not be less than backoff %s, got %s",
+			c.Backoff,
+			c.MaxBackoff,
+		))
+	}
+
+	if c.FailureThreshold <= 0 {
+		errs = append(errs, fmt.Errorf(
+			"failure_threshold: must be positive, got %d",
+			c.FailureThreshold,
+		))
+	}
+
+	return errors.Annotate(errors.Join(errs...), "upstream_health: %w")
+}
+
+// prepareUpstreamHealth wraps the upstreams of uc with the health monitor, if
+// it's enabled.  The state of the upstreams which are still used is kept.  It
+// assumes s.serverLock is locked or the Server not running.
+func (s *Server) prepareUpstreamHealth(uc *proxy.UpstreamConfig) {
+	hc := s.conf.UpstreamHealth
+	if !hc.Enabled {
+		s.upsHealth = nil
+
+		return
+	}
+
+	if s.upsHealth == nil {
+		s.upsHealth = upstreamhealth.New(&upstreamhealth.Config{
+			ProbeInterval:    hc.ProbeInterval.Duration,
+			Backoff:          hc.Backoff.Duration,
+			MaxBackoff:       hc.MaxBackoff.Duration,
+			FailureThreshold: hc.FailureThreshold,
+			Window:           upstreamHealthWindow,
+		})
+	}
+
+	wrapUpstreams(uc, s.upsHealth.Wrap)
+	s.upsHealth.Prune()
+}
+
+// upstreamStatusJSON is the health status of a single upstream server.
+type upstreamStatusJSON struct {
+	// LastErrorTime is the time of the latest failed request, if any.
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+
+	// DownUntil is the time when the upstream returns into rotation.  It's
+	// only set if the upstream is down.
+	DownUntil *time.Time `json:"down_until,omitempty"`
+
+	// Address is the address of the upstream.
+	Address string `json:"address"`
+
+	// Status is the health state of the upstream: "healthy", "degraded", or
+	// "down".
+	Status string `json:"status"`
+
+	// LastError is the error of the latest failed request.
+	LastError string `json:"last_error,omitempty"`
+
+	// SuccessRate is the share of the successful latest requests, from 0 to
+	// 1.
+	SuccessRate float64 `json:"success_rate"`
+
+	// LatencyP50 is the median latency of the latest successful requests in
+	// milliseconds.
+	LatencyP50 float64 `json:"latency_p50_ms"`
+
+	// LatencyP95 is the 95th percentile of the latency of the latest
+	// successful requests in milliseconds.
+	LatencyP95 float64 `json:"latency_p95_ms"`
+
+	// Requests is the number of the latest requests the statistics are
+	// calculated from.
+	Requests int `json:"requests"`
+}
+
+// upstreamsStatusJSON is the response for the GET /control/upstreams/status
+// HTTP API.
+type upstreamsStatusJSON struct {
+	Upstreams []*upstreamStatusJSON `json:"upstreams"`
+}
+
+// handleUpstreamsStatus is the handler for the GET /control/upstreams/status
+// HTTP API.
+func (s *Server) handleUpstreamsStatus(w http.ResponseWriter, r *http.Request) {
+	s.serverLock.RLock()
+	m := s.upsHealth
+	s.serverLock.RUnlock()
+
+	if m == nil {
+		aghhttp.Error(r, w, http.StatusNotImplemented, "upstream health monitoring is disabled")
+
+		return
+	}
+
+	resp := &upstreamsStatusJSON{
+		Upstreams: []*upstreamStatusJSON{},
+	}
+
+	for _, st := range m.Status() {
+		u := &upstreamStatusJSON{
+			Address:     st.Address,
+			Status:      st.State.String(),
+			LastError:   st.LastError,
+			SuccessRate: st.SuccessRate,
+			LatencyP50:  st.LatencyP50.Seconds() * 1000,
+			LatencyP95:  st.LatencyP95.Seconds() * 1000,
+			Requests:    st.Exchanges,
+		}
+
+		if !st.LastErrorTime.IsZero() {
+			u.LastErrorTime = &st.LastErrorTime
+		}
+
+		if !st.DownUntil.IsZero() {
+			u.DownUntil = &st.DownUntil
+		}
+
+		resp.Upstreams = append(resp.Upstreams, u)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
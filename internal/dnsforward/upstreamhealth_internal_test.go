@@ -0,0 +1,115 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghtest"
+)
+
+func TestServer_HandleUpstreamsStatus(t *testing.T) {
+	const addr = "1.2.3.4:53"
+
+	ups := &aghtest.UpstreamMock{
+		OnAddress: func() (a string) { return addr },
+		OnExchange: func(_ *dns.Msg) (resp *dns.Msg, err error) {
+			return nil, errors.Error("test error")
+		},
+		OnClose: func() (err error) { return nil },
+	}
+
+	s := &Server{
+		conf: ServerConfig{
+			Config: Config{
+				UpstreamHealth: UpstreamHealthConfig{
+					Backoff:          timeutil.Duration{Duration: time.Hour},
+					MaxBackoff:       timeutil.Duration{Duration: time.Hour},
+					FailureThreshold: 1,
+					Enabled:          true,
+				},
+			},
+		},
+	}
+
+	uc := &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{ups},
+	}
+	s.prepareUpstreamHealth(uc)
+	require.NotNil(t, s.upsHealth)
+
+	req := (&dns.Msg{}).SetQuestion("example.", dns.TypeA)
+	_, err := uc.Upstreams[0].Exchange(req)
+	require.Error(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/control/upstreams/status", nil)
+	w := httptest.NewRecorder()
+	s.handleUpstreamsStatus(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &upstreamsStatusJSON{}
+	err = json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+	require.Len(t, resp.Upstreams, 1)
+
+	st := resp.Upstreams[0]
+	assert.Equal(t, addr, st.Address)
+	assert.Equal(t, "down", st.Status)
+	assert.Equal(t, "test error", st.LastError)
+	assert.Equal(t, 1, st.Requests)
+	assert.NotNil(t, st.DownUntil)
+
+	s.conf.UpstreamHealth.Enabled = false
+	s.prepareUpstreamHealth(uc)
+
+	w = httptest.NewRecorder()
+	s.handleUpstreamsStatus(w, r)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestUpstreamHealthConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		conf       *UpstreamHealthConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       &UpstreamHealthConfig{},
+		name:       "disabled",
+		wantErrMsg: "",
+	}, {
+		conf: &UpstreamHealthConfig{
+			Backoff:          timeutil.Duration{Duration: time.Second},
+			MaxBackoff:       timeutil.Duration{Duration: time.Minute},
+			FailureThreshold: 1,
+			Enabled:          true,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf: &UpstreamHealthConfig{
+			Backoff:          timeutil.Duration{Duration: time.Minute},
+			MaxBackoff:       timeutil.Duration{Duration: time.Second},
+			FailureThreshold: 0,
+			Enabled:          true,
+		},
+		name: "invalid",
+		wantErrMsg: "upstream_health: max_backoff: must not be less than backoff 1m, got 1s\n" +
+			"failure_threshold: must be positive, got 0",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.Validate())
+		})
+	}
+}
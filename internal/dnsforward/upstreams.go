@@ -10,7 +10,10 @@ import (
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
 	"github.com/tukimoto/AdGuardHome/internal/aghnet"
+	"github.com/tukimoto/AdGuardHome/internal/metrics"
+	"github.com/tukimoto/AdGuardHome/internal/odoh"
 )
 
 // newBootstrap returns a bootstrap resolver based on the configuration of s.
@@ -61,7 +64,7 @@ func newUpstreamConfig(
 	defaultUpstreams []string,
 	opts *upstream.Options,
 ) (uc *proxy.UpstreamConfig, err error) {
-	uc, err = proxy.ParseUpstreamsConfig(upstreams, opts)
+	uc, err = odoh.ParseUpstreamsConfig(upstreams, opts)
 	if err != nil {
 		return uc, fmt.Errorf("parsing upstreams: %w", err)
 	}
@@ -70,7 +73,7 @@ func newUpstreamConfig(
 		log.Info("dnsforward: warning: no default upstreams specified, using %v", defaultUpstreams)
 
 		var defaultUpstreamConfig *proxy.UpstreamConfig
-		defaultUpstreamConfig, err = proxy.ParseUpstreamsConfig(defaultUpstreams, opts)
+		defaultUpstreamConfig, err = odoh.ParseUpstreamsConfig(defaultUpstreams, opts)
 		if err != nil {
 			return uc, fmt.Errorf("parsing default upstreams: %w", err)
 		}
@@ -105,7 +108,7 @@ func newPrivateConfig(
 
 	log.Debug("dnsforward: private-use upstreams: %v", addrs)
 
-	uc, err = proxy.ParseUpstreamsConfig(addrs, opts)
+	uc, err = odoh.ParseUpstreamsConfig(addrs, opts)
 	if err != nil {
 		return uc, fmt.Errorf("preparing private upstreams: %w", err)
 	}
@@ -156,6 +159,11 @@ func setProxyUpstreamMode(
 		conf.FastestPingTimeout = fastestTimeout
 	case UpstreamModeLoadBalance:
 		conf.UpstreamMode = proxy.UpstreamModeLoadBalance
+	case UpstreamModeLatencyWeighted, UpstreamModeSticky:
+		// The upstreams are selected by AdGuard Home itself, see
+		// [Server.ApplyUpstreamMode].  The proxy only sees one upstream per
+		// domain, which is used as is in the load-balancing mode.
+		conf.UpstreamMode = proxy.UpstreamModeLoadBalance
 	default:
 		return fmt.Errorf("unexpected value %q", upstreamMode)
 	}
@@ -169,3 +177,50 @@ func setProxyUpstreamMode(
 func IsCommentOrEmpty(s string) (ok bool) {
 	return len(s) == 0 || s[0] == '#'
 }
+
+// wrapUpstreams replaces each upstream in uc with the result of calling wrap
+// on it.  The same upstream used for several domains is wrapped only once.
+func wrapUpstreams(uc *proxy.UpstreamConfig, wrap func(u upstream.Upstream) (w upstream.Upstream)) {
+	wrapped := map[upstream.Upstream]upstream.Upstream{}
+	wrapAll := func(ups []upstream.Upstream) {
+		for i, u := range ups {
+			w, ok := wrapped[u]
+			if !ok {
+				w = wrap(u)
+				wrapped[u] = w
+			}
+
+			ups[i] = w
+		}
+	}
+
+	wrapAll(uc.Upstreams)
+	for _, ups := range uc.DomainReservedUpstreams {
+		wrapAll(ups)
+	}
+
+	for _, ups := range uc.SpecifiedDomainUpstreams {
+		wrapAll(ups)
+	}
+}
+
+// metricsUpstream is an [upstream.Upstream] which records the failed exchanges
+// into the metrics.
+type metricsUpstream struct {
+	upstream.Upstream
+
+	metrics *metrics.DNS
+}
+
+// type check
+var _ upstream.Upstream = (*metricsUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *metricsUpstream.
+func (u *metricsUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	resp, err = u.Upstream.Exchange(req)
+	if err != nil {
+		u.metrics.UpstreamError(u.Address())
+	}
+
+	return resp, err
+}
@@ -7,11 +7,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghtest"
+	"github.com/tukimoto/AdGuardHome/internal/metrics"
 )
 
 func TestUpstreamConfigValidator(t *testing.T) {
@@ -214,3 +219,48 @@ func TestUpstreamConfigValidator_Check_once(t *testing.T) {
 		})
 	}
 }
+
+func TestWrapUpstreams(t *testing.T) {
+	uc, err := proxy.ParseUpstreamsConfig([]string{
+		"127.0.0.1:53",
+		"[/example.org/]127.0.0.2:53",
+		"[/example.com/example.net/]127.0.0.3:53",
+	}, &upstream.Options{})
+	require.NoError(t, err)
+
+	reg := prometheus.NewRegistry()
+	m := metrics.NewDNS(reg)
+
+	errUps := &aghtest.UpstreamMock{
+		OnAddress:  func() (addr string) { return "127.0.0.4:53" },
+		OnExchange: func(_ *dns.Msg) (_ *dns.Msg, err error) { return nil, assert.AnError },
+		OnClose:    func() (err error) { return nil },
+	}
+	uc.Upstreams = append(uc.Upstreams, errUps)
+
+	wrapUpstreams(uc, func(u upstream.Upstream) (w upstream.Upstream) {
+		return &metricsUpstream{Upstream: u, metrics: m}
+	})
+
+	for _, u := range uc.Upstreams {
+		assert.IsType(t, (*metricsUpstream)(nil), u)
+	}
+
+	comUps := uc.DomainReservedUpstreams["example.com."]
+	netUps := uc.DomainReservedUpstreams["example.net."]
+	require.Len(t, comUps, 1)
+	require.Len(t, netUps, 1)
+
+	// The same upstream must be wrapped only once.
+	assert.Same(t, comUps[0], netUps[0])
+
+	_, err = uc.Upstreams[1].Exchange(new(dns.Msg))
+	require.ErrorIs(t, err, assert.AnError)
+
+	err = promtestutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP adguardhome_dns_upstream_errors_total Total number of failed exchanges with the upstream servers.
+# TYPE adguardhome_dns_upstream_errors_total counter
+adguardhome_dns_upstream_errors_total{upstream="127.0.0.4:53"} 1
+`), "adguardhome_dns_upstream_errors_total")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,430 @@
+package dnsforward
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// isSelecting returns true if the upstreams are selected by AdGuard Home
+// itself instead of the proxy in mode.
+func (mode UpstreamMode) isSelecting() (ok bool) {
+	return mode == UpstreamModeLatencyWeighted || mode == UpstreamModeSticky
+}
+
+// ApplyUpstreamMode replaces each list of several upstreams in uc with a single
+// upstream selecting among them according to mode.  It does nothing for the
+// modes implemented by the proxy itself.  It's intended to be used for both
+// the general and the custom upstream configurations.
+func (s *Server) ApplyUpstreamMode(uc *proxy.UpstreamConfig, mode UpstreamMode) {
+	var sel upstreamSelection
+	switch mode {
+	case UpstreamModeLatencyWeighted:
+		sel = newLatencySelection()
+	case UpstreamModeSticky:
+		sel = stickySelection{}
+	default:
+		return
+	}
+
+	wrap := func(ups []upstream.Upstream) (wrapped []upstream.Upstream) {
+		if len(ups) < 2 {
+			return ups
+		}
+
+		return []upstream.Upstream{newSelectingUpstream(mode, sel, s.upsRequests, ups)}
+	}
+
+	uc.Upstreams = wrap(uc.Upstreams)
+	for _, domainUps := range []map[string][]upstream.Upstream{
+		uc.DomainReservedUpstreams,
+		uc.SpecifiedDomainUpstreams,
+	} {
+		for domain, ups := range domainUps {
+			domainUps[domain] = wrap(ups)
+		}
+	}
+}
+
+// upstreamRequests are the requests being resolved along with the data used
+// for selecting the upstreams for them.  It is safe for concurrent use.
+type upstreamRequests struct {
+	// mu protects reqs.
+	mu *sync.Mutex
+
+	// reqs are the data of the requests being resolved.
+	reqs map[*dns.Msg]*upstreamRequest
+}
+
+// upstreamRequest is the data of a single request used for selecting the
+// upstream.
+type upstreamRequest struct {
+	// chosen is the upstream which has resolved the request, if any.
+	chosen upstream.Upstream
+
+	// key identifies the client for the sticky selection.
+	key string
+}
+
+// newUpstreamRequests returns a new properly initialized *upstreamRequests.
+func newUpstreamRequests() (r *upstreamRequests) {
+	return &upstreamRequests{
+		mu:   &sync.Mutex{},
+		reqs: map[*dns.Msg]*upstreamRequest{},
+	}
+}
+
+// add starts tracking req sent by the client identified by key.
+func (r *upstreamRequests) add(req *dns.Msg, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reqs[req] = &upstreamRequest{
+		key: key,
+	}
+}
+
+// remove stops tracking req.
+func (r *upstreamRequests) remove(req *dns.Msg) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.reqs, req)
+}
+
+// clientKey returns the key of the client which has sent req.  ok is false if
+// req isn't tracked.
+func (r *upstreamRequests) clientKey(req *dns.Msg) (key string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ur, ok := r.reqs[req]
+	if !ok {
+		return "", false
+	}
+
+	return ur.key, true
+}
+
+// setChosen sets the upstream which has resolved req, if it's tracked.
+func (r *upstreamRequests) setChosen(req *dns.Msg, u upstream.Upstream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ur, ok := r.reqs[req]; ok {
+		ur.chosen = u
+	}
+}
+
+// setActualUpstream replaces the selecting upstream in pctx with the upstream
+// which has actually resolved the request, so that it's logged properly.
+func (r *upstreamRequests) setActualUpstream(pctx *proxy.DNSContext) {
+	if _, ok := pctx.Upstream.(*selectingUpstream); !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ur, ok := r.reqs[pctx.Req]; ok && ur.chosen != nil {
+		pctx.Upstream = ur.chosen
+	}
+}
+
+// upstreamSelection defines the order in which the upstreams are tried.
+type upstreamSelection interface {
+	// order returns ups in the order they should be tried for req.  key
+	// identifies the client which has sent req.  ups must not be modified.
+	order(ups []upstream.Upstream, req *dns.Msg, key string) (ordered []upstream.Upstream)
+
+	// record accounts the result of an exchange with u which has taken dur.
+	record(u upstream.Upstream, dur time.Duration, err error)
+}
+
+// selectingUpstream is an [upstream.Upstream] which sends each request to
+// one of its upstreams chosen by the selection, trying the next ones on
+// failure.
+type selectingUpstream struct {
+	// sel defines the order of the upstreams.
+	sel upstreamSelection
+
+	// reqs are the requests being resolved by the server.
+	reqs *upstreamRequests
+
+	// addr is the description of the upstream used as its address.
+	addr string
+
+	// ups are the upstreams to select from.
+	ups []upstream.Upstream
+}
+
+// newSelectingUpstream returns a new upstream selecting among ups.
+func newSelectingUpstream(
+	mode UpstreamMode,
+	sel upstreamSelection,
+	reqs *upstreamRequests,
+	ups []upstream.Upstream,
+) (u *selectingUpstream) {
+	addrs := make([]string, 0, len(ups))
+	for _, ups := range ups {
+		addrs = append(addrs, ups.Address())
+	}
+
+	return &selectingUpstream{
+		sel:  sel,
+		reqs: reqs,
+		addr: fmt.Sprintf("%s(%s)", mode, strings.Join(addrs, ", ")),
+		ups:  ups,
+	}
+}
+
+// type check
+var _ upstream.Upstream = (*selectingUpstream)(nil)
+
+// Address implements the [upstream.Upstream] interface for
+// *selectingUpstream.
+func (u *selectingUpstream) Address() (addr string) {
+	return u.addr
+}
+
+// Exchange implements the [upstream.Upstream] interface for
+// *selectingUpstream.
+func (u *selectingUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	var errs []error
+	for _, ups := range u.sel.order(u.ups, req, u.requestKey(req)) {
+		start := time.Now()
+		resp, err = ups.Exchange(req)
+		u.sel.record(ups, time.Since(start), err)
+		if err == nil {
+			u.reqs.setChosen(req, ups)
+
+			return resp, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, fmt.Errorf("all upstreams failed to exchange request: %w", errors.Join(errs...))
+}
+
+// Close implements the [upstream.Upstream] interface for *selectingUpstream.
+func (u *selectingUpstream) Close() (err error) {
+	var errs []error
+	for _, ups := range u.ups {
+		errs = append(errs, ups.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// requestKey returns the key identifying the client which has sent req.  The
+// EDNS Client Subnet has the highest priority.  The name from the question is
+// used for the requests not sent by clients.
+func (u *selectingUpstream) requestKey(req *dns.Msg) (key string) {
+	if opt := req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			ecs, ok := o.(*dns.EDNS0_SUBNET)
+			if !ok {
+				continue
+			}
+
+			bits := 8 * net.IPv6len
+			if ecs.Family == 1 {
+				bits = 8 * net.IPv4len
+			}
+
+			mask := net.CIDRMask(int(ecs.SourceNetmask), bits)
+
+			return (&net.IPNet{IP: ecs.Address.Mask(mask), Mask: mask}).String()
+		}
+	}
+
+	key, ok := u.reqs.clientKey(req)
+	if ok {
+		return key
+	}
+
+	return strings.ToLower(req.Question[0].Name)
+}
+
+// latencyDecay is the weight of the newest sample in the exponentially decayed
+// statistics of the upstreams.
+const latencyDecay = 0.2
+
+// minLatency is the latency the weights of the upstreams are calculated with
+// if their actual latency is lower or isn't known yet.
+const minLatency = time.Millisecond
+
+// latencyStats are the exponentially decayed statistics of an upstream.
+type latencyStats struct {
+	// rtt is the round-trip time of the exchanges, the failed ones being
+	// accounted as [DefaultTimeout].  It's zero if there were none.
+	rtt time.Duration
+
+	// errRate is the rate of the failed exchanges, from 0 to 1.
+	errRate float64
+}
+
+// weight returns the relative probability of choosing the upstream.
+func (st *latencyStats) weight() (w float64) {
+	// Don't let the weight become zero, so that the upstream which has failed
+	// is still tried sometimes.
+	return (1 - 0.99*st.errRate) / max(st.rtt, minLatency).Seconds()
+}
+
+// latencySelection chooses the upstreams randomly with the probability
+// proportional to the weights calculated from their latencies and error rates.
+type latencySelection struct {
+	// mu protects stats.
+	mu *sync.Mutex
+
+	// stats are the statistics of the upstreams mapped by their addresses.
+	stats map[string]*latencyStats
+}
+
+// newLatencySelection returns a new properly initialized *latencySelection.
+func newLatencySelection() (sel *latencySelection) {
+	return &latencySelection{
+		mu:    &sync.Mutex{},
+		stats: map[string]*latencyStats{},
+	}
+}
+
+// type check
+var _ upstreamSelection = (*latencySelection)(nil)
+
+// order implements the [upstreamSelection] interface for *latencySelection.
+// It uses the weighted random sampling without replacement.
+//
+// See https://doi.org/10.1016/j.ipl.2005.11.003.
+func (sel *latencySelection) order(
+	ups []upstream.Upstream,
+	_ *dns.Msg,
+	_ string,
+) (ordered []upstream.Upstream) {
+	type keyed struct {
+		u   upstream.Upstream
+		key float64
+	}
+
+	keys := make([]keyed, 0, len(ups))
+
+	sel.mu.Lock()
+	for _, u := range ups {
+		st := sel.statsLocked(u.Address())
+
+		// Use 1 - Float64 to avoid calculating the logarithm of zero.
+		keys = append(keys, keyed{
+			u:   u,
+			key: -math.Log(1-rand.Float64()) / st.weight(),
+		})
+	}
+	sel.mu.Unlock()
+
+	slices.SortFunc(keys, func(a, b keyed) (res int) {
+		return cmp.Compare(a.key, b.key)
+	})
+
+	ordered = make([]upstream.Upstream, 0, len(keys))
+	for _, k := range keys {
+		ordered = append(ordered, k.u)
+	}
+
+	return ordered
+}
+
+// record implements the [upstreamSelection] interface for *latencySelection.
+func (sel *latencySelection) record(u upstream.Upstream, dur time.Duration, err error) {
+	sel.mu.Lock()
+	defer sel.mu.Unlock()
+
+	st := sel.statsLocked(u.Address())
+	if err != nil {
+		// Penalize the failed exchanges just like the proxy does, since those
+		// may be either timeouts or immediate failures.
+		dur = DefaultTimeout
+		st.errRate += latencyDecay * (1 - st.errRate)
+	} else {
+		st.errRate -= latencyDecay * st.errRate
+	}
+
+	if st.rtt == 0 {
+		st.rtt = dur
+	} else {
+		st.rtt += time.Duration(latencyDecay * float64(dur-st.rtt))
+	}
+}
+
+// statsLocked returns the statistics of the upstream with the given address,
+// creating them if necessary.  sel.mu is expected to be locked.
+func (sel *latencySelection) statsLocked(addr string) (st *latencyStats) {
+	st, ok := sel.stats[addr]
+	if !ok {
+		st = &latencyStats{}
+		sel.stats[addr] = st
+	}
+
+	return st
+}
+
+// stickySelection orders the upstreams consistently for each client, so that
+// the same client uses the same upstream while it's available.
+type stickySelection struct{}
+
+// type check
+var _ upstreamSelection = stickySelection{}
+
+// order implements the [upstreamSelection] interface for stickySelection.  It
+// uses the rendezvous hashing, so that only the clients of an upstream are
+// moved when it's removed.
+//
+// See https://en.wikipedia.org/wiki/Rendezvous_hashing.
+func (stickySelection) order(
+	ups []upstream.Upstream,
+	_ *dns.Msg,
+	key string,
+) (ordered []upstream.Upstream) {
+	type scored struct {
+		u     upstream.Upstream
+		score uint64
+	}
+
+	scores := make([]scored, 0, len(ups))
+	for _, u := range ups {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(u.Address()))
+
+		scores = append(scores, scored{
+			u:     u,
+			score: h.Sum64(),
+		})
+	}
+
+	slices.SortFunc(scores, func(a, b scored) (res int) {
+		return cmp.Compare(b.score, a.score)
+	})
+
+	ordered = make([]upstream.Upstream, 0, len(scores))
+	for _, s := range scores {
+		ordered = append(ordered, s.u)
+	}
+
+	return ordered
+}
+
+// record implements the [upstreamSelection] interface for stickySelection.
+func (stickySelection) record(_ upstream.Upstream, _ time.Duration, _ error) {}
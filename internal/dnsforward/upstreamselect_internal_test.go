@@ -0,0 +1,192 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghtest"
+)
+
+// newSelectTestUpstream returns a new upstream with the given address which
+// fails if fail is true and counts its exchanges in n.
+func newSelectTestUpstream(addr string, fail bool, n *int) (u *aghtest.UpstreamMock) {
+	return &aghtest.UpstreamMock{
+		OnAddress: func() (a string) { return addr },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			*n++
+			if fail {
+				return nil, errors.Error("test error")
+			}
+
+			return (&dns.Msg{}).SetReply(req), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+}
+
+func TestServer_ApplyUpstreamMode(t *testing.T) {
+	var n int
+	upsA := newSelectTestUpstream("1.1.1.1:53", false, &n)
+	upsB := newSelectTestUpstream("2.2.2.2:53", false, &n)
+
+	s := &Server{
+		upsRequests: newUpstreamRequests(),
+	}
+
+	uc := &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{upsA, upsB},
+		DomainReservedUpstreams: map[string][]upstream.Upstream{
+			"single.example.": {upsA},
+		},
+		SpecifiedDomainUpstreams: map[string][]upstream.Upstream{
+			"both.example.": {upsA, upsB},
+		},
+	}
+
+	s.ApplyUpstreamMode(uc, UpstreamModeParallel)
+	require.Len(t, uc.Upstreams, 2)
+
+	s.ApplyUpstreamMode(uc, UpstreamModeSticky)
+	require.Len(t, uc.Upstreams, 1)
+	assert.IsType(t, (*selectingUpstream)(nil), uc.Upstreams[0])
+	assert.Equal(t, "sticky(1.1.1.1:53, 2.2.2.2:53)", uc.Upstreams[0].Address())
+
+	assert.Equal(t, []upstream.Upstream{upsA}, uc.DomainReservedUpstreams["single.example."])
+
+	require.Len(t, uc.SpecifiedDomainUpstreams["both.example."], 1)
+	assert.IsType(t, (*selectingUpstream)(nil), uc.SpecifiedDomainUpstreams["both.example."][0])
+}
+
+func TestSelectingUpstream_Exchange(t *testing.T) {
+	var nBad, nGood int
+	upsBad := newSelectTestUpstream("1.1.1.1:53", true, &nBad)
+	upsGood := newSelectTestUpstream("2.2.2.2:53", false, &nGood)
+
+	reqs := newUpstreamRequests()
+	u := newSelectingUpstream(
+		UpstreamModeSticky,
+		stickySelection{},
+		reqs,
+		[]upstream.Upstream{upsBad, upsGood},
+	)
+
+	// Find a client the bad upstream is the primary one for.
+	var key string
+	sel := stickySelection{}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if sel.order(u.ups, nil, k)[0] == upsBad {
+			key = k
+
+			break
+		}
+	}
+	require.NotEmpty(t, key)
+
+	req := (&dns.Msg{}).SetQuestion("example.", dns.TypeA)
+	reqs.add(req, key)
+
+	resp, err := u.Exchange(req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	assert.Equal(t, 1, nBad)
+	assert.Equal(t, 1, nGood)
+
+	pctx := &proxy.DNSContext{
+		Req:      req,
+		Upstream: u,
+	}
+	reqs.setActualUpstream(pctx)
+	assert.Equal(t, upsGood, pctx.Upstream)
+
+	reqs.remove(req)
+	assert.Empty(t, reqs.reqs)
+}
+
+func TestStickySelection_order(t *testing.T) {
+	var n int
+	ups := []upstream.Upstream{
+		newSelectTestUpstream("1.1.1.1:53", false, &n),
+		newSelectTestUpstream("2.2.2.2:53", false, &n),
+		newSelectTestUpstream("3.3.3.3:53", false, &n),
+	}
+
+	sel := stickySelection{}
+	first := map[upstream.Upstream]int{}
+	for i := range 300 {
+		key := netip.AddrFrom4([4]byte{192, 0, 2, byte(i)}).String()
+		ordered := sel.order(ups, nil, key)
+		require.Len(t, ordered, len(ups))
+
+		assert.Equal(t, ordered, sel.order(ups, nil, key))
+		first[ordered[0]]++
+	}
+
+	// Every upstream is the primary one for some clients.
+	assert.Len(t, first, len(ups))
+}
+
+func TestSelectingUpstream_requestKey(t *testing.T) {
+	reqs := newUpstreamRequests()
+	u := &selectingUpstream{
+		reqs: reqs,
+	}
+
+	req := (&dns.Msg{}).SetQuestion("WWW.Example.", dns.TypeA)
+	assert.Equal(t, "www.example.", u.requestKey(req))
+
+	reqs.add(req, "client")
+	assert.Equal(t, "client", u.requestKey(req))
+
+	req.SetEdns0(dns.DefaultMsgSize, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.IP{192, 0, 2, 42},
+	})
+	assert.Equal(t, "192.0.2.0/24", u.requestKey(req))
+}
+
+func TestLatencySelection(t *testing.T) {
+	var n int
+	upsFast := newSelectTestUpstream("1.1.1.1:53", false, &n)
+	upsSlow := newSelectTestUpstream("2.2.2.2:53", false, &n)
+	upsBad := newSelectTestUpstream("3.3.3.3:53", false, &n)
+
+	sel := newLatencySelection()
+	for range 10 {
+		sel.record(upsFast, 10*time.Millisecond, nil)
+		sel.record(upsSlow, 100*time.Millisecond, nil)
+		sel.record(upsBad, 10*time.Millisecond, errors.Error("test error"))
+	}
+
+	wFast := sel.stats[upsFast.Address()].weight()
+	wSlow := sel.stats[upsSlow.Address()].weight()
+	wBad := sel.stats[upsBad.Address()].weight()
+
+	assert.InDelta(t, 10*wSlow, wFast, 0.001)
+	assert.Greater(t, wSlow, wBad)
+	assert.Positive(t, wBad)
+
+	ups := []upstream.Upstream{upsSlow, upsBad, upsFast}
+	first := map[upstream.Upstream]int{}
+	for range 1000 {
+		ordered := sel.order(ups, nil, "")
+		require.Len(t, ordered, len(ups))
+
+		first[ordered[0]]++
+	}
+
+	assert.Greater(t, first[upsFast], first[upsSlow])
+	assert.Greater(t, first[upsSlow], first[upsBad])
+}
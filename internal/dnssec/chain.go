@@ -0,0 +1,455 @@
+package dnssec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/bluele/gcache"
+	"github.com/miekg/dns"
+)
+
+// minCacheTTL is the minimum duration for which the key material is cached.
+const minCacheTTL = 1 * time.Second
+
+// Limits of the signature validations mitigating the KeyTrap attacks, in
+// which a zone makes the validator check lots of signatures against lots of
+// keys with colliding key tags.  See CVE-2023-50387.
+const (
+	// maxValidations is the maximum number of signature validations performed
+	// while validating a single response, including the chain of trust.
+	maxValidations = 64
+
+	// maxKeyTagValidations is the maximum number of keys with the same key
+	// tag a single signature is checked against.
+	maxKeyTagValidations = 4
+)
+
+// budget is the number of signature validations left for a single response.
+// It's not safe for concurrent use.
+type budget struct {
+	// left is the number of the validations left.
+	left int
+}
+
+// newBudget returns a new budget with [maxValidations] validations.
+func newBudget() (b *budget) {
+	return &budget{left: maxValidations}
+}
+
+// spend uses a single validation.  ok is false if there are none left.
+func (b *budget) spend() (ok bool) {
+	if b.left <= 0 {
+		return false
+	}
+
+	b.left--
+
+	return true
+}
+
+// zoneKeys is the validated key material of a zone.
+type zoneKeys struct {
+	// zone is the canonical name of the closest enclosing zone.  For the
+	// insecure zones it's the name of the topmost unsigned zone.
+	zone string
+
+	// keys are the validated DNSKEY records of zone.  It's nil if the zone is
+	// insecure.
+	keys []*dns.DNSKEY
+}
+
+// insecure returns true if there is a proof that the zone is unsigned.
+func (zk *zoneKeys) insecure() (ok bool) {
+	return zk.keys == nil
+}
+
+// verify returns the currently valid signature of set made by any of the keys
+// of zk, or an error if there is none.  Each signature is checked against at
+// most [maxKeyTagValidations] keys, and the validations are spent from b.
+func (zk *zoneKeys) verify(set *rrset, b *budget) (valid *dns.RRSIG, err error) {
+	now := time.Now()
+	for _, sig := range set.sigs {
+		if dns.CanonicalName(sig.SignerName) != zk.zone || !sig.ValidityPeriod(now) {
+			continue
+		}
+
+		tried := 0
+		for _, key := range zk.keys {
+			if key.Algorithm != sig.Algorithm || key.KeyTag() != sig.KeyTag {
+				continue
+			} else if tried == maxKeyTagValidations {
+				break
+			}
+
+			if !b.spend() {
+				return nil, fmt.Errorf(
+					"%s %s: too many signature validations: %w",
+					set.owner(),
+					dns.Type(set.rrtype()),
+					ErrBogus,
+				)
+			}
+
+			tried++
+			if sig.Verify(key, set.rrs) == nil {
+				return sig, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf(
+		"%s %s: no valid signature by %s: %w",
+		set.owner(),
+		dns.Type(set.rrtype()),
+		zk.zone,
+		ErrBogus,
+	)
+}
+
+// verifyAuthority verifies the records in the authority section of a negative
+// response and returns the denial of existence records from there.
+func (zk *zoneKeys) verifyAuthority(ns []dns.RR, b *budget) (d *denial, err error) {
+	sets := splitRRsets(ns)
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("no authority records: %w", ErrBogus)
+	}
+
+	d = &denial{}
+	for _, set := range sets {
+		_, err = zk.verify(set, b)
+		if err != nil {
+			return nil, err
+		}
+
+		d.add(set.rrs)
+	}
+
+	return d, nil
+}
+
+// verifyExpansion verifies the denial of existence records of the zone in the
+// authority section of a positive response and returns an error if they don't
+// prove that name, the owner of an RRset synthesized from a wildcard with the
+// given number of labels, doesn't exist.  insecure is true if the proof can't
+// be checked, see [denial.insecure].
+func (zk *zoneKeys) verifyExpansion(
+	ns []dns.RR,
+	name string,
+	labels uint8,
+	b *budget,
+) (insecure bool, err error) {
+	d := &denial{}
+	for _, set := range splitRRsets(ns) {
+		t := set.rrtype()
+		if t != dns.TypeNSEC && t != dns.TypeNSEC3 ||
+			!dns.IsSubDomain(zk.zone, dns.CanonicalName(set.owner())) {
+			continue
+		}
+
+		_, err = zk.verify(set, b)
+		if err != nil {
+			return false, err
+		}
+
+		d.add(set.rrs)
+	}
+
+	if d.insecure {
+		return true, nil
+	} else if !d.provesExpansion(name, int(labels)) {
+		return false, fmt.Errorf("%s: no proof of wildcard expansion: %w", name, ErrBogus)
+	}
+
+	return false, nil
+}
+
+// zoneFor returns the key material of the zone the RRset with the given owner
+// name and type should be signed by.
+func (v *Validator) zoneFor(
+	ctx context.Context,
+	name string,
+	t uint16,
+	b *budget,
+) (zk *zoneKeys, err error) {
+	name = dns.CanonicalName(name)
+	if t == dns.TypeDS && name != "." {
+		// DS records are served by the parent zone.
+		name = parentName(name)
+	}
+
+	return v.findZone(ctx, name, b)
+}
+
+// findZone returns the key material of the closest zone enclosing name, which
+// must be canonical.  The results are cached.
+func (v *Validator) findZone(ctx context.Context, name string, b *budget) (zk *zoneKeys, err error) {
+	if zk = v.cached(name); zk != nil {
+		return zk, nil
+	}
+
+	var ttl time.Duration
+	if _, ok := v.anchors[name]; ok {
+		zk, ttl, err = v.anchoredKeys(ctx, name, b)
+	} else if name == "." {
+		// There is no trust anchor above, so the chain can't be built.
+		zk, ttl = &zoneKeys{zone: name}, time.Hour
+	} else {
+		var parent *zoneKeys
+		parent, err = v.findZone(ctx, parentName(name), b)
+		if err != nil {
+			return nil, err
+		} else if parent.insecure() {
+			// Everything below an insecure zone is insecure.
+			return parent, nil
+		}
+
+		zk, ttl, err = v.delegation(ctx, parent, name, b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = v.cache.SetWithExpire(name, zk, max(ttl, minCacheTTL))
+	if err != nil {
+		log.Debug("dnssec: cache: adding %q: %s", name, err)
+	}
+
+	return zk, nil
+}
+
+// cached returns the cached key material for name, if any.
+func (v *Validator) cached(name string) (zk *zoneKeys) {
+	val, err := v.cache.Get(name)
+	if err != nil {
+		if !errors.Is(err, gcache.KeyNotFoundError) {
+			log.Debug("dnssec: cache: retrieving %q: %s", name, err)
+		}
+
+		return nil
+	}
+
+	zk, ok := val.(*zoneKeys)
+	if !ok {
+		log.Debug("dnssec: cache: %q bad type %T", name, val)
+
+		return nil
+	}
+
+	return zk
+}
+
+// anchoredKeys returns the key material of the zone having a trust anchor.
+func (v *Validator) anchoredKeys(
+	ctx context.Context,
+	zone string,
+	b *budget,
+) (zk *zoneKeys, ttl time.Duration, err error) {
+	keys, ttl, err := v.validKeys(ctx, zone, v.anchors[zone], b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("anchored zone %s: %w", zone, err)
+	}
+
+	return &zoneKeys{zone: zone, keys: keys}, ttl, nil
+}
+
+// delegation checks whether name is a zone cut within the secure zone parent
+// and returns the key material of the zone enclosing name.
+func (v *Validator) delegation(
+	ctx context.Context,
+	parent *zoneKeys,
+	name string,
+	b *budget,
+) (zk *zoneKeys, ttl time.Duration, err error) {
+	resp, err := v.exchange(ctx, name, dns.TypeDS)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp.Rcode == dns.RcodeSuccess {
+		set := findRRset(splitRRsets(resp.Answer), name, dns.TypeDS, dns.ClassINET)
+		if set != nil {
+			return v.signedDelegation(ctx, parent, name, set, b)
+		}
+	}
+
+	d, err := parent.verifyAuthority(resp.Ns, b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ds of %s: %w", name, err)
+	}
+
+	ttl = time.Duration(d.minTTL) * time.Second
+	if resp.Rcode == dns.RcodeNameError {
+		// The name doesn't exist, so it can't be a zone cut.
+		return parent, ttl, nil
+	} else if d.insecure {
+		return &zoneKeys{zone: name}, ttl, nil
+	}
+
+	cut, ok := d.delegation(name)
+	switch {
+	case !ok:
+		return nil, 0, fmt.Errorf("ds of %s: no proof of absence: %w", name, ErrBogus)
+	case cut:
+		return &zoneKeys{zone: name}, ttl, nil
+	default:
+		return parent, ttl, nil
+	}
+}
+
+// signedDelegation returns the key material of the zone name having the DS
+// records ds signed within the secure zone parent.
+func (v *Validator) signedDelegation(
+	ctx context.Context,
+	parent *zoneKeys,
+	name string,
+	ds *rrset,
+	b *budget,
+) (zk *zoneKeys, ttl time.Duration, err error) {
+	_, err = parent.verify(ds, b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var dss []*dns.DS
+	for _, rr := range ds.rrs {
+		if d, ok := rr.(*dns.DS); ok && isSupported(d) {
+			dss = append(dss, d)
+		}
+	}
+
+	if len(dss) == 0 {
+		// RFC 4035 Section 5.2 requires to treat the zone as insecure if none
+		// of the algorithms is supported.
+		return &zoneKeys{zone: name}, ds.minTTL(time.Now()), nil
+	}
+
+	keys, ttl, err := v.validKeys(ctx, name, dss, b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("zone %s: %w", name, err)
+	}
+
+	return &zoneKeys{zone: name, keys: keys}, min(ttl, ds.minTTL(time.Now())), nil
+}
+
+// validKeys requests the DNSKEY records of zone and returns them if they're
+// signed by a key matching one of dss.
+func (v *Validator) validKeys(
+	ctx context.Context,
+	zone string,
+	dss []*dns.DS,
+	b *budget,
+) (keys []*dns.DNSKEY, ttl time.Duration, err error) {
+	resp, err := v.exchange(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	set := findRRset(splitRRsets(resp.Answer), zone, dns.TypeDNSKEY, dns.ClassINET)
+	if set == nil {
+		return nil, 0, fmt.Errorf("no dnskey records: %w", ErrBogus)
+	}
+
+	var all, trusted []*dns.DNSKEY
+	for _, rr := range set.rrs {
+		key, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			continue
+		}
+
+		all = append(all, key)
+		if matchesAny(key, dss) {
+			trusted = append(trusted, key)
+		}
+	}
+
+	if len(trusted) == 0 {
+		return nil, 0, fmt.Errorf("no dnskey matching ds: %w", ErrBogus)
+	}
+
+	// The DNSKEY RRset must be self-signed by one of the trusted keys.
+	_, err = (&zoneKeys{zone: zone, keys: trusted}).verify(set, b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return all, set.minTTL(time.Now()), nil
+}
+
+// exchange requests the records of type t for name.
+func (v *Validator) exchange(ctx context.Context, name string, t uint16) (resp *dns.Msg, err error) {
+	req := (&dns.Msg{}).SetQuestion(name, t)
+	req.SetEdns0(dns.DefaultMsgSize, true)
+
+	resp, err = v.exchanger.Exchange(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s %s: %w", dns.Type(t), name, err)
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+		return resp, nil
+	default:
+		return nil, fmt.Errorf(
+			"requesting %s %s: got %s: %w",
+			dns.Type(t),
+			name,
+			dns.RcodeToString[resp.Rcode],
+			ErrBogus,
+		)
+	}
+}
+
+// matchesAny returns true if key is the key one of dss refer to.
+func matchesAny(key *dns.DNSKEY, dss []*dns.DS) (ok bool) {
+	for _, ds := range dss {
+		if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+			continue
+		}
+
+		calc := key.ToDS(ds.DigestType)
+		if calc != nil && strings.EqualFold(calc.Digest, ds.Digest) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSupported returns true if the algorithms of ds are supported.
+func isSupported(ds *dns.DS) (ok bool) {
+	switch ds.DigestType {
+	case dns.SHA1, dns.SHA256, dns.SHA384:
+		// Go on.
+	default:
+		return false
+	}
+
+	switch ds.Algorithm {
+	case
+		dns.RSASHA1,
+		dns.RSASHA1NSEC3SHA1,
+		dns.RSASHA256,
+		dns.RSASHA512,
+		dns.ECDSAP256SHA256,
+		dns.ECDSAP384SHA384,
+		dns.ED25519:
+		return true
+	default:
+		return false
+	}
+}
+
+// parentName returns the name of the parent domain of name, which must be a
+// fully-qualified name other than the root.
+func parentName(name string) (parent string) {
+	off, end := dns.NextLabel(name, 0)
+	if end || off >= len(name) {
+		return "."
+	}
+
+	return name[off:]
+}
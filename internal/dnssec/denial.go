@@ -0,0 +1,250 @@
+package dnssec
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// nsec3OptOut is the Opt-Out flag of the NSEC3 records.  See RFC 5155 Section
+// 3.1.2.1.
+const nsec3OptOut = 1
+
+// maxNSEC3Iterations is the maximum number of the additional NSEC3 hash
+// iterations the proofs are checked with.  The proofs using more are treated as
+// insecure.  See RFC 9276 Section 3.2.
+const maxNSEC3Iterations = 100
+
+// denial contains the validated records proving the nonexistence of names or
+// types.
+type denial struct {
+	// nsecs are the NSEC records.
+	nsecs []*dns.NSEC
+
+	// nsec3s are the NSEC3 records.
+	nsec3s []*dns.NSEC3
+
+	// minTTL is the lowest TTL of the records.
+	minTTL uint32
+
+	// insecure is true if any of the NSEC3 records uses more than
+	// [maxNSEC3Iterations] hash iterations, so that the proofs mustn't be
+	// checked.
+	insecure bool
+}
+
+// add adds the denial of existence records from rrs to d.
+func (d *denial) add(rrs []dns.RR) {
+	if d.minTTL == 0 {
+		d.minTTL = math.MaxUint32
+	}
+
+	for _, rr := range rrs {
+		d.minTTL = min(d.minTTL, rr.Header().Ttl)
+		if soa, ok := rr.(*dns.SOA); ok {
+			d.minTTL = min(d.minTTL, soa.Minttl)
+		}
+
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			d.nsecs = append(d.nsecs, rr)
+		case *dns.NSEC3:
+			d.insecure = d.insecure || rr.Iterations > maxNSEC3Iterations
+			d.nsec3s = append(d.nsec3s, rr)
+		default:
+			// Go on.
+		}
+	}
+}
+
+// delegation checks whether the records prove that name is an unsigned zone
+// cut.  ok is false if there is no proof either way.
+func (d *denial) delegation(name string) (cut, ok bool) {
+	for _, nsec := range d.nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, name) {
+			return isUnsignedCut(nsec.TypeBitMap)
+		} else if nsecCovers(nsec, name) {
+			return false, true
+		}
+	}
+
+	for _, nsec3 := range d.nsec3s {
+		if nsec3.Match(name) {
+			return isUnsignedCut(nsec3.TypeBitMap)
+		} else if nsec3.Cover(name) {
+			// An Opt-Out span may contain unsigned delegations, which are
+			// considered insecure.  See RFC 5155 Section 6.
+			return nsec3.Flags&nsec3OptOut != 0, true
+		}
+	}
+
+	return false, false
+}
+
+// isUnsignedCut returns true if the type bitmap belongs to a zone cut without
+// DS records.  ok is false if the bitmap contradicts the absence of DS.
+func isUnsignedCut(bitmap []uint16) (cut, ok bool) {
+	if slices.Contains(bitmap, dns.TypeDS) {
+		return false, false
+	}
+
+	return slices.Contains(bitmap, dns.TypeNS) && !slices.Contains(bitmap, dns.TypeSOA), true
+}
+
+// provesNODATA returns true if the records prove that name has no records of
+// type t.
+func (d *denial) provesNODATA(name string, t uint16) (ok bool) {
+	for _, nsec := range d.nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, name) {
+			return hasNoType(nsec.TypeBitMap, t)
+		}
+
+		if nsecCovers(nsec, name) && dns.IsSubDomain(name, nsec.NextDomain) {
+			// name is an empty non-terminal.
+			return true
+		}
+	}
+
+	for _, nsec3 := range d.nsec3s {
+		if nsec3.Match(name) {
+			return hasNoType(nsec3.TypeBitMap, t)
+		}
+
+		if t == dns.TypeDS && nsec3.Flags&nsec3OptOut != 0 && nsec3.Cover(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasNoType returns true if the type bitmap contains neither t nor CNAME.
+func hasNoType(bitmap []uint16, t uint16) (ok bool) {
+	return !slices.Contains(bitmap, t) && !slices.Contains(bitmap, dns.TypeCNAME)
+}
+
+// provesNXDOMAIN returns true if the records prove that name doesn't exist and
+// that there is no wildcard which could be expanded into it.  See RFC 4035
+// Section 5.4 and RFC 5155 Section 8.4.
+func (d *denial) provesNXDOMAIN(name string) (ok bool) {
+	for _, nsec := range d.nsecs {
+		if nsecCovers(nsec, name) {
+			return d.nsecCoversAny(wildcardName(nsecClosestEncloser(nsec, name)))
+		}
+	}
+
+	ce, ok := d.closestEncloser(name)
+
+	return ok && d.nsec3Covers(wildcardName(ce))
+}
+
+// provesExpansion returns true if the records prove that name, which is the
+// owner of an RRset synthesized from a wildcard with the given number of
+// labels, doesn't exist itself.  See RFC 4035 Section 5.3.4 and RFC 5155
+// Section 8.8.
+func (d *denial) provesExpansion(name string, labels int) (ok bool) {
+	if d.nsecCoversAny(name) {
+		return true
+	}
+
+	idx := dns.Split(name)
+	if labels >= len(idx) {
+		return false
+	}
+
+	// The next closer name is the closest encloser, which is the wildcard's
+	// parent, with one more label of name.
+	return d.nsec3Covers(name[idx[len(idx)-labels-1]:])
+}
+
+// closestEncloser returns the closest encloser of name if the NSEC3 records
+// contain its proof.  See RFC 5155 Section 7.2.1.
+func (d *denial) closestEncloser(name string) (ce string, ok bool) {
+	if len(d.nsec3s) == 0 {
+		return "", false
+	}
+
+	next := name
+	for next != "." {
+		encloser := parentName(next)
+		if d.nsec3Matches(encloser) {
+			return encloser, d.nsec3Covers(next)
+		}
+
+		next = encloser
+	}
+
+	return "", false
+}
+
+// nsecCoversAny returns true if any of the NSEC records covers name.
+func (d *denial) nsecCoversAny(name string) (ok bool) {
+	return slices.ContainsFunc(d.nsecs, func(rr *dns.NSEC) (c bool) { return nsecCovers(rr, name) })
+}
+
+// nsec3Matches returns true if any of the NSEC3 records matches name.
+func (d *denial) nsec3Matches(name string) (ok bool) {
+	return slices.ContainsFunc(d.nsec3s, func(rr *dns.NSEC3) (m bool) { return rr.Match(name) })
+}
+
+// nsec3Covers returns true if any of the NSEC3 records covers name.
+func (d *denial) nsec3Covers(name string) (ok bool) {
+	return slices.ContainsFunc(d.nsec3s, func(rr *dns.NSEC3) (c bool) { return rr.Cover(name) })
+}
+
+// nsecCovers returns true if name lies strictly between the owner name and the
+// next domain name of nsec in the canonical order.
+func nsecCovers(nsec *dns.NSEC, name string) (ok bool) {
+	owner, next := nsec.Hdr.Name, nsec.NextDomain
+	afterOwner := canonicalCompare(owner, name) < 0
+	beforeNext := canonicalCompare(name, next) < 0
+	if canonicalCompare(owner, next) < 0 {
+		return afterOwner && beforeNext
+	}
+
+	// The last NSEC record in the zone points to the apex.
+	return afterOwner || beforeNext
+}
+
+// nsecClosestEncloser returns the closest encloser of name, which nsec must
+// cover.  It's the longest of the ancestors name shares with the owner and the
+// next domain name of nsec.  See RFC 4035 Section 5.4.
+func nsecClosestEncloser(nsec *dns.NSEC, name string) (ce string) {
+	n := max(
+		dns.CompareDomainName(name, nsec.Hdr.Name),
+		dns.CompareDomainName(name, nsec.NextDomain),
+	)
+	if n == 0 {
+		return "."
+	}
+
+	idx := dns.Split(name)
+
+	return name[idx[len(idx)-n]:]
+}
+
+// wildcardName returns the name of the wildcard directly below ce.
+func wildcardName(ce string) (name string) {
+	if ce == "." {
+		return "*."
+	}
+
+	return "*." + ce
+}
+
+// canonicalCompare compares the domain names a and b in the canonical order
+// defined by RFC 4034 Section 6.1.
+func canonicalCompare(a, b string) (res int) {
+	al := dns.SplitDomainName(strings.ToLower(a))
+	bl := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(al)-1, len(bl)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if res = bytes.Compare([]byte(al[i]), []byte(bl[j])); res != 0 {
+			return res
+		}
+	}
+
+	return len(al) - len(bl)
+}
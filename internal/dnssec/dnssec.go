@@ -0,0 +1,338 @@
+// Package dnssec validates DNSSEC-signed responses locally using the chain of
+// trust built from the configured trust anchors.
+package dnssec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/bluele/gcache"
+	"github.com/miekg/dns"
+)
+
+// Status is the outcome of the validation of a response.
+type Status uint8
+
+// Status values.
+const (
+	// StatusNone means that the response hasn't been validated, for example
+	// because it's a SERVFAIL or because the validation is disabled.
+	StatusNone Status = iota
+
+	// StatusSecure means that the response has been validated up to a trust
+	// anchor.
+	StatusSecure
+
+	// StatusInsecure means that there is a proof that the response comes from
+	// an unsigned zone.
+	StatusInsecure
+
+	// StatusBogus means that the response should've been signed but its
+	// signatures are missing, expired, or invalid.
+	StatusBogus
+)
+
+// String implements the [fmt.Stringer] interface for Status.
+func (s Status) String() (str string) {
+	switch s {
+	case StatusNone:
+		return ""
+	case StatusSecure:
+		return "secure"
+	case StatusInsecure:
+		return "insecure"
+	case StatusBogus:
+		return "bogus"
+	default:
+		return fmt.Sprintf("!bad_status_%d", s)
+	}
+}
+
+// ErrBogus is returned, possibly wrapped, when the validation fails.
+const ErrBogus errors.Error = "bogus response"
+
+// DefaultRootAnchor is the DS record of the root zone KSK-2017, which is used
+// when no trust anchors are configured.
+const DefaultRootAnchor = ". 172800 IN DS 20326 8 2 " +
+	"E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+
+// Exchanger sends the DNS requests needed to build the chain of trust.
+type Exchanger interface {
+	// Exchange sends req and returns the response.  req must have the DO bit
+	// set.
+	Exchange(ctx context.Context, req *dns.Msg) (resp *dns.Msg, err error)
+}
+
+// Config is the configuration structure for Validator.
+type Config struct {
+	// Exchanger sends the requests for the DS and DNSKEY records.  It must
+	// not be nil.
+	Exchanger Exchanger
+
+	// TrustAnchors are the DS or DNSKEY records of the trusted keys in the
+	// presentation format.  If empty, [DefaultRootAnchor] is used.
+	TrustAnchors []string
+
+	// CacheSize is the maximum number of zones the validated key material of
+	// which is cached.  It must be greater than zero.
+	CacheSize int
+}
+
+// Validator validates the responses using the DNSSEC records within them.  It
+// is safe for concurrent use.
+type Validator struct {
+	// exchanger sends the requests for the DS and DNSKEY records.
+	exchanger Exchanger
+
+	// cache contains the *zoneKeys of the zones keyed by their canonical
+	// names.
+	cache gcache.Cache
+
+	// anchors are the DS records of the trusted keys mapped by the canonical
+	// names of their zones.
+	anchors map[string][]*dns.DS
+}
+
+// New returns a new properly initialized validator.  c must not be nil.
+func New(c *Config) (v *Validator, err error) {
+	anchors, err := parseAnchors(c.TrustAnchors)
+	if err != nil {
+		return nil, fmt.Errorf("trust anchors: %w", err)
+	}
+
+	return &Validator{
+		exchanger: c.Exchanger,
+		cache:     gcache.New(c.CacheSize).LRU().Build(),
+		anchors:   anchors,
+	}, nil
+}
+
+// parseAnchors parses the trust anchors in the presentation format.  DNSKEY
+// records are converted into DS records.
+func parseAnchors(strs []string) (anchors map[string][]*dns.DS, err error) {
+	if len(strs) == 0 {
+		strs = []string{DefaultRootAnchor}
+	}
+
+	anchors = map[string][]*dns.DS{}
+	for i, s := range strs {
+		var rr dns.RR
+		rr, err = dns.NewRR(s)
+		if err != nil {
+			return nil, fmt.Errorf("anchor at index %d: %w", i, err)
+		}
+
+		var ds *dns.DS
+		switch rr := rr.(type) {
+		case *dns.DS:
+			ds = rr
+		case *dns.DNSKEY:
+			ds = rr.ToDS(dns.SHA256)
+		default:
+			ds = nil
+		}
+
+		if ds == nil {
+			return nil, fmt.Errorf("anchor at index %d: bad record %q", i, s)
+		}
+
+		zone := dns.CanonicalName(ds.Hdr.Name)
+		anchors[zone] = append(anchors[zone], ds)
+	}
+
+	return anchors, nil
+}
+
+// Validate validates resp, which must be a response to a request with the DO
+// bit set.  err is only returned along with [StatusBogus] and describes the
+// reason.
+func (v *Validator) Validate(ctx context.Context, resp *dns.Msg) (st Status, err error) {
+	if len(resp.Question) != 1 {
+		return StatusNone, nil
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+		// Go on.
+	default:
+		return StatusNone, nil
+	}
+
+	st, err = v.validate(ctx, resp)
+	if err != nil {
+		return StatusBogus, fmt.Errorf("validating %s: %w", resp.Question[0].Name, err)
+	}
+
+	return st, nil
+}
+
+// validate validates the response and returns its status.
+func (v *Validator) validate(ctx context.Context, resp *dns.Msg) (st Status, err error) {
+	b := newBudget()
+	q := resp.Question[0]
+	answer := splitRRsets(resp.Answer)
+	if len(answer) == 0 {
+		return v.validateNegative(ctx, resp, q, b)
+	}
+
+	st = StatusSecure
+	for _, set := range answer {
+		var zk *zoneKeys
+		zk, err = v.zoneFor(ctx, set.owner(), set.rrtype(), b)
+		if err != nil {
+			return StatusBogus, err
+		}
+
+		if zk.insecure() {
+			st = StatusInsecure
+
+			continue
+		}
+
+		var sig *dns.RRSIG
+		sig, err = zk.verify(set, b)
+		if err != nil {
+			return StatusBogus, err
+		}
+
+		if int(sig.Labels) < dns.CountLabel(set.owner()) {
+			// The set is synthesized from a wildcard, so the exact match must
+			// be proven to not exist.  See RFC 4035 Section 5.3.4.
+			var insecure bool
+			insecure, err = zk.verifyExpansion(resp.Ns, set.owner(), sig.Labels, b)
+			if err != nil {
+				return StatusBogus, err
+			} else if insecure {
+				st = StatusInsecure
+			}
+		}
+	}
+
+	return st, nil
+}
+
+// validateNegative validates the NODATA or NXDOMAIN response to the question
+// q.
+func (v *Validator) validateNegative(
+	ctx context.Context,
+	resp *dns.Msg,
+	q dns.Question,
+	b *budget,
+) (st Status, err error) {
+	zk, err := v.zoneFor(ctx, q.Name, q.Qtype, b)
+	if err != nil {
+		return StatusBogus, err
+	} else if zk.insecure() {
+		return StatusInsecure, nil
+	}
+
+	d, err := zk.verifyAuthority(resp.Ns, b)
+	if err != nil {
+		return StatusBogus, err
+	} else if d.insecure {
+		return StatusInsecure, nil
+	}
+
+	if resp.Rcode == dns.RcodeNameError {
+		if !d.provesNXDOMAIN(q.Name) {
+			return StatusBogus, fmt.Errorf("no proof of nonexistence: %w", ErrBogus)
+		}
+	} else if !d.provesNODATA(q.Name, q.Qtype) {
+		return StatusBogus, fmt.Errorf("no proof of nodata: %w", ErrBogus)
+	}
+
+	return StatusSecure, nil
+}
+
+// rrset is a set of resource records with the same owner, type, and class
+// along with the signatures covering them.
+type rrset struct {
+	// rrs are the records of the set.  It's never empty.
+	rrs []dns.RR
+
+	// sigs are the signatures covering rrs.
+	sigs []*dns.RRSIG
+}
+
+// owner returns the owner name of the set.
+func (s *rrset) owner() (name string) {
+	return s.rrs[0].Header().Name
+}
+
+// rrtype returns the type of the records in the set.
+func (s *rrset) rrtype() (t uint16) {
+	return s.rrs[0].Header().Rrtype
+}
+
+// minTTL returns the lowest TTL of the records and the remaining validity of
+// the signatures in s.
+func (s *rrset) minTTL(now time.Time) (ttl time.Duration) {
+	ttl = time.Duration(s.rrs[0].Header().Ttl) * time.Second
+	for _, rr := range s.rrs[1:] {
+		ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+	}
+
+	for _, sig := range s.sigs {
+		exp := time.Unix(int64(sig.Expiration), 0)
+		ttl = min(ttl, exp.Sub(now))
+	}
+
+	return ttl
+}
+
+// splitRRsets groups rrs into the sets of records with the same owner, type,
+// and class.  The signatures are attached to the sets they cover.
+func splitRRsets(rrs []dns.RR) (sets []*rrset) {
+	var sigs []*dns.RRSIG
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+
+			continue
+		}
+
+		set := findRRset(sets, rr.Header().Name, rr.Header().Rrtype, rr.Header().Class)
+		if set == nil {
+			set = &rrset{}
+			sets = append(sets, set)
+		}
+
+		set.rrs = append(set.rrs, rr)
+	}
+
+	for _, sig := range sigs {
+		set := findRRset(sets, sig.Hdr.Name, sig.TypeCovered, sig.Hdr.Class)
+		if set != nil {
+			set.sigs = append(set.sigs, sig)
+		}
+	}
+
+	return sets
+}
+
+// findRRset returns the set from sets with the given owner, type, and class,
+// or nil if there is none.
+func findRRset(sets []*rrset, name string, t, class uint16) (set *rrset) {
+	for _, s := range sets {
+		h := s.rrs[0].Header()
+		if h.Rrtype == t && h.Class == class && strings.EqualFold(h.Name, name) {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// IsDNSSECType returns true if t is a type of a record only useful for the
+// DNSSEC-aware clients.
+func IsDNSSECType(t uint16) (ok bool) {
+	switch t {
+	case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,420 @@
+package dnssec_test
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/dnssec"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testTTL is the TTL of the records in tests.
+const testTTL = 3600
+
+// testSigner signs the records of a zone.
+type testSigner struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+// newTestSigner generates a key for zone.
+func newTestSigner(t *testing.T, zone string) (s *testSigner) {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    testTTL,
+		},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, err := key.Generate(256)
+	require.NoError(t, err)
+
+	signer, ok := priv.(crypto.Signer)
+	require.True(t, ok)
+
+	return &testSigner{
+		key:  key,
+		priv: signer,
+	}
+}
+
+// sign returns rrs followed by their signature.
+func (s *testSigner) sign(t *testing.T, rrs ...dns.RR) (signed []dns.RR) {
+	t.Helper()
+
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: testTTL},
+		Algorithm:  s.key.Algorithm,
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:     s.key.KeyTag(),
+		SignerName: s.key.Hdr.Name,
+	}
+
+	err := sig.Sign(s.priv, rrs)
+	require.NoError(t, err)
+
+	return append(rrs, sig)
+}
+
+// newRR is a helper that parses the record in the presentation format.
+func newRR(t *testing.T, s string) (rr dns.RR) {
+	t.Helper()
+
+	rr, err := dns.NewRR(s)
+	require.NoError(t, err)
+
+	return rr
+}
+
+// testExchanger is the stand-in upstream serving the prepared responses.
+type testExchanger struct {
+	resps map[string]*dns.Msg
+	count atomic.Int32
+}
+
+// type check
+var _ dnssec.Exchanger = (*testExchanger)(nil)
+
+// Exchange implements the [dnssec.Exchanger] interface for *testExchanger.
+func (e *testExchanger) Exchange(_ context.Context, req *dns.Msg) (resp *dns.Msg, err error) {
+	e.count.Add(1)
+
+	q := req.Question[0]
+	resp, ok := e.resps[respKey(q.Name, q.Qtype)]
+	if !ok {
+		return (&dns.Msg{}).SetRcode(req, dns.RcodeServerFailure), nil
+	}
+
+	return resp.Copy().SetReply(req), nil
+}
+
+// respKey returns the key of the response in the testExchanger.
+func respKey(name string, t uint16) (key string) {
+	return fmt.Sprintf("%s %s", name, dns.Type(t))
+}
+
+// newResp returns a response with the given sections.
+func newResp(name string, t uint16, rcode int, ans, ns []dns.RR) (resp *dns.Msg) {
+	resp = (&dns.Msg{}).SetQuestion(name, t)
+	resp.Response = true
+	resp.Rcode = rcode
+	resp.Answer = ans
+	resp.Ns = ns
+
+	return resp
+}
+
+// newTestValidator returns a validator trusting the root key and the exchanger
+// serving the signed root zone, the signed zone "example.", and the unsigned
+// zone "insecure.".
+func newTestValidator(t *testing.T) (v *dnssec.Validator, e *testExchanger, ex *testSigner) {
+	t.Helper()
+
+	root := newTestSigner(t, ".")
+	ex = newTestSigner(t, "example.")
+
+	rootSOA := newRR(t, ". 3600 IN SOA ns. host. 1 7200 3600 1209600 300")
+	exSOA := newRR(t, "example. 3600 IN SOA ns.example. host.example. 1 7200 3600 1209600 300")
+
+	e = &testExchanger{
+		resps: map[string]*dns.Msg{
+			respKey(".", dns.TypeDNSKEY): newResp(
+				".", dns.TypeDNSKEY, dns.RcodeSuccess, root.sign(t, root.key), nil,
+			),
+			respKey("example.", dns.TypeDS): newResp(
+				"example.", dns.TypeDS, dns.RcodeSuccess,
+				root.sign(t, ex.key.ToDS(dns.SHA256)), nil,
+			),
+			respKey("example.", dns.TypeDNSKEY): newResp(
+				"example.", dns.TypeDNSKEY, dns.RcodeSuccess, ex.sign(t, ex.key), nil,
+			),
+			respKey("insecure.", dns.TypeDS): newResp(
+				"insecure.", dns.TypeDS, dns.RcodeSuccess, nil, append(
+					root.sign(t, rootSOA),
+					root.sign(t, newRR(t, "insecure. 3600 IN NSEC zzz. NS RRSIG NSEC"))...,
+				),
+			),
+			respKey("www.example.", dns.TypeDS): newResp(
+				"www.example.", dns.TypeDS, dns.RcodeSuccess, nil, append(
+					ex.sign(t, exSOA),
+					ex.sign(t, newRR(t, "www.example. 3600 IN NSEC example. A RRSIG NSEC"))...,
+				),
+			),
+			respKey("wild.example.", dns.TypeDS): newResp(
+				"wild.example.", dns.TypeDS, dns.RcodeNameError, nil, append(
+					ex.sign(t, exSOA),
+					ex.sign(t, newRR(t, "example. 3600 IN NSEC www.example. SOA RRSIG NSEC DNSKEY"))...,
+				),
+			),
+			respKey("none.example.", dns.TypeDS): newResp(
+				"none.example.", dns.TypeDS, dns.RcodeNameError, nil, append(
+					ex.sign(t, exSOA),
+					ex.sign(t, newRR(t, "example. 3600 IN NSEC www.example. SOA RRSIG NSEC DNSKEY"))...,
+				),
+			),
+		},
+	}
+
+	v, err := dnssec.New(&dnssec.Config{
+		Exchanger:    e,
+		TrustAnchors: []string{root.key.ToDS(dns.SHA256).String()},
+		CacheSize:    100,
+	})
+	require.NoError(t, err)
+
+	return v, e, ex
+}
+
+func TestValidator_Validate(t *testing.T) {
+	v, _, ex := newTestValidator(t)
+
+	const wwwA = "www.example. 3600 IN A 192.0.2.1"
+
+	signedA := ex.sign(t, newRR(t, wwwA))
+	forged := newRR(t, "www.example. 3600 IN A 192.0.2.2")
+
+	exSOA := newRR(t, "example. 3600 IN SOA ns.example. host.example. 1 7200 3600 1209600 300")
+	nsec := newRR(t, "example. 3600 IN NSEC www.example. SOA RRSIG NSEC DNSKEY")
+	nsecNoWildcard := newRR(t, "m.example. 3600 IN NSEC p.example. A RRSIG NSEC")
+
+	// Only check the NSEC3 records hashed with a sane number of iterations.
+	// See RFC 9276.
+	nsec3Costly := newRR(t, "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom.example. 3600 IN NSEC3 "+
+		"1 0 500 - 2vptu5timamqttgl4luu9kg21e0aor3s A RRSIG")
+
+	// Make the validator check lots of signatures by the key, see
+	// CVE-2023-50387.
+	manySigs := []dns.RR{newRR(t, wwwA)}
+	forgedSig := ex.sign(t, forged)[1].(*dns.RRSIG)
+	for range 100 {
+		sig := dns.Copy(signedA[1]).(*dns.RRSIG)
+		sig.Signature = forgedSig.Signature
+		manySigs = append(manySigs, sig)
+	}
+
+	// Expand the signed wildcard into the requested name.
+	wildA := ex.sign(t, newRR(t, "*.example. 3600 IN A 192.0.2.4"))
+	for _, rr := range wildA {
+		rr.Header().Name = "wild.example."
+	}
+
+	testCases := []struct {
+		resp    *dns.Msg
+		name    string
+		wantErr string
+		want    dnssec.Status
+	}{{
+		resp: newResp("www.example.", dns.TypeA, dns.RcodeSuccess, signedA, nil),
+		name: "secure",
+		want: dnssec.StatusSecure,
+	}, {
+		resp: newResp("www.insecure.", dns.TypeA, dns.RcodeSuccess, []dns.RR{
+			newRR(t, "www.insecure. 3600 IN A 192.0.2.3"),
+		}, nil),
+		name: "insecure",
+		want: dnssec.StatusInsecure,
+	}, {
+		resp: newResp("www.example.", dns.TypeA, dns.RcodeSuccess, []dns.RR{
+			forged,
+			signedA[1],
+		}, nil),
+		name: "forged",
+		wantErr: "validating www.example.: www.example. A: " +
+			"no valid signature by example.: bogus response",
+		want: dnssec.StatusBogus,
+	}, {
+		resp: newResp("www.example.", dns.TypeA, dns.RcodeSuccess, []dns.RR{
+			newRR(t, wwwA),
+		}, nil),
+		name: "unsigned",
+		wantErr: "validating www.example.: www.example. A: " +
+			"no valid signature by example.: bogus response",
+		want: dnssec.StatusBogus,
+	}, {
+		resp: newResp(
+			"www.example.",
+			dns.TypeAAAA,
+			dns.RcodeSuccess,
+			nil,
+			append(
+				ex.sign(t, exSOA),
+				ex.sign(t, newRR(t, "www.example. 3600 IN NSEC example. A RRSIG NSEC"))...,
+			),
+		),
+		name: "nodata",
+		want: dnssec.StatusSecure,
+	}, {
+		resp: newResp(
+			"none.example.",
+			dns.TypeA,
+			dns.RcodeNameError,
+			nil,
+			append(ex.sign(t, exSOA), ex.sign(t, nsec)...),
+		),
+		name: "nxdomain",
+		want: dnssec.StatusSecure,
+	}, {
+		resp: newResp("none.example.", dns.TypeA, dns.RcodeNameError, nil, ex.sign(t, exSOA)),
+		name: "nxdomain_no_proof",
+		wantErr: "validating none.example.: no proof of nonexistence: " +
+			"bogus response",
+		want: dnssec.StatusBogus,
+	}, {
+		resp: newResp(
+			"none.example.",
+			dns.TypeA,
+			dns.RcodeNameError,
+			nil,
+			append(ex.sign(t, exSOA), ex.sign(t, nsecNoWildcard)...),
+		),
+		name: "nxdomain_no_wildcard_proof",
+		wantErr: "validating none.example.: no proof of nonexistence: " +
+			"bogus response",
+		want: dnssec.StatusBogus,
+	}, {
+		resp: newResp(
+			"none.example.",
+			dns.TypeA,
+			dns.RcodeNameError,
+			nil,
+			append(ex.sign(t, exSOA), ex.sign(t, nsec3Costly)...),
+		),
+		name: "nsec3_too_many_iterations",
+		want: dnssec.StatusInsecure,
+	}, {
+		resp: newResp("www.example.", dns.TypeA, dns.RcodeSuccess, manySigs, nil),
+		name: "too_many_signatures",
+		wantErr: "validating www.example.: www.example. A: " +
+			"too many signature validations: bogus response",
+		want: dnssec.StatusBogus,
+	}, {
+		resp: newResp("wild.example.", dns.TypeA, dns.RcodeSuccess, wildA, ex.sign(t, nsec)),
+		name: "wildcard",
+		want: dnssec.StatusSecure,
+	}, {
+		resp: newResp("wild.example.", dns.TypeA, dns.RcodeSuccess, wildA, nil),
+		name: "wildcard_no_proof",
+		wantErr: "validating wild.example.: wild.example.: " +
+			"no proof of wildcard expansion: bogus response",
+		want: dnssec.StatusBogus,
+	}, {
+		resp: newResp("www.example.", dns.TypeA, dns.RcodeServerFailure, nil, nil),
+		name: "servfail",
+		want: dnssec.StatusNone,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := v.Validate(context.Background(), tc.resp)
+			testutil.AssertErrorMsg(t, tc.wantErr, err)
+			assert.Equal(t, tc.want, st)
+		})
+	}
+}
+
+func TestValidator_Validate_cache(t *testing.T) {
+	v, e, ex := newTestValidator(t)
+
+	resp := newResp(
+		"www.example.",
+		dns.TypeA,
+		dns.RcodeSuccess,
+		ex.sign(t, newRR(t, "www.example. 3600 IN A 192.0.2.1")),
+		nil,
+	)
+
+	st, err := v.Validate(context.Background(), resp)
+	require.NoError(t, err)
+	require.Equal(t, dnssec.StatusSecure, st)
+
+	// DNSKEY for the root and the zone, DS for the zone and the name.
+	const wantExchanges = 4
+	require.EqualValues(t, wantExchanges, e.count.Load())
+
+	st, err = v.Validate(context.Background(), resp)
+	require.NoError(t, err)
+
+	assert.Equal(t, dnssec.StatusSecure, st)
+	assert.EqualValues(t, wantExchanges, e.count.Load())
+}
+
+func TestValidator_Validate_keyTagCollision(t *testing.T) {
+	v, e, ex := newTestValidator(t)
+
+	pub, err := base64.StdEncoding.DecodeString(ex.key.PublicKey)
+	require.NoError(t, err)
+
+	// Swapping the 16-bit words of the public key doesn't change the key tag,
+	// so the zone has lots of bad keys with the same tag as the good one.  See
+	// CVE-2023-50387.
+	var keys []dns.RR
+	for i := 2; i < len(pub); i += 2 {
+		bad := slices.Clone(pub)
+		bad[0], bad[1], bad[i], bad[i+1] = bad[i], bad[i+1], bad[0], bad[1]
+
+		key := dns.Copy(ex.key).(*dns.DNSKEY)
+		key.PublicKey = base64.StdEncoding.EncodeToString(bad)
+		require.Equal(t, ex.key.KeyTag(), key.KeyTag())
+
+		keys = append(keys, key)
+	}
+
+	e.resps[respKey("example.", dns.TypeDNSKEY)] = newResp(
+		"example.",
+		dns.TypeDNSKEY,
+		dns.RcodeSuccess,
+		ex.sign(t, append(keys, ex.key)...),
+		nil,
+	)
+
+	resp := newResp(
+		"www.example.",
+		dns.TypeA,
+		dns.RcodeSuccess,
+		ex.sign(t, newRR(t, "www.example. 3600 IN A 192.0.2.1")),
+		nil,
+	)
+
+	st, err := v.Validate(context.Background(), resp)
+	testutil.AssertErrorMsg(
+		t,
+		"validating www.example.: ds of www.example.: example. SOA: "+
+			"no valid signature by example.: bogus response",
+		err,
+	)
+	assert.Equal(t, dnssec.StatusBogus, st)
+}
+
+func TestNew_badAnchor(t *testing.T) {
+	_, err := dnssec.New(&dnssec.Config{
+		TrustAnchors: []string{"example. 3600 IN A 192.0.2.1"},
+		CacheSize:    1,
+	})
+	testutil.AssertErrorMsg(
+		t,
+		`trust anchors: anchor at index 0: bad record "example. 3600 IN A 192.0.2.1"`,
+		err,
+	)
+}
@@ -0,0 +1,177 @@
+// Package dnstap contains the encoder of dnstap messages and the writer sending
+// them to a collector using the Frame Streams protocol.
+//
+// See https://dnstap.info.
+package dnstap
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/tukimoto/AdGuardHome/internal/aghproto"
+)
+
+// ContentType is the Frame Streams content type of dnstap data frames.
+const ContentType = "protobuf:dnstap.Dnstap"
+
+// MessageType is the type of a dnstap message.
+type MessageType uint8
+
+// Message types used by AdGuard Home.  See the dnstap.proto file for the full
+// list.
+const (
+	MessageTypeClientQuery       MessageType = 5
+	MessageTypeClientResponse    MessageType = 6
+	MessageTypeForwarderQuery    MessageType = 7
+	MessageTypeForwarderResponse MessageType = 8
+)
+
+// SocketProtocol is the transport protocol of a message.
+type SocketProtocol uint8
+
+// Socket protocols.
+const (
+	SocketProtocolUDP         SocketProtocol = 1
+	SocketProtocolTCP         SocketProtocol = 2
+	SocketProtocolDOT         SocketProtocol = 3
+	SocketProtocolDOH         SocketProtocol = 4
+	SocketProtocolDNSCryptUDP SocketProtocol = 5
+	SocketProtocolDNSCryptTCP SocketProtocol = 6
+	SocketProtocolDOQ         SocketProtocol = 7
+)
+
+// Socket families.
+const (
+	socketFamilyINET  = 1
+	socketFamilyINET6 = 2
+)
+
+// Field numbers of the Dnstap message.
+const (
+	fieldDnstapIdentity = 1
+	fieldDnstapVersion  = 2
+	fieldDnstapMessage  = 14
+	fieldDnstapType     = 15
+
+	// dnstapTypeMessage is the only type of the Dnstap message.
+	dnstapTypeMessage = 1
+)
+
+// Field numbers of the Message message.
+const (
+	fieldMsgType             = 1
+	fieldMsgSocketFamily     = 2
+	fieldMsgSocketProtocol   = 3
+	fieldMsgQueryAddress     = 4
+	fieldMsgResponseAddress  = 5
+	fieldMsgQueryPort        = 6
+	fieldMsgResponsePort     = 7
+	fieldMsgQueryTimeSec     = 8
+	fieldMsgQueryTimeNsec    = 9
+	fieldMsgQueryMessage     = 10
+	fieldMsgResponseTimeSec  = 12
+	fieldMsgResponseTimeNsec = 13
+	fieldMsgResponseMessage  = 14
+)
+
+// Message is a single dnstap message.
+type Message struct {
+	// QueryTime is the time at which the query has been sent or received.
+	QueryTime time.Time
+
+	// ResponseTime is the time at which the response has been sent or
+	// received.  It should be zero for query messages.
+	ResponseTime time.Time
+
+	// QueryAddr is the address of the initiator of the query.
+	QueryAddr netip.AddrPort
+
+	// ResponseAddr is the address of the responder.
+	ResponseAddr netip.AddrPort
+
+	// QueryMessage is the wire-format query.  It's omitted if empty.
+	QueryMessage []byte
+
+	// ResponseMessage is the wire-format response.  It's omitted if empty.
+	ResponseMessage []byte
+
+	// Type is the type of the message.
+	Type MessageType
+
+	// SocketProtocol is the transport protocol.  It's omitted if zero.
+	SocketProtocol SocketProtocol
+}
+
+// Marshal returns the Dnstap message with m encoded in the protobuf wire
+// format.  identity and version are omitted if empty.
+func Marshal(identity, version string, m *Message) (b []byte) {
+	if identity != "" {
+		b = aghproto.AppendString(b, fieldDnstapIdentity, identity)
+	}
+
+	if version != "" {
+		b = aghproto.AppendString(b, fieldDnstapVersion, version)
+	}
+
+	b = aghproto.AppendBytes(b, fieldDnstapMessage, m.appendProto(nil))
+
+	return aghproto.AppendUint(b, fieldDnstapType, dnstapTypeMessage)
+}
+
+// appendProto appends m encoded as the Message message to b.
+func (m *Message) appendProto(b []byte) (res []byte) {
+	b = aghproto.AppendUint(b, fieldMsgType, uint64(m.Type))
+
+	if m.QueryAddr.IsValid() {
+		family := uint64(socketFamilyINET)
+		if m.QueryAddr.Addr().Unmap().Is6() {
+			family = socketFamilyINET6
+		}
+
+		b = aghproto.AppendUint(b, fieldMsgSocketFamily, family)
+	}
+
+	if m.SocketProtocol != 0 {
+		b = aghproto.AppendUint(b, fieldMsgSocketProtocol, uint64(m.SocketProtocol))
+	}
+
+	b = appendAddrPort(b, fieldMsgQueryAddress, fieldMsgQueryPort, m.QueryAddr)
+	b = appendAddrPort(b, fieldMsgResponseAddress, fieldMsgResponsePort, m.ResponseAddr)
+	b = appendTime(b, fieldMsgQueryTimeSec, fieldMsgQueryTimeNsec, m.QueryTime)
+
+	if len(m.QueryMessage) > 0 {
+		b = aghproto.AppendBytes(b, fieldMsgQueryMessage, m.QueryMessage)
+	}
+
+	b = appendTime(b, fieldMsgResponseTimeSec, fieldMsgResponseTimeNsec, m.ResponseTime)
+
+	if len(m.ResponseMessage) > 0 {
+		b = aghproto.AppendBytes(b, fieldMsgResponseMessage, m.ResponseMessage)
+	}
+
+	return b
+}
+
+// appendAddrPort appends the address and the port fields to b unless ap is
+// invalid.
+func appendAddrPort(b []byte, addrNum, portNum uint32, ap netip.AddrPort) (res []byte) {
+	if !ap.IsValid() {
+		return b
+	}
+
+	b = aghproto.AppendBytes(b, addrNum, ap.Addr().Unmap().AsSlice())
+
+	return aghproto.AppendUint(b, portNum, uint64(ap.Port()))
+}
+
+// appendTime appends the seconds and the nanoseconds fields to b unless t is
+// zero.
+func appendTime(b []byte, secNum, nsecNum uint32, t time.Time) (res []byte) {
+	if t.IsZero() {
+		return b
+	}
+
+	b = aghproto.AppendUint(b, secNum, uint64(t.Unix()))
+
+	return aghproto.AppendFixed32(b, nsecNum, uint32(t.Nanosecond()))
+}
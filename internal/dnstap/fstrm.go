@@ -0,0 +1,143 @@
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// controlType is the type of a Frame Streams control frame.
+type controlType uint32
+
+// Control frame types.
+//
+// See https://github.com/farsightsec/fstrm/blob/master/fstrm/control.h.
+const (
+	controlAccept controlType = 0x01
+	controlStart  controlType = 0x02
+	controlStop   controlType = 0x03
+	controlReady  controlType = 0x04
+	controlFinish controlType = 0x05
+)
+
+// controlFieldContentType is the type of the content type field of a control
+// frame.
+const controlFieldContentType = 0x01
+
+// maxControlFrameSize is the maximum size of a control frame accepted from the
+// collector.
+const maxControlFrameSize = 512
+
+// String implements the [fmt.Stringer] interface for controlType.
+func (t controlType) String() (s string) {
+	switch t {
+	case controlAccept:
+		return "ACCEPT"
+	case controlStart:
+		return "START"
+	case controlStop:
+		return "STOP"
+	case controlReady:
+		return "READY"
+	case controlFinish:
+		return "FINISH"
+	default:
+		return fmt.Sprintf("!bad_control_type_%d", uint32(t))
+	}
+}
+
+// writeControl writes the control frame of type t with an optional content
+// type field to w.
+func writeControl(w io.Writer, t controlType, contentType string) (err error) {
+	payload := binary.BigEndian.AppendUint32(nil, uint32(t))
+	if contentType != "" {
+		payload = binary.BigEndian.AppendUint32(payload, controlFieldContentType)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(contentType)))
+		payload = append(payload, contentType...)
+	}
+
+	// A control frame starts with the escape sequence, which is the zero
+	// length of a data frame.
+	b := binary.BigEndian.AppendUint32(nil, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	b = append(b, payload...)
+
+	_, err = w.Write(b)
+	if err != nil {
+		return fmt.Errorf("writing %s frame: %w", t, err)
+	}
+
+	return nil
+}
+
+// readControl reads a control frame from r and returns its type along with the
+// content types it contains.
+func readControl(r io.Reader) (t controlType, contentTypes []string, err error) {
+	var hdr [8]byte
+	_, err = io.ReadFull(r, hdr[:])
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading control frame header: %w", err)
+	}
+
+	if esc := binary.BigEndian.Uint32(hdr[:4]); esc != 0 {
+		return 0, nil, fmt.Errorf("expected control frame, got data frame of %d bytes", esc)
+	}
+
+	size := binary.BigEndian.Uint32(hdr[4:])
+	if size < 4 || size > maxControlFrameSize {
+		return 0, nil, fmt.Errorf("bad control frame size %d", size)
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading control frame: %w", err)
+	}
+
+	t = controlType(binary.BigEndian.Uint32(payload))
+	payload = payload[4:]
+	for len(payload) >= 8 {
+		ft := binary.BigEndian.Uint32(payload)
+		fl := binary.BigEndian.Uint32(payload[4:])
+		payload = payload[8:]
+		if uint32(len(payload)) < fl {
+			return 0, nil, errors.Error("truncated control frame field")
+		}
+
+		if ft == controlFieldContentType {
+			contentTypes = append(contentTypes, string(payload[:fl]))
+		}
+
+		payload = payload[fl:]
+	}
+
+	return t, contentTypes, nil
+}
+
+// expectControl reads a control frame from r and returns an error if its type
+// isn't want.
+func expectControl(r io.Reader, want controlType) (contentTypes []string, err error) {
+	t, contentTypes, err := readControl(r)
+	if err != nil {
+		return nil, err
+	} else if t != want {
+		return nil, fmt.Errorf("expected %s frame, got %s", want, t)
+	}
+
+	return contentTypes, nil
+}
+
+// writeData writes the data frame with payload to w.
+func writeData(w *bufio.Writer, payload []byte) (err error) {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(payload)))
+	_, err = w.Write(hdr[:])
+	if err == nil {
+		_, err = w.Write(payload)
+	}
+
+	return err
+}
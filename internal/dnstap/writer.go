@@ -0,0 +1,286 @@
+package dnstap
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Network names of the collectors.
+const (
+	NetworkUnix = "unix"
+	NetworkTCP  = "tcp"
+)
+
+// Config is the configuration of a [Writer].
+type Config struct {
+	// Network is the network of the collector: "unix" or "tcp".
+	Network string
+
+	// Address is the path to the Unix socket or the host:port address of the
+	// collector.
+	Address string
+
+	// Identity is the identity of the server sent in each message.  It's
+	// omitted if empty.
+	Identity string
+
+	// Version is the version of the server sent in each message.  It's omitted
+	// if empty.
+	Version string
+}
+
+// Writer constants.
+const (
+	// queueSize is the number of messages waiting to be sent, after which new
+	// messages are dropped.
+	queueSize = 4096
+
+	// ioTimeout is the timeout of connecting, the handshake, and writing.
+	ioTimeout = 10 * time.Second
+
+	// maxReconnectIvl is the maximum time between the attempts to connect to
+	// the collector.
+	maxReconnectIvl = 30 * time.Second
+)
+
+// Writer sends dnstap messages to a collector using the bidirectional Frame
+// Streams protocol.  It reconnects to the collector if the connection is lost.
+// Messages are dropped while there is no connection.
+type Writer struct {
+	// frames is the queue of the encoded messages.  It's never closed.
+	frames chan []byte
+
+	// stop is closed when the writer must finish.
+	stop chan struct{}
+
+	// done is closed when the writing goroutine exits.
+	done chan struct{}
+
+	// stopOnce protects stop from being closed twice.
+	stopOnce *sync.Once
+
+	network  string
+	address  string
+	identity string
+	version  string
+
+	// initialReconnectIvl is the time before the first reconnection attempt.
+	// It's doubled after each failed attempt.
+	initialReconnectIvl time.Duration
+}
+
+// New returns a new dnstap writer.  conf must not be nil.  The writer must be
+// started with [Writer.Start].
+func New(conf *Config) (w *Writer, err error) {
+	switch conf.Network {
+	case NetworkUnix, NetworkTCP:
+		// Go on.
+	default:
+		return nil, fmt.Errorf("bad network %q", conf.Network)
+	}
+
+	if conf.Address == "" {
+		return nil, errors.Error("empty address")
+	}
+
+	return &Writer{
+		frames:              make(chan []byte, queueSize),
+		stop:                make(chan struct{}),
+		done:                make(chan struct{}),
+		stopOnce:            &sync.Once{},
+		network:             conf.Network,
+		address:             conf.Address,
+		identity:            conf.Identity,
+		version:             conf.Version,
+		initialReconnectIvl: 1 * time.Second,
+	}, nil
+}
+
+// Start starts sending the messages in the background.
+func (w *Writer) Start() {
+	go w.run()
+}
+
+// Write queues m for sending.  It never blocks.  m may be reused after the
+// call.
+func (w *Writer) Write(m *Message) {
+	select {
+	case w.frames <- Marshal(w.identity, w.version, m):
+	default:
+		log.Debug("dnstap: queue is full, dropping message")
+	}
+}
+
+// Close sends the queued messages, finishes the stream, and closes the
+// connection.
+func (w *Writer) Close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// run connects to the collector and sends the messages until the writer is
+// closed.
+func (w *Writer) run() {
+	defer log.OnPanic("dnstap: writing")
+	defer close(w.done)
+
+	ivl := w.initialReconnectIvl
+	for {
+		conn, err := w.connect()
+		if err != nil {
+			log.Error("dnstap: connecting to %s %s: %s; retrying in %s", w.network, w.address, err, ivl)
+			if !w.sleep(ivl) {
+				return
+			}
+
+			ivl = min(ivl*2, maxReconnectIvl)
+
+			continue
+		}
+
+		log.Info("dnstap: connected to %s %s", w.network, w.address)
+		ivl = w.initialReconnectIvl
+
+		stopped, err := w.serve(conn)
+		if err != nil {
+			log.Error("dnstap: %s", err)
+		}
+
+		if stopped {
+			return
+		}
+	}
+}
+
+// sleep waits for ivl and returns false if the writer has been closed
+// meanwhile.
+func (w *Writer) sleep(ivl time.Duration) (ok bool) {
+	t := time.NewTimer(ivl)
+	defer t.Stop()
+
+	// Drop the messages while waiting, so that the stale ones aren't sent
+	// after reconnecting.
+	for {
+		select {
+		case <-w.frames:
+		case <-t.C:
+			return true
+		case <-w.stop:
+			return false
+		}
+	}
+}
+
+// connect connects to the collector and performs the handshake.
+func (w *Writer) connect() (conn net.Conn, err error) {
+	conn, err = net.DialTimeout(w.network, w.address, ioTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = handshake(conn)
+	if err != nil {
+		return nil, errors.WithDeferred(err, conn.Close())
+	}
+
+	return conn, nil
+}
+
+// handshake performs the writer's part of the bidirectional handshake.
+func handshake(conn net.Conn) (err error) {
+	err = conn.SetDeadline(time.Now().Add(ioTimeout))
+	if err != nil {
+		return fmt.Errorf("setting deadline: %w", err)
+	}
+
+	err = writeControl(conn, controlReady, ContentType)
+	if err != nil {
+		return err
+	}
+
+	contentTypes, err := expectControl(conn, controlAccept)
+	if err != nil {
+		return err
+	} else if len(contentTypes) > 0 && !slices.Contains(contentTypes, ContentType) {
+		return fmt.Errorf("collector doesn't accept %q", ContentType)
+	}
+
+	err = writeControl(conn, controlStart, ContentType)
+	if err != nil {
+		return err
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
+
+// serve writes the queued messages to conn until the writer is closed or an
+// error occurs.  stopped is true if the writer has been closed.  conn is
+// closed.
+func (w *Writer) serve(conn net.Conn) (stopped bool, err error) {
+	defer func() { err = errors.WithDeferred(err, conn.Close()) }()
+
+	bw := bufio.NewWriter(conn)
+	for {
+		select {
+		case f := <-w.frames:
+			err = w.writeFrame(conn, bw, f)
+			if err != nil {
+				return false, fmt.Errorf("writing: %w", err)
+			}
+		case <-w.stop:
+			return true, w.finish(conn, bw)
+		}
+	}
+}
+
+// writeFrame writes the data frame f to bw and flushes it if there are no
+// more queued messages.
+func (w *Writer) writeFrame(conn net.Conn, bw *bufio.Writer, f []byte) (err error) {
+	err = conn.SetWriteDeadline(time.Now().Add(ioTimeout))
+	if err != nil {
+		return err
+	}
+
+	err = writeData(bw, f)
+	if err != nil || len(w.frames) > 0 {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// finish writes the remaining queued messages and finishes the stream.
+func (w *Writer) finish(conn net.Conn, bw *bufio.Writer) (err error) {
+	err = conn.SetDeadline(time.Now().Add(ioTimeout))
+	if err != nil {
+		return fmt.Errorf("setting deadline: %w", err)
+	}
+
+	// Only this goroutine receives from w.frames, so the receive never blocks.
+	for len(w.frames) > 0 {
+		err = writeData(bw, <-w.frames)
+		if err != nil {
+			return fmt.Errorf("writing: %w", err)
+		}
+	}
+
+	err = writeControl(bw, controlStop, "")
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = expectControl(conn, controlFinish)
+
+	return err
+}
@@ -0,0 +1,176 @@
+package dnstap
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghproto"
+)
+
+// collect runs a Frame Streams reader on the first connection of ln and sends
+// the received data frames to the returned channel, which is closed when the
+// stream is finished.
+func collect(t *testing.T, ln net.Listener) (frames chan []byte) {
+	t.Helper()
+
+	frames = make(chan []byte, 16)
+	go func() {
+		defer close(frames)
+
+		conn, err := ln.Accept()
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+
+		contentTypes, err := expectControl(conn, controlReady)
+		require.NoError(t, err)
+		assert.Equal(t, []string{ContentType}, contentTypes)
+
+		require.NoError(t, writeControl(conn, controlAccept, ContentType))
+
+		_, err = expectControl(conn, controlStart)
+		require.NoError(t, err)
+
+		for {
+			var hdr [4]byte
+			_, err = io.ReadFull(conn, hdr[:])
+			require.NoError(t, err)
+
+			size := binary.BigEndian.Uint32(hdr[:])
+			if size == 0 {
+				// Escape sequence of a control frame.
+				var ctrlSize [4]byte
+				_, err = io.ReadFull(conn, ctrlSize[:])
+				require.NoError(t, err)
+
+				ctrl := make([]byte, binary.BigEndian.Uint32(ctrlSize[:]))
+				_, err = io.ReadFull(conn, ctrl)
+				require.NoError(t, err)
+				require.Equal(t, controlStop, controlType(binary.BigEndian.Uint32(ctrl)))
+
+				require.NoError(t, writeControl(conn, controlFinish, ""))
+
+				return
+			}
+
+			data := make([]byte, size)
+			_, err = io.ReadFull(conn, data)
+			require.NoError(t, err)
+
+			frames <- data
+		}
+	}()
+
+	return frames
+}
+
+// parseDnstap returns the fields of the Dnstap message and of the embedded
+// Message message by their numbers.
+func parseDnstap(t *testing.T, data []byte) (top, msg map[uint32]*aghproto.Field) {
+	t.Helper()
+
+	fields, err := aghproto.ParseMessage(data)
+	require.NoError(t, err)
+
+	top = map[uint32]*aghproto.Field{}
+	for _, f := range fields {
+		top[f.Num] = f
+	}
+
+	require.Contains(t, top, uint32(fieldDnstapMessage))
+
+	fields, err = aghproto.ParseMessage(top[fieldDnstapMessage].Bytes)
+	require.NoError(t, err)
+
+	msg = map[uint32]*aghproto.Field{}
+	for _, f := range fields {
+		msg[f.Num] = f
+	}
+
+	return top, msg
+}
+
+func TestWriter(t *testing.T) {
+	testCases := []struct {
+		listen  func(t *testing.T) (ln net.Listener)
+		network string
+	}{{
+		listen: func(t *testing.T) (ln net.Listener) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+
+			return ln
+		},
+		network: NetworkTCP,
+	}, {
+		listen: func(t *testing.T) (ln net.Listener) {
+			ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "dnstap.sock"))
+			require.NoError(t, err)
+
+			return ln
+		},
+		network: NetworkUnix,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.network, func(t *testing.T) {
+			ln := tc.listen(t)
+			t.Cleanup(func() { _ = ln.Close() })
+
+			frames := collect(t, ln)
+
+			w, err := New(&Config{
+				Network:  tc.network,
+				Address:  ln.Addr().String(),
+				Identity: "agh",
+				Version:  "v0.0.0",
+			})
+			require.NoError(t, err)
+
+			w.Start()
+
+			now := time.Unix(1_700_000_000, 42)
+			w.Write(&Message{
+				QueryTime:      now,
+				QueryAddr:      netip.MustParseAddrPort("[::ffff:1.2.3.4]:5353"),
+				QueryMessage:   []byte{1, 2, 3},
+				Type:           MessageTypeClientQuery,
+				SocketProtocol: SocketProtocolUDP,
+			})
+
+			var data []byte
+			select {
+			case data = <-frames:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no frame received")
+			}
+
+			w.Close()
+
+			top, msg := parseDnstap(t, data)
+			assert.Equal(t, "agh", string(top[fieldDnstapIdentity].Bytes))
+			assert.Equal(t, "v0.0.0", string(top[fieldDnstapVersion].Bytes))
+			assert.Equal(t, uint64(dnstapTypeMessage), top[fieldDnstapType].Uint)
+
+			assert.Equal(t, uint64(MessageTypeClientQuery), msg[fieldMsgType].Uint)
+			assert.Equal(t, uint64(socketFamilyINET), msg[fieldMsgSocketFamily].Uint)
+			assert.Equal(t, uint64(SocketProtocolUDP), msg[fieldMsgSocketProtocol].Uint)
+			assert.Equal(t, []byte{1, 2, 3, 4}, msg[fieldMsgQueryAddress].Bytes)
+			assert.Equal(t, uint64(5353), msg[fieldMsgQueryPort].Uint)
+			assert.Equal(t, uint64(now.Unix()), msg[fieldMsgQueryTimeSec].Uint)
+			assert.Equal(t, uint64(42), msg[fieldMsgQueryTimeNsec].Uint)
+			assert.Equal(t, []byte{1, 2, 3}, msg[fieldMsgQueryMessage].Bytes)
+			assert.NotContains(t, msg, uint32(fieldMsgResponseTimeSec))
+
+			// Make sure the stream has been finished.
+			for range frames {
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package filtering
+
+import (
+	"slices"
+
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/filterutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+)
+
+// TagFilterLists is the selection of the filter lists applied to the
+// persistent clients having a tag.
+type TagFilterLists struct {
+	// Tag is the tag of the clients.
+	Tag string `yaml:"tag"`
+
+	// FilterLists are the IDs of the blocking and allowing filter lists
+	// applied to the clients.
+	FilterLists []rulelist.URLFilterID `yaml:"filter_lists"`
+}
+
+// ApplyTagFilterLists sets the filter lists selected for the first of tags
+// having a selection to setts.  setts isn't changed if there is none.
+func (d *DNSFilter) ApplyTagFilterLists(setts *Settings, tags []string) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	for _, tfl := range d.conf.TagFilterLists {
+		if slices.Contains(tags, tfl.Tag) {
+			setts.FilterLists = tfl.FilterLists
+			setts.UseFilterLists = true
+
+			return
+		}
+	}
+}
+
+// selectsFilterLists returns true if not all of the filter lists are applied
+// to the client of s.
+func (s *Settings) selectsFilterLists() (ok bool) {
+	return s.UseFilterLists || len(s.ExcludedFilterLists) > 0
+}
+
+// isFilterListSelected returns true if the rules of the filter list with id
+// are applied to the client of setts.  The custom filtering rules are always
+// applied.
+func (s *Settings) isFilterListSelected(id rulelist.URLFilterID) (ok bool) {
+	switch {
+	case id == rulelist.URLFilterIDCustom:
+		return true
+	case slices.Contains(s.ExcludedFilterLists, id):
+		return false
+	default:
+		return !s.UseFilterLists || slices.Contains(s.FilterLists, id)
+	}
+}
+
+// selectFilterLists returns the result of matching containing only the rules
+// of the filter lists selected in setts.  matched has the same meaning as in
+// [urlfilter.DNSEngine.MatchRequest].  setts.selectsFilterLists must return
+// true.  hosts is used to find the hosts rules of the selected lists, which the
+// engine doesn't return if a network rule of another list is matched.
+func selectFilterLists(
+	dnsres *urlfilter.DNSResult,
+	hosts *hostRulesIndex,
+	host string,
+	setts *Settings,
+) (res *urlfilter.DNSResult, matched bool) {
+	res = &urlfilter.DNSResult{
+		NetworkRules: slices.DeleteFunc(
+			slices.Clone(dnsres.NetworkRules),
+			func(r *rules.NetworkRule) (ok bool) {
+				return !setts.isFilterListSelected(r.GetFilterListID())
+			},
+		),
+	}
+
+	res.NetworkRule = rules.GetDNSBasicRule(res.NetworkRules)
+	if res.NetworkRule != nil {
+		return res, true
+	}
+
+	v4, v6 := dnsres.HostRulesV4, dnsres.HostRulesV6
+	if dnsres.NetworkRule != nil {
+		v4, v6 = hosts.match(host)
+	}
+
+	res.HostRulesV4 = selectHostRules(v4, setts)
+	res.HostRulesV6 = selectHostRules(v6, setts)
+
+	return res, res.HostRulesV4 != nil || res.HostRulesV6 != nil
+}
+
+// selectHostRules returns the rules from hostRules of the filter lists selected
+// in setts.  res is nil if there are none, since the users of
+// [urlfilter.DNSResult] check the host rules for nil.
+func selectHostRules(hostRules []*rules.HostRule, setts *Settings) (res []*rules.HostRule) {
+	for _, r := range hostRules {
+		if setts.isFilterListSelected(r.GetFilterListID()) {
+			res = append(res, r)
+		}
+	}
+
+	return res
+}
+
+// hostRulesIndex is the index of the hosts rules of a rule storage.  It's used
+// to match the hosts rules, since [urlfilter.DNSEngine] doesn't return those
+// when a network rule is matched.
+type hostRulesIndex struct {
+	// storage is the storage the rules are retrieved from.
+	storage *filterlist.RuleStorage
+
+	// table maps the hashes of the hostnames to the indexes of the rules in
+	// storage.
+	table map[uint32][]int64
+}
+
+// newHostRulesIndex returns the index of the hosts rules of s.  s must not be
+// nil.
+func newHostRulesIndex(s *filterlist.RuleStorage) (idx *hostRulesIndex) {
+	idx = &hostRulesIndex{
+		storage: s,
+		table:   map[uint32][]int64{},
+	}
+
+	scanner := s.NewRuleStorageScanner()
+	for scanner.Scan() {
+		r, storageIdx := scanner.Rule()
+		hr, ok := r.(*rules.HostRule)
+		if !ok {
+			continue
+		}
+
+		for _, h := range hr.Hostnames {
+			hash := filterutil.FastHash(h)
+			idx.table[hash] = append(idx.table[hash], storageIdx)
+		}
+	}
+
+	return idx
+}
+
+// match returns the IPv4 and IPv6 hosts rules matching host.  idx may be nil.
+func (idx *hostRulesIndex) match(host string) (v4, v6 []*rules.HostRule) {
+	if idx == nil {
+		return nil, nil
+	}
+
+	for _, storageIdx := range idx.table[filterutil.FastHash(host)] {
+		r := idx.storage.RetrieveHostRule(storageIdx)
+		if r == nil || !r.Match(host) {
+			continue
+		}
+
+		if r.IP.Is4() {
+			v4 = append(v4, r)
+		} else {
+			v6 = append(v6, r)
+		}
+	}
+
+	return v4, v6
+}
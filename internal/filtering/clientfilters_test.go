@@ -0,0 +1,190 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+)
+
+func TestDNSFilter_CheckHost_filterLists(t *testing.T) {
+	const (
+		socialListID rulelist.URLFilterID = 1
+		strictListID rulelist.URLFilterID = 2
+		allowListID  rulelist.URLFilterID = 3
+	)
+
+	blockFilters := []Filter{{
+		ID:   rulelist.URLFilterIDCustom,
+		Data: []byte("||custom.example^\n"),
+	}, {
+		ID:   socialListID,
+		Data: []byte("||social.example^\n||allowed.example^\n||overlap.example^\n"),
+	}, {
+		ID:   strictListID,
+		Data: []byte("||strict.example^\n0.0.0.0 hosts.example\n0.0.0.0 overlap.example\n"),
+	}}
+
+	allowFilters := []Filter{{
+		ID:   allowListID,
+		Data: []byte("||allowed.example^\n"),
+	}}
+
+	d, _ := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err := d.setFilters(blockFilters, allowFilters, false)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		host        string
+		filterLists []rulelist.URLFilterID
+		excluded    []rulelist.URLFilterID
+		useLists    bool
+		wantReason  Reason
+	}{{
+		name:        "all_social",
+		host:        "social.example",
+		filterLists: nil,
+		useLists:    false,
+		wantReason:  FilteredBlockList,
+	}, {
+		name:        "all_allowed",
+		host:        "allowed.example",
+		filterLists: nil,
+		useLists:    false,
+		wantReason:  NotFilteredAllowList,
+	}, {
+		name:        "strict_social",
+		host:        "social.example",
+		filterLists: []rulelist.URLFilterID{strictListID},
+		useLists:    true,
+		wantReason:  NotFilteredNotFound,
+	}, {
+		name:        "strict_strict",
+		host:        "strict.example",
+		filterLists: []rulelist.URLFilterID{strictListID},
+		useLists:    true,
+		wantReason:  FilteredBlockList,
+	}, {
+		name:        "strict_hosts",
+		host:        "hosts.example",
+		filterLists: []rulelist.URLFilterID{strictListID},
+		useLists:    true,
+		wantReason:  FilteredBlockList,
+	}, {
+		name:        "strict_custom",
+		host:        "custom.example",
+		filterLists: []rulelist.URLFilterID{strictListID},
+		useLists:    true,
+		wantReason:  FilteredBlockList,
+	}, {
+		name:        "none_custom",
+		host:        "custom.example",
+		filterLists: nil,
+		useLists:    true,
+		wantReason:  FilteredBlockList,
+	}, {
+		name:        "social_hosts",
+		host:        "hosts.example",
+		filterLists: []rulelist.URLFilterID{socialListID},
+		useLists:    true,
+		wantReason:  NotFilteredNotFound,
+	}, {
+		name:        "strict_overlap",
+		host:        "overlap.example",
+		filterLists: []rulelist.URLFilterID{strictListID},
+		useLists:    true,
+		wantReason:  FilteredBlockList,
+	}, {
+		name:        "excluded_social_overlap",
+		host:        "overlap.example",
+		filterLists: nil,
+		excluded:    []rulelist.URLFilterID{socialListID},
+		useLists:    false,
+		wantReason:  FilteredBlockList,
+	}, {
+		name:        "excluded_all_overlap",
+		host:        "overlap.example",
+		filterLists: nil,
+		excluded:    []rulelist.URLFilterID{socialListID, strictListID},
+		useLists:    false,
+		wantReason:  NotFilteredNotFound,
+	}, {
+		name:        "social_allowed",
+		host:        "allowed.example",
+		filterLists: []rulelist.URLFilterID{socialListID},
+		useLists:    true,
+		wantReason:  FilteredBlockList,
+	}, {
+		name:        "social_allow_allowed",
+		host:        "allowed.example",
+		filterLists: []rulelist.URLFilterID{socialListID, allowListID},
+		useLists:    true,
+		wantReason:  NotFilteredAllowList,
+	}, {
+		name:        "excluded_social",
+		host:        "social.example",
+		filterLists: nil,
+		excluded:    []rulelist.URLFilterID{socialListID},
+		useLists:    false,
+		wantReason:  NotFilteredNotFound,
+	}, {
+		name:        "excluded_allow_allowed",
+		host:        "allowed.example",
+		filterLists: nil,
+		excluded:    []rulelist.URLFilterID{allowListID},
+		useLists:    false,
+		wantReason:  FilteredBlockList,
+	}, {
+		name:        "excluded_custom",
+		host:        "custom.example",
+		filterLists: nil,
+		excluded:    []rulelist.URLFilterID{rulelist.URLFilterIDCustom},
+		useLists:    false,
+		wantReason:  FilteredBlockList,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setts := &Settings{
+				FilterLists:         tc.filterLists,
+				ExcludedFilterLists: tc.excluded,
+				UseFilterLists:      tc.useLists,
+				ProtectionEnabled:   true,
+				FilteringEnabled:    true,
+			}
+
+			res, checkErr := d.CheckHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, checkErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+		})
+	}
+}
+
+func TestDNSFilter_ApplyTagFilterLists(t *testing.T) {
+	kidsLists := []rulelist.URLFilterID{1, 2}
+
+	d, _ := newForTest(t, &Config{
+		TagFilterLists: []*TagFilterLists{{
+			Tag:         "user_child",
+			FilterLists: kidsLists,
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	setts := &Settings{}
+	d.ApplyTagFilterLists(setts, []string{"device_pc"})
+
+	assert.False(t, setts.UseFilterLists)
+	assert.Nil(t, setts.FilterLists)
+
+	d.ApplyTagFilterLists(setts, []string{"device_tablet", "user_child"})
+
+	assert.True(t, setts.UseFilterLists)
+	assert.Equal(t, kidsLists, setts.FilterLists)
+}
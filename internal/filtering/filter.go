@@ -1,6 +1,7 @@
 package filtering
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"net/http"
@@ -583,6 +584,76 @@ func (d *DNSFilter) load(flt *FilterYAML) (err error) {
 	return nil
 }
 
+// errFilterListNotFound is returned by [DNSFilter.FilterListRules] when there
+// is no filter list with the ID.
+const errFilterListNotFound errors.Error = "filter list not found"
+
+// FilterListRules returns the rules of the filter list with id, which is
+// [rulelist.URLFilterIDCustom] for the custom filtering rules.  The comments
+// and the empty lines are skipped.
+func (d *DNSFilter) FilterListRules(id rulelist.URLFilterID) (rules []string, err error) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	if id == rulelist.URLFilterIDCustom {
+		return appendRules(rules, strings.NewReader(strings.Join(d.conf.UserRules, "\n")))
+	}
+
+	isList := func(flt FilterYAML) (ok bool) { return flt.ID == id }
+	i := slices.IndexFunc(d.conf.Filters, isList)
+	flt := d.conf.Filters
+	if i < 0 {
+		i = slices.IndexFunc(d.conf.WhitelistFilters, isList)
+		flt = d.conf.WhitelistFilters
+	}
+
+	if i < 0 {
+		return nil, errFilterListNotFound
+	}
+
+	file, err := os.Open(flt[i].Path(d.conf.DataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		// The filter list hasn't been downloaded yet.
+		return []string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("opening filter file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, file.Close()) }()
+
+	return appendRules(rules, file)
+}
+
+// FilterListExists returns true if d contains the blocklist or the allowlist
+// with id.
+func (d *DNSFilter) FilterListExists(id rulelist.URLFilterID) (ok bool) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	isList := func(flt FilterYAML) (ok bool) { return flt.ID == id }
+
+	return slices.ContainsFunc(d.conf.Filters, isList) ||
+		slices.ContainsFunc(d.conf.WhitelistFilters, isList)
+}
+
+// appendRules appends the rules read from r to rules and returns it.
+func appendRules(rules []string, r io.Reader) (res []string, err error) {
+	res = rules
+	if res == nil {
+		res = []string{}
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, bufio.MaxScanTokenSize*16)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line != "" && line[0] != '!' && line[0] != '#' {
+			res = append(res, line)
+		}
+	}
+
+	return res, s.Err()
+}
+
 func (d *DNSFilter) EnableFilters(async bool) {
 	d.conf.filtersMu.RLock()
 	defer d.conf.filtersMu.RUnlock()
@@ -3,6 +3,7 @@ package filtering
 import (
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -10,6 +11,8 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
@@ -136,6 +139,45 @@ func TestDNSFilter_Update(t *testing.T) {
 	})
 }
 
+func TestDNSFilter_Update_rpz(t *testing.T) {
+	const content = "$TTL 300\n" +
+		"@ SOA localhost. root.localhost. 1 3600 600 86400 60\n" +
+		"blocked.example CNAME .\n" +
+		"local.example A 192.0.2.1\n"
+
+	f := &FilterYAML{
+		URL: serveFiltersLocally(t, []byte(content)),
+		Filter: Filter{
+			ID: 42,
+		},
+	}
+
+	dnsFilter := newDNSFilter(t)
+	updateAndAssert(t, dnsFilter, f, require.True, 2)
+
+	d, setts := newForTest(t, nil, []Filter{{
+		ID:       f.ID,
+		FilePath: f.Path(dnsFilter.conf.DataDir),
+	}})
+	t.Cleanup(d.Close)
+
+	res, err := d.CheckHost("blocked.example", dns.TypeA, setts)
+	require.NoError(t, err)
+	require.Len(t, res.Rules, 1)
+
+	assert.Equal(t, RewrittenRule, res.Reason)
+	assert.Equal(t, dns.RcodeNameError, res.DNSRewriteResult.RCode)
+	assert.Equal(t, f.ID, res.Rules[0].FilterListID)
+
+	res, err = d.CheckHost("local.example", dns.TypeA, setts)
+	require.NoError(t, err)
+	require.Len(t, res.Rules, 1)
+
+	assert.Equal(t, RewrittenRule, res.Reason)
+	assert.Equal(t, f.ID, res.Rules[0].FilterListID)
+	assert.Equal(t, []rules.RRValue{netip.MustParseAddr("192.0.2.1")}, res.DNSRewriteResult.Response[dns.TypeA])
+}
+
 func TestFilterYAML_EnsureName(t *testing.T) {
 	dnsFilter := newDNSFilter(t)
 
@@ -173,3 +215,42 @@ func TestFilterYAML_EnsureName(t *testing.T) {
 		assert.Equal(t, "List 0", f.Name)
 	})
 }
+
+func TestDNSFilter_FilterListRules(t *testing.T) {
+	const listID = 1
+
+	dataDir := t.TempDir()
+	list := FilterYAML{
+		Enabled: true,
+		Filter: Filter{
+			ID: listID,
+		},
+	}
+
+	listPath := list.Path(dataDir)
+	err := os.MkdirAll(filepath.Dir(listPath), 0o700)
+	require.NoError(t, err)
+
+	err = os.WriteFile(listPath, []byte("! Title: List\n||list.example^\n\n# comment\n"), 0o600)
+	require.NoError(t, err)
+
+	d, _ := newForTest(t, &Config{
+		DataDir:          dataDir,
+		WhitelistFilters: []FilterYAML{list},
+		UserRules:        []string{"! comment", "||custom.example^", " ", "@@||allowed.example^"},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	rules, err := d.FilterListRules(0)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"||custom.example^", "@@||allowed.example^"}, rules)
+
+	rules, err = d.FilterListRules(listID)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"||list.example^"}, rules)
+
+	_, err = d.FilterListRules(listID + 1)
+	testutil.AssertErrorMsg(t, "filter list not found", err)
+}
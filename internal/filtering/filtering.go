@@ -46,6 +46,23 @@ type Settings struct {
 
 	ServicesRules []ServiceEntry
 
+	// FilterLists are the IDs of the blocking and allowing filter lists applied
+	// to the client.  It's only used if UseFilterLists is true.
+	FilterLists []rulelist.URLFilterID
+
+	// ExcludedFilterLists are the IDs of the blocking and allowing filter
+	// lists not applied to the client, for example because their schedule
+	// policies aren't active.
+	ExcludedFilterLists []rulelist.URLFilterID
+
+	// SchedulePolicies are the names of the schedule policies active for the
+	// client.
+	SchedulePolicies []string
+
+	// UseFilterLists, if true, means that only the rules of FilterLists and
+	// the custom filtering rules are applied to the client.
+	UseFilterLists bool
+
 	ProtectionEnabled   bool
 	FilteringEnabled    bool
 	SafeSearchEnabled   bool
@@ -96,6 +113,28 @@ type Config struct {
 	// HTTPClient is the client to use for updating the remote filters.
 	HTTPClient *http.Client `yaml:"-"`
 
+	// RecentQueries returns the most recent logged queries, up to limit.  It's
+	// used to preview the changes of the filter lists.
+	RecentQueries func(limit int) (queries []*PreviewQuery) `yaml:"-"`
+
+	// ApplyClientSettings sets the filtering settings of the client with the
+	// address and the ClientID to setts.  It's used to preview the changes of
+	// the filter lists.
+	ApplyClientSettings func(cliAddr netip.Addr, clientID string, setts *Settings) `yaml:"-"`
+
+	// RequestUser returns the name of the user who made the HTTP request, if
+	// any.  It's used to record the creators of the overrides.
+	RequestUser func(r *http.Request) (name string) `yaml:"-"`
+
+	// PersistentClientExists returns true if there is a persistent client with
+	// the name.  It's used to validate the clients of the overrides.  If nil,
+	// the overrides can only be applied to the clients' IP addresses.
+	PersistentClientExists func(name string) (ok bool) `yaml:"-"`
+
+	// ClientTags are the tags the persistent clients can have.  It's used to
+	// validate the tags of the overrides.
+	ClientTags []string `yaml:"-"`
+
 	// filtersMu protects filter lists.
 	filtersMu *sync.RWMutex
 
@@ -127,6 +166,19 @@ type Config struct {
 	// WhitelistFilters are the allowing filter lists.
 	WhitelistFilters []FilterYAML `yaml:"-"`
 
+	// TagFilterLists are the selections of the filter lists applied to the
+	// persistent clients having the tags.  The first selection with a tag of
+	// the client is used, unless the client has its own selection.
+	TagFilterLists []*TagFilterLists `yaml:"tag_filter_lists"`
+
+	// SchedulePolicies are the filtering settings applied to the clients
+	// according to the weekly schedules.
+	SchedulePolicies []*SchedulePolicy `yaml:"schedule_policies"`
+
+	// Overrides are the temporary allowings and blockings of domains, which
+	// are applied before the filter lists.
+	Overrides []*Override `yaml:"overrides"`
+
 	// UserRules is the global list of custom rules.
 	UserRules []string `yaml:"-"`
 
@@ -228,9 +280,16 @@ type DNSFilter struct {
 	rulesStorage    *filterlist.RuleStorage
 	filteringEngine *urlfilter.DNSEngine
 
+	// hostRules is the index of the hosts rules of rulesStorage used for the
+	// clients with the selected filter lists.
+	hostRules *hostRulesIndex
+
 	rulesStorageAllow    *filterlist.RuleStorage
 	filteringEngineAllow *urlfilter.DNSEngine
 
+	// hostRulesAllow is the index of the hosts rules of rulesStorageAllow.
+	hostRulesAllow *hostRulesIndex
+
 	safeSearch SafeSearch
 
 	// safeBrowsingChecker is the safe browsing hash-prefix checker.
@@ -315,6 +374,10 @@ const (
 	//
 	// See https://github.com/tukimoto/AdGuardHome/issues/2499.
 	RewrittenRule
+
+	// Ratelimited is returned when the request has exceeded the rate limit of
+	// the client.
+	Ratelimited
 )
 
 // TODO(a.garipov): Resync with actual code names or replace completely
@@ -334,6 +397,8 @@ var reasonNames = []string{
 	Rewritten:          "Rewrite",
 	RewrittenAutoHosts: "RewriteEtcHosts",
 	RewrittenRule:      "RewriteRule",
+
+	Ratelimited: "Ratelimited",
 }
 
 func (r Reason) String() string {
@@ -373,6 +438,7 @@ func (d *DNSFilter) WriteDiskConfig(c *Config) {
 
 		*c = *d.conf
 		c.Rewrites = cloneRewrites(c.Rewrites)
+		c.Overrides = activeOverrides(c.Overrides, time.Now())
 	}()
 
 	d.conf.filtersMu.RLock()
@@ -781,6 +847,8 @@ func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) (err erro
 
 	filteringEngine := urlfilter.NewDNSEngine(rulesStorage)
 	filteringEngineAllow := urlfilter.NewDNSEngine(rulesStorageAllow)
+	hostRules := newHostRulesIndex(rulesStorage)
+	hostRulesAllow := newHostRulesIndex(rulesStorageAllow)
 
 	func() {
 		d.engineLock.Lock()
@@ -791,6 +859,8 @@ func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) (err erro
 		d.filteringEngine = filteringEngine
 		d.rulesStorageAllow = rulesStorageAllow
 		d.filteringEngineAllow = filteringEngineAllow
+		d.hostRules = hostRules
+		d.hostRulesAllow = hostRulesAllow
 	}()
 
 	// Make sure that the OS reclaims memory as soon as possible.
@@ -922,6 +992,10 @@ func (d *DNSFilter) matchHost(
 
 	if setts.ProtectionEnabled && d.filteringEngineAllow != nil {
 		dnsres, ok := d.filteringEngineAllow.MatchRequest(ufReq)
+		if setts.selectsFilterLists() {
+			dnsres, ok = selectFilterLists(dnsres, d.hostRulesAllow, host, setts)
+		}
+
 		if ok {
 			return d.matchHostProcessAllowList(host, dnsres)
 		}
@@ -932,6 +1006,9 @@ func (d *DNSFilter) matchHost(
 	}
 
 	dnsres, matchedEngine := d.filteringEngine.MatchRequest(ufReq)
+	if setts.selectsFilterLists() {
+		dnsres, matchedEngine = selectFilterLists(dnsres, d.hostRules, host, setts)
+	}
 
 	// Check DNS rewrites first, because the API there is a bit awkward.
 	dnsRWRes := d.processDNSResultRewrites(dnsres, host)
@@ -998,6 +1075,9 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 	d.hostCheckers = []hostChecker{{
 		check: d.matchSysHosts,
 		name:  "hosts container",
+	}, {
+		check: d.matchOverrides,
+		name:  "overrides",
 	}, {
 		check: d.matchHost,
 		name:  "filtering",
@@ -1032,6 +1112,16 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 		}
 	}
 
+	err = validateSchedulePolicies(d.conf.SchedulePolicies)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateOverrides(d.conf.Overrides)
+	if err != nil {
+		return nil, err
+	}
+
 	if blockFilters != nil {
 		err = d.initFiltering(nil, blockFilters)
 		if err != nil {
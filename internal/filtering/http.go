@@ -579,6 +579,10 @@ func (d *DNSFilter) RegisterFilteringHandlers() {
 	registerHTTP(http.MethodPost, "/control/filtering/refresh", d.handleFilteringRefresh)
 	registerHTTP(http.MethodPost, "/control/filtering/set_rules", d.handleFilteringSetRules)
 	registerHTTP(http.MethodGet, "/control/filtering/check_host", d.handleCheckHost)
+	registerHTTP(http.MethodPost, "/control/filtering/preview", d.handleFilteringPreview)
+	registerHTTP(http.MethodGet, "/control/filtering/overrides", d.handleOverridesList)
+	registerHTTP(http.MethodPost, "/control/filtering/overrides/add", d.handleOverridesAdd)
+	registerHTTP(http.MethodPost, "/control/filtering/overrides/delete", d.handleOverridesDelete)
 }
 
 // ValidateUpdateIvl returns false if i is not a valid filters update interval.
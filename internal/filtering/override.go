@@ -0,0 +1,265 @@
+package filtering
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+)
+
+// OverrideAction is the action of a temporary override.
+type OverrideAction string
+
+// Valid override actions.
+const (
+	// OverrideActionAllow allows the requests for the domain.
+	OverrideActionAllow OverrideAction = "allow"
+
+	// OverrideActionBlock blocks the requests for the domain.
+	OverrideActionBlock OverrideAction = "block"
+)
+
+// maxOverrideTTL is the maximum lifetime of a temporary override.
+const maxOverrideTTL = 30 * 24 * time.Hour
+
+// overrideIDLen is the length of the random part of an override ID, in bytes.
+const overrideIDLen = 8
+
+// Override is a temporary allowing or blocking of a domain, which is applied
+// before the filter lists and expires on its own.  Overrides are stored
+// separately from the custom filtering rules.
+type Override struct {
+	// Created is the time when the override was created.
+	Created time.Time `json:"created" yaml:"created"`
+
+	// Expires is the time after which the override is no longer applied.
+	Expires time.Time `json:"expires" yaml:"expires"`
+
+	// ID is the unique identifier of the override.
+	ID string `json:"id" yaml:"id"`
+
+	// Domain is the domain the override is applied to, including its
+	// subdomains.
+	Domain string `json:"domain" yaml:"domain"`
+
+	// Client, if not empty, is the name of the persistent client or the IP
+	// address of the client the override is applied to.
+	Client string `json:"client,omitempty" yaml:"client,omitempty"`
+
+	// Tag, if not empty, is the tag of the persistent clients the override is
+	// applied to.
+	Tag string `json:"tag,omitempty" yaml:"tag,omitempty"`
+
+	// Action is what is done with the requests for Domain.
+	Action OverrideAction `json:"action" yaml:"action"`
+
+	// Creator is the name of the user who created the override, if any.
+	Creator string `json:"creator" yaml:"creator"`
+}
+
+// validate returns an error if o is invalid.  o must not be nil.
+func (o *Override) validate() (err error) {
+	if o.Domain == "" {
+		return fmt.Errorf("domain: %w", errors.ErrEmptyValue)
+	}
+
+	err = netutil.ValidateHostname(o.Domain)
+	if err != nil {
+		return fmt.Errorf("domain: %w", err)
+	}
+
+	switch o.Action {
+	case OverrideActionAllow, OverrideActionBlock:
+		return nil
+	default:
+		return fmt.Errorf("action: %w: %q", errors.ErrBadEnumValue, o.Action)
+	}
+}
+
+// validateOverrides returns an error if any of overrides is invalid or their
+// IDs aren't unique.
+func validateOverrides(overrides []*Override) (err error) {
+	ids := container.NewMapSet[string]()
+	for i, o := range overrides {
+		err = o.validate()
+		if err != nil {
+			return fmt.Errorf("override at index %d: %w", i, err)
+		} else if o.ID == "" {
+			return fmt.Errorf("override at index %d: id: %w", i, errors.ErrEmptyValue)
+		} else if ids.Has(o.ID) {
+			return fmt.Errorf("override at index %d: duplicate id %q", i, o.ID)
+		}
+
+		ids.Add(o.ID)
+	}
+
+	return nil
+}
+
+// isActive returns true if o is not expired at now.
+func (o *Override) isActive(now time.Time) (ok bool) {
+	return now.Before(o.Expires)
+}
+
+// matches returns true if o is applied to host requested by the client of
+// setts.
+func (o *Override) matches(host string, setts *Settings) (ok bool) {
+	if host != o.Domain && !netutil.IsSubdomain(host, o.Domain) {
+		return false
+	}
+
+	if o.Client != "" && o.Client != setts.ClientName && o.Client != setts.ClientIP.String() {
+		return false
+	}
+
+	return o.Tag == "" || slices.Contains(setts.ClientTags, o.Tag)
+}
+
+// result returns the filtering result of o.
+func (o *Override) result() (res Result) {
+	text := "||" + o.Domain + "^"
+	if o.Action == OverrideActionAllow {
+		res.Reason = NotFilteredAllowList
+		text = "@@" + text
+	} else {
+		res.Reason = FilteredBlockList
+		res.IsFiltered = true
+	}
+
+	res.Rules = []*ResultRule{{
+		Text:         text,
+		FilterListID: rulelist.URLFilterIDOverride,
+	}}
+
+	return res
+}
+
+// activeOverrides returns the overrides not expired at now.
+func activeOverrides(overrides []*Override, now time.Time) (active []*Override) {
+	return slices.DeleteFunc(slices.Clone(overrides), func(o *Override) (ok bool) {
+		return !o.isActive(now)
+	})
+}
+
+// matchOverrides checks host against the temporary overrides.  Block overrides
+// take precedence over allow ones, the same as in the filter lists with
+// important rules.
+func (d *DNSFilter) matchOverrides(host string, _ uint16, setts *Settings) (res Result, err error) {
+	if !setts.ProtectionEnabled || !setts.FilteringEnabled {
+		return Result{}, nil
+	}
+
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	now := time.Now()
+	for _, o := range d.conf.Overrides {
+		if !o.isActive(now) || !o.matches(host, setts) {
+			continue
+		}
+
+		res = o.result()
+		if o.Action == OverrideActionBlock {
+			break
+		}
+	}
+
+	if res.Reason.Matched() {
+		log.Debug("filtering: override %s matched host %q", res.Rules[0].Text, host)
+	}
+
+	return res, nil
+}
+
+// validateOverrideScope returns an error if the client or the tag of o is
+// unknown.  o must not be nil.
+func (d *DNSFilter) validateOverrideScope(o *Override) (err error) {
+	if o.Client != "" {
+		_, err = netip.ParseAddr(o.Client)
+		exists := d.conf.PersistentClientExists
+		if err != nil && (exists == nil || !exists(o.Client)) {
+			return fmt.Errorf("client: no persistent client %q", o.Client)
+		}
+	}
+
+	if o.Tag != "" && !slices.Contains(d.conf.ClientTags, o.Tag) {
+		return fmt.Errorf("tag: %w: %q", errors.ErrBadEnumValue, o.Tag)
+	}
+
+	return nil
+}
+
+// newOverrideID returns a new random override ID.
+func newOverrideID() (id string, err error) {
+	b := make([]byte, overrideIDLen)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("generating override id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// addOverride validates o, sets its ID, and adds it to the overrides, removing
+// the expired ones.  o must not be nil.
+func (d *DNSFilter) addOverride(o *Override) (err error) {
+	o.Domain = strings.TrimSuffix(strings.ToLower(o.Domain), ".")
+	err = o.validate()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	err = d.validateOverrideScope(o)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	o.ID, err = newOverrideID()
+	if err != nil {
+		return err
+	}
+
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	d.conf.Overrides = append(activeOverrides(d.conf.Overrides, o.Created), o)
+
+	return nil
+}
+
+// removeOverride removes the override with id.  It returns false if there is
+// no such override.
+func (d *DNSFilter) removeOverride(id string) (ok bool) {
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	i := slices.IndexFunc(d.conf.Overrides, func(o *Override) (found bool) {
+		return o.ID == id
+	})
+	if i < 0 {
+		return false
+	}
+
+	d.conf.Overrides = slices.Delete(slices.Clone(d.conf.Overrides), i, i+1)
+
+	return true
+}
+
+// overrides returns the overrides not expired at now.
+func (d *DNSFilter) overrides(now time.Time) (active []*Override) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	return activeOverrides(d.conf.Overrides, now)
+}
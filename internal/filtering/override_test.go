@@ -0,0 +1,288 @@
+package filtering
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+)
+
+func TestDNSFilter_CheckHost_overrides(t *testing.T) {
+	const kidName = "Kid"
+
+	kidAddr := netip.MustParseAddr("192.0.2.1")
+	now := time.Now()
+
+	d, _ := newForTest(t, &Config{
+		Overrides: []*Override{{
+			Expires: now.Add(time.Hour),
+			ID:      "1",
+			Domain:  "blocked.example",
+			Action:  OverrideActionAllow,
+		}, {
+			Expires: now.Add(time.Hour),
+			ID:      "2",
+			Domain:  "game.example",
+			Client:  kidName,
+			Action:  OverrideActionAllow,
+		}, {
+			Expires: now.Add(time.Hour),
+			ID:      "3",
+			Domain:  "video.example",
+			Tag:     "user_child",
+			Action:  OverrideActionBlock,
+		}, {
+			Expires: now.Add(time.Hour),
+			ID:      "4",
+			Domain:  "video.example",
+			Client:  kidAddr.String(),
+			Action:  OverrideActionAllow,
+		}, {
+			Expires: now.Add(-time.Hour),
+			ID:      "5",
+			Domain:  "expired.example",
+			Action:  OverrideActionAllow,
+		}},
+	}, []Filter{{
+		ID: rulelist.URLFilterIDCustom,
+		Data: []byte(
+			"||blocked.example^\n||game.example^\n||expired.example^\n",
+		),
+	}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name               string
+		host               string
+		clientName         string
+		clientTags         []string
+		wantRule           string
+		wantReason         Reason
+		protectionDisabled bool
+	}{{
+		name:       "allow",
+		host:       "blocked.example",
+		wantRule:   "@@||blocked.example^",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "allow_subdomain",
+		host:       "www.blocked.example",
+		wantRule:   "@@||blocked.example^",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "client",
+		host:       "game.example",
+		clientName: kidName,
+		wantRule:   "@@||game.example^",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "other_client",
+		host:       "game.example",
+		clientName: "Parent",
+		wantRule:   "||game.example^",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "block_precedence",
+		host:       "video.example",
+		clientTags: []string{"user_child"},
+		wantRule:   "||video.example^",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "no_tag",
+		host:       "video.example",
+		wantRule:   "@@||video.example^",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "expired",
+		host:       "expired.example",
+		wantRule:   "||expired.example^",
+		wantReason: FilteredBlockList,
+	}, {
+		name:               "protection_disabled",
+		host:               "video.example",
+		clientTags:         []string{"user_child"},
+		wantRule:           "",
+		wantReason:         NotFilteredNotFound,
+		protectionDisabled: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setts := &Settings{
+				ClientName:        tc.clientName,
+				ClientIP:          kidAddr,
+				ClientTags:        tc.clientTags,
+				ProtectionEnabled: !tc.protectionDisabled,
+				FilteringEnabled:  true,
+			}
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantRule == "" {
+				assert.Empty(t, res.Rules)
+
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantRule, res.Rules[0].Text)
+		})
+	}
+}
+
+func TestDNSFilter_addOverride(t *testing.T) {
+	now := time.Now()
+
+	d, _ := newForTest(t, &Config{
+		PersistentClientExists: func(name string) (ok bool) {
+			return name == "Kid"
+		},
+		ClientTags: []string{"user_child"},
+		Overrides: []*Override{{
+			Expires: now.Add(-time.Minute),
+			ID:      "expired",
+			Domain:  "expired.example",
+			Action:  OverrideActionAllow,
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	err := d.addOverride(&Override{
+		Created: now,
+		Expires: now.Add(time.Hour),
+		Domain:  "bad domain",
+		Action:  OverrideActionAllow,
+	})
+	testutil.AssertErrorMsg(
+		t,
+		`domain: bad hostname "bad domain": bad top-level domain name label `+
+			`"bad domain": bad top-level domain name label rune ' '`,
+		err,
+	)
+
+	err = d.addOverride(&Override{
+		Created: now,
+		Expires: now.Add(time.Hour),
+		Domain:  "example.org",
+		Action:  "pause",
+	})
+	testutil.AssertErrorMsg(t, `action: bad enum value: "pause"`, err)
+
+	err = d.addOverride(&Override{
+		Created: now,
+		Expires: now.Add(time.Hour),
+		Domain:  "example.org",
+		Client:  "Stranger",
+		Action:  OverrideActionAllow,
+	})
+	testutil.AssertErrorMsg(t, `client: no persistent client "Stranger"`, err)
+
+	err = d.addOverride(&Override{
+		Created: now,
+		Expires: now.Add(time.Hour),
+		Domain:  "example.org",
+		Tag:     "user_unknown",
+		Action:  OverrideActionAllow,
+	})
+	testutil.AssertErrorMsg(t, `tag: bad enum value: "user_unknown"`, err)
+
+	scoped := []*Override{{
+		Created: now,
+		Expires: now.Add(time.Hour),
+		Domain:  "example.org",
+		Client:  "Kid",
+		Tag:     "user_child",
+		Action:  OverrideActionAllow,
+	}, {
+		Created: now,
+		Expires: now.Add(time.Hour),
+		Domain:  "example.org",
+		Client:  "192.0.2.1",
+		Action:  OverrideActionAllow,
+	}}
+	for _, o := range scoped {
+		require.NoError(t, d.addOverride(o))
+		require.True(t, d.removeOverride(o.ID))
+	}
+
+	o := &Override{
+		Created: now,
+		Expires: now.Add(time.Hour),
+		Domain:  "WWW.Example.ORG.",
+		Action:  OverrideActionBlock,
+		Creator: "admin",
+	}
+	err = d.addOverride(o)
+	require.NoError(t, err)
+
+	assert.Equal(t, "www.example.org", o.Domain)
+	assert.Len(t, o.ID, overrideIDLen*2)
+	assert.Equal(t, []*Override{o}, d.conf.Overrides)
+
+	assert.False(t, d.removeOverride("expired"))
+	assert.True(t, d.removeOverride(o.ID))
+	assert.Empty(t, d.overrides(now))
+}
+
+func TestDNSFilter_handleOverridesDelete(t *testing.T) {
+	confModifiedCalled := false
+	d, _ := newForTest(t, &Config{
+		ConfigModified: func() { confModifiedCalled = true },
+		Overrides: []*Override{{
+			Expires: time.Now().Add(time.Hour),
+			ID:      "1",
+			Domain:  "example.org",
+			Action:  OverrideActionAllow,
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name         string
+		id           string
+		wantBody     string
+		wantCode     int
+		wantModified bool
+	}{{
+		name:         "success",
+		id:           "1",
+		wantBody:     "OK\n",
+		wantCode:     http.StatusOK,
+		wantModified: true,
+	}, {
+		name:         "already_removed",
+		id:           "1",
+		wantBody:     "override \"1\" not found\n",
+		wantCode:     http.StatusBadRequest,
+		wantModified: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			confModifiedCalled = false
+
+			data, err := json.Marshal(&overrideDeleteReq{ID: tc.id})
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodPost, "http://example.org", bytes.NewReader(data))
+			w := httptest.NewRecorder()
+
+			d.handleOverridesDelete(w, r)
+			assert.Equal(t, tc.wantCode, w.Code)
+			assert.Equal(t, tc.wantBody, w.Body.String())
+			assert.Equal(t, tc.wantModified, confModifiedCalled)
+		})
+	}
+}
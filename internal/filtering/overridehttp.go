@@ -0,0 +1,112 @@
+package filtering
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+)
+
+// overridesListResp is the response for the GET /control/filtering/overrides
+// HTTP API.
+type overridesListResp struct {
+	Overrides []*Override `json:"overrides"`
+}
+
+// handleOverridesList is the handler for the GET /control/filtering/overrides
+// HTTP API.
+func (d *DNSFilter) handleOverridesList(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &overridesListResp{
+		Overrides: d.overrides(time.Now()),
+	})
+}
+
+// overrideAddReq is the request for the POST /control/filtering/overrides/add
+// HTTP API.
+type overrideAddReq struct {
+	Domain string         `json:"domain"`
+	Client string         `json:"client"`
+	Tag    string         `json:"tag"`
+	Action OverrideAction `json:"action"`
+
+	// TTL is the lifetime of the override in milliseconds.
+	TTL uint64 `json:"ttl"`
+}
+
+// handleOverridesAdd is the handler for the POST
+// /control/filtering/overrides/add HTTP API.
+func (d *DNSFilter) handleOverridesAdd(w http.ResponseWriter, r *http.Request) {
+	req := &overrideAddReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	ttl := time.Duration(req.TTL) * time.Millisecond
+	if ttl <= 0 || ttl > maxOverrideTTL {
+		aghhttp.Error(r, w, http.StatusBadRequest, "ttl: must be positive and at most %s", maxOverrideTTL)
+
+		return
+	}
+
+	now := time.Now()
+	o := &Override{
+		Created: now,
+		Expires: now.Add(ttl),
+		Domain:  req.Domain,
+		Client:  req.Client,
+		Tag:     req.Tag,
+		Action:  req.Action,
+	}
+
+	if d.conf.RequestUser != nil {
+		o.Creator = d.conf.RequestUser(r)
+	}
+
+	err = d.addOverride(o)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "adding override: %s", err)
+
+		return
+	}
+
+	log.Debug("filtering: added override %s: %s %s until %s", o.ID, o.Action, o.Domain, o.Expires)
+
+	d.conf.ConfigModified()
+
+	aghhttp.WriteJSONResponseOK(w, r, o)
+}
+
+// overrideDeleteReq is the request for the POST
+// /control/filtering/overrides/delete HTTP API.
+type overrideDeleteReq struct {
+	ID string `json:"id"`
+}
+
+// handleOverridesDelete is the handler for the POST
+// /control/filtering/overrides/delete HTTP API.
+func (d *DNSFilter) handleOverridesDelete(w http.ResponseWriter, r *http.Request) {
+	req := &overrideDeleteReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	if !d.removeOverride(req.ID) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "override %q not found", req.ID)
+
+		return
+	}
+
+	log.Debug("filtering: removed override %s", req.ID)
+
+	d.conf.ConfigModified()
+
+	aghhttp.OK(w)
+}
@@ -0,0 +1,524 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+)
+
+// PreviewQuery is a logged DNS query replayed to preview the impact of a change
+// of the filter lists.
+type PreviewQuery struct {
+	// ClientIP is the IP address of the client.
+	ClientIP netip.Addr
+
+	// ClientID is the ClientID of the client, if any.
+	ClientID string
+
+	// ClientName is the name of the persistent client, if any.
+	ClientName string
+
+	// Host is the queried host.
+	Host string
+
+	// QType is the type of the query.
+	QType uint16
+}
+
+const (
+	// previewListID is the ID of the candidate filter list in the temporary
+	// filtering engine.  It's not generated by [idGenerator], since the
+	// candidate list isn't added.
+	previewListID rulelist.URLFilterID = math.MaxInt32
+
+	// defaultPreviewLimit is the default number of the replayed queries.
+	defaultPreviewLimit = 1000
+
+	// maxPreviewLimit is the maximum number of the replayed queries.
+	maxPreviewLimit = 10000
+)
+
+// previewReq is the request body of the POST /control/filtering/preview HTTP
+// API.
+type previewReq struct {
+	// URL is the URL or the path of the candidate filter list.  It's only
+	// used if Rules is nil.
+	URL string `json:"url"`
+
+	// Rules, if not nil, are the candidate custom filtering rules replacing
+	// the current ones.
+	Rules []string `json:"rules"`
+
+	// Limit is the number of the most recent queries to replay.
+	Limit int `json:"limit"`
+
+	// Whitelist, if true, means that the candidate filter list is an
+	// allowlist.
+	Whitelist bool `json:"whitelist"`
+}
+
+// previewResp is the response body of the POST /control/filtering/preview HTTP
+// API.
+type previewResp struct {
+	// Duplicates are the numbers of the candidate rules contained in each of
+	// the current filter lists.
+	Duplicates []*previewDuplicates `json:"duplicates"`
+
+	// Clients are the changes of filtering of the replayed queries of each
+	// client.  Only the clients with changes are included.
+	Clients []*previewClient `json:"clients"`
+
+	// RulesCount is the number of rules in the candidate filter list.
+	RulesCount int `json:"rules_count"`
+
+	// DuplicatesCount is the number of the candidate rules contained in any of
+	// the current filter lists.
+	DuplicatesCount int `json:"duplicates_count"`
+
+	// QueriesReplayed is the number of the replayed queries.
+	QueriesReplayed int `json:"queries_replayed"`
+}
+
+// previewDuplicates is the number of the candidate rules contained in a
+// current filter list.
+type previewDuplicates struct {
+	Name  string               `json:"name"`
+	ID    rulelist.URLFilterID `json:"id"`
+	Count int                  `json:"count"`
+}
+
+// previewClient contains the changes of filtering of the replayed queries of
+// a client.
+type previewClient struct {
+	// Client is the ClientID or the IP address of the client.
+	Client string `json:"client"`
+
+	// Name is the name of the persistent client, if any.
+	Name string `json:"name,omitempty"`
+
+	// NewlyBlocked are the previously allowed queries that would be blocked.
+	NewlyBlocked []*previewChange `json:"newly_blocked"`
+
+	// NewlyAllowed are the previously blocked queries that would be allowed.
+	NewlyAllowed []*previewChange `json:"newly_allowed"`
+}
+
+// previewChange is a change of filtering of the replayed queries for a host.
+type previewChange struct {
+	// Host is the queried host.
+	Host string `json:"host"`
+
+	// QType is the type of the queries.
+	QType string `json:"qtype"`
+
+	// Rule is the rule blocking the queries after the change or, for the
+	// newly allowed ones, the rule that has been blocking them.
+	Rule string `json:"rule"`
+
+	// Count is the number of the replayed queries.
+	Count int `json:"count"`
+}
+
+// handleFilteringPreview is the handler for the POST /control/filtering/preview
+// HTTP API.
+func (d *DNSFilter) handleFilteringPreview(w http.ResponseWriter, r *http.Request) {
+	req := &previewReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	if req.Rules == nil {
+		err = validateFilterURL(req.URL)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+			return
+		}
+	}
+
+	if req.Limit <= 0 {
+		req.Limit = defaultPreviewLimit
+	}
+
+	req.Limit = min(req.Limit, maxPreviewLimit)
+
+	resp, err := d.preview(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "previewing: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// preview returns the report on the impact of the change of the filter lists
+// described by req.
+func (d *DNSFilter) preview(req *previewReq) (resp *previewResp, err error) {
+	data, rulesCount, err := d.previewData(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	resp = &previewResp{
+		RulesCount: rulesCount,
+	}
+
+	allowFilters, blockFilters := d.previewFilters(req, data)
+	resp.Duplicates, resp.DuplicatesCount, err = d.previewDuplicates(req, data)
+	if err != nil {
+		return nil, fmt.Errorf("finding duplicates: %w", err)
+	}
+
+	if d.conf.RecentQueries == nil {
+		resp.Clients = []*previewClient{}
+
+		return resp, nil
+	}
+
+	queries := d.conf.RecentQueries(req.Limit)
+	resp.QueriesReplayed = len(queries)
+
+	tmp, err := newPreviewFilter(allowFilters, blockFilters)
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary engine: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, tmp.closeStorages()) }()
+
+	resp.Clients = d.replay(tmp, queries)
+
+	return resp, nil
+}
+
+// previewData returns the parsed content of the candidate filter list from req
+// and the number of rules in it.
+func (d *DNSFilter) previewData(req *previewReq) (data []byte, rulesCount int, err error) {
+	var src io.Reader
+	if req.Rules != nil {
+		src = strings.NewReader(strings.Join(req.Rules, "\n"))
+	} else {
+		var rc io.ReadCloser
+		rc, err = d.reader(req.URL)
+		if err != nil {
+			return nil, 0, fmt.Errorf("fetching filter list %q: %w", req.URL, err)
+		}
+		defer func() { err = errors.WithDeferred(err, rc.Close()) }()
+
+		src = rc
+	}
+
+	bufPtr := d.bufPool.Get()
+	defer d.bufPool.Put(bufPtr)
+
+	dst := &bytes.Buffer{}
+	res, err := rulelist.NewParser().Parse(dst, src, *bufPtr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parsing filter list: %w", err)
+	}
+
+	return dst.Bytes(), res.RulesCount, nil
+}
+
+// previewFilters returns the filter lists of the temporary engines, which are
+// the current enabled filter lists changed according to req.
+func (d *DNSFilter) previewFilters(
+	req *previewReq,
+	data []byte,
+) (allowFilters, blockFilters []Filter) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	custom := Filter{
+		ID:   rulelist.URLFilterIDCustom,
+		Data: []byte(strings.Join(d.conf.UserRules, "\n")),
+	}
+	if req.Rules != nil {
+		custom.Data = data
+	}
+
+	blockFilters = append(blockFilters, custom)
+	blockFilters = d.appendEnabledFilters(blockFilters, d.conf.Filters)
+	allowFilters = d.appendEnabledFilters(allowFilters, d.conf.WhitelistFilters)
+
+	if req.Rules == nil {
+		candidate := Filter{
+			ID:   previewListID,
+			Data: data,
+		}
+
+		if req.Whitelist {
+			allowFilters = append(allowFilters, candidate)
+		} else {
+			blockFilters = append(blockFilters, candidate)
+		}
+	}
+
+	return allowFilters, blockFilters
+}
+
+// appendEnabledFilters appends the enabled filter lists from flts to filters
+// and returns it.  d.conf.filtersMu is expected to be locked.
+func (d *DNSFilter) appendEnabledFilters(filters []Filter, flts []FilterYAML) (res []Filter) {
+	for _, flt := range flts {
+		if flt.Enabled {
+			filters = append(filters, Filter{
+				ID:       flt.ID,
+				FilePath: flt.Path(d.conf.DataDir),
+			})
+		}
+	}
+
+	return filters
+}
+
+// previewDuplicates returns the numbers of the rules from data contained in
+// each of the current filter lists and in any of them.  The current custom
+// filtering rules are ignored if req replaces them.
+func (d *DNSFilter) previewDuplicates(
+	req *previewReq,
+	data []byte,
+) (dups []*previewDuplicates, total int, err error) {
+	candidate := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			candidate[line] = false
+		}
+	}
+
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	dups = []*previewDuplicates{}
+	if req.Rules == nil {
+		n := countDuplicates(candidate, strings.NewReader(strings.Join(d.conf.UserRules, "\n")))
+		if n > 0 {
+			dups = append(dups, &previewDuplicates{
+				ID:    rulelist.URLFilterIDCustom,
+				Count: n,
+			})
+		}
+	}
+
+	for _, flt := range slices.Concat(d.conf.Filters, d.conf.WhitelistFilters) {
+		if !flt.Enabled {
+			continue
+		}
+
+		var n int
+		n, err = countFileDuplicates(candidate, flt.Path(d.conf.DataDir))
+		if err != nil {
+			return nil, 0, fmt.Errorf("filter list %d: %w", flt.ID, err)
+		} else if n > 0 {
+			dups = append(dups, &previewDuplicates{
+				Name:  flt.Name,
+				ID:    flt.ID,
+				Count: n,
+			})
+		}
+	}
+
+	for _, found := range candidate {
+		if found {
+			total++
+		}
+	}
+
+	return dups, total, nil
+}
+
+// countFileDuplicates is a wrapper around [countDuplicates] for the filter list
+// file at path.  A missing file contains no duplicates.
+func countFileDuplicates(candidate map[string]bool, path string) (n int, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return 0, err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	return countDuplicates(candidate, f), nil
+}
+
+// countDuplicates returns the number of the distinct rules from r contained in
+// candidate and marks them as found.
+func countDuplicates(candidate map[string]bool, r io.Reader) (n int) {
+	seen := map[string]struct{}{}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, bufio.MaxScanTokenSize*16)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if _, ok := candidate[line]; !ok {
+			continue
+		} else if _, ok = seen[line]; ok {
+			continue
+		}
+
+		seen[line] = struct{}{}
+		candidate[line] = true
+		n++
+	}
+
+	if err := s.Err(); err != nil {
+		log.Debug("filtering: preview: scanning filter list: %s", err)
+	}
+
+	return n
+}
+
+// newPreviewFilter returns a filter using the temporary engines built from the
+// filter lists.  The engines are only used for matching hosts.
+func newPreviewFilter(allowFilters, blockFilters []Filter) (tmp *DNSFilter, err error) {
+	rulesStorage, err := newRuleStorage(blockFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	rulesStorageAllow, err := newRuleStorage(allowFilters)
+	if err != nil {
+		return nil, errors.WithDeferred(err, rulesStorage.Close())
+	}
+
+	return &DNSFilter{
+		rulesStorage:         rulesStorage,
+		filteringEngine:      urlfilter.NewDNSEngine(rulesStorage),
+		hostRules:            newHostRulesIndex(rulesStorage),
+		rulesStorageAllow:    rulesStorageAllow,
+		filteringEngineAllow: urlfilter.NewDNSEngine(rulesStorageAllow),
+		hostRulesAllow:       newHostRulesIndex(rulesStorageAllow),
+	}, nil
+}
+
+// closeStorages closes the rule storages of the temporary filter.
+func (d *DNSFilter) closeStorages() (err error) {
+	return errors.Join(closeStorage(d.rulesStorage), closeStorage(d.rulesStorageAllow))
+}
+
+// closeStorage closes rs, if it's not nil.
+func closeStorage(rs *filterlist.RuleStorage) (err error) {
+	if rs == nil {
+		return nil
+	}
+
+	return rs.Close()
+}
+
+// replay matches queries against the current filter lists and the ones of tmp
+// and returns the changes of filtering for each client.
+func (d *DNSFilter) replay(tmp *DNSFilter, queries []*PreviewQuery) (clients []*previewClient) {
+	type changeKey struct {
+		host  string
+		qtype uint16
+		block bool
+	}
+
+	clients = []*previewClient{}
+	byClient := map[string]*previewClient{}
+	changes := map[string]map[changeKey]*previewChange{}
+
+	for _, q := range queries {
+		before, after, err := d.replayQuery(tmp, q)
+		if err != nil {
+			log.Debug("filtering: preview: replaying %q: %s", q.Host, err)
+
+			continue
+		} else if before.IsFiltered == after.IsFiltered {
+			continue
+		}
+
+		id := q.ClientID
+		if id == "" {
+			id = q.ClientIP.String()
+		}
+
+		c, ok := byClient[id]
+		if !ok {
+			c = &previewClient{
+				Client:       id,
+				Name:         q.ClientName,
+				NewlyBlocked: []*previewChange{},
+				NewlyAllowed: []*previewChange{},
+			}
+			byClient[id] = c
+			changes[id] = map[changeKey]*previewChange{}
+			clients = append(clients, c)
+		}
+
+		key := changeKey{host: q.Host, qtype: q.QType, block: after.IsFiltered}
+		ch, ok := changes[id][key]
+		if ok {
+			ch.Count++
+
+			continue
+		}
+
+		ch = &previewChange{
+			Host:  q.Host,
+			QType: dns.Type(q.QType).String(),
+			Count: 1,
+		}
+		changes[id][key] = ch
+
+		if after.IsFiltered {
+			ch.Rule = after.Rules[0].Text
+			c.NewlyBlocked = append(c.NewlyBlocked, ch)
+		} else {
+			ch.Rule = before.Rules[0].Text
+			c.NewlyAllowed = append(c.NewlyAllowed, ch)
+		}
+	}
+
+	return clients
+}
+
+// replayQuery returns the results of matching q against the current filter
+// lists and the ones of tmp.  The candidate filter list is considered selected
+// for the clients having a selection of filter lists.
+func (d *DNSFilter) replayQuery(tmp *DNSFilter, q *PreviewQuery) (before, after Result, err error) {
+	setts := d.Settings()
+	setts.FilteringEnabled = true
+	setts.ProtectionEnabled = true
+	if d.conf.ApplyClientSettings != nil {
+		d.conf.ApplyClientSettings(q.ClientIP, q.ClientID, setts)
+	}
+
+	before, err = d.matchHost(q.Host, q.QType, setts)
+	if err != nil {
+		return before, after, fmt.Errorf("current lists: %w", err)
+	}
+
+	if setts.UseFilterLists {
+		// Clip the slice, since it may be shared with the client's settings.
+		setts.FilterLists = append(slices.Clip(setts.FilterLists), previewListID)
+	}
+
+	after, err = tmp.matchHost(q.Host, q.QType, setts)
+	if err != nil {
+		return before, after, fmt.Errorf("changed lists: %w", err)
+	}
+
+	return before, after, nil
+}
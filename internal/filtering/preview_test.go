@@ -0,0 +1,208 @@
+package filtering
+
+import (
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_preview(t *testing.T) {
+	const adsListID = 1
+
+	var (
+		kidAddr   = netip.MustParseAddr("192.0.2.1")
+		otherAddr = netip.MustParseAddr("192.0.2.2")
+	)
+
+	dataDir := t.TempDir()
+	adsList := FilterYAML{
+		Enabled: true,
+		Name:    "ads",
+		Filter: Filter{
+			ID: adsListID,
+		},
+	}
+
+	adsPath := adsList.Path(dataDir)
+	err := os.MkdirAll(filepath.Dir(adsPath), 0o700)
+	require.NoError(t, err)
+
+	err = os.WriteFile(adsPath, []byte("||ads.example^\n||tracker.example^\n"), 0o600)
+	require.NoError(t, err)
+
+	queries := []*PreviewQuery{{
+		ClientIP:   kidAddr,
+		ClientID:   "kid",
+		ClientName: "Kid",
+		Host:       "social.example",
+		QType:      dns.TypeA,
+	}, {
+		ClientIP:   kidAddr,
+		ClientID:   "kid",
+		ClientName: "Kid",
+		Host:       "social.example",
+		QType:      dns.TypeA,
+	}, {
+		ClientIP:   kidAddr,
+		ClientID:   "kid",
+		ClientName: "Kid",
+		Host:       "ads.example",
+		QType:      dns.TypeA,
+	}, {
+		ClientIP:   kidAddr,
+		ClientID:   "kid",
+		ClientName: "Kid",
+		Host:       "custom.example",
+		QType:      dns.TypeAAAA,
+	}, {
+		ClientIP:   kidAddr,
+		ClientID:   "kid",
+		ClientName: "Kid",
+		Host:       "tracker.example",
+		QType:      dns.TypeA,
+	}, {
+		ClientIP: otherAddr,
+		Host:     "social.example",
+		QType:    dns.TypeA,
+	}}
+
+	var gotLimit int
+	d, _ := newForTest(t, &Config{
+		HTTPClient: &http.Client{
+			Timeout: testTimeout,
+		},
+		DataDir:   dataDir,
+		Filters:   []FilterYAML{adsList},
+		UserRules: []string{"||custom.example^"},
+		RecentQueries: func(limit int) (qs []*PreviewQuery) {
+			gotLimit = limit
+
+			return queries
+		},
+	}, []Filter{{
+		ID:   0,
+		Data: []byte("||custom.example^\n"),
+	}, {
+		ID:       adsListID,
+		FilePath: adsPath,
+	}})
+	t.Cleanup(d.Close)
+
+	t.Run("url", func(t *testing.T) {
+		candidateURL := serveFiltersLocally(t, []byte(
+			"! Title: Social\n||social.example^\n||ads.example^\n",
+		))
+
+		resp, prevErr := d.preview(&previewReq{
+			URL:   candidateURL,
+			Limit: 100,
+		})
+		require.NoError(t, prevErr)
+
+		assert.Equal(t, 100, gotLimit)
+		assert.Equal(t, 2, resp.RulesCount)
+		assert.Equal(t, 1, resp.DuplicatesCount)
+		assert.Equal(t, len(queries), resp.QueriesReplayed)
+		assert.Equal(t, []*previewDuplicates{{
+			Name:  "ads",
+			ID:    adsListID,
+			Count: 1,
+		}}, resp.Duplicates)
+		assert.Equal(t, []*previewClient{{
+			Client: "kid",
+			Name:   "Kid",
+			NewlyBlocked: []*previewChange{{
+				Host:  "social.example",
+				QType: "A",
+				Rule:  "||social.example^",
+				Count: 2,
+			}},
+			NewlyAllowed: []*previewChange{},
+		}, {
+			Client: otherAddr.String(),
+			NewlyBlocked: []*previewChange{{
+				Host:  "social.example",
+				QType: "A",
+				Rule:  "||social.example^",
+				Count: 1,
+			}},
+			NewlyAllowed: []*previewChange{},
+		}}, resp.Clients)
+	})
+
+	t.Run("user_rules", func(t *testing.T) {
+		resp, prevErr := d.preview(&previewReq{
+			Rules: []string{"||tracker.example^", "||other.example^"},
+			Limit: defaultPreviewLimit,
+		})
+		require.NoError(t, prevErr)
+
+		assert.Equal(t, 2, resp.RulesCount)
+		assert.Equal(t, 1, resp.DuplicatesCount)
+		assert.Equal(t, []*previewClient{{
+			Client:       "kid",
+			Name:         "Kid",
+			NewlyBlocked: []*previewChange{},
+			NewlyAllowed: []*previewChange{{
+				Host:  "custom.example",
+				QType: "AAAA",
+				Rule:  "||custom.example^",
+				Count: 1,
+			}},
+		}}, resp.Clients)
+	})
+
+	t.Run("selection", func(t *testing.T) {
+		// The kid has none of the filter lists selected.
+		d.conf.ApplyClientSettings = func(_ netip.Addr, id string, setts *Settings) {
+			if id == "kid" {
+				setts.UseFilterLists = true
+			}
+		}
+		t.Cleanup(func() { d.conf.ApplyClientSettings = nil })
+
+		// The hosts rule must be found despite the network rule of the
+		// unselected list.
+		candidateURL := serveFiltersLocally(t, []byte(
+			"||social.example^\n0.0.0.0 tracker.example\n",
+		))
+
+		resp, prevErr := d.preview(&previewReq{
+			URL:   candidateURL,
+			Limit: defaultPreviewLimit,
+		})
+		require.NoError(t, prevErr)
+
+		assert.Equal(t, []*previewClient{{
+			Client: "kid",
+			Name:   "Kid",
+			NewlyBlocked: []*previewChange{{
+				Host:  "social.example",
+				QType: "A",
+				Rule:  "||social.example^",
+				Count: 2,
+			}, {
+				Host:  "tracker.example",
+				QType: "A",
+				Rule:  "0.0.0.0 tracker.example",
+				Count: 1,
+			}},
+			NewlyAllowed: []*previewChange{},
+		}, {
+			Client: otherAddr.String(),
+			NewlyBlocked: []*previewChange{{
+				Host:  "social.example",
+				QType: "A",
+				Rule:  "||social.example^",
+				Count: 1,
+			}},
+			NewlyAllowed: []*previewChange{},
+		}}, resp.Clients)
+	})
+}
@@ -45,8 +45,16 @@ type ParseResult struct {
 }
 
 // Parse parses data from src into dst using buf during parsing.  r is never
-// nil.
+// nil.  If src is a Response Policy Zone, its records are translated into the
+// equivalent filtering rules.
 func (p *Parser) Parse(dst io.Writer, src io.Reader, buf []byte) (r *ParseResult, err error) {
+	src, isZone := peekZoneFile(src)
+	if isZone {
+		err = p.parseRPZ(dst, src)
+
+		return p.result(), err
+	}
+
 	s := bufio.NewScanner(src)
 
 	// Don't use [DefaultRuleBufSize] as the maximum size, since some
@@ -0,0 +1,218 @@
+package rulelist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// rpzDetectSize is the maximum number of bytes read from the beginning of a
+// filtering-rule list to detect whether it's a Response Policy Zone.
+const rpzDetectSize = 4096
+
+// rpzDefaultOrigin is the origin used for the RPZ files which neither set it
+// with the $ORIGIN directive nor use the absolute owner of the SOA record.
+const rpzDefaultOrigin = "rpz.invalid."
+
+// isZoneFile returns true if head, the beginning of a filtering-rule list,
+// looks like a DNS master file.  That is, if its first line, which isn't empty
+// or a zone file comment, is either a $TTL or an $ORIGIN directive or an SOA
+// record.
+func isZoneFile(head []byte) (ok bool) {
+	for len(head) > 0 {
+		var line []byte
+		line, head, _ = bytes.Cut(head, []byte("\n"))
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == ';' {
+			continue
+		}
+
+		if hasPrefixFold(line, []byte("$TTL")) || hasPrefixFold(line, []byte("$ORIGIN")) {
+			return true
+		}
+
+		// The SOA type may be preceded by the owner, the TTL, and the class.
+		fields := bytes.Fields(line)
+		for _, f := range fields[1:min(len(fields), 4)] {
+			if bytes.EqualFold(f, []byte("SOA")) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return false
+}
+
+// parseRPZ translates the Response Policy Zone from src into filtering rules
+// and writes them into dst.  The records, which have no equivalent rules, are
+// skipped.  See https://datatracker.ietf.org/doc/draft-vixie-dnsop-dns-rpz.
+func (p *Parser) parseRPZ(dst io.Writer, src io.Reader) (err error) {
+	zp := dns.NewZoneParser(src, rpzDefaultOrigin, "")
+	origin := ""
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		hdr := rr.Header()
+		name := dns.CanonicalName(hdr.Name)
+
+		if soa, isSOA := rr.(*dns.SOA); isSOA {
+			if origin == "" {
+				origin = dns.CanonicalName(soa.Hdr.Name)
+				p.setRPZTitle(origin)
+			}
+
+			continue
+		} else if origin == "" {
+			return fmt.Errorf("rpz: record %q: no soa record before", hdr.Name)
+		}
+
+		trigger, isTrigger := rpzTrigger(name, origin)
+		if !isTrigger {
+			log.Debug("rulelist: rpz: skipping record %q", hdr.Name)
+
+			continue
+		}
+
+		rule := rpzRule(trigger, rr)
+		if rule == "" {
+			log.Debug("rulelist: rpz: skipping unsupported %s record %q", dns.Type(hdr.Rrtype), hdr.Name)
+
+			continue
+		}
+
+		err = p.writeRule(dst, []byte(rule))
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+	}
+
+	return errors.Annotate(zp.Err(), "rpz: parsing: %w")
+}
+
+// setRPZTitle sets the title of the list to the origin of the zone, unless
+// the title has already been found or the origin is the default one.
+func (p *Parser) setRPZTitle(origin string) {
+	if p.titleFound || origin == rpzDefaultOrigin {
+		return
+	}
+
+	p.title = strings.TrimSuffix(origin, ".")
+	p.titleFound = true
+}
+
+// rpzTrigger returns the QNAME trigger of the record with the lowercased owner
+// name within origin.  ok is false if the record is the zone apex or one of the
+// triggers other than QNAME, which aren't supported.
+func rpzTrigger(name, origin string) (trigger string, ok bool) {
+	if !dns.IsSubDomain(origin, name) || name == origin {
+		return "", false
+	}
+
+	trigger = strings.TrimSuffix(name, "."+origin)
+	for _, label := range dns.SplitDomainName(trigger) {
+		if strings.HasPrefix(label, "rpz-") {
+			// rpz-ip, rpz-nsdname, rpz-nsip, and rpz-client-ip triggers.
+			return "", false
+		}
+	}
+
+	return trigger, true
+}
+
+// rpzRule returns the filtering rule equivalent to the RPZ record rr with
+// the QNAME trigger.  rule is empty if there is no such rule.
+func rpzRule(trigger string, rr dns.RR) (rule string) {
+	pattern := "|" + trigger + "^"
+	if sub, ok := strings.CutPrefix(trigger, "*."); ok {
+		// The wildcard trigger only matches the subdomains.
+		pattern = "||*." + sub + "^"
+	}
+
+	switch rr := rr.(type) {
+	case *dns.CNAME:
+		return rpzCNAMERule(pattern, trigger, dns.CanonicalName(rr.Target))
+	case *dns.A:
+		return fmt.Sprintf("%s$dnsrewrite=NOERROR;A;%s", pattern, rr.A)
+	case *dns.AAAA:
+		return fmt.Sprintf("%s$dnsrewrite=NOERROR;AAAA;%s", pattern, rr.AAAA)
+	case *dns.MX:
+		return fmt.Sprintf("%s$dnsrewrite=NOERROR;MX;%d %s", pattern, rr.Preference, rr.Mx)
+	case *dns.PTR:
+		return fmt.Sprintf("%s$dnsrewrite=NOERROR;PTR;%s", pattern, rr.Ptr)
+	case *dns.SRV:
+		return fmt.Sprintf(
+			"%s$dnsrewrite=NOERROR;SRV;%d %d %d %s",
+			pattern,
+			rr.Priority,
+			rr.Weight,
+			rr.Port,
+			rr.Target,
+		)
+	case *dns.TXT:
+		txt := strings.Join(rr.Txt, "")
+		if strings.ContainsAny(txt, ",$") {
+			// These characters can't be used within the rule modifiers.
+			return ""
+		}
+
+		return fmt.Sprintf("%s$dnsrewrite=NOERROR;TXT;%s", pattern, txt)
+	default:
+		return ""
+	}
+}
+
+// rpzCNAMERule returns the filtering rule for the RPZ CNAME record with the
+// lowercased target.  rule is empty if the action isn't supported.
+func rpzCNAMERule(pattern, trigger, target string) (rule string) {
+	switch target {
+	case ".":
+		return pattern + "$dnsrewrite=NXDOMAIN"
+	case "*.":
+		return pattern + "$dnsrewrite=NOERROR"
+	case "rpz-passthru.", trigger + ".":
+		// The CNAME to the trigger itself is the obsolete form of passthru.
+		return "@@" + pattern
+	case "rpz-drop.":
+		// There is no way to drop a query, so refuse it instead.
+		return pattern + "$dnsrewrite=REFUSED"
+	}
+
+	if strings.HasPrefix(target, "rpz-") || strings.HasPrefix(target, "*.") {
+		// rpz-tcp-only and the CNAME to the wildcard, which keeps the original
+		// name as the prefix.
+		return ""
+	}
+
+	return pattern + "$dnsrewrite=NOERROR;CNAME;" + strings.TrimSuffix(target, ".")
+}
+
+// writeRule writes the translated rule into dst and updates the parser's
+// counters.
+func (p *Parser) writeRule(dst io.Writer, rule []byte) (err error) {
+	p.rulesCount++
+	p.checksum = crc32.Update(p.checksum, crc32.IEEETable, rule)
+
+	n, err := dst.Write(append(rule, '\n'))
+	p.written += n
+
+	return errors.Annotate(err, "writing rule line: %w")
+}
+
+// peekZoneFile returns a reader with the whole content of src and true if src
+// looks like a DNS master file.
+func peekZoneFile(src io.Reader) (r io.Reader, ok bool) {
+	br := bufio.NewReaderSize(src, rpzDetectSize)
+
+	// Ignore the error, since it's returned by the following reads.
+	head, _ := br.Peek(rpzDetectSize)
+
+	return br, isZoneFile(head)
+}
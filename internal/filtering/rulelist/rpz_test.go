@@ -0,0 +1,97 @@
+package rulelist_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+)
+
+func TestParser_Parse_rpz(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		in           string
+		wantDst      string
+		wantErrMsg   string
+		wantTitle    string
+		wantRulesNum int
+	}{{
+		name: "actions",
+		in: "; Threat feed.\n" +
+			"$TTL 300\n" +
+			"@ SOA localhost. root.localhost. 1 3600 600 86400 60\n" +
+			"@ NS localhost.\n" +
+			"nx.example CNAME .\n" +
+			"*.nx.example CNAME .\n" +
+			"nodata.example CNAME *.\n" +
+			"pass.example CNAME rpz-passthru.\n" +
+			"drop.example CNAME rpz-drop.\n" +
+			"tcp.example CNAME rpz-tcp-only.\n" +
+			"32.1.2.0.192.rpz-ip CNAME .\n",
+		wantDst: "|nx.example^$dnsrewrite=NXDOMAIN\n" +
+			"||*.nx.example^$dnsrewrite=NXDOMAIN\n" +
+			"|nodata.example^$dnsrewrite=NOERROR\n" +
+			"@@|pass.example^\n" +
+			"|drop.example^$dnsrewrite=REFUSED\n",
+		wantErrMsg:   "",
+		wantTitle:    "",
+		wantRulesNum: 5,
+	}, {
+		name: "local_data",
+		in: "rpz.example.net. 300 IN SOA ns hostmaster 1 3600 600 86400 60\n" +
+			"a.example.rpz.example.net. A 192.0.2.1\n" +
+			"a.example.rpz.example.net. AAAA 2001:db8::1\n" +
+			"cname.example.rpz.example.net. CNAME target.example.\n" +
+			"mx.example.rpz.example.net. MX 10 mail.example.\n" +
+			"txt.example.rpz.example.net. TXT \"hello\"\n" +
+			"comma.example.rpz.example.net. TXT \"a,b\"\n" +
+			"hinfo.example.rpz.example.net. HINFO \"cpu\" \"os\"\n",
+		wantDst: "|a.example^$dnsrewrite=NOERROR;A;192.0.2.1\n" +
+			"|a.example^$dnsrewrite=NOERROR;AAAA;2001:db8::1\n" +
+			"|cname.example^$dnsrewrite=NOERROR;CNAME;target.example\n" +
+			"|mx.example^$dnsrewrite=NOERROR;MX;10 mail.example.\n" +
+			"|txt.example^$dnsrewrite=NOERROR;TXT;hello\n",
+		wantErrMsg:   "",
+		wantTitle:    "rpz.example.net",
+		wantRulesNum: 5,
+	}, {
+		name:         "no_soa",
+		in:           "$TTL 300\nbad.example CNAME .\n",
+		wantDst:      "",
+		wantErrMsg:   `rpz: record "bad.example.rpz.invalid.": no soa record before`,
+		wantTitle:    "",
+		wantRulesNum: 0,
+	}, {
+		name:         "not_zone",
+		in:           "0.0.0.0 soa.example\n",
+		wantDst:      "0.0.0.0 soa.example\n",
+		wantErrMsg:   "",
+		wantTitle:    "",
+		wantRulesNum: 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dst := &bytes.Buffer{}
+			buf := make([]byte, rulelist.DefaultRuleBufSize)
+
+			p := rulelist.NewParser()
+			r, err := p.Parse(dst, strings.NewReader(tc.in), buf)
+			require.NotNil(t, r)
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			assert.Equal(t, tc.wantDst, dst.String())
+			assert.Equal(t, tc.wantTitle, r.Title)
+			assert.Equal(t, tc.wantRulesNum, r.RulesCount)
+			assert.Equal(t, len(tc.wantDst), r.BytesWritten)
+		})
+	}
+}
@@ -41,6 +41,7 @@ const (
 	URLFilterIDParentalControl URLFilterID = -3
 	URLFilterIDSafeBrowsing    URLFilterID = -4
 	URLFilterIDSafeSearch      URLFilterID = -5
+	URLFilterIDOverride        URLFilterID = -6
 )
 
 // UID is the type for the unique IDs of filtering-rule lists.
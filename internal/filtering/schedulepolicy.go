@@ -0,0 +1,167 @@
+package filtering
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+	"github.com/tukimoto/AdGuardHome/internal/schedule"
+)
+
+// SchedulePolicy is a set of filtering settings applied to the clients while
+// the current time is within its weekly schedule.  Unlike the schedule of
+// [BlockedServices], which pauses the blocking, the schedule of a policy
+// defines when the policy is active.
+type SchedulePolicy struct {
+	// SafeSearch is the safe search engine created from SafeSearchConf, if
+	// any.
+	SafeSearch SafeSearch `yaml:"-"`
+
+	// Schedule defines when the policy is active.  It must not be nil.
+	Schedule *schedule.Weekly `yaml:"schedule"`
+
+	// ProtectionEnabled, if not nil, replaces the protection setting of the
+	// clients while the policy is active.  It doesn't enable the protection
+	// disabled globally.
+	ProtectionEnabled *bool `yaml:"protection_enabled,omitempty"`
+
+	// ParentalEnabled, if not nil, replaces the parental control setting of the
+	// clients while the policy is active.
+	ParentalEnabled *bool `yaml:"parental_enabled,omitempty"`
+
+	// SafeSearchConf, if not nil, replaces the safe search settings of the
+	// clients while the policy is active.
+	SafeSearchConf *SafeSearchConfig `yaml:"safe_search,omitempty"`
+
+	// Name is the unique name of the policy shown in the query log.
+	Name string `yaml:"name"`
+
+	// Clients are the names of the persistent clients the policy is applied
+	// to.
+	Clients []string `yaml:"clients"`
+
+	// Tags are the tags of the persistent clients the policy is applied to.
+	// The policy is applied to all clients if both Clients and Tags are empty.
+	Tags []string `yaml:"tags"`
+
+	// FilterLists are the IDs of the filter lists applied to the clients only
+	// while the policy is active.  The lists are excluded for all other
+	// clients, as well as when the policy isn't active.  The lists must be
+	// enabled.
+	FilterLists []rulelist.URLFilterID `yaml:"filter_lists"`
+}
+
+// validate returns an error if p is invalid.  p must not be nil.
+func (p *SchedulePolicy) validate() (err error) {
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("name: %w", errors.ErrEmptyValue)
+	case p.Schedule == nil:
+		return fmt.Errorf("policy %q: schedule: %w", p.Name, errors.ErrNoValue)
+	case slices.Contains(p.FilterLists, rulelist.URLFilterIDCustom):
+		return fmt.Errorf("policy %q: custom filtering rules can't be scheduled", p.Name)
+	default:
+		return nil
+	}
+}
+
+// validateSchedulePolicies returns an error if any of policies is invalid or
+// their names aren't unique.
+func validateSchedulePolicies(policies []*SchedulePolicy) (err error) {
+	names := container.NewMapSet[string]()
+	for i, p := range policies {
+		err = p.validate()
+		if err != nil {
+			return fmt.Errorf("schedule policy at index %d: %w", i, err)
+		} else if names.Has(p.Name) {
+			return fmt.Errorf("schedule policy at index %d: duplicate name %q", i, p.Name)
+		}
+
+		names.Add(p.Name)
+	}
+
+	return nil
+}
+
+// matches returns true if p is applied to the client of setts.
+func (p *SchedulePolicy) matches(setts *Settings) (ok bool) {
+	if len(p.Clients) == 0 && len(p.Tags) == 0 {
+		return true
+	}
+
+	if setts.ClientName != "" && slices.Contains(p.Clients, setts.ClientName) {
+		return true
+	}
+
+	return slices.ContainsFunc(setts.ClientTags, func(tag string) (found bool) {
+		return slices.Contains(p.Tags, tag)
+	})
+}
+
+// apply replaces the settings in setts with the ones of the active p.
+func (p *SchedulePolicy) apply(setts *Settings) {
+	if p.ProtectionEnabled != nil {
+		setts.ProtectionEnabled = *p.ProtectionEnabled
+	}
+
+	if p.ParentalEnabled != nil {
+		setts.ParentalEnabled = *p.ParentalEnabled
+	}
+
+	if p.SafeSearchConf != nil {
+		setts.SafeSearchEnabled = p.SafeSearchConf.Enabled
+		setts.ClientSafeSearch = p.SafeSearch
+	}
+}
+
+// ApplySchedulePolicies applies the schedule policies matching the client of
+// setts and active at now to setts, in the order of their configuration.  The
+// filter lists of the policies are excluded for the client unless one of these
+// policies is active, in which case they're added to the client's selection of
+// filter lists, if any.  The policies never enable the protection that is
+// disabled or paused globally.  It also sets the names of the active policies
+// to setts.
+func (d *DNSFilter) ApplySchedulePolicies(setts *Settings, now time.Time) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	protectionEnabled := setts.ProtectionEnabled
+
+	var scheduled []rulelist.URLFilterID
+	activeLists := container.NewMapSet[rulelist.URLFilterID]()
+	for _, p := range d.conf.SchedulePolicies {
+		for _, id := range p.FilterLists {
+			if !slices.Contains(scheduled, id) {
+				scheduled = append(scheduled, id)
+			}
+		}
+
+		if !p.matches(setts) || !p.Schedule.Contains(now) {
+			continue
+		}
+
+		setts.SchedulePolicies = append(setts.SchedulePolicies, p.Name)
+		p.apply(setts)
+		for _, id := range p.FilterLists {
+			activeLists.Add(id)
+		}
+	}
+
+	setts.ProtectionEnabled = protectionEnabled && setts.ProtectionEnabled
+
+	for _, id := range scheduled {
+		switch {
+		case !activeLists.Has(id):
+			setts.ExcludedFilterLists = append(setts.ExcludedFilterLists, id)
+		case setts.UseFilterLists && !slices.Contains(setts.FilterLists, id):
+			// Clip the slice, since it may be shared with the client's
+			// settings.
+			setts.FilterLists = append(slices.Clip(setts.FilterLists), id)
+		default:
+			// Go on.
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package filtering
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+	"github.com/tukimoto/AdGuardHome/internal/schedule"
+)
+
+func TestDNSFilter_ApplySchedulePolicies(t *testing.T) {
+	const (
+		gamingListID rulelist.URLFilterID = 1
+		videoListID  rulelist.URLFilterID = 2
+		otherListID  rulelist.URLFilterID = 3
+	)
+
+	var (
+		enabled  = true
+		disabled = false
+	)
+
+	d, _ := newForTest(t, &Config{
+		SchedulePolicies: []*SchedulePolicy{{
+			Schedule:        schedule.FullWeekly(),
+			ParentalEnabled: &enabled,
+			Name:            "kids_evening",
+			Tags:            []string{"user_child"},
+			FilterLists:     []rulelist.URLFilterID{gamingListID},
+		}, {
+			Schedule:          schedule.EmptyWeekly(),
+			ProtectionEnabled: &disabled,
+			Name:              "tv_night",
+			Clients:           []string{"tv"},
+			FilterLists:       []rulelist.URLFilterID{gamingListID, videoListID},
+		}, {
+			Schedule:          schedule.FullWeekly(),
+			ProtectionEnabled: &enabled,
+			Name:              "guest_day",
+			Clients:           []string{"guest"},
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name            string
+		clientName      string
+		tags            []string
+		filterLists     []rulelist.URLFilterID
+		wantPolicies    []string
+		wantExcluded    []rulelist.URLFilterID
+		wantFilterLists []rulelist.URLFilterID
+		useFilterLists  bool
+		protection      bool
+		wantParental    bool
+		wantProtection  bool
+	}{{
+		name:            "other",
+		clientName:      "laptop",
+		tags:            []string{"device_pc"},
+		filterLists:     nil,
+		wantPolicies:    nil,
+		wantExcluded:    []rulelist.URLFilterID{gamingListID, videoListID},
+		wantFilterLists: nil,
+		useFilterLists:  false,
+		protection:      true,
+		wantParental:    false,
+		wantProtection:  true,
+	}, {
+		name:            "child",
+		clientName:      "tablet",
+		tags:            []string{"user_child"},
+		filterLists:     nil,
+		wantPolicies:    []string{"kids_evening"},
+		wantExcluded:    []rulelist.URLFilterID{videoListID},
+		wantFilterLists: nil,
+		useFilterLists:  false,
+		protection:      true,
+		wantParental:    true,
+		wantProtection:  true,
+	}, {
+		name:            "child_selection",
+		clientName:      "tablet",
+		tags:            []string{"user_child"},
+		filterLists:     []rulelist.URLFilterID{otherListID},
+		wantPolicies:    []string{"kids_evening"},
+		wantExcluded:    []rulelist.URLFilterID{videoListID},
+		wantFilterLists: []rulelist.URLFilterID{otherListID, gamingListID},
+		useFilterLists:  true,
+		protection:      true,
+		wantParental:    true,
+		wantProtection:  true,
+	}, {
+		name:            "tv",
+		clientName:      "tv",
+		tags:            nil,
+		filterLists:     nil,
+		wantPolicies:    nil,
+		wantExcluded:    []rulelist.URLFilterID{gamingListID, videoListID},
+		wantFilterLists: nil,
+		useFilterLists:  false,
+		protection:      true,
+		wantParental:    false,
+		wantProtection:  true,
+	}, {
+		name:            "child_tv",
+		clientName:      "tv",
+		tags:            []string{"user_child"},
+		filterLists:     nil,
+		wantPolicies:    []string{"kids_evening"},
+		wantExcluded:    []rulelist.URLFilterID{videoListID},
+		wantFilterLists: nil,
+		useFilterLists:  false,
+		protection:      true,
+		wantParental:    true,
+		wantProtection:  true,
+	}, {
+		name:            "guest_paused",
+		clientName:      "guest",
+		tags:            nil,
+		filterLists:     nil,
+		wantPolicies:    []string{"guest_day"},
+		wantExcluded:    []rulelist.URLFilterID{gamingListID, videoListID},
+		wantFilterLists: nil,
+		useFilterLists:  false,
+		protection:      false,
+		wantParental:    false,
+		wantProtection:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filterLists := slices.Clone(tc.filterLists)
+			setts := &Settings{
+				ClientName:        tc.clientName,
+				ClientTags:        tc.tags,
+				FilterLists:       filterLists,
+				UseFilterLists:    tc.useFilterLists,
+				ProtectionEnabled: tc.protection,
+			}
+
+			d.ApplySchedulePolicies(setts, time.Now())
+
+			assert.Equal(t, tc.wantPolicies, setts.SchedulePolicies)
+			assert.Equal(t, tc.wantExcluded, setts.ExcludedFilterLists)
+			assert.Equal(t, tc.wantFilterLists, setts.FilterLists)
+			assert.Equal(t, tc.wantParental, setts.ParentalEnabled)
+			assert.Equal(t, tc.wantProtection, setts.ProtectionEnabled)
+
+			// The client's selection must not be modified.
+			assert.Equal(t, tc.filterLists, filterLists)
+		})
+	}
+}
+
+func TestValidateSchedulePolicies(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		policies   []*SchedulePolicy
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		policies: []*SchedulePolicy{{
+			Schedule: schedule.EmptyWeekly(),
+			Name:     "first",
+		}, {
+			Schedule: schedule.EmptyWeekly(),
+			Name:     "second",
+		}},
+	}, {
+		name:       "no_name",
+		wantErrMsg: "schedule policy at index 0: name: empty value",
+		policies: []*SchedulePolicy{{
+			Schedule: schedule.EmptyWeekly(),
+		}},
+	}, {
+		name:       "no_schedule",
+		wantErrMsg: `schedule policy at index 0: policy "first": schedule: no value`,
+		policies: []*SchedulePolicy{{
+			Name: "first",
+		}},
+	}, {
+		name: "custom_rules",
+		wantErrMsg: `schedule policy at index 0: policy "first": ` +
+			`custom filtering rules can't be scheduled`,
+		policies: []*SchedulePolicy{{
+			Schedule:    schedule.EmptyWeekly(),
+			Name:        "first",
+			FilterLists: []rulelist.URLFilterID{rulelist.URLFilterIDCustom},
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `schedule policy at index 1: duplicate name "first"`,
+		policies: []*SchedulePolicy{{
+			Schedule: schedule.EmptyWeekly(),
+			Name:     "first",
+		}, {
+			Schedule: schedule.EmptyWeekly(),
+			Name:     "first",
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, validateSchedulePolicies(tc.policies))
+		})
+	}
+}
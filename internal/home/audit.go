@@ -0,0 +1,221 @@
+package home
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/tukimoto/AdGuardHome/internal/audit"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// auditConfig is the configuration of the audit journal.
+type auditConfig struct {
+	// Interval is the interval for the rotation of the journal files.
+	Interval timeutil.Duration `yaml:"interval"`
+
+	// Enabled defines if the configuration changes made through the HTTP API
+	// are recorded.  It's disabled by default, since the journal contains the
+	// details of the configuration, such as the users and the upstreams.
+	Enabled bool `yaml:"enabled"`
+}
+
+// auditDiffDepth is the depth of the configuration sections compared in the
+// audit journal, for example "dns.upstream_dns".
+const auditDiffDepth = 2
+
+// auditSecretKeys are the keys of the configuration file values which are
+// replaced with their fingerprints in the audit journal.
+var auditSecretKeys = map[string]struct{}{
+	"client_secret":  {},
+	"password":       {},
+	"private_key":    {},
+	"recovery_codes": {},
+	"totp_secret":    {},
+}
+
+// initAudit initializes the audit journal.  It returns nil if the journal is
+// disabled.
+func initAudit() (j *audit.Journal, err error) {
+	conf := config.Audit
+	if conf == nil || !conf.Enabled {
+		log.Info("audit: journal is disabled")
+
+		return nil, nil
+	}
+
+	return audit.New(&audit.Config{
+		HTTPRegister: httpRegister,
+		Filename:     filepath.Join(Context.getDataDir(), "audit.json"),
+		RotationIvl:  conf.Interval.Duration,
+	})
+}
+
+// configSnapshot returns the generic representation of the current
+// configuration with the secrets redacted.
+func configSnapshot() (snap map[string]any, err error) {
+	data, err := config.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, &snap)
+	if err != nil {
+		return nil, fmt.Errorf("decoding config: %w", err)
+	}
+
+	redactSecrets(snap)
+
+	return snap, nil
+}
+
+// redactSecrets replaces the values of the secret keys in v with their
+// fingerprints, so that the changes are still visible in the journal.
+func redactSecrets(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if _, ok := auditSecretKeys[k]; ok {
+				v[k] = secretFingerprint(val)
+			} else {
+				redactSecrets(val)
+			}
+		}
+	case []any:
+		for _, val := range v {
+			redactSecrets(val)
+		}
+	default:
+		// Go on.
+	}
+}
+
+// secretFingerprint returns the truncated SHA-256 hash of the string
+// representation of v.  Empty values are kept as is.
+func secretFingerprint(v any) (fp any) {
+	if v == nil || v == "" {
+		return v
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// statusRecorder is an [http.ResponseWriter] that records the status code of
+// the response.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int
+}
+
+// type check
+var _ http.ResponseWriter = (*statusRecorder)(nil)
+
+// WriteHeader implements the [http.ResponseWriter] interface for
+// *statusRecorder.
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the [http.ResponseWriter] interface for *statusRecorder.
+func (w *statusRecorder) Write(b []byte) (n int, err error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// auditHandler returns a handler which records the configuration changes made
+// by the modifying requests into the audit journal.  It must be called with
+// [homeContext.controlLock] locked, so that the changes made by the concurrent
+// requests aren't mixed up.
+func auditHandler(method, path string, handler http.HandlerFunc) (wrapped http.HandlerFunc) {
+	if !modifiesRoute(method, path) {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		j := Context.auditLog
+		if j == nil {
+			handler(w, r)
+
+			return
+		}
+
+		// Take the snapshot before each request, since the configuration may
+		// also be changed without the HTTP API, for example by the updates of
+		// the filter lists, which mustn't be attributed to the request.
+		before, err := configSnapshot()
+		if err != nil {
+			log.Error("audit: %s %s: snapshot before: %s", method, path, err)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		handler(rec, r)
+
+		after, err := configSnapshot()
+		if err != nil {
+			log.Error("audit: %s %s: snapshot after: %s", method, path, err)
+		}
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		addAuditEntry(j, r, method, path, status, audit.Diff(before, after, auditDiffDepth))
+	}
+}
+
+// auditDenied records the request for method and path denied due to the lack
+// of privileges into the audit journal, if it's enabled.
+func auditDenied(r *http.Request, method, path string) {
+	if j := Context.auditLog; j != nil {
+		addAuditEntry(j, r, method, path, http.StatusForbidden, nil)
+	}
+}
+
+// addAuditEntry adds the entry about the request r for method and path to j.
+func addAuditEntry(
+	j *audit.Journal,
+	r *http.Request,
+	method string,
+	path string,
+	status int,
+	changes []*audit.Change,
+) {
+	e := &audit.Entry{
+		Time:     time.Now(),
+		Method:   method,
+		Endpoint: path,
+		Changes:  changes,
+		Status:   status,
+	}
+
+	if Context.auth != nil {
+		e.User = Context.auth.getCurrentUser(r).Name
+	}
+
+	var err error
+	e.RemoteIP, err = realIP(r)
+	if err != nil {
+		log.Debug("audit: %s %s: getting ip: %s", method, path, err)
+	}
+
+	err = j.Add(e)
+	if err != nil {
+		log.Error("audit: adding entry: %s", err)
+	}
+}
@@ -0,0 +1,98 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/audit"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	snap := map[string]any{
+		"users": []any{map[string]any{
+			"name":           "admin",
+			"password":       "$2y$10$hash",
+			"totp_secret":    "",
+			"recovery_codes": []any{"a", "b"},
+		}},
+		"oidc": map[string]any{
+			"client_id":     "agh",
+			"client_secret": "secret",
+		},
+	}
+
+	redactSecrets(snap)
+
+	u := snap["users"].([]any)[0].(map[string]any)
+	assert.Equal(t, "admin", u["name"])
+	assert.Equal(t, secretFingerprint("$2y$10$hash"), u["password"])
+	assert.Regexp(t, `^sha256:[0-9a-f]{16}$`, u["password"])
+	assert.Equal(t, "", u["totp_secret"])
+	assert.NotEqual(t, []any{"a", "b"}, u["recovery_codes"])
+
+	oidc := snap["oidc"].(map[string]any)
+	assert.Equal(t, "agh", oidc["client_id"])
+	assert.NotEqual(t, "secret", oidc["client_secret"])
+}
+
+func TestAuditHandler_outOfBand(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{}
+	j, err := audit.New(&audit.Config{
+		HTTPRegister: func(_, url string, handler http.HandlerFunc) {
+			handlers[url] = handler
+		},
+		Filename:    filepath.Join(t.TempDir(), "audit.json"),
+		RotationIvl: time.Hour,
+	})
+	require.NoError(t, err)
+
+	j.Start()
+	t.Cleanup(j.Close)
+
+	prevLang, prevStorage := config.Language, Context.clients.storage
+	Context.auditLog = j
+	Context.clients.storage = newStorage(t, nil)
+	t.Cleanup(func() {
+		Context.auditLog = nil
+		Context.clients.storage = prevStorage
+		config.Language = prevLang
+	})
+
+	const path = "/control/i18n/change_language"
+
+	setLang := auditHandler(http.MethodPost, path, func(_ http.ResponseWriter, _ *http.Request) {
+		config.Language = "de"
+	})
+	noop := auditHandler(http.MethodPost, path, func(_ http.ResponseWriter, _ *http.Request) {})
+
+	setLang(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+
+	// The change not made by a request mustn't be attributed to the next one.
+	config.Language = "fr"
+
+	noop(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+
+	rw := httptest.NewRecorder()
+	handlers["/control/audit"](rw, httptest.NewRequest(http.MethodGet, "/control/audit", nil))
+	require.Equal(t, http.StatusOK, rw.Code)
+
+	resp := &struct {
+		Entries []*audit.Entry `json:"entries"`
+	}{}
+	err = json.Unmarshal(rw.Body.Bytes(), resp)
+	require.NoError(t, err)
+	require.Len(t, resp.Entries, 2)
+
+	assert.Empty(t, resp.Entries[0].Changes)
+
+	require.Len(t, resp.Entries[1].Changes, 1)
+
+	assert.Equal(t, "language", resp.Entries[1].Changes[0].Section)
+	assert.Equal(t, "de", resp.Entries[1].Changes[0].After)
+}
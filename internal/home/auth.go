@@ -57,7 +57,13 @@ type Auth struct {
 	trustedProxies netutil.SubnetSet
 	db             *bbolt.DB
 	rateLimiter    *authRateLimiter
+	oidc           *oidcProvider
 	sessions       map[string]*session
+	tokens         map[string]*apiToken
+	oidcUsers      map[string]webUser
+	oidcSubjects   map[string]string
+	totpPending    map[string]*totpEnrollment
+	totpLastSteps  map[string]uint64
 	users          []webUser
 	lock           sync.Mutex
 	sessionTTL     uint32
@@ -69,6 +75,18 @@ type Auth struct {
 type webUser struct {
 	Name         string `yaml:"name"`
 	PasswordHash string `yaml:"password"`
+
+	// Role is the access level of the user.  An empty role means
+	// [roleAdmin].
+	Role userRole `yaml:"role,omitempty"`
+
+	// TOTPSecret is the base32-encoded TOTP secret of the user.  If it's not
+	// empty, the two-factor authentication is enabled for the user.
+	TOTPSecret string `yaml:"totp_secret,omitempty"`
+
+	// RecoveryCodes are the hashes of the unused one-time recovery codes, see
+	// [hashRecoveryCode].
+	RecoveryCodes []string `yaml:"recovery_codes,omitempty"`
 }
 
 // InitAuth initializes the global authentication object.
@@ -85,6 +103,11 @@ func InitAuth(
 		sessionTTL:     sessionTTL,
 		rateLimiter:    rateLimiter,
 		sessions:       make(map[string]*session),
+		tokens:         make(map[string]*apiToken),
+		oidcUsers:      make(map[string]webUser),
+		oidcSubjects:   make(map[string]string),
+		totpPending:    make(map[string]*totpEnrollment),
+		totpLastSteps:  make(map[string]uint64),
 		users:          users,
 		trustedProxies: trustedProxies,
 	}
@@ -99,7 +122,14 @@ func InitAuth(
 		return nil
 	}
 	a.loadSessions()
-	log.Info("auth: initialized.  users:%d  sessions:%d", len(a.users), len(a.sessions))
+	a.loadTokens()
+	a.loadOIDCUsers()
+	log.Info(
+		"auth: initialized.  users:%d  sessions:%d  tokens:%d",
+		len(a.users),
+		len(a.sessions),
+		len(a.tokens),
+	)
 
 	return a
 }
@@ -331,6 +361,18 @@ func (a *Auth) addUser(u *webUser, password string) (err error) {
 	return nil
 }
 
+// findBasicUser returns a user if there is one and it may authenticate using
+// the HTTP Basic authentication, that is, the user doesn't have the two-factor
+// authentication enabled.
+func (a *Auth) findBasicUser(login, password string) (u webUser, ok bool) {
+	u, ok = a.findUser(login, password)
+	if !ok || u.TOTPSecret != "" {
+		return webUser{}, false
+	}
+
+	return u, true
+}
+
 // findUser returns a user if there is one.
 func (a *Auth) findUser(login, password string) (u webUser, ok bool) {
 	a.lock.Lock()
@@ -349,12 +391,25 @@ func (a *Auth) findUser(login, password string) (u webUser, ok bool) {
 // getCurrentUser returns the current user.  It returns an empty User if the
 // user is not found.
 func (a *Auth) getCurrentUser(r *http.Request) (u webUser) {
+	if token, ok := bearerToken(r); ok {
+		t := a.findToken(token)
+		if t == nil {
+			return webUser{}
+		}
+
+		// API tokens are limited by their scopes, see [ensureRole].
+		return webUser{
+			Name: t.userName(),
+			Role: roleReadOnly,
+		}
+	}
+
 	cookie, err := r.Cookie(sessionCookieName)
 	if err != nil {
 		// There's no Cookie, check Basic authentication.
 		user, pass, ok := r.BasicAuth()
 		if ok {
-			u, _ = Context.auth.findUser(user, pass)
+			u, _ = a.findBasicUser(user, pass)
 
 			return u
 		}
@@ -376,7 +431,7 @@ func (a *Auth) getCurrentUser(r *http.Request) (u webUser) {
 		}
 	}
 
-	return webUser{}
+	return a.oidcUsers[s.userName]
 }
 
 // usersList returns a copy of a users list.
@@ -399,7 +454,7 @@ func (a *Auth) authRequired() bool {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
-	return len(a.users) != 0
+	return len(a.users) != 0 || a.oidc != nil
 }
 
 // newSessionToken returns cryptographically secure randomly generated slice of
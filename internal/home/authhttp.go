@@ -29,6 +29,10 @@ const sessionCookieName = "agh_session"
 type loginJSON struct {
 	Name     string `json:"name"`
 	Password string `json:"password"`
+
+	// OTP is the TOTP code or a recovery code of the user.  It's only
+	// required if the user has the two-factor authentication enabled.
+	OTP string `json:"otp"`
 }
 
 // newCookie creates a new authentication cookie.
@@ -43,10 +47,32 @@ func (a *Auth) newCookie(req loginJSON, addr string) (c *http.Cookie, err error)
 		return nil, errors.Error("invalid username or password")
 	}
 
+	if u.TOTPSecret != "" {
+		var recoveryUsed bool
+		recoveryUsed, err = a.checkSecondFactor(u.Name, req.OTP)
+		if err != nil {
+			if rateLimiter != nil && req.OTP != "" {
+				rateLimiter.inc(addr)
+			}
+
+			return nil, err
+		}
+
+		if recoveryUsed {
+			onConfigModified()
+		}
+	}
+
 	if rateLimiter != nil {
 		rateLimiter.remove(addr)
 	}
 
+	return a.newSessionCookie(u.Name)
+}
+
+// newSessionCookie creates a new session for the user with the given name and
+// returns the authentication cookie for it.
+func (a *Auth) newSessionCookie(userName string) (c *http.Cookie, err error) {
 	sess, err := newSessionToken()
 	if err != nil {
 		return nil, fmt.Errorf("generating token: %w", err)
@@ -55,7 +81,7 @@ func (a *Auth) newCookie(req loginJSON, addr string) (c *http.Cookie, err error)
 	now := time.Now().UTC()
 
 	a.addSession(sess, &session{
-		userName: u.Name,
+		userName: userName,
 		expire:   uint32(now.Unix()) + a.sessionTTL,
 	})
 
@@ -183,7 +209,12 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 			logIP = ip.String()
 		}
 
-		writeErrorWithIP(r, w, http.StatusForbidden, logIP, "%s", err)
+		code := http.StatusForbidden
+		if errors.Is(err, errOTPRequired) {
+			code = http.StatusUnauthorized
+		}
+
+		writeErrorWithIP(r, w, code, logIP, "%s", err)
 
 		return
 	}
@@ -234,6 +265,10 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 func RegisterAuthHandlers() {
 	Context.mux.Handle("/control/login", postInstallHandler(ensureHandler(http.MethodPost, handleLogin)))
 	httpRegister(http.MethodGet, "/control/logout", handleLogout)
+
+	registerOIDCHandlers()
+	registerTokensHandlers()
+	registerTOTPHandlers()
 }
 
 // optionalAuthThird returns true if a user should authenticate first.
@@ -249,12 +284,17 @@ func optionalAuthThird(w http.ResponseWriter, r *http.Request) (mustAuth bool) {
 	// redirect to login page if not authenticated
 	isAuthenticated := false
 	cookie, err := r.Cookie(sessionCookieName)
-	if err != nil {
+	if token, ok := bearerToken(r); ok {
+		isAuthenticated = Context.auth.findToken(token) != nil
+		if !isAuthenticated {
+			log.Info("%s: invalid or expired api token", pref)
+		}
+	} else if err != nil {
 		// The only error that is returned from r.Cookie is [http.ErrNoCookie].
 		// Check Basic authentication.
 		user, pass, hasBasic := r.BasicAuth()
 		if hasBasic {
-			_, isAuthenticated = Context.auth.findUser(user, pass)
+			_, isAuthenticated = Context.auth.findBasicUser(user, pass)
 			if !isAuthenticated {
 				log.Info("%s: invalid basic authorization value", pref)
 			}
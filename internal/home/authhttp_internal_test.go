@@ -1,14 +1,19 @@
 package home
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"net/textproto"
 	"net/url"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -173,3 +178,56 @@ func TestRealIP(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleLogin_otp(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	Context.auth = InitAuth(filepath.Join(t.TempDir(), "sessions.db"), []webUser{{
+		Name:         "name",
+		PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2",
+		TOTPSecret:   secret,
+	}}, 60, nil, netutil.SliceSubnetSet{})
+	t.Cleanup(func() {
+		Context.auth.Close()
+		Context.auth = nil
+	})
+
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		otp      string
+		wantCode int
+	}{{
+		name:     "no_otp",
+		otp:      "",
+		wantCode: http.StatusUnauthorized,
+	}, {
+		name:     "bad_otp",
+		otp:      "000000",
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "otp",
+		otp:      totpCode(key, totpStep(time.Now())),
+		wantCode: http.StatusOK,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, mErr := json.Marshal(&loginJSON{
+				Name:     "name",
+				Password: "password",
+				OTP:      tc.otp,
+			})
+			require.NoError(t, mErr)
+
+			r := httptest.NewRequest(http.MethodPost, "/control/login", bytes.NewReader(data))
+			w := httptest.NewRecorder()
+
+			handleLogin(w, r)
+			assert.Equal(t, tc.wantCode, w.Code)
+			assert.Equal(t, tc.wantCode == http.StatusOK, len(w.Result().Cookies()) == 1)
+		})
+	}
+}
@@ -0,0 +1,844 @@
+package home
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/ioutil"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+	"go.etcd.io/bbolt"
+)
+
+// oidcConfig is the configuration of the OpenID Connect login.
+type oidcConfig struct {
+	// RoleMapping maps the values of the RolesClaim claim to the roles of the
+	// users that aren't configured locally.
+	RoleMapping map[string]userRole `yaml:"role_mapping"`
+
+	// LocalUsers maps the values of the "sub" claim of the identity provider
+	// users to the names of the local users they log in as.  The identity
+	// provider users are never matched to the local users in any other way,
+	// and the second factor of a linked local user is still required.
+	LocalUsers map[string]string `yaml:"local_users"`
+
+	// Issuer is the URL of the identity provider.  The discovery document is
+	// fetched from its /.well-known/openid-configuration path.
+	Issuer string `yaml:"issuer"`
+
+	// ClientID is the client identifier registered at the identity provider.
+	ClientID string `yaml:"client_id"`
+
+	// ClientSecret is the client secret registered at the identity provider.
+	// It may be empty for public clients.
+	ClientSecret string `yaml:"client_secret"`
+
+	// RedirectURL is the URL of the /control/login/oidc/callback handler as
+	// seen by the browser.
+	RedirectURL string `yaml:"redirect_url"`
+
+	// UsernameClaim is the ID token claim containing the name shown for the
+	// users that aren't linked to the local ones, prefixed with
+	// [oidcUserPrefix].  If it's empty, "preferred_username" is used.  The
+	// users are identified by the issuer and the "sub" claim, since this claim
+	// may usually be changed by the users themselves.
+	UsernameClaim string `yaml:"username_claim"`
+
+	// RolesClaim is the ID token claim containing the user's groups or roles,
+	// which are looked up in RoleMapping.  If it's empty, the users that aren't
+	// configured locally get DefaultRole.
+	RolesClaim string `yaml:"roles_claim"`
+
+	// DefaultRole is the role of the users that aren't configured locally and
+	// aren't matched by RoleMapping.  If it's empty, such users aren't allowed
+	// to log in.
+	DefaultRole userRole `yaml:"default_role"`
+
+	// Scopes are the OAuth2 scopes requested in addition to "openid".
+	Scopes []string `yaml:"scopes"`
+
+	// Enabled defines if the OpenID Connect login is enabled.
+	Enabled bool `yaml:"enabled"`
+}
+
+// defaultOIDCUsernameClaim is the default value of the
+// [oidcConfig.UsernameClaim].
+const defaultOIDCUsernameClaim = "preferred_username"
+
+// oidcUserPrefix is the prefix of the names of the identity provider users
+// that aren't linked to the local ones, so that they are never taken for the
+// local users.
+const oidcUserPrefix = "oidc:"
+
+// validate returns an error if c is not a valid OpenID Connect configuration.
+func (c *oidcConfig) validate() (err error) {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+
+	var errs []error
+	for _, u := range []struct {
+		val  string
+		name string
+	}{{
+		val:  c.Issuer,
+		name: "issuer",
+	}, {
+		val:  c.RedirectURL,
+		name: "redirect_url",
+	}} {
+		_, err = url.ParseRequestURI(u.val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.name, err))
+		}
+	}
+
+	if c.ClientID == "" {
+		errs = append(errs, errors.Error("client_id: empty value"))
+	}
+
+	for claim, role := range c.RoleMapping {
+		if !role.isValid() {
+			errs = append(errs, fmt.Errorf("role_mapping: bad role %q for %q", role, claim))
+		}
+	}
+
+	if c.DefaultRole != "" && !c.DefaultRole.isValid() {
+		errs = append(errs, fmt.Errorf("default_role: bad role %q", c.DefaultRole))
+	}
+
+	for sub, name := range c.LocalUsers {
+		if sub == "" || name == "" {
+			errs = append(errs, fmt.Errorf("local_users: bad mapping %q to %q", sub, name))
+		}
+	}
+
+	return errors.Annotate(errors.Join(errs...), "oidc: %w")
+}
+
+// oidcClockSkew is the allowed difference between the clocks of AdGuard Home
+// and the identity provider when checking the times in the ID token.
+const oidcClockSkew = 1 * time.Minute
+
+// oidcMinRSABits is the minimum size of the RSA keys of the identity provider.
+const oidcMinRSABits = 2048
+
+// oidcPendingTTL is the time given to the user to complete the login at the
+// identity provider.
+const oidcPendingTTL = 10 * time.Minute
+
+// oidcMaxRespSize is the maximum size of the responses from the identity
+// provider.
+const oidcMaxRespSize = 1 * 1024 * 1024
+
+// oidcMetadata is the part of the OpenID Provider metadata used by AdGuard
+// Home.
+//
+// See https://openid.net/specs/openid-connect-discovery-1_0.html.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcPending is the state of a started and not yet completed login.
+type oidcPending struct {
+	expire   time.Time
+	nonce    string
+	verifier string
+}
+
+// oidcOTPPending is the state of a login of a linked local user waiting for
+// the second factor.
+type oidcOTPPending struct {
+	expire   time.Time
+	userName string
+}
+
+// oidcProvider performs the OpenID Connect authorization code flow with PKCE.
+type oidcProvider struct {
+	conf   *oidcConfig
+	client *http.Client
+
+	// mu protects the fields below.
+	mu      *sync.Mutex
+	meta    *oidcMetadata
+	keys    map[string]crypto.PublicKey
+	pending map[string]*oidcPending
+
+	// otpPending are the logins waiting for the second factor by their
+	// tickets.
+	otpPending map[string]*oidcOTPPending
+}
+
+// newOIDCProvider returns a new OpenID Connect provider.  conf must be valid
+// and enabled.
+func newOIDCProvider(conf *oidcConfig, client *http.Client) (p *oidcProvider) {
+	return &oidcProvider{
+		conf:    conf,
+		client:  client,
+		mu:      &sync.Mutex{},
+		keys:    map[string]crypto.PublicKey{},
+		pending: map[string]*oidcPending{},
+
+		otpPending: map[string]*oidcOTPPending{},
+	}
+}
+
+// randomString returns a URL-safe string made of n cryptographically secure
+// random bytes.
+func randomString(n int) (s string, err error) {
+	data := make([]byte, n)
+	_, err = rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// getJSON fetches the JSON document from u and decodes it into v.
+func (p *oidcProvider) getJSON(ctx context.Context, u string, v any) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(httphdr.Accept, aghhttp.HdrValApplicationJSON)
+
+	return p.doJSON(req, v)
+}
+
+// doJSON performs req and decodes the JSON response into v.
+func (p *oidcProvider) doJSON(req *http.Request, v any) (err error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting %s: %w", req.URL, err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	body := ioutil.LimitReader(resp.Body, oidcMaxRespSize)
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(body)
+
+		return fmt.Errorf("requesting %s: status %d: %q", req.URL, resp.StatusCode, msg)
+	}
+
+	err = json.NewDecoder(body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("decoding response from %s: %w", req.URL, err)
+	}
+
+	return nil
+}
+
+// metadata returns the provider metadata, fetching it if necessary.
+func (p *oidcProvider) metadata(ctx context.Context) (meta *oidcMetadata, err error) {
+	p.mu.Lock()
+	meta = p.meta
+	p.mu.Unlock()
+
+	if meta != nil {
+		return meta, nil
+	}
+
+	u := strings.TrimSuffix(p.conf.Issuer, "/") + "/.well-known/openid-configuration"
+	meta = &oidcMetadata{}
+	err = p.getJSON(ctx, u, meta)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+
+	if strings.TrimSuffix(meta.Issuer, "/") != strings.TrimSuffix(p.conf.Issuer, "/") {
+		return nil, fmt.Errorf("discovery: issuer mismatch: got %q", meta.Issuer)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.meta = meta
+
+	return meta, nil
+}
+
+// authCodeURL starts a new login and returns the URL of the authorization
+// endpoint to redirect the user to.
+func (p *oidcProvider) authCodeURL(ctx context.Context) (u string, err error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	pend := &oidcPending{
+		expire: time.Now().Add(oidcPendingTTL),
+	}
+
+	var state string
+	for _, s := range []*string{&state, &pend.nonce, &pend.verifier} {
+		*s, err = randomString(32)
+		if err != nil {
+			return "", fmt.Errorf("generating random value: %w", err)
+		}
+	}
+
+	challenge := sha256.Sum256([]byte(pend.verifier))
+
+	authURL, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("parsing authorization endpoint: %w", err)
+	}
+
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.conf.ClientID)
+	q.Set("redirect_uri", p.conf.RedirectURL)
+	q.Set("scope", strings.Join(append([]string{"openid"}, p.conf.Scopes...), " "))
+	q.Set("state", state)
+	q.Set("nonce", pend.nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	authURL.RawQuery = q.Encode()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range p.pending {
+		if now.After(v.expire) {
+			delete(p.pending, k)
+		}
+	}
+
+	p.pending[state] = pend
+
+	return authURL.String(), nil
+}
+
+// startOTP returns a new ticket for the login of the local user with the given
+// name, which must be completed with the second factor using
+// [oidcProvider.finishOTP].
+func (p *oidcProvider) startOTP(userName string) (ticket string, err error) {
+	ticket, err = randomString(32)
+	if err != nil {
+		return "", fmt.Errorf("generating ticket: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range p.otpPending {
+		if now.After(v.expire) {
+			delete(p.otpPending, k)
+		}
+	}
+
+	p.otpPending[ticket] = &oidcOTPPending{
+		expire:   now.Add(oidcPendingTTL),
+		userName: userName,
+	}
+
+	return ticket, nil
+}
+
+// finishOTP returns the name of the user waiting for the second factor with
+// ticket.  ok is false if there is no such ticket or it has expired.  The
+// ticket is removed if done is true.
+func (p *oidcProvider) finishOTP(ticket string, done bool) (userName string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pend, ok := p.otpPending[ticket]
+	if !ok || time.Now().After(pend.expire) {
+		delete(p.otpPending, ticket)
+
+		return "", false
+	}
+
+	if done {
+		delete(p.otpPending, ticket)
+	}
+
+	return pend.userName, true
+}
+
+// oidcTokenResponse is the part of the token endpoint response used by
+// AdGuard Home.
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// exchange completes the login started with the given state and returns the
+// claims of the verified ID token.
+func (p *oidcProvider) exchange(
+	ctx context.Context,
+	state string,
+	code string,
+) (claims map[string]any, err error) {
+	p.mu.Lock()
+	pend, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(pend.expire) {
+		return nil, errors.Error("unknown or expired state")
+	}
+
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    []string{"authorization_code"},
+		"code":          []string{code},
+		"redirect_uri":  []string{p.conf.RedirectURL},
+		"client_id":     []string{p.conf.ClientID},
+		"code_verifier": []string{pend.verifier},
+	}
+
+	if p.conf.ClientSecret != "" {
+		form.Set("client_secret", p.conf.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		meta.TokenEndpoint,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating token request: %w", err)
+	}
+
+	req.Header.Set(httphdr.ContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(httphdr.Accept, aghhttp.HdrValApplicationJSON)
+
+	tokResp := &oidcTokenResponse{}
+	err = p.doJSON(req, tokResp)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+
+	return p.verifyIDToken(ctx, meta, tokResp.IDToken, pend.nonce)
+}
+
+// jwtHeader is the header of a JSON Web Token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verifyIDToken verifies the signature and the claims of the ID token and
+// returns its claims.
+func (p *oidcProvider) verifyIDToken(
+	ctx context.Context,
+	meta *oidcMetadata,
+	raw string,
+	nonce string,
+) (claims map[string]any, err error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.Error("id token: malformed jwt")
+	}
+
+	hdr := &jwtHeader{}
+	err = decodeJWTPart(parts[0], hdr)
+	if err != nil {
+		return nil, fmt.Errorf("id token: header: %w", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("id token: signature: %w", err)
+	}
+
+	key, err := p.key(ctx, meta, hdr.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("id token: %w", err)
+	}
+
+	err = verifyJWTSignature(hdr.Alg, key, parts[0]+"."+parts[1], sig)
+	if err != nil {
+		return nil, fmt.Errorf("id token: %w", err)
+	}
+
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("id token: claims: %w", err)
+	}
+
+	err = p.validateClaims(meta, claims, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("id token: %w", err)
+	}
+
+	return claims, nil
+}
+
+// validateClaims checks the standard claims of the ID token.
+func (p *oidcProvider) validateClaims(
+	meta *oidcMetadata,
+	claims map[string]any,
+	nonce string,
+) (err error) {
+	if iss, _ := claims["iss"].(string); iss != meta.Issuer {
+		return fmt.Errorf("bad issuer %q", iss)
+	}
+
+	var aud []string
+	switch v := claims["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []any:
+		for _, a := range v {
+			s, _ := a.(string)
+			aud = append(aud, s)
+		}
+	}
+
+	if !slices.Contains(aud, p.conf.ClientID) {
+		return fmt.Errorf("bad audience %q", aud)
+	}
+
+	now := time.Now()
+	exp, _ := claims["exp"].(float64)
+	if now.Unix() >= int64(exp) {
+		return errors.Error("token expired")
+	}
+
+	notAfter := now.Add(oidcClockSkew).Unix()
+	if nbf, ok := claims["nbf"].(float64); ok && int64(nbf) > notAfter {
+		return errors.Error("token not yet valid")
+	}
+
+	if iat, ok := claims["iat"].(float64); ok && int64(iat) > notAfter {
+		return errors.Error("token issued in the future")
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return errors.Error("nonce mismatch")
+	}
+
+	return nil
+}
+
+// decodeJWTPart decodes a base64url-encoded JSON part of a JWT into v.
+func decodeJWTPart(part string, v any) (err error) {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// verifyJWTSignature verifies the signature of the signed part of a JWT.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, sig []byte) (err error) {
+	sum := sha256.Sum256([]byte(signed))
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			break
+		}
+
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig)
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(sig) != 64 {
+			break
+		}
+
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, sum[:], r, s) {
+			return errors.Error("ecdsa: verification error")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// jwk is a JSON Web Key.  Only the RSA and P-256 EC keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey converts k into a public key.
+func (k *jwk) publicKey() (pub crypto.PublicKey, err error) {
+	b64 := base64.RawURLEncoding
+
+	switch k.Kty {
+	case "RSA":
+		n, nErr := b64.DecodeString(k.N)
+		e, eErr := b64.DecodeString(k.E)
+		if err = errors.Join(nErr, eErr); err != nil {
+			return nil, err
+		}
+
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if bits := key.N.BitLen(); bits < oidcMinRSABits {
+			return nil, fmt.Errorf("rsa key of %d bits is too small", bits)
+		}
+
+		return key, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, xErr := b64.DecodeString(k.X)
+		y, yErr := b64.DecodeString(k.Y)
+		if err = errors.Join(xErr, yErr); err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// key returns the public key with the given ID, refreshing the key set if the
+// key is unknown.
+func (p *oidcProvider) key(
+	ctx context.Context,
+	meta *oidcMetadata,
+	kid string,
+) (key crypto.PublicKey, err error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	set := &struct {
+		Keys []*jwk `json:"keys"`
+	}{}
+	err = p.getJSON(ctx, meta.JWKSURI, set)
+	if err != nil {
+		return nil, fmt.Errorf("fetching keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		pub, keyErr := k.publicKey()
+		if keyErr != nil {
+			log.Debug("auth: oidc: skipping key %q: %s", k.Kid, keyErr)
+
+			continue
+		}
+
+		keys[k.Kid] = pub
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = keys
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no key with id %q", kid)
+	}
+
+	return key, nil
+}
+
+// oidcUsersBucketName returns the name of the database bucket with the users
+// logged in using OpenID Connect and not configured locally.
+func oidcUsersBucketName() []byte {
+	return []byte("oidc_users")
+}
+
+// oidcUserJSON is the database representation of a user logged in using
+// OpenID Connect.
+type oidcUserJSON struct {
+	Name string   `json:"name"`
+	Role userRole `json:"role"`
+
+	// Subject is the identifier of the user at the identity provider, see
+	// [oidcSubject].
+	Subject string `json:"subject"`
+}
+
+// oidcSubject returns the identifier of the identity provider user with the
+// claims of a verified ID token.  ok is false if there is no "sub" claim.
+func oidcSubject(claims map[string]any) (subject, sub string, ok bool) {
+	iss, _ := claims["iss"].(string)
+	sub, _ = claims["sub"].(string)
+	if sub == "" {
+		return "", "", false
+	}
+
+	return iss + " " + sub, sub, true
+}
+
+// loadOIDCUsers loads the users logged in using OpenID Connect from the
+// database file.
+func (a *Auth) loadOIDCUsers() {
+	err := a.db.View(func(tx *bbolt.Tx) (viewErr error) {
+		bkt := tx.Bucket(oidcUsersBucketName())
+		if bkt == nil {
+			return nil
+		}
+
+		return bkt.ForEach(func(_, v []byte) (decErr error) {
+			u := &oidcUserJSON{}
+			decErr = json.Unmarshal(v, u)
+			if decErr != nil {
+				log.Error("auth: decoding oidc user: %s", decErr)
+
+				return nil
+			} else if u.Subject == "" || !strings.HasPrefix(u.Name, oidcUserPrefix) {
+				// Skip the users stored by the previous versions, which were
+				// identified by the username claim.
+				return nil
+			}
+
+			a.oidcUsers[u.Name] = webUser{Name: u.Name, Role: u.Role}
+			a.oidcSubjects[u.Name] = u.Subject
+
+			return nil
+		})
+	})
+	if err != nil {
+		log.Error("auth: loading oidc users: %s", err)
+	}
+}
+
+// oidcLogin maps the claims of a verified ID token to a user.  The users
+// linked to the local ones in [oidcConfig.LocalUsers] log in as them, and the
+// caller must check the second factor of the returned user, if it's enabled.
+// The others get their role from the claims.
+func (a *Auth) oidcLogin(conf *oidcConfig, claims map[string]any) (u webUser, err error) {
+	subject, sub, ok := oidcSubject(claims)
+	if !ok {
+		return webUser{}, errors.Error("no subject in id token")
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if localName, linked := conf.LocalUsers[sub]; linked {
+		idx := a.userIndex(localName)
+		if idx < 0 {
+			return webUser{}, fmt.Errorf("linked local user %q not found", localName)
+		}
+
+		return a.users[idx], nil
+	}
+
+	claim := conf.UsernameClaim
+	if claim == "" {
+		claim = defaultOIDCUsernameClaim
+	}
+
+	name, _ := claims[claim].(string)
+	if name == "" {
+		return webUser{}, fmt.Errorf("no username in claim %q", claim)
+	}
+
+	name = oidcUserPrefix + name
+	if a.userIndex(name) >= 0 {
+		return webUser{}, fmt.Errorf("username %q is taken by a local user", name)
+	} else if owner, taken := a.oidcSubjects[name]; taken && owner != subject {
+		return webUser{}, fmt.Errorf("username %q is taken by another user", name)
+	}
+
+	role := oidcRole(conf, claims[conf.RolesClaim])
+	if role == "" {
+		return webUser{}, fmt.Errorf("user %q is not allowed to log in", name)
+	}
+
+	u = webUser{Name: name, Role: role}
+	data, err := json.Marshal(&oidcUserJSON{Name: name, Role: role, Subject: subject})
+	if err != nil {
+		return webUser{}, fmt.Errorf("encoding user: %w", err)
+	}
+
+	err = a.db.Update(func(tx *bbolt.Tx) (updErr error) {
+		bkt, updErr := tx.CreateBucketIfNotExists(oidcUsersBucketName())
+		if updErr != nil {
+			return fmt.Errorf("creating bucket: %w", updErr)
+		}
+
+		return bkt.Put([]byte(subject), data)
+	})
+	if err != nil {
+		return webUser{}, fmt.Errorf("storing user: %w", err)
+	}
+
+	a.oidcUsers[name] = u
+	a.oidcSubjects[name] = subject
+
+	return u, nil
+}
+
+// oidcRole returns the most privileged role matched by the value of the roles
+// claim, or the default role.  Unlike the roles of the local users, an empty
+// role means that the user isn't allowed to log in.
+func oidcRole(conf *oidcConfig, claim any) (role userRole) {
+	var vals []string
+	switch v := claim.(type) {
+	case string:
+		vals = []string{v}
+	case []any:
+		for _, val := range v {
+			s, _ := val.(string)
+			vals = append(vals, s)
+		}
+	}
+
+	for _, v := range vals {
+		mapped := conf.RoleMapping[v]
+		if mapped.isValid() && (role == "" || mapped.level() > role.level()) {
+			role = mapped
+		}
+	}
+
+	if role == "" {
+		return conf.DefaultRole
+	}
+
+	return role
+}
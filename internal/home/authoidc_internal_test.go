@@ -0,0 +1,512 @@
+package home
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIdP is a minimal OpenID Connect identity provider for tests.
+type testIdP struct {
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+
+	// mu protects the fields below.
+	mu        *sync.Mutex
+	challenge string
+	nonce     string
+}
+
+// newTestIdP starts a new test identity provider which issues ID tokens with
+// the given extra claims.
+func newTestIdP(t *testing.T, claims map[string]any) (idp *testIdP) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp = &testIdP{
+		key:    key,
+		claims: claims,
+		mu:     &sync.Mutex{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(&oidcMetadata{
+			Issuer:                idp.srv.URL,
+			AuthorizationEndpoint: idp.srv.URL + "/authorize",
+			TokenEndpoint:         idp.srv.URL + "/token",
+			JWKSURI:               idp.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		b64 := base64.RawURLEncoding
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []*jwk{{
+				Kty: "RSA",
+				Kid: "test",
+				Use: "sig",
+				N:   b64.EncodeToString(key.N.Bytes()),
+				E:   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", idp.handleToken)
+
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+
+	return idp
+}
+
+// authorize emulates the user logging in at the authorization endpoint and
+// returns the callback URL.
+func (idp *testIdP) authorize(t *testing.T, authURL string) (callback *url.URL) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+
+	q := u.Query()
+	require.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	idp.mu.Lock()
+	idp.challenge = q.Get("code_challenge")
+	idp.nonce = q.Get("nonce")
+	idp.mu.Unlock()
+
+	callback, err = url.Parse(q.Get("redirect_uri"))
+	require.NoError(t, err)
+
+	callback.RawQuery = url.Values{
+		"state": []string{q.Get("state")},
+		"code":  []string{"test-code"},
+	}.Encode()
+
+	return callback
+}
+
+// handleToken is the token endpoint of the test identity provider.
+func (idp *testIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if r.FormValue("code") != "test-code" ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+		http.Error(w, "bad code", http.StatusBadRequest)
+
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   idp.srv.URL,
+		"aud":   r.FormValue("client_id"),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": idp.nonce,
+	}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+
+	_ = json.NewEncoder(w).Encode(&oidcTokenResponse{
+		IDToken: idp.sign(claims),
+	})
+}
+
+// sign returns a signed JWT with the given claims.
+func (idp *testIdP) sign(claims map[string]any) (token string) {
+	b64 := base64.RawURLEncoding
+
+	hdr, _ := json.Marshal(&jwtHeader{Alg: "RS256", Kid: "test"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(hdr) + "." + b64.EncodeToString(payload)
+
+	sum := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func TestAuth_oidcLogin(t *testing.T) {
+	users := []webUser{{
+		Name: "local",
+		Role: roleOperator,
+	}}
+
+	testCases := []struct {
+		claims     map[string]any
+		name       string
+		wantName   string
+		wantErrMsg string
+		wantRole   userRole
+	}{{
+		claims: map[string]any{
+			"sub":                "sub-local",
+			"preferred_username": "someone",
+		},
+		name:       "linked_user",
+		wantName:   "local",
+		wantErrMsg: "",
+		wantRole:   roleOperator,
+	}, {
+		claims: map[string]any{
+			"sub":                "sub-other",
+			"preferred_username": "local",
+			"groups":             "helpdesk",
+		},
+		name:       "not_linked_by_name",
+		wantName:   "oidc:local",
+		wantErrMsg: "",
+		wantRole:   roleReadOnly,
+	}, {
+		claims: map[string]any{
+			"sub":                "sub-helpdesk",
+			"preferred_username": "helpdesk",
+			"groups":             []any{"staff", "helpdesk"},
+		},
+		name:       "mapped_role",
+		wantName:   "oidc:helpdesk",
+		wantErrMsg: "",
+		wantRole:   roleReadOnly,
+	}, {
+		claims: map[string]any{
+			"sub":                "sub-admin",
+			"preferred_username": "admin",
+			"groups":             []any{"admins", "helpdesk"},
+		},
+		name:       "highest_role",
+		wantName:   "oidc:admin",
+		wantErrMsg: "",
+		wantRole:   roleAdmin,
+	}, {
+		claims: map[string]any{
+			"sub":                "sub-stranger",
+			"preferred_username": "stranger",
+			"groups":             "guests",
+		},
+		name:       "not_allowed",
+		wantName:   "",
+		wantErrMsg: `user "oidc:stranger" is not allowed to log in`,
+		wantRole:   "",
+	}, {
+		claims: map[string]any{
+			"preferred_username": "admin",
+			"groups":             "admins",
+		},
+		name:       "no_subject",
+		wantName:   "",
+		wantErrMsg: `no subject in id token`,
+		wantRole:   "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			idp := newTestIdP(t, tc.claims)
+			conf := &oidcConfig{
+				RoleMapping: map[string]userRole{
+					"admins":   roleAdmin,
+					"helpdesk": roleReadOnly,
+				},
+				LocalUsers: map[string]string{
+					"sub-local": "local",
+				},
+				Issuer:      idp.srv.URL,
+				ClientID:    "agh",
+				RedirectURL: "http://agh.example/control/login/oidc/callback",
+				RolesClaim:  "groups",
+				Enabled:     true,
+			}
+			require.NoError(t, conf.validate())
+
+			a := InitAuth(filepath.Join(t.TempDir(), "sessions.db"), users, 60, nil, nil)
+			require.NotNil(t, a)
+			t.Cleanup(a.Close)
+
+			p := newOIDCProvider(conf, idp.srv.Client())
+			authURL, err := p.authCodeURL(testutil.ContextWithTimeout(t, time.Second))
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(authURL, idp.srv.URL+"/authorize?"))
+
+			callback := idp.authorize(t, authURL)
+			q := callback.Query()
+
+			claims, err := p.exchange(
+				testutil.ContextWithTimeout(t, time.Second),
+				q.Get("state"),
+				q.Get("code"),
+			)
+			require.NoError(t, err)
+
+			u, err := a.oidcLogin(conf, claims)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.wantName, u.Name)
+			assert.Equal(t, tc.wantRole, u.Role)
+
+			// The state must only be used once.
+			_, err = p.exchange(
+				testutil.ContextWithTimeout(t, time.Second),
+				q.Get("state"),
+				q.Get("code"),
+			)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAuth_oidcLogin_takeover(t *testing.T) {
+	users := []webUser{{
+		Name:       "admin",
+		TOTPSecret: "JBSWY3DPEHPK3PXP",
+	}}
+
+	conf := &oidcConfig{
+		LocalUsers: map[string]string{
+			"sub-admin": "admin",
+		},
+		DefaultRole: roleReadOnly,
+	}
+
+	a := InitAuth(filepath.Join(t.TempDir(), "sessions.db"), users, 60, nil, nil)
+	require.NotNil(t, a)
+	t.Cleanup(a.Close)
+
+	claims := func(sub, name string) (c map[string]any) {
+		return map[string]any{
+			"iss":                "https://idp.example",
+			"sub":                sub,
+			"preferred_username": name,
+		}
+	}
+
+	u, err := a.oidcLogin(conf, claims("sub-1", "admin"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "oidc:admin", u.Name)
+	assert.Equal(t, roleReadOnly, u.Role)
+
+	_, err = a.oidcLogin(conf, claims("sub-2", "admin"))
+	testutil.AssertErrorMsg(t, `username "oidc:admin" is taken by another user`, err)
+
+	u, err = a.oidcLogin(conf, claims("sub-1", "admin"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "oidc:admin", u.Name)
+
+	// The linked local user must still provide the second factor.
+	u, err = a.oidcLogin(conf, claims("sub-admin", "anything"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "admin", u.Name)
+	assert.NotEmpty(t, u.TOTPSecret)
+}
+
+func TestOIDCProvider_OTP(t *testing.T) {
+	p := newOIDCProvider(&oidcConfig{}, http.DefaultClient)
+
+	ticket, err := p.startOTP("admin")
+	require.NoError(t, err)
+
+	_, ok := p.finishOTP("unknown", false)
+	assert.False(t, ok)
+
+	name, ok := p.finishOTP(ticket, false)
+	require.True(t, ok)
+
+	assert.Equal(t, "admin", name)
+
+	_, ok = p.finishOTP(ticket, true)
+	require.True(t, ok)
+
+	_, ok = p.finishOTP(ticket, false)
+	assert.False(t, ok)
+}
+
+func TestOIDCProvider_verifyIDToken(t *testing.T) {
+	idp := newTestIdP(t, nil)
+	conf := &oidcConfig{
+		Issuer:   idp.srv.URL,
+		ClientID: "agh",
+		Enabled:  true,
+	}
+
+	p := newOIDCProvider(conf, idp.srv.Client())
+	ctx := testutil.ContextWithTimeout(t, time.Second)
+	meta, err := p.metadata(ctx)
+	require.NoError(t, err)
+
+	validClaims := func() (c map[string]any) {
+		return map[string]any{
+			"iss":   idp.srv.URL,
+			"aud":   []any{"other", "agh"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": "nonce",
+		}
+	}
+
+	claims, err := p.verifyIDToken(ctx, meta, idp.sign(validClaims()), "nonce")
+	require.NoError(t, err)
+
+	assert.Equal(t, "nonce", claims["nonce"])
+
+	testCases := []struct {
+		modify     func(c map[string]any)
+		name       string
+		wantErrMsg string
+	}{{
+		modify:     func(c map[string]any) { c["aud"] = "other" },
+		name:       "bad_audience",
+		wantErrMsg: `id token: bad audience ["other"]`,
+	}, {
+		modify:     func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		name:       "expired",
+		wantErrMsg: `id token: token expired`,
+	}, {
+		modify:     func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		name:       "not_yet_valid",
+		wantErrMsg: `id token: token not yet valid`,
+	}, {
+		modify:     func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		name:       "issued_in_future",
+		wantErrMsg: `id token: token issued in the future`,
+	}, {
+		modify: func(c map[string]any) {
+			c["iat"] = time.Now().Add(oidcClockSkew / 2).Unix()
+			c["nbf"] = time.Now().Add(oidcClockSkew / 2).Unix()
+		},
+		name:       "skew",
+		wantErrMsg: ``,
+	}, {
+		modify:     func(c map[string]any) { c["nonce"] = "other" },
+		name:       "bad_nonce",
+		wantErrMsg: `id token: nonce mismatch`,
+	}, {
+		modify:     func(c map[string]any) { c["iss"] = "https://evil.example" },
+		name:       "bad_issuer",
+		wantErrMsg: `id token: bad issuer "https://evil.example"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := validClaims()
+			tc.modify(c)
+
+			_, err = p.verifyIDToken(ctx, meta, idp.sign(c), "nonce")
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+
+	t.Run("bad_signature", func(t *testing.T) {
+		tok := idp.sign(validClaims())
+		tok = tok[:strings.LastIndex(tok, ".")+1] + "AAAA"
+
+		_, err = p.verifyIDToken(ctx, meta, tok, "nonce")
+		assert.Error(t, err)
+	})
+}
+
+func TestJWK_publicKey(t *testing.T) {
+	b64 := base64.RawURLEncoding
+
+	// The size of the key is irrelevant to the test, so use a random modulus
+	// instead of generating a real weak key.
+	n := make([]byte, 1024/8)
+	_, err := rand.Read(n)
+	require.NoError(t, err)
+
+	n[0] |= 0x80
+
+	k := &jwk{
+		Kty: "RSA",
+		N:   b64.EncodeToString(n),
+		E:   b64.EncodeToString(big.NewInt(65537).Bytes()),
+	}
+
+	_, err = k.publicKey()
+	testutil.AssertErrorMsg(t, "rsa key of 1024 bits is too small", err)
+
+	n = make([]byte, oidcMinRSABits/8)
+	_, err = rand.Read(n)
+	require.NoError(t, err)
+
+	n[0] |= 0x80
+	k.N = b64.EncodeToString(n)
+
+	_, err = k.publicKey()
+	require.NoError(t, err)
+}
+
+func TestOIDCRole(t *testing.T) {
+	conf := &oidcConfig{
+		RoleMapping: map[string]userRole{
+			"admins": roleAdmin,
+			"empty":  "",
+			"staff":  roleReadOnly,
+		},
+		DefaultRole: "",
+	}
+
+	testCases := []struct {
+		claim any
+		name  string
+		want  userRole
+	}{{
+		claim: "admins",
+		name:  "mapped",
+		want:  roleAdmin,
+	}, {
+		claim: "empty",
+		name:  "empty_mapped",
+		want:  "",
+	}, {
+		claim: []any{"empty", "staff"},
+		name:  "empty_and_mapped",
+		want:  roleReadOnly,
+	}, {
+		claim: "guests",
+		name:  "default",
+		want:  "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, oidcRole(conf, tc.claim))
+		})
+	}
+}
+
+func TestOIDCConfig_validate(t *testing.T) {
+	conf := &oidcConfig{
+		RoleMapping: map[string]userRole{
+			"empty": "",
+		},
+		Issuer:      "https://idp.example",
+		ClientID:    "agh",
+		RedirectURL: "https://agh.example/control/login/oidc/callback",
+		Enabled:     true,
+	}
+
+	testutil.AssertErrorMsg(t, `oidc: role_mapping: bad role "" for "empty"`, conf.validate())
+
+	conf.RoleMapping = map[string]userRole{"admins": roleAdmin}
+	assert.NoError(t, conf.validate())
+}
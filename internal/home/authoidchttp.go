@@ -0,0 +1,231 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+)
+
+// oidcStatusJSON is the response for the GET /control/login/oidc/status HTTP
+// API.
+type oidcStatusJSON struct {
+	// Enabled is true if the OpenID Connect login is enabled.
+	Enabled bool `json:"enabled"`
+}
+
+// handleOIDCStatus is the handler for the GET /control/login/oidc/status HTTP
+// API.  It's used by the login page to show the single sign-on button.
+func handleOIDCStatus(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &oidcStatusJSON{
+		Enabled: Context.auth != nil && Context.auth.oidc != nil,
+	})
+}
+
+// handleOIDCLogin is the handler for the GET /control/login/oidc HTTP API.  It
+// redirects the user to the identity provider.
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	p := Context.auth.oidc
+	if p == nil {
+		aghhttp.Error(r, w, http.StatusNotFound, "oidc login is disabled")
+
+		return
+	}
+
+	u, err := p.authCodeURL(r.Context())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadGateway, "oidc: %s", err)
+
+		return
+	}
+
+	w.Header().Set(httphdr.CacheControl, "no-store")
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// handleOIDCCallback is the handler for the GET /control/login/oidc/callback
+// HTTP API.  The identity provider redirects the user here after the login.
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	p := Context.auth.oidc
+	if p == nil {
+		aghhttp.Error(r, w, http.StatusNotFound, "oidc login is disabled")
+
+		return
+	}
+
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		writeErrorWithIP(
+			r,
+			w,
+			http.StatusForbidden,
+			r.RemoteAddr,
+			"oidc: identity provider error %q: %s",
+			errCode,
+			q.Get("error_description"),
+		)
+
+		return
+	}
+
+	claims, err := p.exchange(r.Context(), q.Get("state"), q.Get("code"))
+	if err != nil {
+		writeErrorWithIP(r, w, http.StatusForbidden, r.RemoteAddr, "oidc: %s", err)
+
+		return
+	}
+
+	u, err := Context.auth.oidcLogin(p.conf, claims)
+	if err != nil {
+		writeErrorWithIP(r, w, http.StatusForbidden, r.RemoteAddr, "oidc: %s", err)
+
+		return
+	}
+
+	if u.TOTPSecret != "" {
+		var ticket string
+		ticket, err = p.startOTP(u.Name)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusInternalServerError, "oidc: %s", err)
+
+			return
+		}
+
+		log.Debug("auth: user %q logged in using oidc, waiting for second factor", u.Name)
+
+		w.Header().Set(httphdr.CacheControl, "no-store")
+		http.Redirect(w, r, "/login.html?"+url.Values{"oidc_ticket": {ticket}}.Encode(), http.StatusFound)
+
+		return
+	}
+
+	cookie, err := Context.auth.newSessionCookie(u.Name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "oidc: %s", err)
+
+		return
+	}
+
+	log.Info("auth: user %q successfully logged in using oidc from ip %s", u.Name, r.RemoteAddr)
+
+	http.SetCookie(w, cookie)
+	w.Header().Set(httphdr.CacheControl, "no-store")
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// oidcOTPJSON is the request for the POST /control/login/oidc/otp HTTP API.
+type oidcOTPJSON struct {
+	// Ticket is the value of the oidc_ticket query parameter of the login
+	// page the user has been redirected to.
+	Ticket string `json:"ticket"`
+
+	// OTP is the TOTP code or a recovery code of the user.
+	OTP string `json:"otp"`
+}
+
+// handleOIDCOTP is the handler for the POST /control/login/oidc/otp HTTP API.
+// It completes the login of a local user with the two-factor authentication
+// enabled linked to an identity provider user.
+func handleOIDCOTP(w http.ResponseWriter, r *http.Request) {
+	p := Context.auth.oidc
+	if p == nil {
+		aghhttp.Error(r, w, http.StatusNotFound, "oidc login is disabled")
+
+		return
+	}
+
+	req := &oidcOTPJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json decode: %s", err)
+
+		return
+	}
+
+	remoteIP, err := netutil.SplitHost(r.RemoteAddr)
+	if err != nil {
+		writeErrorWithIP(r, w, http.StatusBadRequest, r.RemoteAddr, "oidc: %s", err)
+
+		return
+	}
+
+	rateLimiter := Context.auth.rateLimiter
+	if rateLimiter != nil {
+		if left := rateLimiter.check(remoteIP); left > 0 {
+			w.Header().Set(httphdr.RetryAfter, strconv.Itoa(int(left.Seconds())))
+			writeErrorWithIP(r, w, http.StatusTooManyRequests, remoteIP, "auth: blocked for %s", left)
+
+			return
+		}
+	}
+
+	name, ok := p.finishOTP(req.Ticket, false)
+	if !ok {
+		writeErrorWithIP(r, w, http.StatusForbidden, remoteIP, "oidc: unknown or expired ticket")
+
+		return
+	}
+
+	recoveryUsed, err := Context.auth.checkSecondFactor(name, req.OTP)
+	if err != nil {
+		if rateLimiter != nil {
+			rateLimiter.inc(remoteIP)
+		}
+
+		writeErrorWithIP(r, w, http.StatusForbidden, remoteIP, "oidc: %s", err)
+
+		return
+	}
+
+	if recoveryUsed {
+		onConfigModified()
+	}
+
+	// Remove the ticket only after a successful check, so that the user could
+	// retry a mistyped code, which is limited by the rate limiter above.
+	p.finishOTP(req.Ticket, true)
+
+	if rateLimiter != nil {
+		rateLimiter.remove(remoteIP)
+	}
+
+	cookie, err := Context.auth.newSessionCookie(name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "oidc: %s", err)
+
+		return
+	}
+
+	log.Info("auth: user %q successfully logged in using oidc from ip %s", name, remoteIP)
+
+	http.SetCookie(w, cookie)
+	w.Header().Set(httphdr.CacheControl, "no-store")
+
+	aghhttp.OK(w)
+}
+
+// registerOIDCHandlers registers the HTTP handlers for the OpenID Connect
+// login.  These handlers don't require authentication.
+func registerOIDCHandlers() {
+	Context.mux.Handle(
+		"/control/login/oidc",
+		postInstallHandler(ensureHandler(http.MethodGet, handleOIDCLogin)),
+	)
+	Context.mux.Handle(
+		"/control/login/oidc/status",
+		postInstallHandler(ensureHandler(http.MethodGet, handleOIDCStatus)),
+	)
+	Context.mux.Handle(
+		"/control/login/oidc/callback",
+		postInstallHandler(ensureHandler(http.MethodGet, handleOIDCCallback)),
+	)
+	Context.mux.Handle(
+		"/control/login/oidc/otp",
+		postInstallHandler(ensureHandler(http.MethodPost, handleOIDCOTP)),
+	)
+}
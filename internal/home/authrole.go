@@ -0,0 +1,182 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+)
+
+// userRole is the access level of a Web UI user.
+type userRole string
+
+// Allowed [userRole] values.  An empty role is considered to be [roleAdmin] to
+// keep the users from the configuration files of the previous versions fully
+// privileged.
+const (
+	// roleReadOnly allows to view the settings, the statistics, and the query
+	// log, but not to change anything except the user's own profile.
+	roleReadOnly userRole = "read_only"
+
+	// roleOperator allows everything [roleReadOnly] allows as well as the
+	// day-to-day actions listed in [operatorRoutes].
+	roleOperator userRole = "operator"
+
+	// roleAdmin allows everything.
+	roleAdmin userRole = "admin"
+)
+
+// UnmarshalText implements [encoding.TextUnmarshaler] interface for *userRole.
+func (r *userRole) UnmarshalText(b []byte) (err error) {
+	switch role := userRole(b); role {
+	case "", roleReadOnly, roleOperator, roleAdmin:
+		*r = role
+	default:
+		return fmt.Errorf(
+			"invalid role %q, supported: %q, %q, %q",
+			b,
+			roleReadOnly,
+			roleOperator,
+			roleAdmin,
+		)
+	}
+
+	return nil
+}
+
+// isValid returns true if r is one of the non-empty roles.
+func (r userRole) isValid() (ok bool) {
+	return r == roleReadOnly || r == roleOperator || r == roleAdmin
+}
+
+// level returns the numeric privilege level of r.  The greater the level, the
+// more privileges the role has.
+func (r userRole) level() (l uint8) {
+	switch r {
+	case roleReadOnly:
+		return 1
+	case roleOperator:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// effective returns the role with the empty value replaced with [roleAdmin].
+func (r userRole) effective() (eff userRole) {
+	if r == "" {
+		return roleAdmin
+	}
+
+	return r
+}
+
+// permits returns true if r has all the privileges of required.
+func (r userRole) permits(required userRole) (ok bool) {
+	return r.level() >= required.level()
+}
+
+// operatorRoutes are the paths of the data-modifying HTTP API handlers which
+// users with [roleOperator] are allowed to call.
+var operatorRoutes = container.NewMapSet(
+	"/control/cache_clear",
+	"/control/cache_evict",
+	"/control/filtering/overrides/add",
+	"/control/filtering/overrides/delete",
+	"/control/filtering/preview",
+	"/control/filtering/refresh",
+	"/control/filtering/set_rules",
+	"/control/protection",
+)
+
+// selfServiceRoutes are the paths of the data-modifying HTTP API handlers
+// which any authenticated user is allowed to call.
+var selfServiceRoutes = container.NewMapSet(
+	"/control/profile/totp/confirm",
+	"/control/profile/totp/disable",
+	"/control/profile/totp/enroll",
+	"/control/profile/update",
+)
+
+// adminRoutes are the paths of the HTTP API handlers which only users with
+// [roleAdmin] are allowed to call, regardless of the method.
+var adminRoutes = container.NewMapSet(
+	"/control/audit",
+	"/control/tokens",
+	"/control/tokens/add",
+	"/control/tokens/delete",
+)
+
+// routeRole returns the minimum role required to call the HTTP API handler for
+// method and path.
+func routeRole(method, path string) (required userRole) {
+	switch {
+	case adminRoutes.Has(path):
+		return roleAdmin
+	case !modifiesRoute(method, path), selfServiceRoutes.Has(path):
+		return roleReadOnly
+	case operatorRoutes.Has(path):
+		return roleOperator
+	default:
+		return roleAdmin
+	}
+}
+
+// currentRole returns the role of the user making r.  The authentication must
+// be required.
+func currentRole(r *http.Request) (role userRole) {
+	if glProcessCookie(r) {
+		return roleAdmin
+	}
+
+	u := Context.auth.getCurrentUser(r)
+	if u.Name == "" {
+		// Shouldn't happen, since the authentication is checked before.
+		return roleReadOnly
+	}
+
+	return u.Role.effective()
+}
+
+// ensureRole returns a wrapped handler that makes sure that the current user
+// has at least the role required to call the handler for method and path.
+// Requests made with an API token are checked against the token's scopes
+// instead.
+func ensureRole(method, path string, handler http.HandlerFunc) (wrapped http.HandlerFunc) {
+	required := routeRole(method, path)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if Context.auth == nil || !Context.auth.authRequired() {
+			handler(w, r)
+
+			return
+		}
+
+		if token, ok := bearerToken(r); ok {
+			t := Context.auth.findToken(token)
+			if t == nil || !t.permits(method, path) {
+				auditDenied(r, method, path)
+				aghhttp.Error(r, w, http.StatusForbidden, "api token is not permitted")
+
+				return
+			}
+
+			handler(w, r)
+
+			return
+		}
+
+		role := currentRole(r)
+		if !role.permits(required) {
+			log.Debug("auth: raddr %s: role %q is not permitted to %s", r.RemoteAddr, role, r.URL)
+			auditDenied(r, method, path)
+			aghhttp.Error(r, w, http.StatusForbidden, "role %q is not permitted", role)
+
+			return
+		}
+
+		handler(w, r)
+	}
+}
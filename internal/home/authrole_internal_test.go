@@ -0,0 +1,119 @@
+package home
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRole_UnmarshalText(t *testing.T) {
+	testCases := []struct {
+		name       string
+		in         string
+		wantErrMsg string
+		want       userRole
+	}{{
+		name:       "empty",
+		in:         "",
+		wantErrMsg: "",
+		want:       "",
+	}, {
+		name:       "read_only",
+		in:         "read_only",
+		wantErrMsg: "",
+		want:       roleReadOnly,
+	}, {
+		name:       "operator",
+		in:         "operator",
+		wantErrMsg: "",
+		want:       roleOperator,
+	}, {
+		name:       "admin",
+		in:         "admin",
+		wantErrMsg: "",
+		want:       roleAdmin,
+	}, {
+		name: "bad",
+		in:   "root",
+		wantErrMsg: `invalid role "root", supported: "read_only", "operator", ` +
+			`"admin"`,
+		want: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var r userRole
+			err := r.UnmarshalText([]byte(tc.in))
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.want, r)
+		})
+	}
+}
+
+func TestRouteRole(t *testing.T) {
+	testCases := []struct {
+		role   userRole
+		name   string
+		method string
+		path   string
+		want   bool
+	}{{
+		role:   roleReadOnly,
+		name:   "read_only_querylog",
+		method: http.MethodGet,
+		path:   "/control/querylog",
+		want:   true,
+	}, {
+		role:   roleReadOnly,
+		name:   "read_only_profile",
+		method: http.MethodPut,
+		path:   "/control/profile/update",
+		want:   true,
+	}, {
+		role:   roleReadOnly,
+		name:   "read_only_protection",
+		method: http.MethodPost,
+		path:   "/control/protection",
+		want:   false,
+	}, {
+		role:   roleReadOnly,
+		name:   "read_only_version",
+		method: http.MethodPost,
+		path:   "/control/version.json",
+		want:   true,
+	}, {
+		role:   roleOperator,
+		name:   "operator_protection",
+		method: http.MethodPost,
+		path:   "/control/protection",
+		want:   true,
+	}, {
+		role:   roleOperator,
+		name:   "operator_dns_config",
+		method: http.MethodPost,
+		path:   "/control/dns_config",
+		want:   false,
+	}, {
+		role:   roleAdmin,
+		name:   "admin_dns_config",
+		method: http.MethodPost,
+		path:   "/control/dns_config",
+		want:   true,
+	}, {
+		role:   "",
+		name:   "empty_dns_config",
+		method: http.MethodPost,
+		path:   "/control/dns_config",
+		want:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			required := routeRole(tc.method, tc.path)
+			assert.Equal(t, tc.want, tc.role.permits(required))
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package home
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"go.etcd.io/bbolt"
+)
+
+// apiTokenSize is the length of an API token in bytes.
+const apiTokenSize = 32
+
+// apiTokenLastUsedIvl is the minimum interval between two updates of the
+// last-used time of an API token in the database.
+const apiTokenLastUsedIvl = 1 * time.Minute
+
+// apiTokenUserPrefix is the prefix of the name of the pseudo-user making
+// requests with an API token.
+const apiTokenUserPrefix = "token:"
+
+// apiScope is a permission granted to an API token.  It has the form
+// "<area>:<access>", where access is either "read" or "write".
+type apiScope string
+
+// API scope access levels.
+const (
+	scopeAccessRead  = "read"
+	scopeAccessWrite = "write"
+)
+
+// API scope areas.  Each area covers a group of HTTP API handlers, see
+// [routeArea].
+const (
+	scopeAreaClients   = "clients"
+	scopeAreaDHCP      = "dhcp"
+	scopeAreaDNS       = "dns"
+	scopeAreaFiltering = "filtering"
+	scopeAreaQueryLog  = "querylog"
+	scopeAreaSettings  = "settings"
+	scopeAreaStats     = "stats"
+)
+
+// scopeAreas are all the valid API scope areas.
+var scopeAreas = []string{
+	scopeAreaClients,
+	scopeAreaDHCP,
+	scopeAreaDNS,
+	scopeAreaFiltering,
+	scopeAreaQueryLog,
+	scopeAreaSettings,
+	scopeAreaStats,
+}
+
+// routeAreaPrefixes are the path prefixes of the HTTP API handlers and the
+// scope areas they belong to.  The paths not matched by any of the prefixes
+// can't be called with an API token.
+var routeAreaPrefixes = []struct {
+	prefix string
+	area   string
+}{{
+	prefix: "/control/clients",
+	area:   scopeAreaClients,
+}, {
+	prefix: "/control/dhcp/",
+	area:   scopeAreaDHCP,
+}, {
+	prefix: "/control/access/",
+	area:   scopeAreaDNS,
+}, {
+	prefix: "/control/cache_",
+	area:   scopeAreaDNS,
+}, {
+	prefix: "/control/dns_",
+	area:   scopeAreaDNS,
+}, {
+	prefix: "/control/protection",
+	area:   scopeAreaDNS,
+}, {
+	prefix: "/control/test_upstream_dns",
+	area:   scopeAreaDNS,
+}, {
+	prefix: "/control/upstreams/",
+	area:   scopeAreaDNS,
+}, {
+	prefix: "/control/blocked_services/",
+	area:   scopeAreaFiltering,
+}, {
+	prefix: "/control/filtering/",
+	area:   scopeAreaFiltering,
+}, {
+	prefix: "/control/parental/",
+	area:   scopeAreaFiltering,
+}, {
+	prefix: "/control/rewrite/",
+	area:   scopeAreaFiltering,
+}, {
+	prefix: "/control/safebrowsing/",
+	area:   scopeAreaFiltering,
+}, {
+	prefix: "/control/safesearch/",
+	area:   scopeAreaFiltering,
+}, {
+	prefix: "/control/querylog",
+	area:   scopeAreaQueryLog,
+}, {
+	prefix: "/control/stats",
+	area:   scopeAreaStats,
+}, {
+	prefix: "/metrics",
+	area:   scopeAreaStats,
+}, {
+	prefix: "/control/status",
+	area:   scopeAreaSettings,
+}, {
+	prefix: "/control/tls/",
+	area:   scopeAreaSettings,
+}, {
+	prefix: "/control/version.json",
+	area:   scopeAreaSettings,
+}}
+
+// routeArea returns the scope area of the HTTP API handler for path.  area is
+// empty if the handler can't be called with an API token at all, which is the
+// case for the handlers in [adminRoutes] and the ones not belonging to any
+// area, like /control/update.
+func routeArea(path string) (area string) {
+	if adminRoutes.Has(path) {
+		return ""
+	}
+
+	for _, p := range routeAreaPrefixes {
+		if strings.HasPrefix(path, p.prefix) {
+			return p.area
+		}
+	}
+
+	return ""
+}
+
+// validate returns an error if s is not a valid API scope.
+func (s apiScope) validate() (err error) {
+	area, access, ok := strings.Cut(string(s), ":")
+	if !ok || !slices.Contains(scopeAreas, area) {
+		return fmt.Errorf("invalid scope %q", s)
+	}
+
+	if access != scopeAccessRead && access != scopeAccessWrite {
+		return fmt.Errorf("invalid scope %q: bad access %q", s, access)
+	}
+
+	return nil
+}
+
+// apiToken is a long-lived token to access the HTTP API without a session.
+// Only the SHA-256 hash of the token itself is stored.
+type apiToken struct {
+	// CreatedAt is the time when the token has been created.
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is the time after which the token is no longer accepted.
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// LastUsedAt is the time when the token has been used for the last time.
+	// It's zero if the token has never been used.
+	LastUsedAt time.Time `json:"last_used_at"`
+
+	// Name is the unique name of the token.
+	Name string `json:"name"`
+
+	// Creator is the name of the user who has created the token.
+	Creator string `json:"creator"`
+
+	// Hash is the hex-encoded SHA-256 hash of the token.
+	Hash string `json:"hash"`
+
+	// Scopes are the permissions granted to the token.
+	Scopes []apiScope `json:"scopes"`
+}
+
+// permits returns true if t is allowed to call the HTTP API handler for method
+// and path.
+func (t *apiToken) permits(method, path string) (ok bool) {
+	area := routeArea(path)
+	if area == "" {
+		return false
+	}
+
+	for _, s := range t.Scopes {
+		sArea, access, _ := strings.Cut(string(s), ":")
+		if sArea != area {
+			continue
+		}
+
+		if access == scopeAccessWrite || !modifiesRoute(method, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clone returns a deep copy of t.
+func (t *apiToken) clone() (c *apiToken) {
+	c = &apiToken{}
+	*c = *t
+	c.Scopes = slices.Clone(t.Scopes)
+
+	return c
+}
+
+// userName returns the name of the pseudo-user making requests with t.
+func (t *apiToken) userName() (name string) {
+	return apiTokenUserPrefix + t.Name
+}
+
+// hashAPIToken returns the hex-encoded SHA-256 hash of the token.
+func hashAPIToken(token string) (hash string) {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the API token from the Authorization header of r, if
+// there is one.
+func bearerToken(r *http.Request) (token string, ok bool) {
+	const prefix = "Bearer "
+
+	v := r.Header.Get(httphdr.Authorization)
+	if len(v) < len(prefix) || !strings.EqualFold(v[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(v[len(prefix):]), true
+}
+
+// tokensBucketName returns the name of the database bucket with API tokens.
+func tokensBucketName() []byte {
+	return []byte("tokens")
+}
+
+// loadTokens loads API tokens from the database file.
+func (a *Auth) loadTokens() {
+	err := a.db.View(func(tx *bbolt.Tx) (viewErr error) {
+		bkt := tx.Bucket(tokensBucketName())
+		if bkt == nil {
+			return nil
+		}
+
+		return bkt.ForEach(func(_, v []byte) (decErr error) {
+			t := &apiToken{}
+			decErr = json.Unmarshal(v, t)
+			if decErr != nil {
+				log.Error("auth: decoding api token: %s", decErr)
+
+				return nil
+			}
+
+			a.tokens[t.Hash] = t
+
+			return nil
+		})
+	})
+	if err != nil {
+		log.Error("auth: loading api tokens: %s", err)
+	}
+
+	log.Debug("auth: loaded %d api tokens from DB", len(a.tokens))
+}
+
+// storeToken saves an API token in the database file.
+func (a *Auth) storeToken(t *apiToken) (err error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("encoding token: %w", err)
+	}
+
+	return a.db.Update(func(tx *bbolt.Tx) (updErr error) {
+		bkt, updErr := tx.CreateBucketIfNotExists(tokensBucketName())
+		if updErr != nil {
+			return fmt.Errorf("creating bucket: %w", updErr)
+		}
+
+		return bkt.Put([]byte(t.Hash), data)
+	})
+}
+
+// addToken creates a new API token and returns it along with its secret
+// value, which is not stored anywhere.
+func (a *Auth) addToken(
+	name string,
+	creator string,
+	expiresAt time.Time,
+	scopes []apiScope,
+) (t *apiToken, token string, err error) {
+	data := make([]byte, apiTokenSize)
+	_, err = rand.Read(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("generating token: %w", err)
+	}
+
+	token = hex.EncodeToString(data)
+	t = &apiToken{
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+		Name:      name,
+		Creator:   creator,
+		Hash:      hashAPIToken(token),
+		Scopes:    slices.Clone(scopes),
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, existing := range a.tokens {
+		if existing.Name == name {
+			return nil, "", fmt.Errorf("token %q already exists", name)
+		}
+	}
+
+	err = a.storeToken(t)
+	if err != nil {
+		return nil, "", fmt.Errorf("storing token: %w", err)
+	}
+
+	a.tokens[t.Hash] = t
+
+	log.Debug("auth: added api token %q", name)
+
+	return t.clone(), token, nil
+}
+
+// removeToken revokes the API token with the given name.
+func (a *Auth) removeToken(name string) (err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for hash, t := range a.tokens {
+		if t.Name != name {
+			continue
+		}
+
+		err = a.db.Update(func(tx *bbolt.Tx) (updErr error) {
+			bkt := tx.Bucket(tokensBucketName())
+			if bkt == nil {
+				return nil
+			}
+
+			return bkt.Delete([]byte(hash))
+		})
+		if err != nil {
+			return fmt.Errorf("removing token: %w", err)
+		}
+
+		delete(a.tokens, hash)
+
+		log.Debug("auth: removed api token %q", name)
+
+		return nil
+	}
+
+	return errors.Error("no such token")
+}
+
+// findToken returns a copy of the API token if it exists and hasn't expired.
+// It also updates the time when the token was last used.
+func (a *Auth) findToken(token string) (t *apiToken) {
+	hash := hashAPIToken(token)
+	now := time.Now().UTC()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	t, ok := a.tokens[hash]
+	if !ok || !now.Before(t.ExpiresAt) {
+		return nil
+	}
+
+	// Don't write into the database on each request.
+	if now.Sub(t.LastUsedAt) >= apiTokenLastUsedIvl {
+		t.LastUsedAt = now
+		err := a.storeToken(t)
+		if err != nil {
+			log.Error("auth: updating api token %q: %s", t.Name, err)
+		}
+	}
+
+	return t.clone()
+}
+
+// tokensList returns copies of all API tokens sorted by name.
+func (a *Auth) tokensList() (tokens []*apiToken) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	tokens = make([]*apiToken, 0, len(a.tokens))
+	for _, t := range a.tokens {
+		tokens = append(tokens, t.clone())
+	}
+
+	slices.SortFunc(tokens, func(a, b *apiToken) (res int) {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return tokens
+}
@@ -0,0 +1,186 @@
+package home
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth_tokens(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "sessions.db")
+
+	a := InitAuth(fn, nil, 60, nil, nil)
+	require.NotNil(t, a)
+
+	scopes := []apiScope{"querylog:read", "filtering:write"}
+	tok, secret, err := a.addToken("ci", "admin", time.Now().Add(time.Hour), scopes)
+	require.NoError(t, err)
+	require.NotEmpty(t, secret)
+
+	assert.Equal(t, "ci", tok.Name)
+	assert.Equal(t, "admin", tok.Creator)
+
+	_, _, err = a.addToken("ci", "admin", time.Now().Add(time.Hour), scopes)
+	assert.Error(t, err)
+
+	assert.Nil(t, a.findToken("bad"))
+
+	found := a.findToken(secret)
+	require.NotNil(t, found)
+
+	assert.Equal(t, tok.Name, found.Name)
+	assert.False(t, found.LastUsedAt.IsZero())
+
+	a.Close()
+
+	// Load the saved token.
+	a = InitAuth(fn, nil, 60, nil, nil)
+	require.NotNil(t, a)
+	t.Cleanup(a.Close)
+
+	require.NotNil(t, a.findToken(secret))
+	require.Len(t, a.tokensList(), 1)
+
+	_, expSecret, err := a.addToken("old", "admin", time.Now().Add(-time.Hour), scopes)
+	require.NoError(t, err)
+
+	assert.Nil(t, a.findToken(expSecret))
+
+	require.NoError(t, a.removeToken("ci"))
+	assert.Error(t, a.removeToken("ci"))
+	assert.Nil(t, a.findToken(secret))
+}
+
+func TestAPIToken_permits(t *testing.T) {
+	tok := &apiToken{
+		Scopes: []apiScope{"querylog:read", "filtering:write"},
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		want   bool
+	}{{
+		name:   "querylog_read",
+		method: http.MethodGet,
+		path:   "/control/querylog",
+		want:   true,
+	}, {
+		name:   "querylog_write",
+		method: http.MethodPost,
+		path:   "/control/querylog_clear",
+		want:   false,
+	}, {
+		name:   "filtering_read",
+		method: http.MethodGet,
+		path:   "/control/filtering/status",
+		want:   true,
+	}, {
+		name:   "filtering_write",
+		method: http.MethodPost,
+		path:   "/control/filtering/set_rules",
+		want:   true,
+	}, {
+		name:   "clients",
+		method: http.MethodGet,
+		path:   "/control/clients",
+		want:   false,
+	}, {
+		name:   "tokens",
+		method: http.MethodGet,
+		path:   "/control/tokens",
+		want:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tok.permits(tc.method, tc.path))
+		})
+	}
+
+	settingsTok := &apiToken{
+		Scopes: []apiScope{"settings:read", "settings:write"},
+	}
+
+	settingsTestCases := []struct {
+		name   string
+		method string
+		path   string
+		want   bool
+	}{{
+		name:   "status",
+		method: http.MethodGet,
+		path:   "/control/status",
+		want:   true,
+	}, {
+		name:   "tls",
+		method: http.MethodPost,
+		path:   "/control/tls/configure",
+		want:   true,
+	}, {
+		name:   "audit",
+		method: http.MethodGet,
+		path:   "/control/audit",
+		want:   false,
+	}, {
+		name:   "update",
+		method: http.MethodPost,
+		path:   "/control/update",
+		want:   false,
+	}, {
+		name:   "tokens_add",
+		method: http.MethodPost,
+		path:   "/control/tokens/add",
+		want:   false,
+	}, {
+		name:   "unknown",
+		method: http.MethodPost,
+		path:   "/control/unknown",
+		want:   false,
+	}}
+
+	for _, tc := range settingsTestCases {
+		t.Run("settings_"+tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, settingsTok.permits(tc.method, tc.path))
+		})
+	}
+
+	readTok := &apiToken{
+		Scopes: []apiScope{"settings:read"},
+	}
+
+	assert.True(t, readTok.permits(http.MethodPost, "/control/version.json"))
+	assert.False(t, readTok.permits(http.MethodPost, "/control/tls/configure"))
+}
+
+func TestAPIScope_validate(t *testing.T) {
+	assert.NoError(t, apiScope("clients:write").validate())
+	assert.NoError(t, apiScope("querylog:read").validate())
+
+	assert.Error(t, apiScope("clients").validate())
+	assert.Error(t, apiScope("tokens:read").validate())
+	assert.Error(t, apiScope("clients:delete").validate())
+}
+
+func TestBearerToken(t *testing.T) {
+	r := &http.Request{Header: http.Header{}}
+
+	_, ok := bearerToken(r)
+	assert.False(t, ok)
+
+	r.SetBasicAuth("name", "password")
+	_, ok = bearerToken(r)
+	assert.False(t, ok)
+
+	r.Header.Set(httphdr.Authorization, "Bearer abcd")
+	tok, ok := bearerToken(r)
+	require.True(t, ok)
+
+	assert.Equal(t, "abcd", tok)
+}
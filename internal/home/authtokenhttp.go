@@ -0,0 +1,158 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+)
+
+// apiTokenJSON is the JSON representation of an [apiToken].
+type apiTokenJSON struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Name       string     `json:"name"`
+	Creator    string     `json:"creator"`
+	Scopes     []apiScope `json:"scopes"`
+}
+
+// toAPITokenJSON converts t into its JSON representation.
+func toAPITokenJSON(t *apiToken) (tj *apiTokenJSON) {
+	tj = &apiTokenJSON{
+		CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt,
+		Name:      t.Name,
+		Creator:   t.Creator,
+		Scopes:    t.Scopes,
+	}
+
+	if !t.LastUsedAt.IsZero() {
+		lastUsed := t.LastUsedAt
+		tj.LastUsedAt = &lastUsed
+	}
+
+	return tj
+}
+
+// apiTokenListJSON is the response for the GET /control/tokens HTTP API.
+type apiTokenListJSON struct {
+	Tokens []*apiTokenJSON `json:"tokens"`
+}
+
+// handleTokensList is the handler for the GET /control/tokens HTTP API.
+func handleTokensList(w http.ResponseWriter, r *http.Request) {
+	tokens := Context.auth.tokensList()
+
+	resp := &apiTokenListJSON{
+		Tokens: make([]*apiTokenJSON, 0, len(tokens)),
+	}
+
+	for _, t := range tokens {
+		resp.Tokens = append(resp.Tokens, toAPITokenJSON(t))
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// apiTokenAddJSON is the request for the POST /control/tokens/add HTTP API.
+type apiTokenAddJSON struct {
+	ExpiresAt time.Time  `json:"expires_at"`
+	Name      string     `json:"name"`
+	Scopes    []apiScope `json:"scopes"`
+}
+
+// apiTokenAddRespJSON is the response for the POST /control/tokens/add HTTP
+// API.
+type apiTokenAddRespJSON struct {
+	*apiTokenJSON
+
+	// Token is the secret value of the token.  It's only returned once.
+	Token string `json:"token"`
+}
+
+// handleTokensAdd is the handler for the POST /control/tokens/add HTTP API.
+func handleTokensAdd(w http.ResponseWriter, r *http.Request) {
+	req := &apiTokenAddJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "failed to process request body: %s", err)
+
+		return
+	}
+
+	if req.Name == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "token's name must be non-empty")
+
+		return
+	}
+
+	if !req.ExpiresAt.After(time.Now()) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "expires_at must be in the future")
+
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		aghhttp.Error(r, w, http.StatusBadRequest, "scopes must be non-empty")
+
+		return
+	}
+
+	for _, s := range req.Scopes {
+		err = s.validate()
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+			return
+		}
+	}
+
+	creator := Context.auth.getCurrentUser(r).Name
+	t, token, err := Context.auth.addToken(req.Name, creator, req.ExpiresAt, req.Scopes)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "adding token: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &apiTokenAddRespJSON{
+		apiTokenJSON: toAPITokenJSON(t),
+		Token:        token,
+	})
+}
+
+// apiTokenDeleteJSON is the request for the POST /control/tokens/delete HTTP
+// API.
+type apiTokenDeleteJSON struct {
+	Name string `json:"name"`
+}
+
+// handleTokensDelete is the handler for the POST /control/tokens/delete HTTP
+// API.
+func handleTokensDelete(w http.ResponseWriter, r *http.Request) {
+	req := &apiTokenDeleteJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "failed to process request body: %s", err)
+
+		return
+	}
+
+	err = Context.auth.removeToken(req.Name)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "removing token %q: %s", req.Name, err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
+
+// registerTokensHandlers registers the HTTP handlers for the API tokens.
+func registerTokensHandlers() {
+	httpRegister(http.MethodGet, "/control/tokens", handleTokensList)
+	httpRegister(http.MethodPost, "/control/tokens/add", handleTokensAdd)
+	httpRegister(http.MethodPost, "/control/tokens/delete", handleTokensDelete)
+}
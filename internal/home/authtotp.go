@@ -0,0 +1,354 @@
+package home
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// TOTP parameters.  These are the defaults of RFC 6238, which are the only
+// ones supported by most authenticator applications.
+const (
+	// totpPeriod is the time step of TOTP codes.
+	totpPeriod = 30 * time.Second
+
+	// totpDigits is the number of digits in a TOTP code.
+	totpDigits = 6
+
+	// totpSkew is the number of time steps before and after the current one
+	// for which the codes are also accepted.
+	totpSkew = 1
+
+	// totpSecretSize is the length of a TOTP secret in bytes.
+	totpSecretSize = 20
+
+	// totpIssuer is the issuer shown in authenticator applications.
+	totpIssuer = "AdGuard Home"
+)
+
+// Recovery codes parameters.
+const (
+	// recoveryCodesNum is the number of recovery codes generated at once.
+	recoveryCodesNum = 10
+
+	// recoveryCodeSize is the length of a recovery code in bytes.
+	recoveryCodeSize = 5
+)
+
+// totpModulus is 10 to the power of [totpDigits], by which the truncated HMAC
+// values are reduced to get the codes.
+var totpModulus = func() (m uint32) {
+	m = 1
+	for range totpDigits {
+		m *= 10
+	}
+
+	return m
+}()
+
+// totpEncoding is the encoding of TOTP secrets used in provisioning URIs.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Second factor errors.
+const (
+	// errOTPRequired is returned when the user has two-factor authentication
+	// enabled, but hasn't provided a code.
+	errOTPRequired errors.Error = "two-factor authentication code required"
+
+	// errOTPInvalid is returned when the provided code is neither a valid TOTP
+	// code nor an unused recovery code.
+	errOTPInvalid errors.Error = "invalid two-factor authentication code"
+)
+
+// newTOTPSecret returns a new randomly generated TOTP secret encoded in
+// base32.
+func newTOTPSecret() (secret string, err error) {
+	data := make([]byte, totpSecretSize)
+	_, err = rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(data), nil
+}
+
+// totpCode returns the TOTP code for the given time step as defined by
+// RFC 4226 and RFC 6238.
+func totpCode(key []byte, step uint64) (code string) {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, step)
+
+	mac := hmac.New(sha1.New, key)
+	_, _ = mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, bin%totpModulus)
+}
+
+// totpStep returns the TOTP time step for t.
+func totpStep(t time.Time) (step uint64) {
+	return uint64(t.Unix()) / uint64(totpPeriod/time.Second)
+}
+
+// validateTOTP returns the time step matched by code, if any.  Only the steps
+// after lastStep are checked to prevent replays.
+func validateTOTP(secret, code string, now time.Time, lastStep uint64) (step uint64, ok bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	cur := totpStep(now)
+	for s := cur - totpSkew; s <= cur+totpSkew; s++ {
+		if s <= lastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+// totpProvisioningURI returns the otpauth:// URI for the authenticator
+// applications.
+//
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+func totpProvisioningURI(userName, secret string) (uri string) {
+	u := &url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + totpIssuer + ":" + userName,
+		RawQuery: url.Values{
+			"secret":    []string{secret},
+			"issuer":    []string{totpIssuer},
+			"algorithm": []string{"SHA1"},
+			"digits":    []string{fmt.Sprint(totpDigits)},
+			"period":    []string{fmt.Sprint(int(totpPeriod / time.Second))},
+		}.Encode(),
+	}
+
+	return u.String()
+}
+
+// newRecoveryCodes returns new recovery codes along with their hashes.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for range recoveryCodesNum {
+		data := make([]byte, recoveryCodeSize)
+		_, err = rand.Read(data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		c := strings.ToLower(enc.EncodeToString(data))
+		c = c[:len(c)/2] + "-" + c[len(c)/2:]
+		codes = append(codes, c)
+		hashes = append(hashes, hashRecoveryCode(c))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns the hex-encoded SHA-256 hash of the normalized
+// recovery code.  Recovery codes have enough entropy for a fast hash to be
+// sufficient.
+func hashRecoveryCode(code string) (hash string) {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// userIndex returns the index of the user with the given name in a.users or -1.
+// a.lock is expected to be locked.
+func (a *Auth) userIndex(name string) (idx int) {
+	for i, u := range a.users {
+		if u.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// checkSecondFactor checks the TOTP or recovery code of the user.
+// recoveryUsed is true if a recovery code has been consumed, so the users must
+// be written to the configuration file.
+func (a *Auth) checkSecondFactor(name, code string) (recoveryUsed bool, err error) {
+	if code == "" {
+		return false, errOTPRequired
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	idx := a.userIndex(name)
+	if idx < 0 {
+		return false, errOTPInvalid
+	}
+
+	u := &a.users[idx]
+	step, ok := validateTOTP(u.TOTPSecret, code, time.Now(), a.totpLastSteps[name])
+	if ok {
+		a.totpLastSteps[name] = step
+
+		return false, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, h := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			log.Info("auth: user %q used a recovery code, %d left", name, len(u.RecoveryCodes))
+
+			return true, nil
+		}
+	}
+
+	return false, errOTPInvalid
+}
+
+// totpEnrollment is a pending enrollment into the two-factor authentication.
+type totpEnrollment struct {
+	// secret is the new TOTP secret.
+	secret string
+
+	// replaces is the TOTP secret of the user at the time the enrollment was
+	// started, if any.
+	replaces string
+}
+
+// totpSecret returns the current TOTP secret of the user with the given name.
+// ok is false if there is no such user.
+func (a *Auth) totpSecret(name string) (secret string, ok bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	idx := a.userIndex(name)
+	if idx < 0 {
+		return "", false
+	}
+
+	return a.users[idx].TOTPSecret, true
+}
+
+// startTOTPEnrollment generates a new pending TOTP secret for the user and
+// returns it.  The secret isn't used until the enrollment is confirmed with
+// [Auth.confirmTOTPEnrollment].  If the user already has the two-factor
+// authentication enabled, code must be a valid second factor, and
+// recoveryUsed is true if a recovery code has been consumed.
+func (a *Auth) startTOTPEnrollment(
+	name string,
+	code string,
+) (secret string, recoveryUsed bool, err error) {
+	cur, ok := a.totpSecret(name)
+	if !ok {
+		return "", false, fmt.Errorf("user %q is not configured locally", name)
+	}
+
+	if cur != "" {
+		recoveryUsed, err = a.checkSecondFactor(name, code)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	secret, err = newTOTPSecret()
+	if err != nil {
+		return "", recoveryUsed, fmt.Errorf("generating secret: %w", err)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.totpPending[name] = &totpEnrollment{
+		secret:   secret,
+		replaces: cur,
+	}
+
+	return secret, recoveryUsed, nil
+}
+
+// confirmTOTPEnrollment enables two-factor authentication for the user if the
+// code matches the pending secret.  It returns new recovery codes.  The
+// enrollment is rejected if the second factor of the user has changed since it
+// was started.
+func (a *Auth) confirmTOTPEnrollment(name, code string) (codes []string, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	p, ok := a.totpPending[name]
+	idx := a.userIndex(name)
+	if !ok || idx < 0 {
+		return nil, errors.Error("no pending enrollment")
+	}
+
+	if a.users[idx].TOTPSecret != p.replaces {
+		delete(a.totpPending, name)
+
+		return nil, errors.Error("second factor changed since the enrollment started")
+	}
+
+	step, ok := validateTOTP(p.secret, code, time.Now(), 0)
+	if !ok {
+		return nil, errOTPInvalid
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("generating recovery codes: %w", err)
+	}
+
+	delete(a.totpPending, name)
+	a.totpLastSteps[name] = step
+	a.users[idx].TOTPSecret = p.secret
+	a.users[idx].RecoveryCodes = hashes
+
+	log.Info("auth: enabled two-factor authentication for user %q", name)
+
+	return codes, nil
+}
+
+// disableTOTP disables two-factor authentication for the user if code is a
+// valid second factor.
+func (a *Auth) disableTOTP(name, code string) (err error) {
+	_, err = a.checkSecondFactor(name, code)
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	idx := a.userIndex(name)
+	if idx < 0 {
+		return errOTPInvalid
+	}
+
+	a.users[idx].TOTPSecret = ""
+	a.users[idx].RecoveryCodes = nil
+	delete(a.totpLastSteps, name)
+	delete(a.totpPending, name)
+
+	log.Info("auth: disabled two-factor authentication for user %q", name)
+
+	return nil
+}
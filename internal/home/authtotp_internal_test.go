@@ -0,0 +1,157 @@
+package home
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// See RFC 6238, Appendix B.  The codes are truncated to 6 digits.
+	key := []byte("12345678901234567890")
+
+	testCases := []struct {
+		want string
+		unix int64
+	}{{
+		want: "287082",
+		unix: 59,
+	}, {
+		want: "081804",
+		unix: 1111111109,
+	}, {
+		want: "050471",
+		unix: 1111111111,
+	}, {
+		want: "005924",
+		unix: 1234567890,
+	}, {
+		want: "279037",
+		unix: 2000000000,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			step := totpStep(time.Unix(tc.unix, 0))
+			assert.Equal(t, tc.want, totpCode(key, step))
+		})
+	}
+
+	assert.Equal(t, uint32(1_000_000), totpModulus)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := totpProvisioningURI("name", "SECRET")
+
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/AdGuard Home:name", u.Path)
+	assert.Equal(t, "SECRET", u.Query().Get("secret"))
+}
+
+func TestAuth_secondFactor(t *testing.T) {
+	a := InitAuth(filepath.Join(t.TempDir(), "sessions.db"), []webUser{{
+		Name: "name",
+	}}, 60, nil, nil)
+	require.NotNil(t, a)
+	t.Cleanup(a.Close)
+
+	_, _, err := a.startTOTPEnrollment("unknown", "")
+	require.Error(t, err)
+
+	secret, _, err := a.startTOTPEnrollment("name", "")
+	require.NoError(t, err)
+
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+
+	now := time.Now()
+	_, err = a.confirmTOTPEnrollment("name", "0000000")
+	require.ErrorIs(t, err, errOTPInvalid)
+
+	// Use the previous step for the enrollment to be able to log in with the
+	// current one.
+	codes, err := a.confirmTOTPEnrollment("name", totpCode(key, totpStep(now)-1))
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodesNum)
+
+	u := a.usersList()[0]
+	assert.Equal(t, secret, u.TOTPSecret)
+	assert.Len(t, u.RecoveryCodes, recoveryCodesNum)
+
+	_, err = a.checkSecondFactor("name", "")
+	assert.ErrorIs(t, err, errOTPRequired)
+
+	code := totpCode(key, totpStep(now))
+	used, err := a.checkSecondFactor("name", code)
+	require.NoError(t, err)
+	assert.False(t, used)
+
+	// Replays are not allowed.
+	_, err = a.checkSecondFactor("name", code)
+	assert.ErrorIs(t, err, errOTPInvalid)
+
+	used, err = a.checkSecondFactor("name", codes[0])
+	require.NoError(t, err)
+	assert.True(t, used)
+	assert.Len(t, a.usersList()[0].RecoveryCodes, recoveryCodesNum-1)
+
+	// Recovery codes are one-time.
+	_, err = a.checkSecondFactor("name", codes[0])
+	assert.ErrorIs(t, err, errOTPInvalid)
+
+	// Re-enrolling requires the current second factor.
+	_, _, err = a.startTOTPEnrollment("name", "")
+	assert.ErrorIs(t, err, errOTPRequired)
+
+	_, _, err = a.startTOTPEnrollment("name", "000000")
+	assert.ErrorIs(t, err, errOTPInvalid)
+	assert.Equal(t, secret, a.usersList()[0].TOTPSecret)
+
+	err = a.disableTOTP("name", "000000")
+	assert.ErrorIs(t, err, errOTPInvalid)
+
+	newSecret, used, err := a.startTOTPEnrollment("name", codes[1])
+	require.NoError(t, err)
+	assert.True(t, used)
+	assert.NotEqual(t, secret, newSecret)
+	assert.Equal(t, secret, a.usersList()[0].TOTPSecret)
+
+	require.NoError(t, a.disableTOTP("name", codes[2]))
+
+	u = a.usersList()[0]
+	assert.Empty(t, u.TOTPSecret)
+	assert.Empty(t, u.RecoveryCodes)
+}
+
+func TestAuth_confirmTOTPEnrollment_replaced(t *testing.T) {
+	a := InitAuth(filepath.Join(t.TempDir(), "sessions.db"), []webUser{{
+		Name: "name",
+	}}, 60, nil, nil)
+	require.NotNil(t, a)
+	t.Cleanup(a.Close)
+
+	// Start an enrollment while the two-factor authentication is disabled.
+	stale, _, err := a.startTOTPEnrollment("name", "")
+	require.NoError(t, err)
+
+	staleKey, err := totpEncoding.DecodeString(stale)
+	require.NoError(t, err)
+
+	// Enable it with another secret in the meantime.
+	a.users[0].TOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	now := time.Now()
+	_, err = a.confirmTOTPEnrollment("name", totpCode(staleKey, totpStep(now)))
+	testutil.AssertErrorMsg(t, "second factor changed since the enrollment started", err)
+
+	assert.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", a.usersList()[0].TOTPSecret)
+}
@@ -0,0 +1,122 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+)
+
+// totpEnrollJSON is the response for the POST /control/profile/totp/enroll
+// HTTP API.
+type totpEnrollJSON struct {
+	// Secret is the base32-encoded TOTP secret for manual entry.
+	Secret string `json:"secret"`
+
+	// ProvisioningURI is the otpauth:// URI to be shown as a QR code.
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// totpCodeJSON is the request for the HTTP API methods requiring a TOTP code
+// or a recovery code.
+type totpCodeJSON struct {
+	Code string `json:"code"`
+}
+
+// totpConfirmJSON is the response for the POST /control/profile/totp/confirm
+// HTTP API.
+type totpConfirmJSON struct {
+	// RecoveryCodes are the one-time recovery codes.  They're only returned
+	// once.
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// handleTOTPEnroll is the handler for the POST /control/profile/totp/enroll
+// HTTP API.  If the current user already has the two-factor authentication
+// enabled, the request must contain a valid second factor.
+func handleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	req := &totpCodeJSON{}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+			return
+		}
+	}
+
+	u := Context.auth.getCurrentUser(r)
+	secret, recoveryUsed, err := Context.auth.startTOTPEnrollment(u.Name, req.Code)
+	if recoveryUsed {
+		onConfigModified()
+	}
+
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "enrolling totp: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &totpEnrollJSON{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(u.Name, secret),
+	})
+}
+
+// handleTOTPConfirm is the handler for the POST /control/profile/totp/confirm
+// HTTP API.
+func handleTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	req := &totpCodeJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	u := Context.auth.getCurrentUser(r)
+	codes, err := Context.auth.confirmTOTPEnrollment(u.Name, req.Code)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "confirming totp: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+
+	aghhttp.WriteJSONResponseOK(w, r, &totpConfirmJSON{
+		RecoveryCodes: codes,
+	})
+}
+
+// handleTOTPDisable is the handler for the POST /control/profile/totp/disable
+// HTTP API.
+func handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	req := &totpCodeJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	u := Context.auth.getCurrentUser(r)
+	err = Context.auth.disableTOTP(u.Name, req.Code)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "disabling totp: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+
+	aghhttp.OK(w)
+}
+
+// registerTOTPHandlers registers the HTTP handlers for the two-factor
+// authentication settings of the current user.
+func registerTOTPHandlers() {
+	httpRegister(http.MethodPost, "/control/profile/totp/enroll", handleTOTPEnroll)
+	httpRegister(http.MethodPost, "/control/profile/totp/confirm", handleTOTPConfirm)
+	httpRegister(http.MethodPost, "/control/profile/totp/disable", handleTOTPDisable)
+}
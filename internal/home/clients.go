@@ -20,7 +20,10 @@ import (
 	"github.com/tukimoto/AdGuardHome/internal/dhcpsvc"
 	"github.com/tukimoto/AdGuardHome/internal/dnsforward"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+	"github.com/tukimoto/AdGuardHome/internal/odoh"
 	"github.com/tukimoto/AdGuardHome/internal/querylog"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
 	"github.com/tukimoto/AdGuardHome/internal/schedule"
 	"github.com/tukimoto/AdGuardHome/internal/whois"
 )
@@ -54,6 +57,10 @@ type clientsContainer struct {
 	// settings.
 	clientChecker BlockedClientChecker
 
+	// filterListChecker checks if the filter lists selected for the persistent
+	// clients exist.
+	filterListChecker FilterListChecker
+
 	// etcHosts contains list of rewrite rules taken from the operating system's
 	// hosts database.
 	etcHosts *aghnet.HostsContainer
@@ -87,6 +94,11 @@ type BlockedClientChecker interface {
 	IsBlockedClient(ip netip.Addr, clientID string) (blocked bool, rule string)
 }
 
+// FilterListChecker checks if a filter list exists.
+type FilterListChecker interface {
+	FilterListExists(id rulelist.URLFilterID) (ok bool)
+}
+
 // Init initializes clients container
 // dhcpServer: optional
 // Note: this function must be called only once
@@ -179,12 +191,19 @@ type clientObject struct {
 	// BlockedServices is the configuration of blocked services of a client.
 	BlockedServices *filtering.BlockedServices `yaml:"blocked_services"`
 
+	// Ratelimit is the custom rate limiting policy of the client, if any.
+	Ratelimit *ratelimit.Policy `yaml:"ratelimit,omitempty"`
+
 	Name string `yaml:"name"`
 
 	IDs       []string `yaml:"ids"`
 	Tags      []string `yaml:"tags"`
 	Upstreams []string `yaml:"upstreams"`
 
+	// FilterLists are the IDs of the filter lists applied to the client.  It's
+	// only used if UseOwnFilterLists is true.
+	FilterLists []rulelist.URLFilterID `yaml:"filter_lists,omitempty"`
+
 	// UID is the unique identifier of the persistent client.
 	UID client.UID `yaml:"uid"`
 
@@ -201,6 +220,7 @@ type clientObject struct {
 	ParentalEnabled          bool `yaml:"parental_enabled"`
 	SafeBrowsingEnabled      bool `yaml:"safebrowsing_enabled"`
 	UseGlobalBlockedServices bool `yaml:"use_global_blocked_services"`
+	UseOwnFilterLists        bool `yaml:"use_own_filter_lists"`
 
 	IgnoreQueryLog   bool `yaml:"ignore_querylog"`
 	IgnoreStatistics bool `yaml:"ignore_statistics"`
@@ -213,7 +233,8 @@ func (o *clientObject) toPersistent(
 	cli = &client.Persistent{
 		Name: o.Name,
 
-		Upstreams: o.Upstreams,
+		Upstreams:   o.Upstreams,
+		FilterLists: slices.Clone(o.FilterLists),
 
 		UID: o.UID,
 
@@ -223,10 +244,12 @@ func (o *clientObject) toPersistent(
 		SafeSearchConf:        o.SafeSearchConf,
 		SafeBrowsingEnabled:   o.SafeBrowsingEnabled,
 		UseOwnBlockedServices: !o.UseGlobalBlockedServices,
+		UseOwnFilterLists:     o.UseOwnFilterLists,
 		IgnoreQueryLog:        o.IgnoreQueryLog,
 		IgnoreStatistics:      o.IgnoreStatistics,
 		UpstreamsCacheEnabled: o.UpstreamsCacheEnabled,
 		UpstreamsCacheSize:    o.UpstreamsCacheSize,
+		Ratelimit:             o.Ratelimit.Clone(),
 	}
 
 	err = cli.SetIDs(o.IDs)
@@ -304,11 +327,14 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 			Name: cli.Name,
 
 			BlockedServices: cli.BlockedServices.Clone(),
+			Ratelimit:       cli.Ratelimit.Clone(),
 
 			IDs:       cli.IDs(),
 			Tags:      slices.Clone(cli.Tags),
 			Upstreams: slices.Clone(cli.Upstreams),
 
+			FilterLists: slices.Clone(cli.FilterLists),
+
 			UID: cli.UID,
 
 			UseGlobalSettings:        !cli.UseOwnSettings,
@@ -317,6 +343,7 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 			SafeSearchConf:           cli.SafeSearchConf,
 			SafeBrowsingEnabled:      cli.SafeBrowsingEnabled,
 			UseGlobalBlockedServices: !cli.UseOwnBlockedServices,
+			UseOwnFilterLists:        cli.UseOwnFilterLists,
 			IgnoreQueryLog:           cli.IgnoreQueryLog,
 			IgnoreStatistics:         cli.IgnoreStatistics,
 			UpstreamsCacheEnabled:    cli.UpstreamsCacheEnabled,
@@ -481,7 +508,7 @@ func (clients *clientsContainer) UpstreamConfigByID(
 	}
 
 	var upsConf *proxy.UpstreamConfig
-	upsConf, err = proxy.ParseUpstreamsConfig(
+	upsConf, err = odoh.ParseUpstreamsConfig(
 		upstreams,
 		&upstream.Options{
 			Bootstrap:    bootstrap,
@@ -495,9 +522,15 @@ func (clients *clientsContainer) UpstreamConfigByID(
 		return nil, err
 	}
 
+	if Context.dnsServer != nil {
+		Context.dnsServer.ApplyUpstreamMode(upsConf, config.DNS.UpstreamMode)
+	}
+
+	// The persistent cache replaces the one of the proxy, see
+	// [clientsContainer.CustomCacheByID].
 	conf = proxy.NewCustomUpstreamConfig(
 		upsConf,
-		c.UpstreamsCacheEnabled,
+		c.UpstreamsCacheEnabled && Context.dnsCache == nil,
 		int(c.UpstreamsCacheSize),
 		config.DNS.EDNSClientSubnet.Enabled,
 	)
@@ -506,6 +539,42 @@ func (clients *clientsContainer) UpstreamConfigByID(
 	return conf, nil
 }
 
+// CustomCacheByID implements the [dnsforward.ClientsContainer] interface for
+// *clientsContainer.  cacheID is the name of the client, since it's stable
+// across restarts.
+func (clients *clientsContainer) CustomCacheByID(id string) (cacheID string, size int, ok bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.findLocked(id)
+	if !ok || !c.UpstreamsCacheEnabled {
+		return "", 0, false
+	}
+
+	upstreams := stringutil.FilterOut(c.Upstreams, dnsforward.IsCommentOrEmpty)
+	if len(upstreams) == 0 {
+		return "", 0, false
+	}
+
+	return c.Name, int(c.UpstreamsCacheSize), true
+}
+
+// RatelimitByID implements the [dnsforward.ClientsContainer] interface for
+// *clientsContainer.
+func (clients *clientsContainer) RatelimitByID(
+	id string,
+) (name string, tags []string, p *ratelimit.Policy, ok bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.findLocked(id)
+	if !ok {
+		return "", nil, nil, false
+	}
+
+	return c.Name, slices.Clone(c.Tags), c.Ratelimit.Clone(), true
+}
+
 // findLocked searches for a client by its ID.  clients.lock is expected to be
 // locked.
 func (clients *clientsContainer) findLocked(id string) (c *client.Persistent, ok bool) {
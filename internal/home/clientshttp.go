@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"net/http"
 	"net/netip"
+	"slices"
 
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/tukimoto/AdGuardHome/internal/aghalg"
 	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
 	"github.com/tukimoto/AdGuardHome/internal/client"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
 	"github.com/tukimoto/AdGuardHome/internal/schedule"
 	"github.com/tukimoto/AdGuardHome/internal/whois"
 )
@@ -59,6 +63,16 @@ type clientJSON struct {
 
 	UpstreamsCacheSize    uint32          `json:"upstreams_cache_size"`
 	UpstreamsCacheEnabled aghalg.NullBool `json:"upstreams_cache_enabled"`
+
+	// Ratelimit is the custom rate limiting policy of the client.  It's only
+	// used when RatelimitEnabled is true.
+	Ratelimit        *ratelimit.Policy `json:"ratelimit,omitempty"`
+	RatelimitEnabled aghalg.NullBool   `json:"ratelimit_enabled"`
+
+	// FilterLists are the IDs of the filter lists applied to the client.  It's
+	// only used when UseOwnFilterLists is true.
+	FilterLists       []rulelist.URLFilterID `json:"filter_lists"`
+	UseOwnFilterLists aghalg.NullBool        `json:"use_own_filter_lists"`
 }
 
 // runtimeClientJSON is a JSON representation of the [client.Runtime].
@@ -142,6 +156,9 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		ignoreStatistics bool
 		upsCacheEnabled  bool
 		upsCacheSize     uint32
+		rlPolicy         *ratelimit.Policy
+		filterLists      []rulelist.URLFilterID
+		useOwnFilters    bool
 	)
 
 	if prev != nil {
@@ -150,6 +167,9 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		ignoreStatistics = prev.IgnoreStatistics
 		upsCacheEnabled = prev.UpstreamsCacheEnabled
 		upsCacheSize = prev.UpstreamsCacheSize
+		rlPolicy = prev.Ratelimit.Clone()
+		filterLists = slices.Clone(prev.FilterLists)
+		useOwnFilters = prev.UseOwnFilterLists
 	}
 
 	if cj.IgnoreQueryLog != aghalg.NBNull {
@@ -165,6 +185,24 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		upsCacheSize = cj.UpstreamsCacheSize
 	}
 
+	if cj.UseOwnFilterLists != aghalg.NBNull {
+		useOwnFilters = cj.UseOwnFilterLists == aghalg.NBTrue
+		filterLists = slices.Clone(cj.FilterLists)
+	}
+
+	switch cj.RatelimitEnabled {
+	case aghalg.NBTrue:
+		if cj.Ratelimit == nil {
+			return nil, fmt.Errorf("ratelimit: %w", errors.ErrNoValue)
+		}
+
+		rlPolicy = cj.Ratelimit.Clone()
+	case aghalg.NBFalse:
+		rlPolicy = nil
+	default:
+		// Keep the previous policy, if any.
+	}
+
 	svcs, err := copyBlockedServices(cj.Schedule, cj.BlockedServices, prev)
 	if err != nil {
 		return nil, fmt.Errorf("invalid blocked services: %w", err)
@@ -184,9 +222,24 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		IgnoreStatistics:      ignoreStatistics,
 		UpstreamsCacheEnabled: upsCacheEnabled,
 		UpstreamsCacheSize:    upsCacheSize,
+		Ratelimit:             rlPolicy,
+		FilterLists:           filterLists,
+		UseOwnFilterLists:     useOwnFilters,
 	}, nil
 }
 
+// validateFilterLists returns an error if any of ids isn't the ID of an existing
+// blocklist or allowlist.
+func (clients *clientsContainer) validateFilterLists(ids []rulelist.URLFilterID) (err error) {
+	for _, id := range ids {
+		if !clients.filterListChecker.FilterListExists(id) {
+			return fmt.Errorf("no filter list with id %d", id)
+		}
+	}
+
+	return nil
+}
+
 // jsonToClient converts JSON object to persistent client object if there are no
 // errors.
 func (clients *clientsContainer) jsonToClient(
@@ -199,6 +252,11 @@ func (clients *clientsContainer) jsonToClient(
 		return nil, err
 	}
 
+	err = clients.validateFilterLists(c.FilterLists)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter lists: %w", err)
+	}
+
 	err = c.SetIDs(cj.IDs)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
@@ -317,6 +375,12 @@ func clientToJSON(c *client.Persistent) (cj *clientJSON) {
 
 		UpstreamsCacheSize:    c.UpstreamsCacheSize,
 		UpstreamsCacheEnabled: aghalg.BoolToNullBool(c.UpstreamsCacheEnabled),
+
+		Ratelimit:        c.Ratelimit.Clone(),
+		RatelimitEnabled: aghalg.BoolToNullBool(c.Ratelimit != nil),
+
+		FilterLists:       c.FilterLists,
+		UseOwnFilterLists: aghalg.BoolToNullBool(c.UseOwnFilterLists),
 	}
 }
 
@@ -16,14 +16,20 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/tukimoto/AdGuardHome/internal/client"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
 	"github.com/tukimoto/AdGuardHome/internal/schedule"
 )
 
 const (
 	testClientIP1 = "1.1.1.1"
 	testClientIP2 = "2.2.2.2"
+	testClientIP3 = "3.3.3.3"
 )
 
+// testFilterListID is the ID of the only filter list known to
+// [testFilterListChecker].
+const testFilterListID rulelist.URLFilterID = 1
+
 // testBlockedClientChecker is a mock implementation of the
 // [BlockedClientChecker] interface.
 type testBlockedClientChecker struct {
@@ -42,6 +48,19 @@ func (c *testBlockedClientChecker) IsBlockedClient(
 	return c.onIsBlockedClient(ip, clientID)
 }
 
+// testFilterListChecker is a mock implementation of the [FilterListChecker]
+// interface.
+type testFilterListChecker struct{}
+
+// type check
+var _ FilterListChecker = testFilterListChecker{}
+
+// FilterListExists implements the [FilterListChecker] interface for
+// testFilterListChecker.
+func (testFilterListChecker) FilterListExists(id rulelist.URLFilterID) (ok bool) {
+	return id == testFilterListID
+}
+
 // newPersistentClient is a helper function that returns a persistent client
 // with the specified name and newly generated UID.
 func newPersistentClient(name string) (c *client.Persistent) {
@@ -140,10 +159,19 @@ func assertPersistentClientsData(
 
 func TestClientsContainer_HandleAddClient(t *testing.T) {
 	clients := newClientsContainer(t)
+	clients.filterListChecker = testFilterListChecker{}
 
 	clientOne := newPersistentClientWithIDs(t, "client1", []string{testClientIP1})
 	clientTwo := newPersistentClientWithIDs(t, "client2", []string{testClientIP2})
 
+	clientFilterLists := newPersistentClientWithIDs(t, "client3", []string{testClientIP3})
+	clientFilterLists.UseOwnFilterLists = true
+	clientFilterLists.FilterLists = []rulelist.URLFilterID{testFilterListID}
+
+	clientUnknownList := newPersistentClientWithIDs(t, "client3", []string{testClientIP3})
+	clientUnknownList.UseOwnFilterLists = true
+	clientUnknownList.FilterLists = []rulelist.URLFilterID{testFilterListID, 404}
+
 	clientEmptyID := newPersistentClient("empty_client_id")
 	clientEmptyID.ClientIDs = []string{""}
 
@@ -172,6 +200,16 @@ func TestClientsContainer_HandleAddClient(t *testing.T) {
 		client:     clientEmptyID,
 		wantCode:   http.StatusBadRequest,
 		wantClient: []*client.Persistent{clientOne, clientTwo},
+	}, {
+		name:       "unknown_filter_list",
+		client:     clientUnknownList,
+		wantCode:   http.StatusBadRequest,
+		wantClient: []*client.Persistent{clientOne, clientTwo},
+	}, {
+		name:       "filter_lists",
+		client:     clientFilterLists,
+		wantCode:   http.StatusOK,
+		wantClient: []*client.Persistent{clientOne, clientTwo, clientFilterLists},
 	}}
 
 	for _, tc := range testCases {
@@ -258,6 +296,7 @@ func TestClientsContainer_HandleDelClient(t *testing.T) {
 
 func TestClientsContainer_HandleUpdateClient(t *testing.T) {
 	clients := newClientsContainer(t)
+	clients.filterListChecker = testFilterListChecker{}
 
 	clientOne := newPersistentClientWithIDs(t, "client1", []string{testClientIP1})
 	err := clients.storage.Add(clientOne)
@@ -270,6 +309,10 @@ func TestClientsContainer_HandleUpdateClient(t *testing.T) {
 	clientEmptyID := newPersistentClient("empty_client_id")
 	clientEmptyID.ClientIDs = []string{""}
 
+	clientUnknownList := newPersistentClientWithIDs(t, "client3", []string{testClientIP3})
+	clientUnknownList.UseOwnFilterLists = true
+	clientUnknownList.FilterLists = []rulelist.URLFilterID{404}
+
 	testCases := []struct {
 		name       string
 		clientName string
@@ -306,6 +349,12 @@ func TestClientsContainer_HandleUpdateClient(t *testing.T) {
 		modified:   newPersistentClient("no_ids"),
 		wantCode:   http.StatusBadRequest,
 		wantClient: []*client.Persistent{clientModified},
+	}, {
+		name:       "unknown_filter_list",
+		clientName: clientModified.Name,
+		modified:   clientUnknownList,
+		wantCode:   http.StatusBadRequest,
+		wantClient: []*client.Persistent{clientModified},
 	}}
 
 	for _, tc := range testCases {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/AdguardTeam/dnsproxy/fastip"
 	"github.com/AdguardTeam/golibs/errors"
@@ -16,11 +17,13 @@ import (
 	"github.com/google/renameio/v2/maybe"
 	"github.com/tukimoto/AdGuardHome/internal/aghalg"
 	"github.com/tukimoto/AdGuardHome/internal/aghtls"
+	"github.com/tukimoto/AdGuardHome/internal/authzone"
 	"github.com/tukimoto/AdGuardHome/internal/configmigrate"
 	"github.com/tukimoto/AdGuardHome/internal/dhcpd"
 	"github.com/tukimoto/AdGuardHome/internal/dnsforward"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
 	"github.com/tukimoto/AdGuardHome/internal/querylog"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
 	"github.com/tukimoto/AdGuardHome/internal/schedule"
 	"github.com/tukimoto/AdGuardHome/internal/stats"
 	yaml "gopkg.in/yaml.v3"
@@ -112,6 +115,8 @@ type configuration struct {
 	// AuthBlockMin is the duration, in minutes, of the block of new login
 	// attempts after AuthAttempts unsuccessful login attempts.
 	AuthBlockMin uint `yaml:"block_auth_min"`
+	// OIDC is the configuration of the OpenID Connect login.
+	OIDC *oidcConfig `yaml:"oidc"`
 	// ProxyURL is the address of proxy server for the internal HTTP client.
 	ProxyURL string `yaml:"http_proxy"`
 	// Language is a two-letter ISO 639-1 language code.
@@ -126,6 +131,10 @@ type configuration struct {
 	QueryLog queryLogConfig    `yaml:"querylog"`
 	Stats    statsConfig       `yaml:"statistics"`
 
+	// Audit is the configuration of the audit journal of the configuration
+	// changes.
+	Audit *auditConfig `yaml:"audit"`
+
 	// Filters reflects the filters from [filtering.Config].  It's cloned to the
 	// config used in the filtering module at the startup.  Afterwards it's
 	// cloned from the filtering module back here.
@@ -165,6 +174,9 @@ type httpConfig struct {
 	// Pprof defines the profiling HTTP handler.
 	Pprof *httpPprofConfig `yaml:"pprof"`
 
+	// Metrics defines the Prometheus metrics HTTP handler.
+	Metrics *httpMetricsConfig `yaml:"metrics"`
+
 	// Address is the address to serve the web UI on.
 	Address netip.AddrPort
 
@@ -202,6 +214,21 @@ type dnsConfig struct {
 	// UpstreamTimeout is the timeout for querying upstream servers.
 	UpstreamTimeout timeutil.Duration `yaml:"upstream_timeout"`
 
+	// CachePersistent defines if the DNS cache should be saved into a file
+	// and restored from it on start.
+	CachePersistent bool `yaml:"cache_persistent"`
+
+	// CacheSnapshotInterval is the interval between the periodic snapshots of
+	// the persistent DNS cache.  If zero, the cache is only saved on shutdown.
+	CacheSnapshotInterval timeutil.Duration `yaml:"cache_snapshot_interval"`
+
+	// Dnstap is the configuration of the dnstap output.
+	Dnstap *dnstapConfig `yaml:"dnstap"`
+
+	// LocalZones are the zones answered authoritatively from the local zone
+	// files.
+	LocalZones []*authzone.ZoneConfig `yaml:"local_zones"`
+
 	// PrivateNets is the set of IP networks for which the private reverse DNS
 	// resolver should be used.
 	PrivateNets []netutil.Prefix `yaml:"private_networks"`
@@ -283,6 +310,13 @@ type queryLogConfig struct {
 	// "." is considered to be the root domain.
 	Ignored []string `yaml:"ignored"`
 
+	// Sinks are the external destinations of the query log entries.
+	Sinks []*querylog.SinkConfig `yaml:"sinks"`
+
+	// Storage is the type of the persistent storage of the query log:
+	// "json" or "indexed".
+	Storage string `yaml:"storage"`
+
 	// Interval is the interval for query log's files rotation.
 	Interval timeutil.Duration `yaml:"interval"`
 
@@ -290,6 +324,9 @@ type queryLogConfig struct {
 	// to disk.
 	MemSize uint `yaml:"size_memory"`
 
+	// IndexMaxSizeMB is the maximum size of the indexed storage in megabytes.
+	IndexMaxSizeMB uint64 `yaml:"index_max_size_mb"`
+
 	// Enabled defines if the query log is enabled.
 	Enabled bool `yaml:"enabled"`
 
@@ -324,6 +361,7 @@ const (
 var config = &configuration{
 	AuthAttempts: 5,
 	AuthBlockMin: 15,
+	OIDC:         &oidcConfig{},
 	HTTPConfig: httpConfig{
 		Address:    netip.AddrPortFrom(netip.IPv4Unspecified(), 3000),
 		SessionTTL: timeutil.Duration{Duration: 30 * timeutil.Day},
@@ -331,6 +369,10 @@ var config = &configuration{
 			Enabled: false,
 			Port:    6060,
 		},
+		Metrics: &httpMetricsConfig{
+			Enabled:     false,
+			RequireAuth: true,
+		},
 	},
 	DNS: dnsConfig{
 		BindHosts: []netip.Addr{netip.IPv4Unspecified()},
@@ -351,6 +393,23 @@ var config = &configuration{
 			}, {
 				Prefix: netip.MustParsePrefix("::1/128"),
 			}},
+			UpstreamHealth: dnsforward.UpstreamHealthConfig{
+				ProbeInterval:    timeutil.Duration{Duration: 30 * time.Second},
+				Backoff:          timeutil.Duration{Duration: 30 * time.Second},
+				MaxBackoff:       timeutil.Duration{Duration: 10 * time.Minute},
+				FailureThreshold: 5,
+				Enabled:          true,
+			},
+			ResponseRatelimit: dnsforward.ResponseRatelimitConfig{
+				ResponsesPerSecond: 10,
+				Window:             15,
+				Slip:               2,
+				SubnetLenIPv4:      24,
+				SubnetLenIPv6:      56,
+				TableSize:          ratelimit.DefaultLimiterSize,
+				PlainUDP:           false,
+				Encrypted:          false,
+			},
 			CacheSize: 4 * 1024 * 1024,
 
 			EDNSClientSubnet: &dnsforward.EDNSClientSubnet{
@@ -365,10 +424,11 @@ var config = &configuration{
 			// was later increased to 300 due to https://github.com/tukimoto/AdGuardHome/issues/2257
 			MaxGoroutines: 300,
 		},
-		UpstreamTimeout:  timeutil.Duration{Duration: dnsforward.DefaultTimeout},
-		UsePrivateRDNS:   true,
-		ServePlainDNS:    true,
-		HostsFileEnabled: true,
+		UpstreamTimeout:       timeutil.Duration{Duration: dnsforward.DefaultTimeout},
+		CacheSnapshotInterval: timeutil.Duration{Duration: 1 * time.Hour},
+		UsePrivateRDNS:        true,
+		ServePlainDNS:         true,
+		HostsFileEnabled:      true,
 	},
 	TLS: tlsConfigSettings{
 		PortHTTPS:       defaultPortHTTPS,
@@ -381,12 +441,19 @@ var config = &configuration{
 		Interval:    timeutil.Duration{Duration: 90 * timeutil.Day},
 		MemSize:     1000,
 		Ignored:     []string{},
+		Storage:     querylog.StorageJSON,
+
+		IndexMaxSizeMB: 1024,
 	},
 	Stats: statsConfig{
 		Enabled:  true,
 		Interval: timeutil.Duration{Duration: 1 * timeutil.Day},
 		Ignored:  []string{},
 	},
+	Audit: &auditConfig{
+		Enabled:  false,
+		Interval: timeutil.Duration{Duration: 90 * timeutil.Day},
+	},
 	// NOTE: Keep these parameters in sync with the one put into
 	// client/src/helpers/filters/filters.ts by scripts/vetted-filters.
 	//
@@ -592,6 +659,18 @@ func validateConfig() (err error) {
 		return fmt.Errorf("validating udp ports: %w", err)
 	}
 
+	err = config.OIDC.validate()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	err = config.DNS.UpstreamHealth.Validate()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
 	if !filtering.ValidateUpdateIvl(config.Filtering.FiltersUpdateIntervalHours) {
 		config.Filtering.FiltersUpdateIntervalHours = 24
 	}
@@ -629,6 +708,25 @@ func readConfigFile() (fileData []byte, err error) {
 
 // Saves configuration to the YAML file and also saves the user filter contents to a file
 func (c *configuration) write() (err error) {
+	data, err := c.encode()
+	if err != nil {
+		return err
+	}
+
+	confPath := configFilePath()
+	log.Debug("writing config file %q", confPath)
+
+	err = maybe.WriteFile(confPath, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing config file: %w", err)
+	}
+
+	return nil
+}
+
+// encode updates the configuration from the current state of the modules and
+// returns its YAML representation.
+func (c *configuration) encode() (data []byte, err error) {
 	c.Lock()
 	defer c.Unlock()
 
@@ -688,24 +786,16 @@ func (c *configuration) write() (err error) {
 
 	config.Clients.Persistent = Context.clients.forConfig()
 
-	confPath := configFilePath()
-	log.Debug("writing config file %q", confPath)
-
 	buf := &bytes.Buffer{}
 	enc := yaml.NewEncoder(buf)
 	enc.SetIndent(2)
 
 	err = enc.Encode(config)
 	if err != nil {
-		return fmt.Errorf("generating config file: %w", err)
+		return nil, fmt.Errorf("generating config file: %w", err)
 	}
 
-	err = maybe.WriteFile(confPath, buf.Bytes(), 0o644)
-	if err != nil {
-		return fmt.Errorf("writing config file: %w", err)
-	}
-
-	return nil
+	return buf.Bytes(), nil
 }
 
 // setContextTLSCipherIDs sets the TLS cipher suite IDs to use.
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
@@ -171,10 +172,7 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 // registration of handlers
 // ------------------------
 func registerControlHandlers(web *webAPI) {
-	Context.mux.HandleFunc(
-		"/control/version.json",
-		postInstall(optionalAuth(web.handleVersionJSON)),
-	)
+	httpRegister(http.MethodPost, "/control/version.json", web.handleVersionJSON)
 	httpRegister(http.MethodPost, "/control/update", web.handleUpdate)
 
 	httpRegister(http.MethodGet, "/control/status", handleStatus)
@@ -196,9 +194,23 @@ func httpRegister(method, url string, handler http.HandlerFunc) {
 		return
 	}
 
-	Context.mux.Handle(url, postInstallHandler(optionalAuthHandler(gziphandler.GzipHandler(ensureHandler(method, handler)))))
+	handler = ensureRole(method, url, auditHandler(method, url, handler))
+
+	var h http.Handler = ensureHandler(method, handler)
+	if !streamingRoutes.Has(url) {
+		h = gziphandler.GzipHandler(h)
+	}
+
+	Context.mux.Handle(url, postInstallHandler(optionalAuthHandler(h)))
 }
 
+// streamingRoutes are the paths of the HTTP API handlers which stream their
+// responses.  Their responses aren't compressed, since the compressing handler
+// buffers the response.
+var streamingRoutes = container.NewMapSet(
+	"/control/querylog/stream",
+)
+
 // ensure returns a wrapped handler that makes sure that the request has the
 // correct method as well as additional method and header checks.
 func ensure(
@@ -235,6 +247,18 @@ func modifiesData(m string) (ok bool) {
 	return m == http.MethodPost || m == http.MethodPut || m == http.MethodDelete
 }
 
+// readOnlyPostRoutes are the paths of the HTTP API handlers which use the POST
+// method but don't modify the configuration.
+var readOnlyPostRoutes = container.NewMapSet(
+	"/control/version.json",
+)
+
+// modifiesRoute returns true if the HTTP API handler for method and path may
+// modify the configuration.
+func modifiesRoute(method, path string) (ok bool) {
+	return modifiesData(method) && !readOnlyPostRoutes.Has(path)
+}
+
 // ensureContentType makes sure that the content type of a data-modifying
 // request is set correctly.  If it is not, ensureContentType writes a response
 // to w, and ok is false.
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"net/netip"
 	"net/url"
 	"os"
@@ -20,6 +21,7 @@ import (
 	"github.com/tukimoto/AdGuardHome/internal/client"
 	"github.com/tukimoto/AdGuardHome/internal/dnsforward"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
 	"github.com/tukimoto/AdGuardHome/internal/querylog"
 	"github.com/tukimoto/AdGuardHome/internal/stats"
 	yaml "gopkg.in/yaml.v3"
@@ -60,6 +62,9 @@ func initDNS(l *slog.Logger) (err error) {
 		HTTPRegister:      httpRegister,
 		Enabled:           config.Stats.Enabled,
 		ShouldCountClient: Context.clients.shouldCountClient,
+		FilterListRules: func(id rulelist.URLFilterID) (rules []string, err error) {
+			return Context.filters.FilterListRules(id)
+		},
 	}
 
 	engine, err := aghnet.NewIgnoreEngine(config.Stats.Ignored)
@@ -84,6 +89,9 @@ func initDNS(l *slog.Logger) (err error) {
 		MemSize:           config.QueryLog.MemSize,
 		Enabled:           config.QueryLog.Enabled,
 		FileEnabled:       config.QueryLog.FileEnabled,
+		Sinks:             config.QueryLog.Sinks,
+		Storage:           config.QueryLog.Storage,
+		IndexMaxSize:      config.QueryLog.IndexMaxSizeMB * 1024 * 1024,
 	}
 
 	engine, err = aghnet.NewIgnoreEngine(config.QueryLog.Ignored)
@@ -97,12 +105,48 @@ func initDNS(l *slog.Logger) (err error) {
 		return fmt.Errorf("init querylog: %w", err)
 	}
 
+	config.Filtering.RecentQueries = Context.queryLog.RecentQueries
+	config.Filtering.ApplyClientSettings = applyAdditionalFiltering
+	config.Filtering.RequestUser = func(r *http.Request) (name string) {
+		if Context.auth == nil {
+			return ""
+		}
+
+		return Context.auth.getCurrentUser(r).Name
+	}
+	config.Filtering.PersistentClientExists = func(name string) (ok bool) {
+		_, ok = Context.clients.storage.FindByName(name)
+
+		return ok
+	}
+	config.Filtering.ClientTags = clientTags
+
 	Context.filters, err = filtering.New(config.Filtering, nil)
 	if err != nil {
 		// Don't wrap the error, since it's informative enough as is.
 		return err
 	}
 
+	Context.clients.filterListChecker = Context.filters
+
+	Context.dnstap, err = initDnstap()
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
+	Context.localZones, err = initLocalZones()
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
+	Context.dnsCache, err = initDNSCache()
+	if err != nil {
+		// Don't wrap the error, since it's informative enough as is.
+		return err
+	}
+
 	tlsConf := &tlsConfigSettings{}
 	Context.tls.WriteDiskConfig(tlsConf)
 
@@ -143,6 +187,10 @@ func initDNSServer(
 		Anonymizer:  anonymizer,
 		DHCPServer:  dhcpSrv,
 		EtcHosts:    Context.etcHosts,
+		Metrics:     Context.dnsMetrics,
+		Dnstap:      Context.dnstap,
+		LocalZones:  Context.localZones,
+		Cache:       Context.dnsCache,
 		LocalDomain: config.DHCP.LocalDomainName,
 	})
 	defer func() {
@@ -434,6 +482,13 @@ func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filte
 		}
 	}
 
+	if c.UseOwnFilterLists {
+		setts.FilterLists = c.FilterLists
+		setts.UseFilterLists = true
+	} else {
+		Context.filters.ApplyTagFilterLists(setts, c.Tags)
+	}
+
 	setts.ClientName = c.Name
 	setts.ClientTags = c.Tags
 	if !c.UseOwnSettings {
@@ -515,6 +570,29 @@ func closeDNSServer() {
 		Context.dnsServer = nil
 	}
 
+	if Context.dnstap != nil {
+		Context.dnstap.Close()
+		Context.dnstap = nil
+	}
+
+	if Context.localZones != nil {
+		err := Context.localZones.Close()
+		if err != nil {
+			log.Debug("closing local zones: %s", err)
+		}
+
+		Context.localZones = nil
+	}
+
+	if Context.dnsCache != nil {
+		err := Context.dnsCache.Close()
+		if err != nil {
+			log.Debug("closing dns cache: %s", err)
+		}
+
+		Context.dnsCache = nil
+	}
+
 	if Context.filters != nil {
 		Context.filters.Close()
 	}
@@ -0,0 +1,43 @@
+package home
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/tukimoto/AdGuardHome/internal/dnscache"
+)
+
+// dnsCacheFilename is the name of the file within the data directory to save
+// the persistent DNS cache into.
+const dnsCacheFilename = "dnscache.json"
+
+// initDNSCache returns a new persistent DNS cache restored from the data
+// directory, if it's enabled.  The cache is created even if EDNS Client Subnet
+// is enabled, since it may be disabled later.
+func initDNSCache() (g *dnscache.Group, err error) {
+	dnsConf := config.DNS
+	if !dnsConf.CachePersistent || dnsConf.CacheSize == 0 {
+		return nil, nil
+	}
+
+	if ecs := dnsConf.EDNSClientSubnet; ecs != nil && ecs.Enabled {
+		// The responses depend on the client subnets which aren't taken into
+		// account by the persistent cache, so the DNS server bypasses it while
+		// EDNS Client Subnet is enabled.
+		log.Info("WARNING: persistent dns cache is not used with edns client subnet")
+	}
+
+	g, err = dnscache.NewGroup(&dnscache.GroupConfig{
+		FilePath:         filepath.Join(Context.getDataDir(), dnsCacheFilename),
+		SnapshotInterval: dnsConf.CacheSnapshotInterval.Duration,
+		Size:             int(dnsConf.CacheSize),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("persistent dns cache: %w", err)
+	}
+
+	g.Start()
+
+	return g, nil
+}
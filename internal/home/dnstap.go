@@ -0,0 +1,54 @@
+package home
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tukimoto/AdGuardHome/internal/dnstap"
+	"github.com/tukimoto/AdGuardHome/internal/version"
+)
+
+// dnstapConfig is the configuration of the dnstap output of the DNS server.
+type dnstapConfig struct {
+	// Network is the network of the collector: "unix" or "tcp".
+	Network string `yaml:"network"`
+
+	// Address is the path to the Unix socket or the host:port address of the
+	// collector.
+	Address string `yaml:"address"`
+
+	// Identity is the identity of the server sent in each message.  If empty,
+	// the hostname is used.
+	Identity string `yaml:"identity"`
+
+	// Enabled defines if the dnstap messages are sent.
+	Enabled bool `yaml:"enabled"`
+}
+
+// initDnstap returns a new started dnstap writer, if it's enabled.
+func initDnstap() (w *dnstap.Writer, err error) {
+	conf := config.DNS.Dnstap
+	if conf == nil || !conf.Enabled {
+		return nil, nil
+	}
+
+	identity := conf.Identity
+	if identity == "" {
+		// Ignore the error, since the identity is optional.
+		identity, _ = os.Hostname()
+	}
+
+	w, err = dnstap.New(&dnstap.Config{
+		Network:  conf.Network,
+		Address:  conf.Address,
+		Identity: identity,
+		Version:  "AdGuard Home " + version.Version(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dnstap: %w", err)
+	}
+
+	w.Start()
+
+	return w, nil
+}
@@ -32,11 +32,16 @@ import (
 	"github.com/tukimoto/AdGuardHome/internal/aghos"
 	"github.com/tukimoto/AdGuardHome/internal/aghtls"
 	"github.com/tukimoto/AdGuardHome/internal/arpdb"
+	"github.com/tukimoto/AdGuardHome/internal/audit"
+	"github.com/tukimoto/AdGuardHome/internal/authzone"
 	"github.com/tukimoto/AdGuardHome/internal/dhcpd"
+	"github.com/tukimoto/AdGuardHome/internal/dnscache"
 	"github.com/tukimoto/AdGuardHome/internal/dnsforward"
+	"github.com/tukimoto/AdGuardHome/internal/dnstap"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
 	"github.com/tukimoto/AdGuardHome/internal/filtering/hashprefix"
 	"github.com/tukimoto/AdGuardHome/internal/filtering/safesearch"
+	"github.com/tukimoto/AdGuardHome/internal/metrics"
 	"github.com/tukimoto/AdGuardHome/internal/querylog"
 	"github.com/tukimoto/AdGuardHome/internal/stats"
 	"github.com/tukimoto/AdGuardHome/internal/updater"
@@ -51,6 +56,11 @@ type homeContext struct {
 	clients    clientsContainer     // per-client-settings module
 	stats      stats.Interface      // statistics module
 	queryLog   querylog.QueryLog    // query log module
+	auditLog   *audit.Journal       // audit journal of configuration changes
+	dnsMetrics *metrics.DNS         // metrics of the DNS server
+	dnstap     *dnstap.Writer       // dnstap output of the DNS server
+	localZones *authzone.Container  // local authoritative zones
+	dnsCache   *dnscache.Group      // persistent DNS cache
 	dnsServer  *dnsforward.Server   // DNS module
 	dhcpServer dhcpd.Interface      // DHCP module
 	auth       *Auth                // HTTP authentication module
@@ -454,6 +464,22 @@ func setupDNSFilteringConf(conf *filtering.Config) (err error) {
 		return fmt.Errorf("initializing safesearch: %w", err)
 	}
 
+	for _, p := range conf.SchedulePolicies {
+		if p.SafeSearchConf == nil {
+			continue
+		}
+
+		p.SafeSearch, err = safesearch.NewDefault(
+			*p.SafeSearchConf,
+			fmt.Sprintf("schedule policy %q", p.Name),
+			conf.SafeSearchCacheSize,
+			cacheTime,
+		)
+		if err != nil {
+			return fmt.Errorf("initializing safesearch for schedule policy %q: %w", p.Name, err)
+		}
+	}
+
 	return nil
 }
 
@@ -639,6 +665,15 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 	Context.auth, err = initUsers()
 	fatalOnError(err)
 
+	Context.auditLog, err = initAudit()
+	fatalOnError(errors.Annotate(err, "initializing audit journal: %w"))
+
+	if Context.auditLog != nil {
+		Context.auditLog.Start()
+	}
+
+	initMetrics()
+
 	Context.tls, err = newTLSManager(config.TLS, config.DNS.ServePlainDNS)
 	if err != nil {
 		log.Error("initializing tls: %s", err)
@@ -696,6 +731,10 @@ func initUsers() (auth *Auth, err error) {
 		return nil, errors.Error("initializing auth module failed")
 	}
 
+	if oidcConf := config.OIDC; oidcConf != nil && oidcConf.Enabled {
+		auth.oidc = newOIDCProvider(oidcConf, httpClient())
+	}
+
 	config.Users = nil
 
 	return auth, nil
@@ -829,6 +868,10 @@ func cleanup(ctx context.Context) {
 		Context.auth.Close()
 		Context.auth = nil
 	}
+	if Context.auditLog != nil {
+		Context.auditLog.Close()
+		Context.auditLog = nil
+	}
 
 	err := stopDNSServer()
 	if err != nil {
@@ -0,0 +1,57 @@
+package home
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/osutil"
+	"github.com/tukimoto/AdGuardHome/internal/aghos"
+	"github.com/tukimoto/AdGuardHome/internal/authzone"
+)
+
+// initLocalZones returns a new container of the local authoritative zones, if
+// there are any configured.  The relative paths to the zone files are resolved
+// against the working directory.
+func initLocalZones() (c *authzone.Container, err error) {
+	zones := config.DNS.LocalZones
+	if len(zones) == 0 {
+		return nil, nil
+	}
+
+	confs := make([]*authzone.ZoneConfig, 0, len(zones))
+	for _, z := range zones {
+		p := z.File
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(Context.workDir, p)
+		}
+
+		// Make the path relative to the root directory, since that's the form
+		// expected by both [osutil.RootDirFS] and [aghos.FSWatcher].
+		confs = append(confs, &authzone.ZoneConfig{
+			Origin: z.Origin,
+			File:   strings.TrimPrefix(filepath.ToSlash(p), "/"),
+		})
+	}
+
+	w, err := aghos.NewOSWritesWatcher()
+	if err != nil {
+		log.Info("WARNING: initializing zones watcher: %s; not watching for changes", err)
+
+		w = aghos.EmptyFSWatcher{}
+	}
+
+	c, err = authzone.NewContainer(osutil.RootDirFS(), w, confs)
+	if err != nil {
+		return nil, errors.WithDeferred(fmt.Errorf("local zones: %w", err), w.Close())
+	}
+
+	err = w.Start()
+	if err != nil {
+		return nil, errors.WithDeferred(fmt.Errorf("local zones: starting watcher: %w", err), c.Close())
+	}
+
+	return c, nil
+}
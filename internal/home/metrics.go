@@ -0,0 +1,114 @@
+package home
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+	"github.com/tukimoto/AdGuardHome/internal/metrics"
+	"github.com/tukimoto/AdGuardHome/internal/version"
+)
+
+// httpMetricsConfig is the block with Prometheus metrics HTTP configuration.
+type httpMetricsConfig struct {
+	// Enabled defines if the /metrics HTTP handler is enabled.
+	Enabled bool `yaml:"enabled"`
+
+	// RequireAuth defines if the /metrics HTTP handler requires the same
+	// authentication as the HTTP API.  API tokens must have the "stats:read"
+	// scope.
+	RequireAuth bool `yaml:"require_auth"`
+}
+
+// initMetrics initializes the metrics registry and registers the /metrics HTTP
+// handler, if it's enabled.  The DNS metrics are always collected, so that they
+// don't depend on the state of the DNS server.
+func initMetrics() {
+	reg := prometheus.NewRegistry()
+	Context.dnsMetrics = metrics.NewDNS(reg)
+
+	reg.MustRegister(
+		metrics.NewGaugeFunc(
+			"adguardhome_build_info",
+			"Version of AdGuard Home.",
+			func(set metrics.SetFunc) { set(1, version.Version(), version.Channel()) },
+			"version",
+			"channel",
+		),
+		metrics.NewGaugeFunc(
+			"adguardhome_filter_rules",
+			"Number of rules in the enabled filter lists.",
+			collectFilterRules,
+			"id",
+			"name",
+			"type",
+		),
+		metrics.NewGaugeFunc(
+			"adguardhome_dhcp_leases",
+			"Number of DHCP leases.",
+			collectDHCPLeases,
+			"type",
+		),
+	)
+
+	conf := config.HTTPConfig.Metrics
+	if conf == nil || !conf.Enabled {
+		return
+	}
+
+	h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+	if conf.RequireAuth {
+		httpRegister(http.MethodGet, "/metrics", h.ServeHTTP)
+	} else {
+		Context.mux.Handle("/metrics", postInstallHandler(ensureHandler(http.MethodGet, h.ServeHTTP)))
+	}
+
+	log.Info("metrics: serving on /metrics, auth required: %t", conf.RequireAuth)
+}
+
+// collectFilterRules sets the numbers of rules of the enabled filter lists.
+func collectFilterRules(set metrics.SetFunc) {
+	if Context.filters == nil {
+		return
+	}
+
+	conf := &filtering.Config{}
+	Context.filters.WriteDiskConfig(conf)
+
+	setAll := func(filters []filtering.FilterYAML, typ string) {
+		for _, f := range filters {
+			if f.Enabled {
+				set(float64(f.RulesCount), strconv.Itoa(f.ID), f.Name, typ)
+			}
+		}
+	}
+
+	setAll(conf.Filters, "block")
+	setAll(conf.WhitelistFilters, "allow")
+	customID := strconv.Itoa(rulelist.URLFilterIDCustom)
+	set(float64(len(conf.UserRules)), customID, "User rules", "custom")
+}
+
+// collectDHCPLeases sets the numbers of dynamic and static DHCP leases.
+func collectDHCPLeases(set metrics.SetFunc) {
+	srv := Context.dhcpServer
+	if srv == nil || !srv.Enabled() {
+		return
+	}
+
+	var dynamic, static uint
+	for _, l := range srv.Leases() {
+		if l.IsStatic {
+			static++
+		} else {
+			dynamic++
+		}
+	}
+
+	set(float64(dynamic), "dynamic")
+	set(float64(static), "static")
+}
@@ -43,6 +43,14 @@ type profileJSON struct {
 	Name     string `json:"name"`
 	Language string `json:"language"`
 	Theme    Theme  `json:"theme"`
+
+	// Role is the access level of the current user.  It's ignored by
+	// /control/profile/update.
+	Role userRole `json:"role,omitempty"`
+
+	// TOTPEnabled is true if the current user has the two-factor
+	// authentication enabled.  It's ignored by /control/profile/update.
+	TOTPEnabled bool `json:"totp_enabled"`
 }
 
 // handleGetProfile is the handler for GET /control/profile endpoint.
@@ -58,6 +66,9 @@ func handleGetProfile(w http.ResponseWriter, r *http.Request) {
 			Name:     u.Name,
 			Language: config.Language,
 			Theme:    config.Theme,
+			Role:     u.Role.effective(),
+
+			TOTPEnabled: u.TOTPSecret != "",
 		}
 	}()
 
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DNS contains the metrics of the DNS server.  A nil *DNS is not valid.
+type DNS struct {
+	queries        *prometheus.CounterVec
+	processingTime prometheus.Histogram
+	upstreamTime   *prometheus.HistogramVec
+	upstreamErrors *prometheus.CounterVec
+	cacheHits      prometheus.Counter
+	cacheMisses    prometheus.Counter
+}
+
+// NewDNS returns new metrics of the DNS server registered in reg.  It panics if
+// the metrics can't be registered.
+func NewDNS(reg prometheus.Registerer) (m *DNS) {
+	m = &DNS{
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "adguardhome_dns_queries_total",
+			Help: "Total number of processed DNS queries by the result of filtering.",
+		}, []string{"result"}),
+		processingTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "adguardhome_dns_processing_duration_seconds",
+			Help:    "Duration of the DNS query processing including the upstream exchange.",
+			Buckets: durationBuckets,
+		}),
+		upstreamTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "adguardhome_dns_upstream_duration_seconds",
+			Help:    "Duration of the successful exchanges with the upstream servers.",
+			Buckets: durationBuckets,
+		}, []string{"upstream"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "adguardhome_dns_upstream_errors_total",
+			Help: "Total number of failed exchanges with the upstream servers.",
+		}, []string{"upstream"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "adguardhome_dns_cache_hits_total",
+			Help: "Total number of DNS queries answered from the cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "adguardhome_dns_cache_misses_total",
+			Help: "Total number of DNS queries forwarded to the upstream servers.",
+		}),
+	}
+
+	reg.MustRegister(
+		m.queries,
+		m.processingTime,
+		m.upstreamTime,
+		m.upstreamErrors,
+		m.cacheHits,
+		m.cacheMisses,
+	)
+
+	return m
+}
+
+// ObserveQuery records a processed DNS query with the given result of
+// filtering.
+func (m *DNS) ObserveQuery(result string, processingTime time.Duration) {
+	m.queries.WithLabelValues(result).Inc()
+	m.processingTime.Observe(processingTime.Seconds())
+}
+
+// ObserveUpstream records a DNS query resolved using an upstream server.
+// cached is true if the response has been taken from the cache, in which case
+// dur is ignored.
+func (m *DNS) ObserveUpstream(addr string, dur time.Duration, cached bool) {
+	if cached {
+		m.cacheHits.Inc()
+
+		return
+	}
+
+	m.cacheMisses.Inc()
+	m.upstreamTime.WithLabelValues(addr).Observe(dur.Seconds())
+}
+
+// UpstreamError records a failed exchange with the upstream server.
+func (m *DNS) UpstreamError(addr string) {
+	m.upstreamErrors.WithLabelValues(addr).Inc()
+}
@@ -0,0 +1,52 @@
+// Package metrics contains the Prometheus metrics of AdGuard Home.  The metrics
+// are registered in a [prometheus.Registerer] and exposed using the handlers of
+// package promhttp.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// durationBuckets are the upper bounds of the buckets of the histograms of
+// durations in seconds.
+var durationBuckets = []float64{
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// SetFunc sets the value of a gauge with the given label values.
+type SetFunc func(v float64, labelValues ...string)
+
+// GaugeFunc is a gauge which values are collected on each scrape.  Unlike
+// [prometheus.GaugeFunc], it's partitioned by labels, and the set of the label
+// values may change between the scrapes.
+type GaugeFunc struct {
+	desc    *prometheus.Desc
+	collect func(set SetFunc)
+}
+
+// NewGaugeFunc returns a new gauge with the given label names.  collect is
+// called on each scrape and must call set for each value of the gauge.  The
+// names are validated when the gauge is registered.
+func NewGaugeFunc(name, help string, collect func(set SetFunc), labels ...string) (g *GaugeFunc) {
+	return &GaugeFunc{
+		desc:    prometheus.NewDesc(name, help, labels, nil),
+		collect: collect,
+	}
+}
+
+// type check
+var _ prometheus.Collector = (*GaugeFunc)(nil)
+
+// Describe implements the [prometheus.Collector] interface for *GaugeFunc.
+func (g *GaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+// Collect implements the [prometheus.Collector] interface for *GaugeFunc.  It
+// panics if the number of label values passed to set doesn't match the number
+// of labels of g, since it's a programmer error.
+func (g *GaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	g.collect(func(v float64, labelValues ...string) {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, v, labelValues...)
+	})
+}
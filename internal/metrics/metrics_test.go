@@ -0,0 +1,168 @@
+package metrics_test
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/metrics"
+)
+
+// newGoldenRegistry returns a registry with the metrics covering the corner
+// cases of the text exposition format.
+func newGoldenRegistry(tb testing.TB) (reg *prometheus.Registry) {
+	tb.Helper()
+
+	reg = prometheus.NewRegistry()
+
+	m := metrics.NewDNS(reg)
+	m.ObserveQuery("filtered", 10*time.Millisecond)
+	m.ObserveQuery("normal", 2*time.Second)
+	m.ObserveUpstream("1.1.1.1:53", 5*time.Millisecond, false)
+	m.ObserveUpstream("1.1.1.1:53", 0, true)
+	m.UpstreamError(`[/a"b\c/]8.8.8.8:53`)
+
+	g := metrics.NewGaugeFunc(
+		"golden_values",
+		"Special values with a backslash \\ and\na newline.",
+		func(set metrics.SetFunc) {
+			set(math.Inf(1), "pos_inf")
+			set(math.Inf(-1), "neg_inf")
+			set(math.NaN(), "nan")
+			set(1e-7, "small")
+			set(1e21, "large")
+			set(-0.25, "line1\nline2")
+		},
+		"kind",
+	)
+
+	empty := metrics.NewGaugeFunc("golden_empty", "No samples.", func(_ metrics.SetFunc) {}, "kind")
+
+	require.NoError(tb, reg.Register(g))
+	require.NoError(tb, reg.Register(empty))
+
+	return reg
+}
+
+func TestRegistry_golden(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("testdata", "exposition.txt"))
+	require.NoError(t, err)
+
+	err = testutil.GatherAndCompare(newGoldenRegistry(t), bytes.NewReader(want))
+	assert.NoError(t, err)
+}
+
+func TestRegistry_handler(t *testing.T) {
+	h := promhttp.HandlerFor(newGoldenRegistry(t), promhttp.HandlerOpts{})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	p := &expfmt.TextParser{}
+	families, err := p.TextToMetricFamilies(w.Body)
+	require.NoError(t, err)
+
+	queries := families["adguardhome_dns_queries_total"]
+	require.NotNil(t, queries)
+	assert.Equal(t, dto.MetricType_COUNTER, queries.GetType())
+	require.Len(t, queries.GetMetric(), 2)
+
+	filtered := queries.GetMetric()[0]
+	assert.Equal(t, "filtered", filtered.GetLabel()[0].GetValue())
+	assert.Equal(t, 1.0, filtered.GetCounter().GetValue())
+
+	errs := families["adguardhome_dns_upstream_errors_total"]
+	require.NotNil(t, errs)
+	require.Len(t, errs.GetMetric(), 1)
+	assert.Equal(t, `[/a"b\c/]8.8.8.8:53`, errs.GetMetric()[0].GetLabel()[0].GetValue())
+
+	procTime := families["adguardhome_dns_processing_duration_seconds"]
+	require.NotNil(t, procTime)
+	assert.Equal(t, dto.MetricType_HISTOGRAM, procTime.GetType())
+	require.Len(t, procTime.GetMetric(), 1)
+
+	hist := procTime.GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(2), hist.GetSampleCount())
+	assert.InDelta(t, 2.01, hist.GetSampleSum(), 1e-9)
+
+	values := families["golden_values"]
+	require.NotNil(t, values)
+	assert.Equal(t, dto.MetricType_GAUGE, values.GetType())
+	assert.Equal(t, "Special values with a backslash \\ and\na newline.", values.GetHelp())
+
+	got := map[string]float64{}
+	for _, m := range values.GetMetric() {
+		got[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+	}
+
+	assert.True(t, math.IsNaN(got["nan"]))
+	delete(got, "nan")
+
+	assert.Equal(t, map[string]float64{
+		"pos_inf":      math.Inf(1),
+		"neg_inf":      math.Inf(-1),
+		"small":        1e-7,
+		"large":        1e21,
+		"line1\nline2": -0.25,
+	}, got)
+}
+
+func TestNewGaugeFunc_register(t *testing.T) {
+	testCases := []struct {
+		name    string
+		metric  string
+		labels  []string
+		wantErr bool
+	}{{
+		name:    "valid",
+		metric:  "test_gauge",
+		labels:  []string{"a", "b"},
+		wantErr: false,
+	}, {
+		name:    "bad_name",
+		metric:  "bad-name",
+		labels:  nil,
+		wantErr: true,
+	}, {
+		name:    "bad_label",
+		metric:  "test_gauge",
+		labels:  []string{"bad-label"},
+		wantErr: true,
+	}, {
+		name:    "reserved_prefix",
+		metric:  "test_gauge",
+		labels:  []string{"__name"},
+		wantErr: true,
+	}, {
+		name:    "duplicate_label",
+		metric:  "test_gauge",
+		labels:  []string{"a", "a"},
+		wantErr: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := metrics.NewGaugeFunc(tc.metric, "Test.", func(_ metrics.SetFunc) {}, tc.labels...)
+
+			err := prometheus.NewRegistry().Register(g)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
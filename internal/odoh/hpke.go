@@ -0,0 +1,240 @@
+package odoh
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// The identifiers of the only HPKE cipher suite supported, which is the one
+// mandatory for ODoH.
+//
+// See https://datatracker.ietf.org/doc/html/rfc9180#section-7.
+const (
+	// kemX25519 is DHKEM(X25519, HKDF-SHA256).
+	kemX25519 uint16 = 0x0020
+
+	// kdfHKDFSHA256 is HKDF-SHA256.
+	kdfHKDFSHA256 uint16 = 0x0001
+
+	// aeadAES128GCM is AES-128-GCM.
+	aeadAES128GCM uint16 = 0x0001
+)
+
+// The sizes of the values of the supported cipher suite, see RFC 9180.
+const (
+	// nEnc is the length of the encapsulated key.
+	nEnc = 32
+
+	// nSecret is the length of the KEM shared secret.
+	nSecret = 32
+
+	// nh is the output size of the KDF's Extract function.
+	nh = sha256.Size
+
+	// nk is the length of the AEAD key.
+	nk = 16
+
+	// nn is the length of the AEAD nonce.
+	nn = 12
+)
+
+// hpkeModeBase is the identifier of the base HPKE mode.
+const hpkeModeBase byte = 0x00
+
+// hpkeVersion is the prefix of the labels of the labeled KDF functions.
+const hpkeVersion = "HPKE-v1"
+
+// kemSuiteID is the suite ID used within the KEM.
+var kemSuiteID = binary.BigEndian.AppendUint16([]byte("KEM"), kemX25519)
+
+// hpkeSuiteID is the suite ID used within the key schedule.
+var hpkeSuiteID = binary.BigEndian.AppendUint16(
+	binary.BigEndian.AppendUint16(
+		binary.BigEndian.AppendUint16([]byte("HPKE"), kemX25519),
+		kdfHKDFSHA256,
+	),
+	aeadAES128GCM,
+)
+
+// labeledExtract is the LabeledExtract function of RFC 9180.
+func labeledExtract(suiteID, salt []byte, label string, ikm []byte) (prk []byte) {
+	labeled := append([]byte(hpkeVersion), suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, ikm...)
+
+	return hkdf.Extract(sha256.New, labeled, salt)
+}
+
+// labeledExpand is the LabeledExpand function of RFC 9180.
+func labeledExpand(suiteID, prk []byte, label string, info []byte, l uint16) (out []byte) {
+	labeled := binary.BigEndian.AppendUint16(nil, l)
+	labeled = append(labeled, hpkeVersion...)
+	labeled = append(labeled, suiteID...)
+	labeled = append(labeled, label...)
+	labeled = append(labeled, info...)
+
+	return expand(prk, labeled, int(l))
+}
+
+// expand is the HKDF-Expand function with SHA-256.  l must not exceed 255*nh.
+func expand(prk, info []byte, l int) (out []byte) {
+	out = make([]byte, l)
+	_, err := io.ReadFull(hkdf.Expand(sha256.New, prk, info), out)
+	if err != nil {
+		// Shouldn't happen, since l is always small.
+		panic(fmt.Errorf("odoh: expanding %d bytes: %w", l, err))
+	}
+
+	return out
+}
+
+// sharedSecret is the ExtractAndExpand function of the DH-based KEM.
+func sharedSecret(dh, kemContext []byte) (secret []byte) {
+	prk := labeledExtract(kemSuiteID, nil, "eae_prk", dh)
+
+	return labeledExpand(kemSuiteID, prk, "shared_secret", kemContext, nSecret)
+}
+
+// encap generates an ephemeral key pair and returns the shared secret along
+// with the encapsulated ephemeral public key for pkR.
+func encap(pkR *ecdh.PublicKey) (secret, enc []byte, err error) {
+	skE, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating ephemeral key: %w", err)
+	}
+
+	return encapWith(skE, pkR)
+}
+
+// encapWith returns the shared secret along with the encapsulated public key
+// of the ephemeral key skE for pkR.  It's separated from encap for the tests
+// against the known-answer vectors.
+func encapWith(skE *ecdh.PrivateKey, pkR *ecdh.PublicKey) (secret, enc []byte, err error) {
+	dh, err := skE.ECDH(pkR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("computing dh: %w", err)
+	}
+
+	enc = skE.PublicKey().Bytes()
+	kemContext := append(append([]byte{}, enc...), pkR.Bytes()...)
+
+	return sharedSecret(dh, kemContext), enc, nil
+}
+
+// decap returns the shared secret for the encapsulated key enc using skR.
+func decap(enc []byte, skR *ecdh.PrivateKey) (secret []byte, err error) {
+	pkE, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, fmt.Errorf("parsing encapsulated key: %w", err)
+	}
+
+	dh, err := skR.ECDH(pkE)
+	if err != nil {
+		return nil, fmt.Errorf("computing dh: %w", err)
+	}
+
+	kemContext := append(append([]byte{}, enc...), skR.PublicKey().Bytes()...)
+
+	return sharedSecret(dh, kemContext), nil
+}
+
+// hpkeContext is the encryption context of the base HPKE mode.  Since ODoH
+// only seals or opens a single message within a context, the sequence number
+// is always zero, so the base nonce is used as is.
+type hpkeContext struct {
+	// aead is the AEAD cipher with the context's key.
+	aead cipher.AEAD
+
+	// baseNonce is the nonce of the first message.
+	baseNonce []byte
+
+	// exporterSecret is the secret used to export the further secrets.
+	exporterSecret []byte
+}
+
+// newHPKEContext is the KeyScheduleS and KeyScheduleR functions of the base
+// HPKE mode.
+func newHPKEContext(secret []byte, info string) (ctx *hpkeContext, err error) {
+	pskIDHash := labeledExtract(hpkeSuiteID, nil, "psk_id_hash", nil)
+	infoHash := labeledExtract(hpkeSuiteID, nil, "info_hash", []byte(info))
+
+	ksContext := append([]byte{hpkeModeBase}, pskIDHash...)
+	ksContext = append(ksContext, infoHash...)
+
+	prk := labeledExtract(hpkeSuiteID, secret, "secret", nil)
+
+	aead, err := newAEAD(labeledExpand(hpkeSuiteID, prk, "key", ksContext, nk))
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	return &hpkeContext{
+		aead:           aead,
+		baseNonce:      labeledExpand(hpkeSuiteID, prk, "base_nonce", ksContext, nn),
+		exporterSecret: labeledExpand(hpkeSuiteID, prk, "exp", ksContext, nh),
+	}, nil
+}
+
+// setupSender is the SetupBaseS function of RFC 9180.
+func setupSender(pkR *ecdh.PublicKey, info string) (enc []byte, ctx *hpkeContext, err error) {
+	secret, enc, err := encap(pkR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encapsulating: %w", err)
+	}
+
+	ctx, err = newHPKEContext(secret, info)
+	if err != nil {
+		return nil, nil, fmt.Errorf("scheduling keys: %w", err)
+	}
+
+	return enc, ctx, nil
+}
+
+// setupReceiver is the SetupBaseR function of RFC 9180.
+func setupReceiver(enc []byte, skR *ecdh.PrivateKey, info string) (ctx *hpkeContext, err error) {
+	secret, err := decap(enc, skR)
+	if err != nil {
+		return nil, fmt.Errorf("decapsulating: %w", err)
+	}
+
+	ctx, err = newHPKEContext(secret, info)
+	if err != nil {
+		return nil, fmt.Errorf("scheduling keys: %w", err)
+	}
+
+	return ctx, nil
+}
+
+// seal encrypts the first message of the context.
+func (ctx *hpkeContext) seal(aad, pt []byte) (ct []byte) {
+	return ctx.aead.Seal(nil, ctx.baseNonce, pt, aad)
+}
+
+// open decrypts the first message of the context.
+func (ctx *hpkeContext) open(aad, ct []byte) (pt []byte, err error) {
+	return ctx.aead.Open(nil, ctx.baseNonce, ct, aad)
+}
+
+// export is the Export function of the context.
+func (ctx *hpkeContext) export(exporterContext string, l uint16) (secret []byte) {
+	return labeledExpand(hpkeSuiteID, ctx.exporterSecret, "sec", []byte(exporterContext), l)
+}
+
+// newAEAD returns the AES-128-GCM cipher with key.
+func newAEAD(key []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,123 @@
+package odoh
+
+import (
+	"crypto/ecdh"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mustDecodeHex decodes s or fails the test.
+func mustDecodeHex(t *testing.T, s string) (b []byte) {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+
+	return b
+}
+
+// deriveKeyPair is the DeriveKeyPair function of DHKEM(X25519, HKDF-SHA256).
+func deriveKeyPair(t *testing.T, ikm []byte) (sk *ecdh.PrivateKey) {
+	t.Helper()
+
+	prk := labeledExtract(kemSuiteID, nil, "dkp_prk", ikm)
+	sk, err := ecdh.X25519().NewPrivateKey(labeledExpand(kemSuiteID, prk, "sk", nil, 32))
+	require.NoError(t, err)
+
+	return sk
+}
+
+// TestHPKE_vectors checks the implementation against the test vectors of the
+// base mode of DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM.  Only the
+// first encryption is checked, since ODoH never uses the others.
+//
+// See https://datatracker.ietf.org/doc/html/rfc9180#appendix-A.1.1.
+func TestHPKE_vectors(t *testing.T) {
+	const (
+		info = "4f6465206f6e2061204772656369616e2055726e"
+
+		ikmE = "7268600d403fce431561aef583ee1613527cff655c1343f29812e66706df3234"
+		skEm = "52c4a758a802cd8b936eceea314432798d5baf2d7e9235dc084ab1b9cfa2f736"
+		pkEm = "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431"
+
+		ikmR = "6db9df30aa07dd42ee5e8181afdb977e538f5e1fec8a06223f33f7013e525037"
+		skRm = "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8"
+		pkRm = "3948cfe0ad1ddb695d780e59077195da6c56506b027329794ab02bca80815c4d"
+
+		sharedSecretHex = "fe0e18c9f024ce43799ae393c7e8fe8fce9d218875e8227b0187c04e7d2ea1fc"
+		baseNonce       = "56d890e5accaaf011cff4b7d"
+		exporterSecret  = "45ff1c2e220db587171952c0592d5f5ebe103f1561a2614e38f2ffd47e99e3f8"
+
+		pt  = "4265617574792069732074727574682c20747275746820626561757479"
+		aad = "436f756e742d30"
+		ct  = "f938558b5d72f1a23810b4be2ab4f84331acc02fc97babc53a52ae8218a355a9" +
+			"6d8770ac83d07bea87e13c512a"
+	)
+
+	skE := deriveKeyPair(t, mustDecodeHex(t, ikmE))
+	assert.Equal(t, skEm, hex.EncodeToString(skE.Bytes()))
+
+	skR := deriveKeyPair(t, mustDecodeHex(t, ikmR))
+	assert.Equal(t, skRm, hex.EncodeToString(skR.Bytes()))
+	assert.Equal(t, pkRm, hex.EncodeToString(skR.PublicKey().Bytes()))
+
+	pkR, err := ecdh.X25519().NewPublicKey(mustDecodeHex(t, pkRm))
+	require.NoError(t, err)
+
+	secret, enc, err := encapWith(skE, pkR)
+	require.NoError(t, err)
+
+	assert.Equal(t, pkEm, hex.EncodeToString(enc))
+	assert.Equal(t, sharedSecretHex, hex.EncodeToString(secret))
+
+	recvSecret, err := decap(enc, skR)
+	require.NoError(t, err)
+
+	assert.Equal(t, sharedSecretHex, hex.EncodeToString(recvSecret))
+
+	infoStr := string(mustDecodeHex(t, info))
+	ctx, err := newHPKEContext(secret, infoStr)
+	require.NoError(t, err)
+
+	assert.Equal(t, baseNonce, hex.EncodeToString(ctx.baseNonce))
+	assert.Equal(t, exporterSecret, hex.EncodeToString(ctx.exporterSecret))
+
+	sealed := ctx.seal(mustDecodeHex(t, aad), mustDecodeHex(t, pt))
+	assert.Equal(t, ct, hex.EncodeToString(sealed))
+
+	recvCtx, err := setupReceiver(enc, skR, infoStr)
+	require.NoError(t, err)
+
+	opened, err := recvCtx.open(mustDecodeHex(t, aad), mustDecodeHex(t, ct))
+	require.NoError(t, err)
+
+	assert.Equal(t, pt, hex.EncodeToString(opened))
+
+	testCases := []struct {
+		name    string
+		context string
+		want    string
+	}{{
+		name:    "empty",
+		context: "",
+		want:    "3853fe2b4035195a573ffc53856e77058e15d9ea064de3e59f4961d0095250ee",
+	}, {
+		name:    "zero",
+		context: "00",
+		want:    "2e8f0b54673c7029649d4eb9d5e33bf1872cf76d623ff164ac185da9e88c21a5",
+	}, {
+		name:    "test_context",
+		context: "54657374436f6e74657874",
+		want:    "e9e43065102c3836401bed8c3c3c75ae46be1639869391d62c61f1ec7af54931",
+	}}
+
+	for _, tc := range testCases {
+		t.Run("export_"+tc.name, func(t *testing.T) {
+			exported := ctx.export(string(mustDecodeHex(t, tc.context)), 32)
+			assert.Equal(t, tc.want, hex.EncodeToString(exported))
+		})
+	}
+}
@@ -0,0 +1,359 @@
+// Package odoh implements Oblivious DNS over HTTPS, both the client and the
+// target parts of the protocol.  Only the HPKE cipher suite mandatory for ODoH
+// is supported.
+//
+// See https://datatracker.ietf.org/doc/html/rfc9230.
+package odoh
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ContentType is the media type of the ODoH messages.
+const ContentType = "application/oblivious-dns-message"
+
+// ConfigsPath is the well-known path of the target's ODoH configurations.
+const ConfigsPath = "/.well-known/odohconfigs"
+
+// ErrKeyMismatch is returned, possibly wrapped, when a query is encrypted with
+// a key which the target doesn't have.  The target should respond with the
+// 401 Unauthorized status in this case.
+const ErrKeyMismatch errors.Error = "unknown key id"
+
+// configVersion is the only supported version of ObliviousDoHConfig.
+const configVersion uint16 = 0x0001
+
+// Message types.
+const (
+	messageTypeQuery    uint8 = 0x01
+	messageTypeResponse uint8 = 0x02
+)
+
+// The labels used in the ODoH key derivation.
+const (
+	labelQuery    = "odoh query"
+	labelResponse = "odoh response"
+	labelKeyID    = "odoh key id"
+	labelKey      = "odoh key"
+	labelNonce    = "odoh nonce"
+)
+
+// paddingBlock is the block size the plaintext DNS queries are padded to.
+const paddingBlock = 128
+
+// config is a single ODoH configuration of a target, which is the public key
+// of the target.
+type config struct {
+	// publicKey is the public key of the target.
+	publicKey *ecdh.PublicKey
+
+	// keyID is the identifier of publicKey calculated from the encoded
+	// contents of the configuration.
+	keyID []byte
+}
+
+// newConfig returns a new configuration with pk.
+func newConfig(pk *ecdh.PublicKey) (c *config) {
+	b := cryptobyte.NewBuilder(nil)
+	addConfigContents(b, pk.Bytes())
+	contents := b.BytesOrPanic()
+
+	return &config{
+		publicKey: pk,
+		keyID:     expand(hkdf.Extract(sha256.New, contents, nil), []byte(labelKeyID), nh),
+	}
+}
+
+// addConfigContents adds the encoded ObliviousDoHConfigContents with the
+// public key pk to b.
+func addConfigContents(b *cryptobyte.Builder, pk []byte) {
+	b.AddUint16(kemX25519)
+	b.AddUint16(kdfHKDFSHA256)
+	b.AddUint16(aeadAES128GCM)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(pk) })
+}
+
+// marshalConfigs returns the encoded ObliviousDoHConfigs containing c.
+func marshalConfigs(c *config) (data []byte) {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(configVersion)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			addConfigContents(b, c.publicKey.Bytes())
+		})
+	})
+
+	return b.BytesOrPanic()
+}
+
+// parseConfigs returns the first supported configuration from the encoded
+// ObliviousDoHConfigs.
+func parseConfigs(data []byte) (c *config, err error) {
+	s := cryptobyte.String(data)
+	var configs cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&configs) || !s.Empty() {
+		return nil, errors.Error("bad configs encoding")
+	}
+
+	for !configs.Empty() {
+		var version uint16
+		var contents cryptobyte.String
+		if !configs.ReadUint16(&version) || !configs.ReadUint16LengthPrefixed(&contents) {
+			return nil, errors.Error("bad config encoding")
+		}
+
+		if version != configVersion {
+			continue
+		}
+
+		var kem, kdf, aead uint16
+		var pk cryptobyte.String
+		if !contents.ReadUint16(&kem) ||
+			!contents.ReadUint16(&kdf) ||
+			!contents.ReadUint16(&aead) ||
+			!contents.ReadUint16LengthPrefixed(&pk) ||
+			!contents.Empty() {
+			return nil, errors.Error("bad config contents encoding")
+		}
+
+		if kem != kemX25519 || kdf != kdfHKDFSHA256 || aead != aeadAES128GCM {
+			continue
+		}
+
+		var pubKey *ecdh.PublicKey
+		pubKey, err = ecdh.X25519().NewPublicKey(pk)
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key: %w", err)
+		}
+
+		return newConfig(pubKey), nil
+	}
+
+	return nil, errors.Error("no supported configs")
+}
+
+// message is an ObliviousDoHMessage.
+type message struct {
+	// keyID is the key identifier for queries and the response nonce for
+	// responses.
+	keyID []byte
+
+	// encrypted is the encrypted message.
+	encrypted []byte
+
+	// typ is the type of the message.
+	typ uint8
+}
+
+// marshal returns the encoded m.
+func (m *message) marshal() (data []byte) {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint8(m.typ)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(m.keyID) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(m.encrypted) })
+
+	return b.BytesOrPanic()
+}
+
+// parseMessage returns the decoded message of type typ.
+func parseMessage(data []byte, typ uint8) (m *message, err error) {
+	m = &message{}
+	s := cryptobyte.String(data)
+	var keyID, encrypted cryptobyte.String
+	if !s.ReadUint8(&m.typ) ||
+		!s.ReadUint16LengthPrefixed(&keyID) ||
+		!s.ReadUint16LengthPrefixed(&encrypted) ||
+		!s.Empty() {
+		return nil, errors.Error("bad message encoding")
+	}
+
+	if m.typ != typ {
+		return nil, fmt.Errorf("message type: want %d, got %d", typ, m.typ)
+	}
+
+	m.keyID, m.encrypted = keyID, encrypted
+
+	return m, nil
+}
+
+// aad returns the additional authenticated data of m.
+func (m *message) aad() (aad []byte) {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint8(m.typ)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(m.keyID) })
+
+	return b.BytesOrPanic()
+}
+
+// marshalPlaintext returns the encoded ObliviousDoHMessagePlaintext with the
+// DNS message msg padded with padding zero bytes.
+func marshalPlaintext(msg []byte, padding int) (data []byte) {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(msg) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(make([]byte, padding)) })
+
+	return b.BytesOrPanic()
+}
+
+// parsePlaintext returns the DNS message from the encoded
+// ObliviousDoHMessagePlaintext.
+func parsePlaintext(data []byte) (msg []byte, err error) {
+	s := cryptobyte.String(data)
+	var dnsMsg, padding cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&dnsMsg) ||
+		!s.ReadUint16LengthPrefixed(&padding) ||
+		!s.Empty() {
+		return nil, errors.Error("bad plaintext encoding")
+	}
+
+	if slices.ContainsFunc(padding, func(b byte) (ok bool) { return b != 0 }) {
+		return nil, errors.Error("non-zero padding")
+	}
+
+	return dnsMsg, nil
+}
+
+// responseContext is the state of an encrypted query needed to encrypt or
+// decrypt the response to it.
+type responseContext struct {
+	// hpke is the encryption context of the query.
+	hpke *hpkeContext
+
+	// query is the encoded plaintext query.
+	query []byte
+}
+
+// encryptQuery encrypts the DNS message msg for the target with configuration
+// c.
+func encryptQuery(c *config, msg []byte) (data []byte, rc *responseContext, err error) {
+	enc, ctx, err := setupSender(c.publicKey, labelQuery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("setting up hpke: %w", err)
+	}
+
+	padding := (paddingBlock - len(msg)%paddingBlock) % paddingBlock
+	query := marshalPlaintext(msg, padding)
+
+	m := &message{
+		keyID: c.keyID,
+		typ:   messageTypeQuery,
+	}
+	m.encrypted = append(enc, ctx.seal(m.aad(), query)...)
+
+	return m.marshal(), &responseContext{hpke: ctx, query: query}, nil
+}
+
+// decryptQuery decrypts the encoded query message data with the key sk of
+// configuration c.  err is [ErrKeyMismatch] if the query is encrypted with
+// another key.
+func decryptQuery(
+	c *config,
+	sk *ecdh.PrivateKey,
+	data []byte,
+) (msg []byte, rc *responseContext, err error) {
+	m, err := parseMessage(data, messageTypeQuery)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	}
+
+	if !bytes.Equal(m.keyID, c.keyID) {
+		return nil, nil, ErrKeyMismatch
+	} else if len(m.encrypted) < nEnc {
+		return nil, nil, errors.Error("encrypted message too short")
+	}
+
+	ctx, err := setupReceiver(m.encrypted[:nEnc], sk, labelQuery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("setting up hpke: %w", err)
+	}
+
+	query, err := ctx.open(m.aad(), m.encrypted[nEnc:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("decrypting: %w", err)
+	}
+
+	msg, err = parsePlaintext(query)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	}
+
+	return msg, &responseContext{hpke: ctx, query: query}, nil
+}
+
+// responseAEAD returns the AEAD cipher and its nonce for the response with
+// responseNonce.
+func (rc *responseContext) responseAEAD(
+	responseNonce []byte,
+) (aead cipher.AEAD, nonce []byte, err error) {
+	secret := rc.hpke.export(labelResponse, nk)
+
+	b := cryptobyte.NewBuilder(slices.Clip(rc.query))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(responseNonce) })
+	salt := b.BytesOrPanic()
+
+	prk := hkdf.Extract(sha256.New, secret, salt)
+	aead, err = newAEAD(expand(prk, []byte(labelKey), nk))
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	}
+
+	return aead, expand(prk, []byte(labelNonce), nn), nil
+}
+
+// encryptResponse encrypts the DNS message msg as the response to the query of
+// rc.
+func (rc *responseContext) encryptResponse(msg []byte) (data []byte, err error) {
+	respNonce := make([]byte, max(nn, nk))
+	_, err = rand.Read(respNonce)
+	if err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	aead, nonce, err := rc.responseAEAD(respNonce)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+
+	m := &message{
+		keyID: respNonce,
+		typ:   messageTypeResponse,
+	}
+	m.encrypted = aead.Seal(nil, nonce, marshalPlaintext(msg, 0), m.aad())
+
+	return m.marshal(), nil
+}
+
+// decryptResponse decrypts the encoded response message data to the query of
+// rc.
+func (rc *responseContext) decryptResponse(data []byte) (msg []byte, err error) {
+	m, err := parseMessage(data, messageTypeResponse)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	aead, nonce, err := rc.responseAEAD(m.keyID)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+
+	pt, err := aead.Open(nil, nonce, m.encrypted, m.aad())
+	if err != nil {
+		return nil, fmt.Errorf("decrypting: %w", err)
+	}
+
+	return parsePlaintext(pt)
+}
@@ -0,0 +1,248 @@
+package odoh_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/odoh"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// testAnswerIP is the IP address in the answers of the test target.
+var testAnswerIP = net.IP{1, 2, 3, 4}
+
+// newTestTarget starts a target server answering all the A queries with
+// testAnswerIP.  The target can be replaced using the returned pointer.
+func newTestTarget(t *testing.T) (srv *httptest.Server, target *atomic.Pointer[odoh.Target]) {
+	t.Helper()
+
+	target = &atomic.Pointer[odoh.Target]{}
+	tgt, err := odoh.NewTarget()
+	require.NoError(t, err)
+
+	target.Store(tgt)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(odoh.ConfigsPath, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(target.Load().Configs())
+	})
+	mux.HandleFunc("/dns-query", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, odoh.ContentType, r.Header.Get("Content-Type"))
+
+		body, rerr := io.ReadAll(r.Body)
+		require.NoError(t, rerr)
+
+		q, qerr := target.Load().DecryptQuery(body)
+		if errors.Is(qerr, odoh.ErrKeyMismatch) {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		require.NoError(t, qerr)
+
+		req := &dns.Msg{}
+		require.NoError(t, req.Unpack(q.Msg))
+
+		resp := (&dns.Msg{}).SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   req.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    60,
+			},
+			A: testAnswerIP,
+		})
+
+		packed, perr := resp.Pack()
+		require.NoError(t, perr)
+
+		data, eerr := q.EncryptResponse(packed)
+		require.NoError(t, eerr)
+
+		w.Header().Set("Content-Type", odoh.ContentType)
+		_, _ = w.Write(data)
+	})
+
+	srv = httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv, target
+}
+
+// newTestRelay starts a relay server forwarding the queries to the targets
+// and counting them.
+func newTestRelay(t *testing.T, client *http.Client) (srv *httptest.Server, relayed *atomic.Int32) {
+	t.Helper()
+
+	relayed = &atomic.Int32{}
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		relayed.Add(1)
+
+		q := r.URL.Query()
+		targetURL := &url.URL{
+			Scheme: "https",
+			Host:   q.Get("targethost"),
+			Path:   q.Get("targetpath"),
+		}
+
+		resp, err := client.Post(targetURL.String(), odoh.ContentType, r.Body)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, resp.Body.Close()) }()
+
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, relayed
+}
+
+// newTestUpstream starts the target and the relay and returns the ODoH upstream
+// sending the queries through them.
+func newTestUpstream(t *testing.T) (
+	u *odoh.Upstream,
+	target *atomic.Pointer[odoh.Target],
+	relayed *atomic.Int32,
+) {
+	t.Helper()
+
+	targetSrv, target := newTestTarget(t)
+	relaySrv, relayed := newTestRelay(t, targetSrv.Client())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(targetSrv.Certificate())
+
+	targetURL, err := url.Parse(targetSrv.URL)
+	require.NoError(t, err)
+
+	addr := "odoh://" + targetURL.Host + "/dns-query?relay=" + relaySrv.URL + "/proxy"
+	u, err = odoh.NewUpstream(addr, &upstream.Options{RootCAs: roots})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, u.Close)
+
+	return u, target, relayed
+}
+
+func TestUpstream_Exchange(t *testing.T) {
+	u, target, relayed := newTestUpstream(t)
+
+	req := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+
+	resp, err := u.Exchange(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, req.Id, resp.Id)
+	assert.Equal(t, int32(1), relayed.Load())
+
+	require.Len(t, resp.Answer, 1)
+
+	a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+	assert.Equal(t, testAnswerIP, a.A)
+
+	t.Run("key_rotation", func(t *testing.T) {
+		tgt, tgtErr := odoh.NewTarget()
+		require.NoError(t, tgtErr)
+
+		target.Store(tgt)
+
+		resp, err = u.Exchange(req)
+		require.NoError(t, err)
+
+		assert.Len(t, resp.Answer, 1)
+
+		// The rejected query and the retried one.
+		assert.Equal(t, int32(3), relayed.Load())
+	})
+}
+
+func TestTarget_DecryptQuery(t *testing.T) {
+	tgt, err := odoh.NewTarget()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		data       []byte
+	}{{
+		name:       "empty",
+		wantErrMsg: "bad message encoding",
+		data:       nil,
+	}, {
+		name:       "response",
+		wantErrMsg: "message type: want 1, got 2",
+		data:       []byte{2, 0, 0, 0, 1, 0},
+	}, {
+		name:       "unknown_key",
+		wantErrMsg: string(odoh.ErrKeyMismatch),
+		data:       []byte{1, 0, 1, 42, 0, 1, 0},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, qErr := tgt.DecryptQuery(tc.data)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, qErr)
+		})
+	}
+}
+
+func TestParseUpstreamsConfig(t *testing.T) {
+	const odohAddr = "odoh://target.example/dns-query?relay=https://relay.example/proxy"
+
+	uc, err := odoh.ParseUpstreamsConfig([]string{
+		odohAddr,
+		"[/example.org/]" + odohAddr + " 1.1.1.1",
+		"[/example.net/]#",
+	}, nil)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, uc.Close)
+
+	require.Len(t, uc.Upstreams, 1)
+
+	u := testutil.RequireTypeAssert[*odoh.Upstream](t, uc.Upstreams[0])
+	assert.Equal(t, odohAddr, u.Address())
+
+	ups := uc.DomainReservedUpstreams["example.org."]
+	require.Len(t, ups, 2)
+
+	assert.Same(t, u, ups[0])
+	assert.Equal(t, "1.1.1.1:53", ups[1].Address())
+
+	t.Run("bad", func(t *testing.T) {
+		_, err = odoh.ParseUpstreamsConfig([]string{"odoh://target.example"}, nil)
+		require.Error(t, err)
+
+		assert.ErrorContains(t, err, `parsing error at index 0: odoh upstream "odoh://target.example": no relay`)
+	})
+}
+
+func TestUpstream_Exchange_padding(t *testing.T) {
+	u, _, _ := newTestUpstream(t)
+
+	// Make sure that the queries of different sizes are handled.
+	for _, name := range []string{
+		"a.",
+		string(bytes.Repeat([]byte("a."), 100)),
+	} {
+		resp, err := u.Exchange((&dns.Msg{}).SetQuestion(name, dns.TypeA))
+		require.NoError(t, err)
+
+		assert.Len(t, resp.Answer, 1)
+	}
+}
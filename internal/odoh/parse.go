@@ -0,0 +1,162 @@
+package odoh
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// placeholderDomain is the domain of the placeholder upstreams substituted for
+// the ODoH ones while parsing the configuration.
+const placeholderDomain = "odoh.invalid"
+
+// ParseUpstreamsConfig is a wrapper around [proxy.ParseUpstreamsConfig] which
+// also supports the ODoH upstreams, see [Scheme].  The syntax of lines is the
+// same, and the errors about the lines with invalid ODoH upstreams are joined
+// with the ones returned by [proxy.ParseUpstreamsConfig].
+func ParseUpstreamsConfig(
+	lines []string,
+	opts *upstream.Options,
+) (uc *proxy.UpstreamConfig, err error) {
+	p := &configParser{
+		opts:         opts,
+		byAddr:       map[string]*Upstream{},
+		placeholders: map[string]*Upstream{},
+	}
+
+	replaced := make([]string, 0, len(lines))
+	for i, l := range lines {
+		replaced = append(replaced, p.replaceLine(i, l))
+	}
+
+	uc, err = proxy.ParseUpstreamsConfig(replaced, opts)
+	if len(p.placeholders) == 0 && len(p.errs) == 0 {
+		// Don't wrap the error since it's informative enough as is.
+		return uc, err
+	}
+
+	if uc != nil && len(p.placeholders) > 0 {
+		p.substitute(uc)
+	}
+
+	var errs []error
+	if err != nil {
+		if wrapper, ok := err.(errors.WrapperSlice); ok {
+			errs = wrapper.Unwrap()
+		} else {
+			errs = []error{err}
+		}
+	}
+
+	return uc, errors.Join(append(errs, p.errs...)...)
+}
+
+// configParser replaces the ODoH upstreams in the upstream configuration with
+// the placeholders and back.
+type configParser struct {
+	// opts are the options for the ODoH upstreams.
+	opts *upstream.Options
+
+	// byAddr are the ODoH upstreams mapped by their addresses.
+	byAddr map[string]*Upstream
+
+	// placeholders are the ODoH upstreams mapped by the hosts of their
+	// placeholders.
+	placeholders map[string]*Upstream
+
+	// errs are the errors of parsing the ODoH upstreams.
+	errs []error
+}
+
+// replaceLine returns the configuration line l with the index idx with the
+// ODoH upstreams replaced by the placeholders.
+func (p *configParser) replaceLine(idx int, l string) (replaced string) {
+	if !strings.HasPrefix(l, "[/") {
+		return p.replaceAddr(idx, l)
+	}
+
+	domains, ups, found := strings.Cut(l, "/]")
+	if !found {
+		// Let the proxy report the error.
+		return l
+	}
+
+	fields := strings.Fields(ups)
+	for i, f := range fields {
+		fields[i] = p.replaceAddr(idx, f)
+	}
+
+	return domains + "/]" + strings.Join(fields, " ")
+}
+
+// replaceAddr returns the placeholder for addr if it's an address of an ODoH
+// upstream.  Otherwise, or if the address is invalid, it returns addr as is.
+func (p *configParser) replaceAddr(idx int, addr string) (replaced string) {
+	if !isAddress(addr) {
+		return addr
+	}
+
+	u, ok := p.byAddr[addr]
+	if !ok {
+		var err error
+		u, err = NewUpstream(addr, p.opts)
+		if err != nil {
+			p.errs = append(p.errs, fmt.Errorf("parsing error at index %d: %w", idx, err))
+
+			return addr
+		}
+
+		p.byAddr[addr] = u
+	}
+
+	host := fmt.Sprintf("u%d.%s", len(p.placeholders), placeholderDomain)
+	p.placeholders[host] = u
+
+	return (&url.URL{Scheme: "https", Host: host, Path: defaultTargetPath}).String()
+}
+
+// substitute replaces the placeholders in uc with the ODoH upstreams.
+func (p *configParser) substitute(uc *proxy.UpstreamConfig) {
+	replaceAll := func(ups []upstream.Upstream) {
+		for i, u := range ups {
+			if odohUps, ok := p.placeholder(u); ok {
+				ups[i] = odohUps
+			}
+		}
+	}
+
+	replaceAll(uc.Upstreams)
+	for _, ups := range uc.DomainReservedUpstreams {
+		replaceAll(ups)
+	}
+
+	for _, ups := range uc.SpecifiedDomainUpstreams {
+		replaceAll(ups)
+	}
+}
+
+// placeholder returns the ODoH upstream for which u is the placeholder.  It
+// closes u, since it's never used.
+func (p *configParser) placeholder(u upstream.Upstream) (odohUps *Upstream, ok bool) {
+	addr, err := url.Parse(u.Address())
+	if err != nil {
+		return nil, false
+	}
+
+	odohUps, ok = p.placeholders[addr.Hostname()]
+	if !ok {
+		return nil, false
+	}
+
+	err = u.Close()
+	if err != nil {
+		log.Debug("odoh: closing placeholder: %s", err)
+	}
+
+	return odohUps, true
+}
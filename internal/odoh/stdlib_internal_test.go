@@ -0,0 +1,174 @@
+//go:build go1.26
+
+package odoh
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hpke"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHPKE_stdlib checks the interoperability with the standard library's HPKE
+// implementation in both directions.
+func TestHPKE_stdlib(t *testing.T) {
+	const info = "odoh interop"
+
+	var (
+		aad = []byte("aad")
+		pt  = []byte("plaintext")
+	)
+
+	skR, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	kem := hpke.DHKEM(ecdh.X25519())
+	kdf := hpke.HKDFSHA256()
+	aead := hpke.AES128GCM()
+
+	require.Equal(t, kemX25519, kem.ID())
+	require.Equal(t, kdfHKDFSHA256, kdf.ID())
+	require.Equal(t, aeadAES128GCM, aead.ID())
+
+	t.Run("stdlib_sender", func(t *testing.T) {
+		pkR, pkErr := kem.NewPublicKey(skR.PublicKey().Bytes())
+		require.NoError(t, pkErr)
+
+		enc, sender, sErr := hpke.NewSender(pkR, kdf, aead, []byte(info))
+		require.NoError(t, sErr)
+
+		ct, sealErr := sender.Seal(aad, pt)
+		require.NoError(t, sealErr)
+
+		wantSecret, expErr := sender.Export("context", 32)
+		require.NoError(t, expErr)
+
+		ctx, rErr := setupReceiver(enc, skR, info)
+		require.NoError(t, rErr)
+
+		got, openErr := ctx.open(aad, ct)
+		require.NoError(t, openErr)
+
+		assert.Equal(t, pt, got)
+		assert.Equal(t, wantSecret, ctx.export("context", 32))
+	})
+
+	t.Run("stdlib_recipient", func(t *testing.T) {
+		enc, ctx, sErr := setupSender(skR.PublicKey(), info)
+		require.NoError(t, sErr)
+
+		ct := ctx.seal(aad, pt)
+
+		sk, skErr := kem.NewPrivateKey(skR.Bytes())
+		require.NoError(t, skErr)
+
+		recipient, rErr := hpke.NewRecipient(enc, sk, kdf, aead, []byte(info))
+		require.NoError(t, rErr)
+
+		got, openErr := recipient.Open(aad, ct)
+		require.NoError(t, openErr)
+
+		wantSecret, expErr := recipient.Export("context", 32)
+		require.NoError(t, expErr)
+
+		assert.Equal(t, pt, got)
+		assert.Equal(t, wantSecret, ctx.export("context", 32))
+	})
+}
+
+// appendVector appends the data prefixed with its 2-byte length to b.
+func appendVector(b, data []byte) (res []byte) {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+
+	return append(b, data...)
+}
+
+// TestODoH_stdlib checks the ODoH messages against the ones constructed
+// directly from RFC 9230 using the standard library's HPKE implementation.
+//
+// See https://datatracker.ietf.org/doc/html/rfc9230#section-6.
+func TestODoH_stdlib(t *testing.T) {
+	var (
+		queryMsg = []byte("query")
+		respMsg  = []byte("response")
+	)
+
+	skR, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	c := newConfig(skR.PublicKey())
+
+	contents := binary.BigEndian.AppendUint16(nil, kemX25519)
+	contents = binary.BigEndian.AppendUint16(contents, kdfHKDFSHA256)
+	contents = binary.BigEndian.AppendUint16(contents, aeadAES128GCM)
+	contents = appendVector(contents, skR.PublicKey().Bytes())
+
+	prk, err := hkdf.Extract(sha256.New, contents, nil)
+	require.NoError(t, err)
+
+	keyID, err := hkdf.Expand(sha256.New, prk, "odoh key id", sha256.Size)
+	require.NoError(t, err)
+	require.Equal(t, keyID, c.keyID)
+
+	pkR, err := hpke.DHKEM(ecdh.X25519()).NewPublicKey(skR.PublicKey().Bytes())
+	require.NoError(t, err)
+
+	enc, sender, err := hpke.NewSender(pkR, hpke.HKDFSHA256(), hpke.AES128GCM(), []byte("odoh query"))
+	require.NoError(t, err)
+
+	queryPlain := appendVector(appendVector(nil, queryMsg), make([]byte, 3))
+	queryAAD := appendVector([]byte{0x01}, keyID)
+
+	ct, err := sender.Seal(queryAAD, queryPlain)
+	require.NoError(t, err)
+
+	query := appendVector(appendVector([]byte{0x01}, keyID), append(enc, ct...))
+
+	gotMsg, rc, err := decryptQuery(c, skR, query)
+	require.NoError(t, err)
+
+	assert.Equal(t, queryMsg, gotMsg)
+
+	resp, err := rc.encryptResponse(respMsg)
+	require.NoError(t, err)
+
+	nonceLen := max(nn, nk)
+	require.Greater(t, len(resp), 3+nonceLen+2)
+	require.Equal(t, byte(0x02), resp[0])
+	require.Equal(t, uint16(nonceLen), binary.BigEndian.Uint16(resp[1:]))
+
+	respNonce := resp[3 : 3+nonceLen]
+	respCT := resp[3+nonceLen+2:]
+	require.Equal(t, uint16(len(respCT)), binary.BigEndian.Uint16(resp[3+nonceLen:]))
+
+	secret, err := sender.Export("odoh response", nk)
+	require.NoError(t, err)
+
+	prk, err = hkdf.Extract(sha256.New, secret, appendVector(queryPlain, respNonce))
+	require.NoError(t, err)
+
+	key, err := hkdf.Expand(sha256.New, prk, "odoh key", nk)
+	require.NoError(t, err)
+
+	nonce, err := hkdf.Expand(sha256.New, prk, "odoh nonce", nn)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+
+	respPlain, err := aead.Open(nil, nonce, respCT, appendVector([]byte{0x02}, respNonce))
+	require.NoError(t, err)
+
+	assert.Equal(t, appendVector(appendVector(nil, respMsg), nil), respPlain)
+}
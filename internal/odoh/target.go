@@ -0,0 +1,73 @@
+package odoh
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+)
+
+// Target decrypts the ODoH queries sent to it through the relays and encrypts
+// the responses to them.  It is safe for concurrent use.
+type Target struct {
+	// key is the private key of the target.
+	key *ecdh.PrivateKey
+
+	// conf is the configuration with the public key of the target.
+	conf *config
+
+	// configs are the encoded configurations of the target.
+	configs []byte
+}
+
+// NewTarget returns a new target with a newly generated key.
+func NewTarget() (t *Target, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+
+	conf := newConfig(key.PublicKey())
+
+	return &Target{
+		key:     key,
+		conf:    conf,
+		configs: marshalConfigs(conf),
+	}, nil
+}
+
+// Configs returns the encoded ObliviousDoHConfigs of t, which are served at
+// [ConfigsPath].  The returned slice must not be modified.
+func (t *Target) Configs() (configs []byte) {
+	return t.configs
+}
+
+// Query is a decrypted ODoH query.
+type Query struct {
+	// rc is the state needed to encrypt the response.
+	rc *responseContext
+
+	// Msg is the plaintext DNS message of the query.
+	Msg []byte
+}
+
+// DecryptQuery decrypts the encoded ODoH query message data.  err is
+// [ErrKeyMismatch] if the query is encrypted with a key other than the one of
+// t.
+func (t *Target) DecryptQuery(data []byte) (q *Query, err error) {
+	msg, rc, err := decryptQuery(t.conf, t.key, data)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	return &Query{
+		rc:  rc,
+		Msg: msg,
+	}, nil
+}
+
+// EncryptResponse returns the encoded ODoH response message containing the DNS
+// message msg.
+func (q *Query) EncryptResponse(msg []byte) (data []byte, err error) {
+	return q.rc.encryptResponse(msg)
+}
@@ -0,0 +1,383 @@
+package odoh
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// Scheme is the URL scheme of the ODoH upstreams.  The address of an ODoH
+// upstream has the following format:
+//
+//	odoh://target.example[:port][/path]?relay=https://relay.example/proxy
+//
+// The path of the target defaults to "/dns-query".
+const Scheme = "odoh"
+
+// defaultTargetPath is the path of the target used when the address doesn't
+// contain one.
+const defaultTargetPath = "/dns-query"
+
+// configsTTL is the duration for which the fetched configurations of a target
+// are used.
+const configsTTL = 1 * time.Hour
+
+// maxMessageSize is the maximum size of an ODoH message or configurations
+// accepted.  It's the maximum size of a DNS message along with the encoding
+// and encryption overhead.
+const maxMessageSize = dns.MaxMsgSize + 1024
+
+// errKeyRejected is returned when the target responds with 401 Unauthorized,
+// which means that it doesn't have the key the query is encrypted with.
+const errKeyRejected errors.Error = "key rejected by target"
+
+// Upstream is an [upstream.Upstream] that sends the queries encrypted for the
+// target through the relay, so that the relay doesn't see the queries and the
+// target doesn't see the client's address.
+type Upstream struct {
+	// confExpire is the time when conf should be fetched again.
+	confExpire time.Time
+
+	// client is the HTTP client used to connect to both the target and the
+	// relay.
+	client *http.Client
+
+	// mu protects conf and confExpire.
+	mu *sync.Mutex
+
+	// conf is the configuration of the target.  It's nil if it hasn't been
+	// fetched yet.
+	conf *config
+
+	// addr is the original address of the upstream.
+	addr string
+
+	// configsURL is the URL of the target's configurations.
+	configsURL string
+
+	// relayURL is the URL of the relay with the target parameters.
+	relayURL string
+}
+
+// NewUpstream returns a new ODoH upstream with the address addr.  opts are used
+// to configure the HTTP connections to both the relay and the target, except
+// the HTTP versions, since HTTP/3 isn't supported.
+func NewUpstream(addr string, opts *upstream.Options) (u *Upstream, err error) {
+	defer func() { err = errors.Annotate(err, "odoh upstream %q: %w", addr) }()
+
+	if opts == nil {
+		opts = &upstream.Options{}
+	}
+
+	targetURL, relayURL, err := parseAddress(addr)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	return &Upstream{
+		client:     newHTTPClient(opts),
+		mu:         &sync.Mutex{},
+		addr:       addr,
+		configsURL: targetURL.JoinPath(ConfigsPath).String(),
+		relayURL:   relayURL.String(),
+	}, nil
+}
+
+// parseAddress returns the URL of the target and the URL of the relay with the
+// target parameters from the ODoH upstream address addr.
+func parseAddress(addr string) (targetURL, relayURL *url.URL, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	}
+
+	if u.Scheme != Scheme {
+		return nil, nil, fmt.Errorf("bad scheme %q", u.Scheme)
+	} else if u.Host == "" {
+		return nil, nil, errors.Error("no target host")
+	}
+
+	relay := u.Query().Get("relay")
+	if relay == "" {
+		return nil, nil, errors.Error("no relay")
+	}
+
+	relayURL, err = url.Parse(relay)
+	if err != nil {
+		return nil, nil, fmt.Errorf("relay: %w", err)
+	} else if relayURL.Scheme != "https" || relayURL.Host == "" {
+		return nil, nil, fmt.Errorf("relay: want https url, got %q", relay)
+	}
+
+	targetPath := u.Path
+	if targetPath == "" {
+		targetPath = defaultTargetPath
+	}
+
+	q := relayURL.Query()
+	q.Set("targethost", u.Host)
+	q.Set("targetpath", targetPath)
+	relayURL.RawQuery = q.Encode()
+
+	targetURL = &url.URL{
+		Scheme: "https",
+		Host:   u.Host,
+	}
+
+	return targetURL, relayURL, nil
+}
+
+// newHTTPClient returns a new HTTP client configured with opts.
+func newHTTPClient(opts *upstream.Options) (c *http.Client) {
+	tlsConf := &tls.Config{
+		RootCAs:               opts.RootCAs,
+		CipherSuites:          opts.CipherSuites,
+		VerifyPeerCertificate: opts.VerifyServerCertificate,
+		VerifyConnection:      opts.VerifyConnection,
+		InsecureSkipVerify:    opts.InsecureSkipVerify,
+		MinVersion:            tls.VersionTLS12,
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:       newDialContext(opts.Bootstrap, opts.PreferIPv6),
+			TLSClientConfig:   tlsConf,
+			ForceAttemptHTTP2: true,
+			IdleConnTimeout:   5 * time.Minute,
+		},
+		Timeout: opts.Timeout,
+	}
+}
+
+// newDialContext returns a dialing function resolving the hostnames with boot,
+// if it's not nil.
+func newDialContext(
+	boot upstream.Resolver,
+	preferIPv6 bool,
+) (dial func(ctx context.Context, network, addr string) (conn net.Conn, err error)) {
+	dialer := &net.Dialer{}
+	if boot == nil {
+		return dialer.DialContext
+	}
+
+	return func(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if netutil.IsValidIPString(host) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		ips, err := boot.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %q: %w", host, err)
+		} else if len(ips) == 0 {
+			return nil, fmt.Errorf("no addresses for host %q", host)
+		}
+
+		var errs []error
+		for _, ip := range sortAddrs(ips, preferIPv6) {
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+
+			errs = append(errs, err)
+		}
+
+		return nil, errors.Join(errs...)
+	}
+}
+
+// sortAddrs returns ips with the addresses of the preferred family first.
+func sortAddrs(ips []netip.Addr, preferIPv6 bool) (sorted []netip.Addr) {
+	sorted = make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if ip.Is6() == preferIPv6 {
+			sorted = append(sorted, ip)
+		}
+	}
+
+	for _, ip := range ips {
+		if ip.Is6() != preferIPv6 {
+			sorted = append(sorted, ip)
+		}
+	}
+
+	return sorted
+}
+
+// type check
+var _ upstream.Upstream = (*Upstream)(nil)
+
+// Address implements the [upstream.Upstream] interface for *Upstream.
+func (u *Upstream) Address() (addr string) {
+	return u.addr
+}
+
+// Exchange implements the [upstream.Upstream] interface for *Upstream.
+func (u *Upstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	// Use the zero ID just like DoH clients do, since the ID is useless within
+	// HTTP and may be used to identify the client.
+	//
+	// See https://datatracker.ietf.org/doc/html/rfc8484#section-4.1.
+	q := req.Copy()
+	q.Id = 0
+
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("packing request: %w", err)
+	}
+
+	resp, err = u.exchange(packed)
+	if errors.Is(err, errKeyRejected) {
+		log.Debug("odoh: %s: refetching configs: %s", u.addr, err)
+
+		u.resetConfig()
+		resp, err = u.exchange(packed)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("odoh upstream %s: %w", u.addr, err)
+	}
+
+	resp.Id = req.Id
+
+	return resp, nil
+}
+
+// exchange sends the packed DNS query to the target through the relay.
+func (u *Upstream) exchange(packed []byte) (resp *dns.Msg, err error) {
+	c, err := u.config()
+	if err != nil {
+		return nil, fmt.Errorf("getting configs: %w", err)
+	}
+
+	body, rc, err := encryptQuery(c, packed)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting query: %w", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, u.relayURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", ContentType)
+	httpReq.Header.Set("Accept", ContentType)
+
+	respBody, err := u.do(httpReq)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	msg, err := rc.decryptResponse(respBody)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting response: %w", err)
+	}
+
+	resp = &dns.Msg{}
+	err = resp.Unpack(msg)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking response: %w", err)
+	}
+
+	return resp, nil
+}
+
+// do performs the HTTP request and returns the response body.  err is
+// errKeyRejected if the target has responded with 401 Unauthorized.
+func (u *Upstream) do(req *http.Request) (body []byte, err error) {
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", req.URL.Host, err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Go on.
+	case http.StatusUnauthorized:
+		return nil, errKeyRejected
+	default:
+		return nil, fmt.Errorf("requesting %s: status code %d", req.URL.Host, resp.StatusCode)
+	}
+
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+
+	return body, nil
+}
+
+// config returns the configuration of the target, fetching it if needed.
+func (u *Upstream) config() (c *config, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	if u.conf != nil && now.Before(u.confExpire) {
+		return u.conf, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.configsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	data, err := u.do(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	c, err = parseConfigs(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing configs: %w", err)
+	}
+
+	u.conf, u.confExpire = c, now.Add(configsTTL)
+
+	return c, nil
+}
+
+// resetConfig makes u fetch the configuration of the target again.
+func (u *Upstream) resetConfig() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.conf = nil
+}
+
+// Close implements the [upstream.Upstream] interface for *Upstream.
+func (u *Upstream) Close() (err error) {
+	u.client.CloseIdleConnections()
+
+	return nil
+}
+
+// isAddress returns true if s looks like the address of an ODoH upstream.
+func isAddress(s string) (ok bool) {
+	return strings.HasPrefix(s, Scheme+"://")
+}
@@ -146,6 +146,16 @@ var logEntryHandlers = map[string]logEntryHandler{
 
 		return nil
 	},
+	"DNSSEC": func(t json.Token, ent *logEntry) error {
+		v, ok := t.(string)
+		if !ok {
+			return nil
+		}
+
+		ent.DNSSECStatus = v
+
+		return nil
+	},
 	"Upstream": func(t json.Token, ent *logEntry) error {
 		v, ok := t.(string)
 		if !ok {
@@ -644,6 +654,38 @@ var resultDecHandlers = map[string]func(dec *json.Decoder, ent *logEntry){
 	"DNSRewriteResult": decodeResultDNSRewriteResult,
 }
 
+// decodeSchedulePolicies parses the dec's tokens into logEntry ent
+// interpreting it as the names of the active schedule policies.
+func decodeSchedulePolicies(dec *json.Decoder, ent *logEntry) {
+	for {
+		itemToken, err := dec.Token()
+		if err != nil {
+			if err != io.EOF {
+				log.Debug("decodeSchedulePolicies err: %s", err)
+			}
+
+			return
+		}
+
+		switch v := itemToken.(type) {
+		case json.Delim:
+			if v == '[' {
+				continue
+			} else if v == ']' {
+				return
+			}
+
+			log.Debug("decodeSchedulePolicies: unexpected delim %q", v)
+
+			return
+		case string:
+			ent.SchedulePolicies = append(ent.SchedulePolicies, v)
+		default:
+			continue
+		}
+	}
+}
+
 // decodeLogEntry decodes string str to logEntry ent.
 func decodeLogEntry(ent *logEntry, str string) {
 	dec := json.NewDecoder(strings.NewReader(str))
@@ -670,9 +712,14 @@ func decodeLogEntry(ent *logEntry, str string) {
 			return
 		}
 
-		if key == "Result" {
+		switch key {
+		case "Result":
 			decodeResult(dec, ent)
 
+			continue
+		case "SP":
+			decodeSchedulePolicies(dec, ent)
+
 			continue
 		}
 
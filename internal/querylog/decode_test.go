@@ -38,6 +38,8 @@ func TestDecodeLogEntry(t *testing.T) {
 			`"Answer":"` + ansStr + `",` +
 			`"Cached":true,` +
 			`"AD":true,` +
+			`"DNSSEC":"secure",` +
+			`"SP":["kids_evening","night"],` +
 			`"Result":{` +
 			`"IsFiltered":true,` +
 			`"Reason":3,` +
@@ -89,6 +91,8 @@ func TestDecodeLogEntry(t *testing.T) {
 			Upstream:          "https://some.upstream",
 			Elapsed:           837429,
 			AuthenticatedData: true,
+			DNSSECStatus:      "secure",
+			SchedulePolicies:  []string{"kids_evening", "night"},
 		}
 
 		got := &logEntry{}
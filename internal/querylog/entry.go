@@ -40,6 +40,13 @@ type logEntry struct {
 
 	Cached            bool `json:",omitempty"`
 	AuthenticatedData bool `json:"AD,omitempty"`
+
+	// DNSSECStatus is the outcome of the local DNSSEC validation, if any.
+	DNSSECStatus string `json:"DNSSEC,omitempty"`
+
+	// SchedulePolicies are the names of the schedule policies active when the
+	// request was filtered.
+	SchedulePolicies []string `json:"SP,omitempty"`
 }
 
 // shallowClone returns a shallow clone of e.
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/tukimoto/AdGuardHome/internal/aghalg"
@@ -59,6 +60,9 @@ type getConfigResp struct {
 // Register web handlers
 func (l *queryLog) initWeb() {
 	l.conf.HTTPRegister(http.MethodGet, "/control/querylog", l.handleQueryLog)
+	l.conf.HTTPRegister(http.MethodGet, "/control/querylog/stream", l.handleQueryLogStream)
+	l.conf.HTTPRegister(http.MethodGet, "/control/querylog/top_domains", l.handleTopDomains)
+	l.conf.HTTPRegister(http.MethodGet, "/control/querylog/export", l.handleExport)
 	l.conf.HTTPRegister(http.MethodPost, "/control/querylog_clear", l.handleQueryLogClear)
 	l.conf.HTTPRegister(http.MethodGet, "/control/querylog/config", l.handleGetQueryLogConfig)
 	l.conf.HTTPRegister(
@@ -95,6 +99,112 @@ func (l *queryLog) handleQueryLog(w http.ResponseWriter, r *http.Request) {
 	aghhttp.WriteJSONResponseOK(w, r, resp)
 }
 
+// topDomainsResp is the response to the GET /control/querylog/top_domains HTTP
+// API.
+type topDomainsResp struct {
+	TopDomains []*domainCount `json:"top_domains"`
+}
+
+// Limits of the number of the top domains.
+const (
+	defaultTopDomainsLimit = 10
+	maxTopDomainsLimit     = 1000
+)
+
+// handleTopDomains is the handler for the GET /control/querylog/top_domains
+// HTTP API.
+func (l *queryLog) handleTopDomains(w http.ResponseWriter, r *http.Request) {
+	if l.index == nil {
+		aghhttp.Error(r, w, http.StatusNotImplemented, "indexed storage is not enabled")
+
+		return
+	}
+
+	q := r.URL.Query()
+	client := q.Get("client")
+	if client == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "client is required")
+
+		return
+	}
+
+	rng, err := parseIndexRange(q)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	limit := defaultTopDomainsLimit
+	if s := q.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > maxTopDomainsLimit {
+			aghhttp.Error(r, w, http.StatusBadRequest, "limit: bad value %q", s)
+
+			return
+		}
+	}
+
+	top, err := l.topDomains(client, rng, limit)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "%s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &topDomainsResp{
+		TopDomains: top,
+	})
+}
+
+// handleExport is the handler for the GET /control/querylog/export HTTP API.
+// It writes the entries in the format of the query log files.
+func (l *queryLog) handleExport(w http.ResponseWriter, r *http.Request) {
+	rng, err := parseIndexRange(r.URL.Query())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, ndjsonContentType)
+	h.Set(httphdr.ContentDisposition, `attachment; filename="querylog.json"`)
+
+	err = l.export(w, rng)
+	if err != nil {
+		// The headers have been sent already.
+		log.Error("querylog: exporting: %s", err)
+	}
+}
+
+// parseIndexRange parses the optional "from" and "to" query parameters in the
+// RFC 3339 format.
+func parseIndexRange(q url.Values) (r indexRange, err error) {
+	for _, p := range []struct {
+		t    *time.Time
+		name string
+	}{{
+		t:    &r.newerThan,
+		name: "from",
+	}, {
+		t:    &r.olderThan,
+		name: "to",
+	}} {
+		s := q.Get(p.name)
+		if s == "" {
+			continue
+		}
+
+		*p.t, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return indexRange{}, fmt.Errorf("%s: %w", p.name, err)
+		}
+	}
+
+	return r, nil
+}
+
 // handleQueryLogClear is the handler for the POST /control/querylog/clear HTTP
 // API.
 func (l *queryLog) handleQueryLogClear(_ http.ResponseWriter, _ *http.Request) {
@@ -343,6 +453,14 @@ func parseSearchParams(r *http.Request) (p *searchParams, err error) {
 		}
 	}
 
+	newerThan := q.Get("newer_than")
+	if len(newerThan) != 0 {
+		p.newerThan, err = time.Parse(time.RFC3339Nano, newerThan)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var limit64 int64
 	if limit64, err = strconv.ParseInt(q.Get("limit"), 10, 64); err == nil {
 		p.limit = int(limit64)
@@ -0,0 +1,484 @@
+package querylog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"go.etcd.io/bbolt"
+)
+
+// Storage types.
+const (
+	// StorageJSON stores the entries in the JSON files.
+	StorageJSON = "json"
+
+	// StorageIndexed stores the entries in a database indexed by time, domain
+	// name, and client.
+	StorageIndexed = "indexed"
+)
+
+// indexFileName is the name of the indexed storage database file.
+const indexFileName = "querylog.db"
+
+// Index buckets.
+//
+// The keys of bucketEntries are the entry keys, see [entryKey].  The values
+// are the client names at the time of writing prefixed with their length as
+// a varint and followed by the JSON-encoded entries.
+//
+// The keys of bucketDomains are the lowercased domain names followed by the
+// zero byte and the entry key.  The values are empty.
+//
+// The keys of bucketClients are the lowercased IP addresses, ClientIDs, and
+// client names followed by the zero byte and the entry key.  The values are
+// the domain names of the entries, or empty for the ratelimited ones, which
+// aren't counted in the top domains.
+var (
+	bucketEntries = []byte("entries")
+	bucketDomains = []byte("domains")
+	bucketClients = []byte("clients")
+)
+
+// entryKeyLen is the length of an entry key.
+const entryKeyLen = 16
+
+// entryKey returns the key of the entry with the given time and sequence
+// number.  Keys are ordered by time.
+func entryKey(t time.Time, seq uint64) (key []byte) {
+	key = make([]byte, 0, entryKeyLen)
+	key = binary.BigEndian.AppendUint64(key, uint64(t.UnixNano()))
+
+	return binary.BigEndian.AppendUint64(key, seq)
+}
+
+// timeKey returns the smallest key of the entries with the time not before t.
+// A zero t means no bound.
+func timeKey(t time.Time) (key []byte) {
+	if t.IsZero() {
+		return nil
+	}
+
+	return entryKey(t, 0)
+}
+
+// entryKeyTime returns the time of the entry with the given key.
+func entryKeyTime(key []byte) (t time.Time) {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
+
+// secondaryKey returns the key of the secondary index.
+func secondaryKey(val string, key []byte) (res []byte) {
+	res = make([]byte, 0, len(val)+1+len(key))
+	res = append(res, strings.ToLower(val)...)
+	res = append(res, 0)
+
+	return append(res, key...)
+}
+
+// indexedEntry is an entry prepared for writing into the index.
+type indexedEntry struct {
+	entry *logEntry
+
+	// clientName is the name of the persistent client, if any.
+	clientName string
+}
+
+// index is the query log storage indexed by time, domain name, and client.
+type index struct {
+	db *bbolt.DB
+
+	// maxSize is the maximum size of the stored data in bytes.
+	maxSize uint64
+}
+
+// openIndex opens or creates the index database at path.
+func openIndex(path string, maxSize uint64) (idx *index, err error) {
+	db, err := bbolt.Open(path, 0o644, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) (txErr error) {
+		for _, name := range [][]byte{bucketEntries, bucketDomains, bucketClients} {
+			_, txErr = tx.CreateBucketIfNotExists(name)
+			if txErr != nil {
+				return txErr
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithDeferred(fmt.Errorf("creating buckets: %w", err), db.Close())
+	}
+
+	return &index{
+		db:      db,
+		maxSize: maxSize,
+	}, nil
+}
+
+// close closes the database.
+func (idx *index) close() (err error) {
+	return idx.db.Close()
+}
+
+// add writes entries into the index.
+func (idx *index) add(entries []*indexedEntry) (err error) {
+	return idx.db.Update(func(tx *bbolt.Tx) (txErr error) {
+		eb := tx.Bucket(bucketEntries)
+		db := tx.Bucket(bucketDomains)
+		cb := tx.Bucket(bucketClients)
+
+		for _, ie := range entries {
+			txErr = putEntry(eb, db, cb, ie)
+			if txErr != nil {
+				return txErr
+			}
+		}
+
+		return nil
+	})
+}
+
+// putEntry writes a single entry into the buckets.
+func putEntry(eb, db, cb *bbolt.Bucket, ie *indexedEntry) (err error) {
+	e := ie.entry
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding entry: %w", err)
+	}
+
+	seq, err := eb.NextSequence()
+	if err != nil {
+		return fmt.Errorf("getting sequence: %w", err)
+	}
+
+	key := entryKey(e.Time, seq)
+
+	val := binary.AppendUvarint(nil, uint64(len(ie.clientName)))
+	val = append(val, ie.clientName...)
+	val = append(val, data...)
+
+	err = eb.Put(key, val)
+	if err != nil {
+		return fmt.Errorf("putting entry: %w", err)
+	}
+
+	err = db.Put(secondaryKey(e.QHost, key), nil)
+	if err != nil {
+		return fmt.Errorf("putting domain: %w", err)
+	}
+
+	var host []byte
+	if e.Result.Reason != filtering.Ratelimited {
+		host = []byte(e.QHost)
+	}
+
+	for _, id := range clientKeys(e, ie.clientName) {
+		err = cb.Put(secondaryKey(id, key), host)
+		if err != nil {
+			return fmt.Errorf("putting client: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// clientKeys returns the unique non-empty identifiers of the client of e.
+func clientKeys(e *logEntry, name string) (ids []string) {
+	for _, id := range []string{e.IP.String(), e.ClientID, name} {
+		id = strings.ToLower(id)
+		if id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// splitIndexValue splits the value of bucketEntries into the client name and
+// the JSON-encoded entry.
+func splitIndexValue(val []byte) (name string, data []byte, err error) {
+	l, n := binary.Uvarint(val)
+	if n <= 0 || uint64(len(val)-n) < l {
+		return "", nil, errors.Error("bad entry value")
+	}
+
+	return string(val[n : n+int(l)]), val[n+int(l):], nil
+}
+
+// decodeIndexValue decodes the value of bucketEntries.
+func decodeIndexValue(val []byte) (e *logEntry, name string, err error) {
+	name, data, err := splitIndexValue(val)
+	if err != nil {
+		return nil, "", err
+	}
+
+	e = &logEntry{}
+	err = json.Unmarshal(data, e)
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding entry: %w", err)
+	}
+
+	return e, name, nil
+}
+
+// clear removes all entries from the index.
+func (idx *index) clear() (err error) {
+	return idx.db.Update(func(tx *bbolt.Tx) (txErr error) {
+		for _, name := range [][]byte{bucketEntries, bucketDomains, bucketClients} {
+			txErr = tx.DeleteBucket(name)
+			if txErr != nil && !errors.Is(txErr, bbolt.ErrBucketNotFound) {
+				return txErr
+			}
+
+			_, txErr = tx.CreateBucket(name)
+			if txErr != nil {
+				return txErr
+			}
+		}
+
+		return nil
+	})
+}
+
+// cleanupBatchSize is the number of entries removed in a single transaction
+// when the index is too large.
+const cleanupBatchSize = 1000
+
+// cleanup removes the entries older than olderThan and then the oldest entries
+// until the size of the stored data is within the limit.  olderThan must not be
+// zero.
+func (idx *index) cleanup(olderThan time.Time) (err error) {
+	for {
+		var n int
+		n, err = idx.removeOldest(olderThan, cleanupBatchSize)
+		if err != nil {
+			return fmt.Errorf("removing old entries: %w", err)
+		} else if n < cleanupBatchSize {
+			break
+		}
+	}
+
+	return idx.shrink()
+}
+
+// shrink removes the oldest entries until the size of the stored data is
+// within the limit.
+func (idx *index) shrink() (err error) {
+	for idx.usedSize() > idx.maxSize {
+		var n int
+		n, err = idx.removeOldest(time.Time{}, cleanupBatchSize)
+		if err != nil {
+			return fmt.Errorf("removing oldest entries: %w", err)
+		} else if n == 0 {
+			break
+		}
+	}
+
+	return nil
+}
+
+// usedSize returns the approximate size of the stored data.  The database file
+// doesn't shrink, but the freed pages are reused.
+func (idx *index) usedSize() (size uint64) {
+	_ = idx.db.View(func(tx *bbolt.Tx) (_ error) {
+		size = uint64(max(tx.Size()-int64(idx.db.Stats().FreeAlloc), 0))
+
+		return nil
+	})
+
+	return size
+}
+
+// removeOldest removes up to limit oldest entries.  If olderThan isn't zero,
+// only the entries older than it are removed.
+func (idx *index) removeOldest(olderThan time.Time, limit int) (n int, err error) {
+	err = idx.db.Update(func(tx *bbolt.Tx) (txErr error) {
+		eb := tx.Bucket(bucketEntries)
+		db := tx.Bucket(bucketDomains)
+		cb := tx.Bucket(bucketClients)
+
+		bound := timeKey(olderThan)
+		c := eb.Cursor()
+		for k, v := c.First(); k != nil && n < limit; k, v = c.First() {
+			if bound != nil && bytes.Compare(k, bound) >= 0 {
+				break
+			}
+
+			e, name, decErr := decodeIndexValue(v)
+			if decErr == nil {
+				txErr = errors.Join(
+					db.Delete(secondaryKey(e.QHost, k)),
+					deleteClientKeys(cb, e, name, k),
+				)
+			} else {
+				log.Debug("querylog: index: removing bad entry: %s", decErr)
+			}
+
+			txErr = errors.WithDeferred(txErr, c.Delete())
+			if txErr != nil {
+				return txErr
+			}
+
+			n++
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+// deleteClientKeys removes the client index keys of the entry.
+func deleteClientKeys(cb *bbolt.Bucket, e *logEntry, name string, key []byte) (err error) {
+	var errs []error
+	for _, id := range clientKeys(e, name) {
+		errs = append(errs, cb.Delete(secondaryKey(id, key)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// indexRange is the time range of the entries, where the zero values mean no
+// bound.  newerThan is inclusive and olderThan is exclusive.
+type indexRange struct {
+	newerThan time.Time
+	olderThan time.Time
+}
+
+// iterFunc is called for each entry key with the corresponding value of the
+// bucket.  It returns false to stop the iteration.
+type iterFunc = func(key, val []byte) (cont bool)
+
+// reverseRange calls f for the entries in bucketEntries within r, newest
+// first.
+func reverseRange(b *bbolt.Bucket, r indexRange, f iterFunc) {
+	c := b.Cursor()
+	lower := timeKey(r.newerThan)
+
+	var k, v []byte
+	if upper := timeKey(r.olderThan); upper == nil {
+		k, v = c.Last()
+	} else if k, _ = c.Seek(upper); k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+
+	for ; k != nil; k, v = c.Prev() {
+		if lower != nil && bytes.Compare(k, lower) < 0 {
+			return
+		}
+
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// prefixCursor iterates over the entry keys of a secondary index with the
+// given identifier, newest first.
+type prefixCursor struct {
+	c      *bbolt.Cursor
+	prefix []byte
+
+	// key is the current entry key.  It's nil if the cursor is exhausted.
+	key []byte
+
+	// val is the current value.
+	val []byte
+
+	// lower is the smallest allowed entry key.  It's nil if there is no
+	// lower bound.
+	lower []byte
+}
+
+// newPrefixCursor returns a new cursor positioned at the newest entry key with
+// the given identifier within r.
+func newPrefixCursor(b *bbolt.Bucket, id string, r indexRange) (pc *prefixCursor) {
+	pc = &prefixCursor{
+		c:      b.Cursor(),
+		prefix: secondaryKey(id, nil),
+		lower:  timeKey(r.newerThan),
+	}
+
+	var seekKey []byte
+	if r.olderThan.IsZero() {
+		// Seek to the position right after all the keys with the prefix.
+		seekKey = bytes.Clone(pc.prefix)
+		seekKey[len(seekKey)-1] = 1
+	} else {
+		seekKey = append(bytes.Clone(pc.prefix), timeKey(r.olderThan)...)
+	}
+
+	var k, v []byte
+	if k, _ = pc.c.Seek(seekKey); k == nil {
+		k, v = pc.c.Last()
+	} else {
+		k, v = pc.c.Prev()
+	}
+
+	pc.set(k, v)
+
+	return pc
+}
+
+// set sets the current position of the cursor to k if it's within the prefix
+// and the lower bound.
+func (pc *prefixCursor) set(k, v []byte) {
+	pc.key, pc.val = nil, nil
+
+	entKey, ok := bytes.CutPrefix(k, pc.prefix)
+	if !ok || len(entKey) != entryKeyLen {
+		return
+	}
+
+	if pc.lower != nil && bytes.Compare(entKey, pc.lower) < 0 {
+		return
+	}
+
+	pc.key, pc.val = entKey, v
+}
+
+// next moves the cursor to the previous key.
+func (pc *prefixCursor) next() {
+	pc.set(pc.c.Prev())
+}
+
+// mergeReverse calls f for the entry keys of all cursors, newest first.  The
+// keys present in several cursors are only passed once.
+func mergeReverse(cursors []*prefixCursor, f iterFunc) {
+	var last []byte
+	for {
+		var cur *prefixCursor
+		for _, pc := range cursors {
+			if pc.key != nil && (cur == nil || bytes.Compare(pc.key, cur.key) > 0) {
+				cur = pc
+			}
+		}
+
+		if cur == nil {
+			return
+		}
+
+		if !bytes.Equal(cur.key, last) {
+			last = bytes.Clone(cur.key)
+			if !f(cur.key, cur.val) {
+				return
+			}
+		}
+
+		cur.next()
+	}
+}
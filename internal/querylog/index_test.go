@@ -0,0 +1,282 @@
+package querylog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"go.etcd.io/bbolt"
+)
+
+// newIndexedQueryLog returns a new query log with the indexed storage.
+func newIndexedQueryLog(t *testing.T) (l *queryLog) {
+	t.Helper()
+
+	l, err := newQueryLog(Config{
+		Enabled:      true,
+		FileEnabled:  true,
+		RotationIvl:  timeutil.Day,
+		MemSize:      100,
+		BaseDir:      t.TempDir(),
+		Storage:      StorageIndexed,
+		IndexMaxSize: 1 << 30,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, l.index.close()) })
+
+	return l
+}
+
+func TestQueryLog_indexed(t *testing.T) {
+	l := newIndexedQueryLog(t)
+
+	// Add entries to the index.
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+	addEntry(l, "test.example.org", net.IPv4(1, 1, 1, 3), net.IPv4(2, 2, 2, 1))
+	require.NoError(t, l.flushLogBuffer())
+	require.Zero(t, l.buffer.Len())
+
+	// Add memory entries.
+	addEntry(l, "example.com", net.IPv4(1, 1, 1, 4), net.IPv4(2, 2, 2, 1))
+
+	testCases := []struct {
+		name      string
+		sCr       []searchCriterion
+		wantHosts []string
+	}{{
+		name:      "all",
+		sCr:       nil,
+		wantHosts: []string{"example.com", "test.example.org", "example.org", "example.org"},
+	}, {
+		name: "by_domain_strict",
+		sCr: []searchCriterion{{
+			criterionType: ctTerm,
+			strict:        true,
+			value:         "EXAMPLE.org",
+		}},
+		wantHosts: []string{"example.org", "example.org"},
+	}, {
+		name: "by_client_strict",
+		sCr: []searchCriterion{{
+			criterionType: ctTerm,
+			strict:        true,
+			value:         "2.2.2.1",
+		}},
+		wantHosts: []string{"example.com", "test.example.org", "example.org"},
+	}, {
+		name: "by_domain_non-strict",
+		sCr: []searchCriterion{{
+			criterionType: ctTerm,
+			strict:        false,
+			value:         "test",
+		}},
+		wantHosts: []string{"test.example.org"},
+	}, {
+		name: "none",
+		sCr: []searchCriterion{{
+			criterionType: ctTerm,
+			strict:        true,
+			value:         "example.net",
+		}},
+		wantHosts: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := newSearchParams()
+			params.searchCriteria = tc.sCr
+
+			entries, _ := l.search(params)
+
+			var hosts []string
+			for _, e := range entries {
+				hosts = append(hosts, e.QHost)
+			}
+
+			assert.Equal(t, tc.wantHosts, hosts)
+		})
+	}
+}
+
+// addIndexed writes an entry with the given parameters directly into the
+// index.
+func addIndexed(t *testing.T, idx *index, host, client string, tm time.Time) {
+	t.Helper()
+
+	err := idx.add([]*indexedEntry{{
+		entry: &logEntry{
+			Time:   tm,
+			QHost:  host,
+			QType:  "A",
+			QClass: "IN",
+			IP:     net.ParseIP(client),
+		},
+	}})
+	require.NoError(t, err)
+}
+
+func TestIndex_timeRange(t *testing.T) {
+	l := newIndexedQueryLog(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 10 {
+		addIndexed(t, l.index, "example.org", "1.2.3.4", start.Add(time.Duration(i)*time.Hour))
+	}
+
+	t.Run("search", func(t *testing.T) {
+		params := newSearchParams()
+		params.newerThan = start.Add(2 * time.Hour)
+		params.olderThan = start.Add(5 * time.Hour)
+
+		entries, _ := l.search(params)
+		require.Len(t, entries, 3)
+
+		assert.Equal(t, start.Add(4*time.Hour), entries[0].Time.UTC())
+		assert.Equal(t, start.Add(2*time.Hour), entries[2].Time.UTC())
+	})
+
+	t.Run("search_strict", func(t *testing.T) {
+		params := newSearchParams()
+		params.newerThan = start.Add(8 * time.Hour)
+		params.searchCriteria = []searchCriterion{{
+			criterionType: ctTerm,
+			strict:        true,
+			value:         "1.2.3.4",
+		}}
+
+		entries, _ := l.search(params)
+		require.Len(t, entries, 2)
+
+		assert.Equal(t, start.Add(9*time.Hour), entries[0].Time.UTC())
+	})
+
+	t.Run("export", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := l.export(buf, indexRange{
+			newerThan: start.Add(7 * time.Hour),
+		})
+		require.NoError(t, err)
+
+		var times []time.Time
+		s := bufio.NewScanner(buf)
+		for s.Scan() {
+			e := &logEntry{}
+			require.NoError(t, json.Unmarshal(s.Bytes(), e))
+
+			times = append(times, e.Time.UTC())
+		}
+
+		assert.Equal(t, []time.Time{
+			start.Add(7 * time.Hour),
+			start.Add(8 * time.Hour),
+			start.Add(9 * time.Hour),
+		}, times)
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		require.NoError(t, l.index.cleanup(start.Add(6*time.Hour)))
+
+		params := newSearchParams()
+		entries, _ := l.search(params)
+		require.Len(t, entries, 4)
+
+		assert.Equal(t, start.Add(6*time.Hour), entries[3].Time.UTC())
+	})
+}
+
+func TestIndex_topDomains(t *testing.T) {
+	l := newIndexedQueryLog(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, host := range []string{
+		"a.example", "b.example", "b.example", "c.example", "c.example", "c.example",
+	} {
+		addIndexed(t, l.index, host, "1.2.3.4", start.Add(time.Duration(i)*time.Minute))
+		addIndexed(t, l.index, "other.example", "5.6.7.8", start.Add(time.Duration(i)*time.Minute))
+	}
+
+	// The ratelimited queries aren't counted.
+	for i := range 4 {
+		err := l.index.add([]*indexedEntry{{
+			entry: &logEntry{
+				Time:   start.Add(time.Duration(i) * time.Minute),
+				QHost:  "limited.example",
+				QType:  "A",
+				QClass: "IN",
+				IP:     net.ParseIP("1.2.3.4"),
+				Result: filtering.Result{Reason: filtering.Ratelimited},
+			},
+		}})
+		require.NoError(t, err)
+	}
+
+	top, err := l.topDomains("1.2.3.4", indexRange{}, 2)
+	require.NoError(t, err)
+
+	assert.Equal(t, []*domainCount{{
+		Domain: "c.example",
+		Count:  3,
+	}, {
+		Domain: "b.example",
+		Count:  2,
+	}}, top)
+
+	top, err = l.topDomains("1.2.3.4", indexRange{olderThan: start.Add(3 * time.Minute)}, 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, []*domainCount{{
+		Domain: "b.example",
+		Count:  2,
+	}, {
+		Domain: "a.example",
+		Count:  1,
+	}}, top)
+}
+
+func TestIndex_shrink(t *testing.T) {
+	idx, err := openIndex(filepath.Join(t.TempDir(), indexFileName), 1<<30)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, idx.close()) })
+
+	start := time.Now()
+	for i := range 2 * cleanupBatchSize {
+		addIndexed(t, idx, "example.org", "1.2.3.4", start.Add(time.Duration(i)*time.Second))
+	}
+
+	size := idx.usedSize()
+	idx.maxSize = size * 3 / 4
+
+	require.NoError(t, idx.shrink())
+	assert.LessOrEqual(t, idx.usedSize(), idx.maxSize)
+
+	// The newest entry must be kept.
+	var last time.Time
+	reverseRange(idxBucket(t, idx), indexRange{}, func(key, _ []byte) (cont bool) {
+		last = entryKeyTime(key)
+
+		return false
+	})
+
+	assert.Equal(t, start.Add((2*cleanupBatchSize-1)*time.Second).UnixNano(), last.UnixNano())
+}
+
+// idxBucket returns the entries bucket from a read-only transaction, which is
+// rolled back at the end of the test.
+func idxBucket(t *testing.T, idx *index) (b *bbolt.Bucket) {
+	t.Helper()
+
+	tx, err := idx.db.Begin(false)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tx.Rollback() })
+
+	return tx.Bucket(bucketEntries)
+}
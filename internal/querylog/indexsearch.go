@@ -0,0 +1,437 @@
+package querylog
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/tukimoto/AdGuardHome/internal/filtering"
+	"go.etcd.io/bbolt"
+)
+
+// flushToIndex moves the buffered entries into the index.
+func (l *queryLog) flushToIndex() (err error) {
+	var entries []*logEntry
+	func() {
+		l.bufferLock.Lock()
+		defer l.bufferLock.Unlock()
+
+		l.buffer.Range(func(e *logEntry) (cont bool) {
+			entries = append(entries, e)
+
+			return true
+		})
+
+		l.buffer.Clear()
+		l.flushPending = false
+	}()
+
+	if len(entries) == 0 {
+		return errors.Error("nothing to write to the index")
+	}
+
+	start := time.Now()
+
+	cache := clientCache{}
+	indexed := make([]*indexedEntry, 0, len(entries))
+	for _, e := range entries {
+		ie := &indexedEntry{
+			entry: e,
+		}
+
+		c, cErr := l.client(e.ClientID, e.IP.String(), cache)
+		if cErr != nil {
+			log.Debug("querylog: finding client for index: %s", cErr)
+		} else if c != nil {
+			ie.clientName = c.Name
+		}
+
+		indexed = append(indexed, ie)
+	}
+
+	err = l.index.add(indexed)
+	if err != nil {
+		return fmt.Errorf("writing to index: %w", err)
+	}
+
+	log.Debug("querylog: %d entries written to index in %s", len(entries), time.Since(start))
+
+	return l.index.shrink()
+}
+
+// strictTerm returns the strict term criterion of the search parameters, if
+// any.
+func (s *searchParams) strictTerm() (c searchCriterion, ok bool) {
+	for _, c = range s.searchCriteria {
+		if c.criterionType == ctTerm && c.strict {
+			return c, true
+		}
+	}
+
+	return searchCriterion{}, false
+}
+
+// termCursors returns the cursors over the keys of the entries which domain
+// name or client may be equal to the term of c.
+//
+// NOTE:  Entries are indexed by the name the persistent client had at the time
+// of writing, so renamed clients are only found by their IP addresses and
+// ClientIDs.
+func termCursors(tx *bbolt.Tx, c searchCriterion, r indexRange) (cursors []*prefixCursor) {
+	db := tx.Bucket(bucketDomains)
+
+	cursors = []*prefixCursor{
+		newPrefixCursor(db, c.value, r),
+		newPrefixCursor(tx.Bucket(bucketClients), c.value, r),
+	}
+
+	if c.asciiVal != "" {
+		cursors = append(cursors, newPrefixCursor(db, c.asciiVal, r))
+	}
+
+	return cursors
+}
+
+// searchIndex looks up log records in the index.  It optionally uses the client
+// cache, if provided.  Like [queryLog.searchFiles], it doesn't scan more than
+// maxFileScanEntries.  oldest and total are the time of the oldest processed
+// entry and the total number of processed entries, including discarded ones,
+// correspondingly.  oldest is zero if there are no more entries to process.
+func (l *queryLog) searchIndex(
+	params *searchParams,
+	cache clientCache,
+) (entries []*logEntry, oldest time.Time, total int) {
+	totalLimit := params.offset + params.limit
+	r := indexRange{
+		newerThan: params.newerThan,
+		olderThan: params.olderThan,
+	}
+
+	stopped := false
+	err := l.index.db.View(func(tx *bbolt.Tx) (_ error) {
+		eb := tx.Bucket(bucketEntries)
+		f := func(key, val []byte) (cont bool) {
+			if params.maxFileScanEntries > 0 && total >= params.maxFileScanEntries {
+				stopped = true
+
+				return false
+			}
+
+			total++
+			oldest = entryKeyTime(key)
+
+			e := l.indexedEntry(val, params, cache)
+			if e == nil {
+				return true
+			}
+
+			entries = append(entries, e)
+			stopped = len(entries) == totalLimit
+
+			return !stopped
+		}
+
+		if c, ok := params.strictTerm(); ok {
+			mergeReverse(termCursors(tx, c, r), func(key, _ []byte) (cont bool) {
+				return f(key, eb.Get(key))
+			})
+		} else {
+			reverseRange(eb, r, f)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Error("querylog: searching index: %s", err)
+	}
+
+	if !stopped {
+		oldest = time.Time{}
+	}
+
+	return entries, oldest, total
+}
+
+// indexedEntry decodes the value of the index entry and checks if it matches
+// the search criteria.  e is nil if the entry doesn't match.
+func (l *queryLog) indexedEntry(val []byte, params *searchParams, cache clientCache) (e *logEntry) {
+	e, _, err := decodeIndexValue(val)
+	if err != nil {
+		log.Debug("querylog: index: %s", err)
+
+		return nil
+	}
+
+	if l.isIgnored(e.QHost) {
+		return nil
+	}
+
+	e.client, err = l.client(e.ClientID, e.IP.String(), cache)
+	if err != nil {
+		log.Error(
+			"querylog: enriching index record at time %s for client %q (clientid %q): %s",
+			e.Time,
+			e.IP,
+			e.ClientID,
+			err,
+		)
+
+		// Go on and try to match anyway.
+	}
+
+	if e.client != nil && e.client.IgnoreQueryLog {
+		return nil
+	}
+
+	if !params.match(e) {
+		return nil
+	}
+
+	return e
+}
+
+// domainCount is the number of queries for a domain name.
+type domainCount struct {
+	Domain string `json:"domain"`
+	Count  uint64 `json:"count"`
+}
+
+// topDomains returns up to limit domain names most queried by the client
+// within r.  The ratelimited queries aren't counted.  client is an IP address, a ClientID, or a name of a persistent
+// client.  The index must be enabled.
+func (l *queryLog) topDomains(client string, r indexRange, limit int) (top []*domainCount, err error) {
+	client = strings.ToLower(client)
+	counts := map[string]uint64{}
+
+	err = l.index.db.View(func(tx *bbolt.Tx) (_ error) {
+		pc := newPrefixCursor(tx.Bucket(bucketClients), client, r)
+		for ; pc.key != nil; pc.next() {
+			if len(pc.val) > 0 {
+				counts[string(pc.val)]++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+
+	l.countMemoryDomains(client, r, counts)
+
+	top = make([]*domainCount, 0, len(counts))
+	for d, n := range counts {
+		top = append(top, &domainCount{Domain: d, Count: n})
+	}
+
+	slices.SortFunc(top, func(a, b *domainCount) (res int) {
+		if res = cmp.Compare(b.Count, a.Count); res != 0 {
+			return res
+		}
+
+		return strings.Compare(a.Domain, b.Domain)
+	})
+
+	return top[:min(len(top), limit)], nil
+}
+
+// countMemoryDomains adds the domain names queried by the client within r to
+// counts from the entries in the memory buffer.  client must be lowercased.
+func (l *queryLog) countMemoryDomains(client string, r indexRange, counts map[string]uint64) {
+	l.bufferLock.Lock()
+	defer l.bufferLock.Unlock()
+
+	cache := clientCache{}
+	l.buffer.Range(func(e *logEntry) (cont bool) {
+		if !r.contains(e.Time) || e.Result.Reason == filtering.Ratelimited {
+			return true
+		}
+
+		var name string
+		if c, _ := l.client(e.ClientID, e.IP.String(), cache); c != nil {
+			name = c.Name
+		}
+
+		if slices.Contains(clientKeys(e, name), client) {
+			counts[e.QHost]++
+		}
+
+		return true
+	})
+}
+
+// contains returns true if t is within r.
+func (r indexRange) contains(t time.Time) (ok bool) {
+	return (r.newerThan.IsZero() || !t.Before(r.newerThan)) &&
+		(r.olderThan.IsZero() || t.Before(r.olderThan))
+}
+
+// exportBatchSize is the number of entries read from the index in a single
+// transaction while exporting.
+const exportBatchSize = 1000
+
+// export writes the JSON-encoded entries within r to w, oldest first, one per
+// line, in the format of the query log files.
+func (l *queryLog) export(w io.Writer, r indexRange) (err error) {
+	if l.index != nil {
+		err = l.index.export(w, r)
+	} else {
+		err = l.exportFiles(w, r)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return l.exportMemory(w, r)
+}
+
+// export writes the JSON-encoded entries within r to w, oldest first.  It
+// reads the entries in batches to avoid holding a read transaction for the
+// whole time of writing.
+func (idx *index) export(w io.Writer, r indexRange) (err error) {
+	next := timeKey(r.newerThan)
+	upper := timeKey(r.olderThan)
+
+	for {
+		var batch [][]byte
+		n := 0
+		err = idx.db.View(func(tx *bbolt.Tx) (txErr error) {
+			c := tx.Bucket(bucketEntries).Cursor()
+
+			var k, v []byte
+			if next == nil {
+				k, v = c.First()
+			} else {
+				k, v = c.Seek(next)
+			}
+
+			for ; k != nil && n < exportBatchSize; k, v = c.Next() {
+				if upper != nil && bytes.Compare(k, upper) >= 0 {
+					k = nil
+
+					break
+				}
+
+				n++
+
+				_, data, splitErr := splitIndexValue(v)
+				if splitErr != nil {
+					log.Debug("querylog: index: exporting: %s", splitErr)
+
+					continue
+				}
+
+				// Copy the data, since it's only valid within the
+				// transaction.
+				batch = append(batch, append(bytes.Clone(data), '\n'))
+			}
+
+			next = bytes.Clone(k)
+
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("reading index: %w", err)
+		}
+
+		for _, line := range batch {
+			_, err = w.Write(line)
+			if err != nil {
+				return fmt.Errorf("writing: %w", err)
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+	}
+}
+
+// exportFiles writes the lines of the query log files with the entries within
+// r to w, oldest first.
+func (l *queryLog) exportFiles(w io.Writer, r indexRange) (err error) {
+	for _, fn := range []string{l.logFile + ".1", l.logFile} {
+		err = exportFile(w, fn, r)
+		if err != nil {
+			return fmt.Errorf("exporting %q: %w", fn, err)
+		}
+	}
+
+	return nil
+}
+
+// exportFile writes the lines of the query log file with the entries within r
+// to w.  It's not an error if the file doesn't exist.
+func exportFile(w io.Writer, fn string, r indexRange) (err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	br := bufio.NewReader(f)
+	for {
+		var line []byte
+		line, err = br.ReadBytes('\n')
+		if err != nil {
+			// Ignore the last line if it's incomplete, since it's being
+			// written at the moment.
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		if !r.contains(time.Unix(0, readQLogTimestamp(string(line)))) {
+			continue
+		}
+
+		_, err = w.Write(line)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// exportMemory writes the JSON-encoded entries within r from the memory
+// buffer to w, oldest first.
+func (l *queryLog) exportMemory(w io.Writer, r indexRange) (err error) {
+	var entries []*logEntry
+	func() {
+		l.bufferLock.Lock()
+		defer l.bufferLock.Unlock()
+
+		l.buffer.Range(func(e *logEntry) (cont bool) {
+			if r.contains(e.Time) {
+				entries = append(entries, e)
+			}
+
+			return true
+		})
+	}()
+
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		err = enc.Encode(e)
+		if err != nil {
+			return fmt.Errorf("encoding entry: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -85,6 +85,14 @@ func entryToJSON(entry *logEntry, anonFunc aghnet.IPMutFunc) (jsonEntry jobject)
 		jsonEntry["ecs"] = entry.ReqECS
 	}
 
+	if entry.DNSSECStatus != "" {
+		jsonEntry["dnssec_status"] = entry.DNSSECStatus
+	}
+
+	if len(entry.SchedulePolicies) > 0 {
+		jsonEntry["schedule_policies"] = entry.SchedulePolicies
+	}
+
 	if len(entry.Result.Rules) > 0 {
 		if r := entry.Result.Rules[0]; len(r.Text) > 0 {
 			jsonEntry["rule"] = r.Text
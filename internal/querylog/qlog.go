@@ -3,7 +3,10 @@ package querylog
 
 import (
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -34,6 +37,20 @@ type queryLog struct {
 	// be modified.
 	buffer *container.RingBuffer[*logEntry]
 
+	// subsMu protects subs.
+	subsMu *sync.Mutex
+
+	// subs are the live tail clients.
+	subs map[*subscriber]struct{}
+
+	// index is the indexed storage of the entries.  It's nil if the entries
+	// are stored in the JSON files.
+	index *index
+
+	// sinks are the external destinations of the entries.  The slice isn't
+	// modified after creation.
+	sinks []*sink
+
 	// logFile is the path to the log file.
 	logFile string
 
@@ -81,10 +98,15 @@ func (l *queryLog) Start() {
 		l.initWeb()
 	}
 
+	l.startSinks()
+
 	go l.periodicRotate()
 }
 
 func (l *queryLog) Close() {
+	l.closeSubscribers()
+	l.closeSinks()
+
 	l.confMu.RLock()
 	defer l.confMu.RUnlock()
 
@@ -94,6 +116,13 @@ func (l *queryLog) Close() {
 			log.Error("querylog: closing: %s", err)
 		}
 	}
+
+	if l.index != nil {
+		err := l.index.close()
+		if err != nil {
+			log.Error("querylog: closing index: %s", err)
+		}
+	}
 }
 
 func checkInterval(ivl time.Duration) (ok bool) {
@@ -143,6 +172,13 @@ func (l *queryLog) clear() {
 		l.flushPending = false
 	}()
 
+	if l.index != nil {
+		err := l.index.clear()
+		if err != nil {
+			log.Error("querylog: clearing index: %s", err)
+		}
+	}
+
 	oldLogFile := l.logFile + ".1"
 	err := os.Remove(oldLogFile)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
@@ -157,8 +193,9 @@ func (l *queryLog) clear() {
 	log.Debug("querylog: cleared")
 }
 
-// newLogEntry creates an instance of logEntry from parameters.
-func newLogEntry(params *AddParams) (entry *logEntry) {
+// newLogEntry creates an instance of logEntry from parameters.  The client
+// subnet from the EDNS Client-Subnet option is anonymized with anonFunc.
+func newLogEntry(params *AddParams, anonFunc aghnet.IPMutFunc) (entry *logEntry) {
 	q := params.Question.Question[0]
 	qHost := aghnet.NormalizeDomain(q.Name)
 
@@ -181,10 +218,12 @@ func newLogEntry(params *AddParams) (entry *logEntry) {
 
 		Cached:            params.Cached,
 		AuthenticatedData: params.AuthenticatedData,
+		DNSSECStatus:      params.DNSSECStatus.String(),
+		SchedulePolicies:  params.SchedulePolicies,
 	}
 
 	if params.ReqECS != nil {
-		entry.ReqECS = params.ReqECS.String()
+		entry.ReqECS = anonymizeECS(params.ReqECS, anonFunc)
 	}
 
 	entry.addResponse(params.Answer, false)
@@ -193,6 +232,15 @@ func newLogEntry(params *AddParams) (entry *logEntry) {
 	return entry
 }
 
+// anonymizeECS returns the string representation of the client subnet ecs with
+// its address anonymized by anonFunc.  ecs must not be nil.
+func anonymizeECS(ecs *net.IPNet, anonFunc aghnet.IPMutFunc) (s string) {
+	ip := slices.Clone(ecs.IP)
+	anonFunc(ip)
+
+	return (&net.IPNet{IP: ip.Mask(ecs.Mask), Mask: ecs.Mask}).String()
+}
+
 // Add implements the [QueryLog] interface for *queryLog.
 func (l *queryLog) Add(params *AddParams) {
 	var isEnabled, fileIsEnabled bool
@@ -220,7 +268,10 @@ func (l *queryLog) Add(params *AddParams) {
 		params.Result = &filtering.Result{}
 	}
 
-	entry := newLogEntry(params)
+	entry := newLogEntry(params, l.anonymizer.Load())
+
+	l.publish(entry)
+	l.sendToSinks(entry)
 
 	l.bufferLock.Lock()
 	defer l.bufferLock.Unlock()
@@ -262,3 +313,38 @@ func (l *queryLog) ShouldLog(host string, _, _ uint16, ids []string) bool {
 func (l *queryLog) isIgnored(host string) bool {
 	return l.conf.Ignored.Has(host)
 }
+
+// RecentQueries returns the most recent logged queries, up to limit.
+func (l *queryLog) RecentQueries(limit int) (queries []*filtering.PreviewQuery) {
+	params := newSearchParams()
+	params.limit = limit
+
+	var entries []*logEntry
+	func() {
+		l.confMu.RLock()
+		defer l.confMu.RUnlock()
+
+		entries, _ = l.search(params)
+	}()
+
+	queries = make([]*filtering.PreviewQuery, 0, len(entries))
+	for _, e := range entries {
+		q := &filtering.PreviewQuery{
+			ClientID: e.ClientID,
+			Host:     e.QHost,
+			QType:    dns.StringToType[e.QType],
+		}
+
+		if ip, ok := netip.AddrFromSlice(e.IP); ok {
+			q.ClientIP = ip.Unmap()
+		}
+
+		if e.client != nil {
+			q.ClientName = e.client.Name
+		}
+
+		queries = append(queries, q)
+	}
+
+	return queries
+}
@@ -394,3 +394,41 @@ func assertLogEntry(t *testing.T, entry *logEntry, host string, answer, client n
 	a := testutil.RequireTypeAssert[*dns.A](t, msg.Answer[0])
 	assert.Equal(t, answer, a.A.To16())
 }
+
+func TestAnonymizeECS(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ecs      string
+		anonFunc aghnet.IPMutFunc
+		want     string
+	}{{
+		name:     "v4_off",
+		ecs:      "1.2.3.0/24",
+		anonFunc: func(net.IP) {},
+		want:     "1.2.3.0/24",
+	}, {
+		name:     "v4",
+		ecs:      "1.2.3.0/24",
+		anonFunc: AnonymizeIP,
+		want:     "1.2.0.0/24",
+	}, {
+		name:     "v4_full",
+		ecs:      "1.2.3.4/32",
+		anonFunc: AnonymizeIP,
+		want:     "1.2.0.0/32",
+	}, {
+		name:     "v6",
+		ecs:      "2001:db8:1:2::/64",
+		anonFunc: AnonymizeIP,
+		want:     "2001:db8:1::/64",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ecs, err := net.ParseCIDR(tc.ecs)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, anonymizeECS(ecs, tc.anonFunc))
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/miekg/dns"
 	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
 	"github.com/tukimoto/AdGuardHome/internal/aghnet"
+	"github.com/tukimoto/AdGuardHome/internal/dnssec"
 	"github.com/tukimoto/AdGuardHome/internal/filtering"
 )
 
@@ -30,6 +31,9 @@ type QueryLog interface {
 
 	// ShouldLog returns true if request for the host should be logged.
 	ShouldLog(host string, qType, qClass uint16, ids []string) bool
+
+	// RecentQueries returns the most recent logged queries, up to limit.
+	RecentQueries(limit int) (queries []*filtering.PreviewQuery)
 }
 
 // Config is the query log configuration structure.
@@ -40,7 +44,8 @@ type Config struct {
 	// log, and matches them.
 	Ignored *aghnet.IgnoreEngine
 
-	// Anonymizer processes the IP addresses to anonymize those if needed.
+	// Anonymizer processes the IP addresses to anonymize those if needed.  If
+	// nil, the addresses aren't anonymized.
 	Anonymizer *aghnet.IPMut
 
 	// ConfigModified is called when the configuration is changed, for example
@@ -53,6 +58,14 @@ type Config struct {
 	// FindClient returns client information by their IDs.
 	FindClient func(ids []string) (c *Client, err error)
 
+	// Sinks are the configurations of the external destinations of the log
+	// entries.  The disabled ones are ignored.
+	Sinks []*SinkConfig
+
+	// Storage is the type of the persistent storage of the entries:
+	// [StorageJSON] or [StorageIndexed].  If empty, [StorageJSON] is used.
+	Storage string
+
 	// BaseDir is the base directory for log files.
 	BaseDir string
 
@@ -61,6 +74,11 @@ type Config struct {
 	// is twice the interval.
 	RotationIvl time.Duration
 
+	// IndexMaxSize is the maximum size of the data in the indexed storage in
+	// bytes.  The oldest entries are removed to keep the size within the
+	// limit.  It must be positive if Storage is [StorageIndexed].
+	IndexMaxSize uint64
+
 	// MemSize is the number of entries kept in a memory buffer before they are
 	// flushed to disk.
 	MemSize uint
@@ -111,6 +129,14 @@ type AddParams struct {
 
 	// AuthenticatedData shows if the response had the AD bit set.
 	AuthenticatedData bool
+
+	// DNSSECStatus is the outcome of the local DNSSEC validation of the
+	// response, if any.
+	DNSSECStatus dnssec.Status
+
+	// SchedulePolicies are the names of the schedule policies active when the
+	// request was filtered.
+	SchedulePolicies []string
 }
 
 // validate returns an error if the parameters aren't valid.
@@ -150,16 +176,24 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 		memSize = 1
 	}
 
+	anonymizer := conf.Anonymizer
+	if anonymizer == nil {
+		anonymizer = aghnet.NewIPMut(nil)
+	}
+
 	l = &queryLog{
 		findClient: findClient,
 
 		buffer: container.NewRingBuffer[*logEntry](memSize),
 
+		subsMu: &sync.Mutex{},
+		subs:   map[*subscriber]struct{}{},
+
 		conf:    &Config{},
 		confMu:  &sync.RWMutex{},
 		logFile: filepath.Join(conf.BaseDir, queryLogFileName),
 
-		anonymizer: conf.Anonymizer,
+		anonymizer: anonymizer,
 	}
 
 	*l.conf = conf
@@ -169,5 +203,26 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 		return nil, fmt.Errorf("unsupported interval: %w", err)
 	}
 
+	switch conf.Storage {
+	case "", StorageJSON:
+		// Go on.
+	case StorageIndexed:
+		if conf.IndexMaxSize == 0 {
+			return nil, fmt.Errorf("index max size: %w", errors.ErrNotPositive)
+		}
+
+		l.index, err = openIndex(filepath.Join(conf.BaseDir, indexFileName), conf.IndexMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("opening index: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported storage %q", conf.Storage)
+	}
+
+	l.sinks, err = newSinks(conf.Sinks)
+	if err != nil {
+		return nil, fmt.Errorf("sinks: %w", err)
+	}
+
 	return l, nil
 }
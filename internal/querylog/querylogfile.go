@@ -18,6 +18,10 @@ func (l *queryLog) flushLogBuffer() (err error) {
 	l.fileFlushLock.Lock()
 	defer l.fileFlushLock.Unlock()
 
+	if l.index != nil {
+		return l.flushToIndex()
+	}
+
 	b, err := l.encodeEntries()
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
@@ -155,7 +159,8 @@ func (l *queryLog) periodicRotate() {
 }
 
 // checkAndRotate rotates log files if those are older than the specified
-// rotation interval.
+// rotation interval.  If the index is used, it removes the entries older than
+// the interval instead.
 func (l *queryLog) checkAndRotate() {
 	var rotationIvl time.Duration
 	func() {
@@ -165,6 +170,15 @@ func (l *queryLog) checkAndRotate() {
 		rotationIvl = l.conf.RotationIvl
 	}()
 
+	if l.index != nil {
+		err := l.index.cleanup(time.Now().Add(-rotationIvl))
+		if err != nil {
+			log.Error("querylog: cleaning up index: %s", err)
+		}
+
+		return
+	}
+
 	oldest, err := l.readFileFirstTimeValue()
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.Error("querylog: reading oldest record for rotation: %s", err)
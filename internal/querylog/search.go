@@ -98,8 +98,15 @@ func (l *queryLog) search(params *searchParams) (entries []*logEntry, oldest tim
 	memoryEntries, bufLen := l.searchMemory(params, cache)
 	log.Debug("querylog: got %d entries from memory", len(memoryEntries))
 
-	fileEntries, oldest, total := l.searchFiles(params, cache)
-	log.Debug("querylog: got %d entries from files", len(fileEntries))
+	var fileEntries []*logEntry
+	var total int
+	if l.index != nil {
+		fileEntries, oldest, total = l.searchIndex(params, cache)
+		log.Debug("querylog: got %d entries from index", len(fileEntries))
+	} else {
+		fileEntries, oldest, total = l.searchFiles(params, cache)
+		log.Debug("querylog: got %d entries from files", len(fileEntries))
+	}
 
 	total += bufLen
 
@@ -208,6 +215,14 @@ func (l *queryLog) readEntries(
 			log.Error("querylog: reading next entry: %s", rErr)
 		}
 
+		if !params.newerThan.IsZero() && ts != 0 && ts < params.newerThan.UnixNano() {
+			// The entries are read newest first, so there are no more
+			// matching entries.
+			oldestNano = 0
+
+			break
+		}
+
 		oldestNano = ts
 		total++
 
@@ -33,6 +33,7 @@ const (
 	filteringStatusRewritten           = "rewritten"            // all kinds of rewrites
 	filteringStatusSafeSearch          = "safe_search"          // enforced safe search
 	filteringStatusProcessed           = "processed"            // not blocked, not white-listed entries
+	filteringStatusRatelimited         = "ratelimited"          // limited by the rate limiting
 )
 
 // filteringStatusValues -- array with all possible filteringStatus values
@@ -40,7 +41,7 @@ var filteringStatusValues = []string{
 	filteringStatusAll, filteringStatusFiltered, filteringStatusBlocked,
 	filteringStatusBlockedService, filteringStatusBlockedSafebrowsing, filteringStatusBlockedParental,
 	filteringStatusWhitelisted, filteringStatusRewritten, filteringStatusSafeSearch,
-	filteringStatusProcessed,
+	filteringStatusProcessed, filteringStatusRatelimited,
 }
 
 // searchCriterion is a search criterion that is used to match a record.
@@ -177,7 +178,10 @@ func (c *searchCriterion) ctFilteringStatusCase(
 			filtering.FilteredBlockList,
 			filtering.FilteredBlockedService,
 			filtering.NotFilteredAllowList,
+			filtering.Ratelimited,
 		)
+	case filteringStatusRatelimited:
+		return reason == filtering.Ratelimited
 	default:
 		return false
 	}
@@ -8,6 +8,10 @@ type searchParams struct {
 	// parameter value.  If not set, disregard it and return any value.
 	olderThan time.Time
 
+	// newerThan represents a parameter for entries that are not older than
+	// this parameter value.  If not set, disregard it and return any value.
+	newerThan time.Time
+
 	// searchCriteria is a list of search criteria that we use to get filter
 	// results.
 	searchCriteria []searchCriterion
@@ -57,6 +61,10 @@ func (s *searchParams) match(entry *logEntry) bool {
 		return false
 	}
 
+	if !s.newerThan.IsZero() && entry.Time.Before(s.newerThan) {
+		return false
+	}
+
 	for _, c := range s.searchCriteria {
 		if !c.match(entry) {
 			return false
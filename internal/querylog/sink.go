@@ -0,0 +1,486 @@
+package querylog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+)
+
+// SinkField is the name of a query log entry field sent to a sink.
+type SinkField string
+
+// Sink fields.
+const (
+	SinkFieldTime         SinkField = "time"
+	SinkFieldClientIP     SinkField = "client_ip"
+	SinkFieldClientID     SinkField = "client_id"
+	SinkFieldClientProto  SinkField = "client_proto"
+	SinkFieldQName        SinkField = "qname"
+	SinkFieldQType        SinkField = "qtype"
+	SinkFieldQClass       SinkField = "qclass"
+	SinkFieldRCode        SinkField = "rcode"
+	SinkFieldAnswer       SinkField = "answer"
+	SinkFieldReason       SinkField = "reason"
+	SinkFieldRule         SinkField = "rule"
+	SinkFieldFilterListID SinkField = "filter_list_id"
+	SinkFieldUpstream     SinkField = "upstream"
+	SinkFieldElapsedMs    SinkField = "elapsed_ms"
+	SinkFieldCached       SinkField = "cached"
+	SinkFieldDNSSEC       SinkField = "dnssec"
+	SinkFieldECS          SinkField = "ecs"
+)
+
+// allSinkFields are all the supported sink fields in the order of encoding.
+var allSinkFields = []SinkField{
+	SinkFieldTime,
+	SinkFieldClientIP,
+	SinkFieldClientID,
+	SinkFieldClientProto,
+	SinkFieldQName,
+	SinkFieldQType,
+	SinkFieldQClass,
+	SinkFieldRCode,
+	SinkFieldAnswer,
+	SinkFieldReason,
+	SinkFieldRule,
+	SinkFieldFilterListID,
+	SinkFieldUpstream,
+	SinkFieldElapsedMs,
+	SinkFieldCached,
+	SinkFieldDNSSEC,
+	SinkFieldECS,
+}
+
+// SinkConfig is the configuration of an external destination of the query log
+// entries.  Exactly one of Syslog, HTTP, and File must be set.
+type SinkConfig struct {
+	// Syslog is the configuration of the syslog sink.
+	Syslog *SyslogSinkConfig `yaml:"syslog,omitempty"`
+
+	// HTTP is the configuration of the HTTP sink.
+	HTTP *HTTPSinkConfig `yaml:"http,omitempty"`
+
+	// File is the configuration of the protobuf file sink.
+	File *FileSinkConfig `yaml:"file,omitempty"`
+
+	// Name is the name of the sink used in logs.
+	Name string `yaml:"name"`
+
+	// Fields are the entry fields sent to the sink.  If empty, all fields are
+	// sent.
+	Fields []SinkField `yaml:"fields"`
+
+	// Enabled tells if the sink is used.
+	Enabled bool `yaml:"enabled"`
+
+	// AnonymizeClientIP tells if the client IP addresses and the EDNS
+	// Client-Subnet networks sent to the sink should be anonymized.  It doesn't
+	// depend on the same setting of the query log itself.
+	AnonymizeClientIP bool `yaml:"anonymize_client_ip"`
+}
+
+// validate returns an error if the sink configuration isn't valid.
+func (c *SinkConfig) validate() (err error) {
+	var set int
+	for _, ok := range []bool{c.Syslog != nil, c.HTTP != nil, c.File != nil} {
+		if ok {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return errors.Error("exactly one of syslog, http, and file must be set")
+	}
+
+	for _, f := range c.Fields {
+		if !slices.Contains(allSinkFields, f) {
+			return fmt.Errorf("unknown field %q", f)
+		}
+	}
+
+	return nil
+}
+
+// sinkWriter sends batches of records to a destination.
+type sinkWriter interface {
+	// write sends recs to the destination.  ctx is canceled when the sink is
+	// closed and the final batch doesn't fit in the timeout.
+	write(ctx context.Context, recs []*sinkRecord) (err error)
+
+	io.Closer
+}
+
+// Sink constants.
+const (
+	// sinkQueueSize is the number of entries waiting to be sent to a sink,
+	// after which new entries are dropped.
+	sinkQueueSize = 4096
+
+	// sinkCloseTimeout is the maximum time to wait for a sink to send the
+	// remaining entries on shutdown.
+	sinkCloseTimeout = 5 * time.Second
+)
+
+// sink sends the query log entries to an external destination in the
+// background.
+type sink struct {
+	writer sinkWriter
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// entries is the queue of the entries to send.  It's never closed.
+	entries chan *logEntry
+
+	// stop is closed when the sink must send the remaining entries and exit.
+	stop chan struct{}
+
+	// done is closed when the sink goroutine exits.
+	done chan struct{}
+
+	// stopOnce protects stop from being closed twice.
+	stopOnce *sync.Once
+
+	name   string
+	fields []SinkField
+
+	// batchSize is the maximum number of records written at once.
+	batchSize int
+
+	// flushIvl is the maximum time a record waits for the batch to fill up.
+	// If it's zero, the batch is written once there are no more queued
+	// entries.
+	flushIvl time.Duration
+
+	anonymize bool
+}
+
+// newSink creates a new sink.  c must be valid.
+func newSink(c *SinkConfig) (s *sink, err error) {
+	s = &sink{
+		entries:   make(chan *logEntry, sinkQueueSize),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		stopOnce:  &sync.Once{},
+		name:      c.Name,
+		fields:    c.Fields,
+		batchSize: sinkQueueSize,
+		anonymize: c.AnonymizeClientIP,
+	}
+
+	if len(s.fields) == 0 {
+		s.fields = allSinkFields
+	}
+
+	switch {
+	case c.Syslog != nil:
+		s.writer, err = newSyslogWriter(c.Syslog)
+	case c.HTTP != nil:
+		s.writer, err = newHTTPWriter(c.HTTP)
+		s.batchSize = c.HTTP.batchSize()
+		s.flushIvl = c.HTTP.flushIvl()
+	default:
+		s.writer, err = newFileWriter(c.File)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	return s, nil
+}
+
+// newSinks creates the enabled sinks.
+func newSinks(confs []*SinkConfig) (sinks []*sink, err error) {
+	for i, c := range confs {
+		if c == nil || !c.Enabled {
+			continue
+		}
+
+		err = c.validate()
+		if err != nil {
+			return nil, fmt.Errorf("sink at index %d: %w", i, err)
+		}
+
+		var s *sink
+		s, err = newSink(c)
+		if err != nil {
+			return nil, fmt.Errorf("sink at index %d: %w", i, err)
+		}
+
+		sinks = append(sinks, s)
+	}
+
+	return sinks, nil
+}
+
+// start starts sending the entries in the background.
+func (s *sink) start() {
+	go s.run()
+}
+
+// send queues the entry for sending.  It never blocks.
+func (s *sink) send(e *logEntry) {
+	select {
+	case s.entries <- e:
+	default:
+		log.Debug("querylog: sink %q: queue is full, dropping entry", s.name)
+	}
+}
+
+// close sends the remaining entries and closes the writer.
+func (s *sink) close() (err error) {
+	s.stopOnce.Do(func() { close(s.stop) })
+
+	t := time.NewTimer(sinkCloseTimeout)
+	defer t.Stop()
+
+	select {
+	case <-s.done:
+	case <-t.C:
+		s.cancel()
+		<-s.done
+	}
+
+	s.cancel()
+
+	return s.writer.Close()
+}
+
+// run collects the entries into batches and writes them until the sink is
+// stopped.
+func (s *sink) run() {
+	defer log.OnPanic("querylog: sink " + s.name)
+	defer close(s.done)
+
+	var batch []*sinkRecord
+	var timer *time.Timer
+	var timerCh <-chan time.Time
+	for {
+		select {
+		case e := <-s.entries:
+			batch = append(batch, s.newRecord(e))
+			if len(batch) < s.batchSize {
+				if s.flushIvl > 0 {
+					if timerCh == nil {
+						timer = time.NewTimer(s.flushIvl)
+						timerCh = timer.C
+					}
+
+					continue
+				} else if len(s.entries) > 0 {
+					continue
+				}
+			}
+		case <-timerCh:
+		case <-s.stop:
+			s.drain(batch)
+
+			return
+		}
+
+		if timer != nil {
+			timer.Stop()
+			timer, timerCh = nil, nil
+		}
+
+		s.write(batch)
+		batch = nil
+	}
+}
+
+// drain writes batch along with the rest of the queued entries.
+func (s *sink) drain(batch []*sinkRecord) {
+	for {
+		select {
+		case e := <-s.entries:
+			batch = append(batch, s.newRecord(e))
+			if len(batch) < s.batchSize {
+				continue
+			}
+
+			s.write(batch)
+			batch = nil
+		default:
+			s.write(batch)
+
+			return
+		}
+	}
+}
+
+// write writes a non-empty batch and logs the error, if any.
+func (s *sink) write(batch []*sinkRecord) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := s.writer.write(s.ctx, batch)
+	if err != nil {
+		log.Error("querylog: sink %q: writing %d entries: %s", s.name, len(batch), err)
+	}
+}
+
+// sinkRecord is a query log entry prepared for sending to a sink.
+type sinkRecord struct {
+	entry *logEntry
+
+	// answer is the unpacked response, if any.
+	answer *dns.Msg
+
+	// clientIP is the client IP address, anonymized if needed.
+	clientIP net.IP
+
+	// ecs is the client subnet from the EDNS Client-Subnet option, anonymized
+	// if needed.
+	ecs string
+
+	// fields are the fields to encode.
+	fields []SinkField
+}
+
+// newRecord returns a new record for e.
+func (s *sink) newRecord(e *logEntry) (r *sinkRecord) {
+	r = &sinkRecord{
+		entry:    e,
+		clientIP: e.IP,
+		ecs:      e.ReqECS,
+		fields:   s.fields,
+	}
+
+	if s.anonymize {
+		r.clientIP = slices.Clone(e.IP)
+		AnonymizeIP(r.clientIP)
+
+		if _, ecs, err := net.ParseCIDR(e.ReqECS); err == nil {
+			r.ecs = anonymizeECS(ecs, AnonymizeIP)
+		}
+	}
+
+	if len(e.Answer) > 0 {
+		msg := &dns.Msg{}
+		if err := msg.Unpack(e.Answer); err != nil {
+			log.Debug("querylog: sink %q: unpacking answer: %s", s.name, err)
+		} else {
+			r.answer = msg
+		}
+	}
+
+	return r
+}
+
+// rcode returns the response code of the answer or an empty string if there
+// is no answer.
+func (r *sinkRecord) rcode() (rcode string) {
+	if r.answer == nil {
+		return ""
+	}
+
+	return dns.RcodeToString[r.answer.Rcode]
+}
+
+// rule returns the text and the filter list ID of the first matched rule, if
+// any.
+func (r *sinkRecord) rule() (text string, listID rulelist.URLFilterID) {
+	if rules := r.entry.Result.Rules; len(rules) > 0 {
+		return rules[0].Text, rules[0].FilterListID
+	}
+
+	return "", 0
+}
+
+// dnssec returns true if the response has the AD bit set.
+func (r *sinkRecord) dnssec() (ok bool) {
+	return r.entry.AuthenticatedData || (r.answer != nil && r.answer.AuthenticatedData)
+}
+
+// jsonValue returns the value of the field for JSON-based sinks.
+func (r *sinkRecord) jsonValue(f SinkField) (v any) {
+	e := r.entry
+	switch f {
+	case SinkFieldTime:
+		return e.Time
+	case SinkFieldClientIP:
+		return r.clientIP.String()
+	case SinkFieldClientID:
+		return e.ClientID
+	case SinkFieldClientProto:
+		return e.ClientProto
+	case SinkFieldQName:
+		return e.QHost
+	case SinkFieldQType:
+		return e.QType
+	case SinkFieldQClass:
+		return e.QClass
+	case SinkFieldRCode:
+		return r.rcode()
+	case SinkFieldAnswer:
+		return answerToJSON(r.answer)
+	case SinkFieldReason:
+		return e.Result.Reason.String()
+	case SinkFieldRule:
+		text, _ := r.rule()
+
+		return text
+	case SinkFieldFilterListID:
+		_, id := r.rule()
+
+		return id
+	case SinkFieldUpstream:
+		return e.Upstream
+	case SinkFieldElapsedMs:
+		return float64(e.Elapsed) / float64(time.Millisecond)
+	case SinkFieldCached:
+		return e.Cached
+	case SinkFieldDNSSEC:
+		return r.dnssec()
+	case SinkFieldECS:
+		return r.ecs
+	default:
+		return nil
+	}
+}
+
+// toJSON returns the selected fields of the record as a JSON object.
+func (r *sinkRecord) toJSON() (obj jobject) {
+	obj = make(jobject, len(r.fields))
+	for _, f := range r.fields {
+		obj[string(f)] = r.jsonValue(f)
+	}
+
+	return obj
+}
+
+// startSinks starts all sinks.
+func (l *queryLog) startSinks() {
+	for _, s := range l.sinks {
+		s.start()
+	}
+}
+
+// sendToSinks queues the entry for sending to all sinks.
+func (l *queryLog) sendToSinks(e *logEntry) {
+	for _, s := range l.sinks {
+		s.send(e)
+	}
+}
+
+// closeSinks sends the remaining entries and closes all sinks.
+func (l *queryLog) closeSinks() {
+	for _, s := range l.sinks {
+		err := s.close()
+		if err != nil {
+			log.Error("querylog: closing sink %q: %s", s.name, err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghproto"
+)
+
+// newSinkTestLog returns a query log with the single sink, which is started
+// and closed on cleanup.
+func newSinkTestLog(t *testing.T, sc *SinkConfig) (l *queryLog) {
+	t.Helper()
+
+	sc.Enabled = true
+
+	l, err := newQueryLog(Config{
+		Sinks:       []*SinkConfig{sc},
+		Enabled:     true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     t.TempDir(),
+	})
+	require.NoError(t, err)
+	require.Len(t, l.sinks, 1)
+
+	return l
+}
+
+// testSinkFields are the fields selected in tests.
+var testSinkFields = []SinkField{SinkFieldClientIP, SinkFieldQName, SinkFieldRCode}
+
+// decodeSinkJSON decodes the JSON object from the end of msg.
+func decodeSinkJSON(t *testing.T, msg string) (obj map[string]any) {
+	t.Helper()
+
+	i := strings.IndexByte(msg, '{')
+	require.NotEqual(t, -1, i)
+
+	obj = map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(msg[i:]), &obj))
+
+	return obj
+}
+
+func TestSink_syslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	l := newSinkTestLog(t, &SinkConfig{
+		Syslog: &SyslogSinkConfig{
+			Network: SyslogNetworkUDP,
+			Address: conn.LocalAddr().String(),
+		},
+		Name:              "udp",
+		Fields:            testSinkFields,
+		AnonymizeClientIP: true,
+	})
+	l.startSinks()
+	t.Cleanup(l.closeSinks)
+
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 2))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	buf := make([]byte, 64*1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<134>1 "), msg)
+	assert.Contains(t, msg, " AdGuardHome ")
+	assert.Contains(t, msg, " query - {")
+
+	assert.Equal(t, map[string]any{
+		"client_ip": "2.2.0.0",
+		"qname":     "example.org",
+		"rcode":     "NOERROR",
+	}, decodeSinkJSON(t, msg))
+}
+
+func TestSink_syslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	l := newSinkTestLog(t, &SinkConfig{
+		Syslog: &SyslogSinkConfig{
+			Network:  SyslogNetworkTCP,
+			Address:  ln.Addr().String(),
+			AppName:  "agh",
+			Facility: 1,
+		},
+		Name:   "tcp",
+		Fields: testSinkFields,
+	})
+	l.startSinks()
+	t.Cleanup(l.closeSinks)
+
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 2))
+	addEntry(l, "example.net", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 3))
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"example.org", "example.net"} {
+		sizeStr, rerr := r.ReadString(' ')
+		require.NoError(t, rerr)
+
+		size, rerr := strconv.Atoi(strings.TrimSuffix(sizeStr, " "))
+		require.NoError(t, rerr)
+
+		data := make([]byte, size)
+		_, rerr = io.ReadFull(r, data)
+		require.NoError(t, rerr)
+
+		msg := string(data)
+		assert.True(t, strings.HasPrefix(msg, "<14>1 "), msg)
+		assert.Contains(t, msg, " agh ")
+		assert.Equal(t, want, decodeSinkJSON(t, msg)["qname"])
+	}
+}
+
+func TestSink_http(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	var reqNum int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		reqNum++
+		if reqNum == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		assert.Equal(t, ndjsonContentType, r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		bodies = append(bodies, string(b))
+	}))
+	t.Cleanup(srv.Close)
+
+	l := newSinkTestLog(t, &SinkConfig{
+		HTTP: &HTTPSinkConfig{
+			Headers: map[string]string{
+				"Authorization": "Bearer secret",
+			},
+			URL:           srv.URL,
+			FlushInterval: timeutil.Duration{Duration: time.Minute},
+			BatchSize:     2,
+		},
+		Name:   "http",
+		Fields: testSinkFields,
+	})
+	l.sinks[0].writer.(*httpWriter).initialBackoff = time.Millisecond
+	l.startSinks()
+	t.Cleanup(l.closeSinks)
+
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 2))
+	addEntry(l, "example.net", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 3))
+
+	require.Eventually(t, func() (ok bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(bodies) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, 2, reqNum)
+
+	lines := strings.Split(strings.TrimSuffix(bodies[0], "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	assert.Equal(t, "example.org", decodeSinkJSON(t, lines[0])["qname"])
+	assert.Equal(t, "2.2.2.3", decodeSinkJSON(t, lines[1])["client_ip"])
+}
+
+func TestSink_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "querylog.pb")
+
+	l := newSinkTestLog(t, &SinkConfig{
+		File: &FileSinkConfig{
+			Path: path,
+		},
+		Name:              "file",
+		Fields:            testSinkFields,
+		AnonymizeClientIP: true,
+	})
+	l.startSinks()
+
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 2))
+	l.closeSinks()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	size, n, err := aghproto.ConsumeVarint(data)
+	require.NoError(t, err)
+	require.Len(t, data[n:], int(size))
+
+	fields, err := aghproto.ParseMessage(data[n:])
+	require.NoError(t, err)
+	require.Len(t, fields, 3)
+
+	assert.Equal(t, pbFieldClientIP, fields[0].Num)
+	assert.Equal(t, []byte{2, 2, 0, 0}, fields[0].Bytes)
+	assert.Equal(t, pbFieldQName, fields[1].Num)
+	assert.Equal(t, "example.org", string(fields[1].Bytes))
+	assert.Equal(t, pbFieldRCode, fields[2].Num)
+	assert.Equal(t, "NOERROR", string(fields[2].Bytes))
+}
+
+func TestSinkConfig_validate(t *testing.T) {
+	testCases := []struct {
+		conf       *SinkConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf: &SinkConfig{
+			File: &FileSinkConfig{Path: "file"},
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf:       &SinkConfig{},
+		name:       "no_destination",
+		wantErrMsg: "exactly one of syslog, http, and file must be set",
+	}, {
+		conf: &SinkConfig{
+			File: &FileSinkConfig{Path: "file"},
+			HTTP: &HTTPSinkConfig{URL: "http://example.org"},
+		},
+		name:       "two_destinations",
+		wantErrMsg: "exactly one of syslog, http, and file must be set",
+	}, {
+		conf: &SinkConfig{
+			File:   &FileSinkConfig{Path: "file"},
+			Fields: []SinkField{"bad"},
+		},
+		name:       "bad_field",
+		wantErrMsg: `unknown field "bad"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.conf.validate()
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package querylog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/tukimoto/AdGuardHome/internal/aghproto"
+)
+
+// FileSinkConfig is the configuration of a sink appending the entries to a
+// file as length-delimited protobuf messages.
+type FileSinkConfig struct {
+	// Path is the path to the file.  It's opened in append mode for each
+	// batch, so it may be rotated by external tools.
+	Path string `yaml:"path"`
+}
+
+// Field numbers of the protobuf message of the file sink.  The message
+// resembles the dnstap Message, but carries the filtering data as well:
+//
+//	message QueryLogEntry {
+//	    uint64  time_sec         = 1;
+//	    fixed32 time_nsec        = 2;
+//	    bytes   client_ip        = 3;
+//	    string  client_id        = 4;
+//	    string  client_proto     = 5;
+//	    string  qname            = 6;
+//	    string  qtype            = 7;
+//	    string  qclass           = 8;
+//	    string  rcode            = 9;
+//	    bytes   response_message = 10;
+//	    string  reason           = 11;
+//	    string  rule             = 12;
+//	    int64   filter_list_id   = 13;
+//	    string  upstream         = 14;
+//	    uint64  elapsed_ns       = 15;
+//	    bool    cached           = 16;
+//	    bool    dnssec           = 17;
+//	    string  ecs              = 18;
+//	}
+//
+// Fields with zero values are omitted as per proto3.
+const (
+	pbFieldTimeSec uint32 = iota + 1
+	pbFieldTimeNsec
+	pbFieldClientIP
+	pbFieldClientID
+	pbFieldClientProto
+	pbFieldQName
+	pbFieldQType
+	pbFieldQClass
+	pbFieldRCode
+	pbFieldResponseMessage
+	pbFieldReason
+	pbFieldRule
+	pbFieldFilterListID
+	pbFieldUpstream
+	pbFieldElapsedNs
+	pbFieldCached
+	pbFieldDNSSEC
+	pbFieldECS
+)
+
+// fileWriter is a [sinkWriter] appending length-delimited protobuf messages,
+// each prefixed with its size as a varint, to a file.
+type fileWriter struct {
+	path string
+}
+
+// newFileWriter returns a new file writer.
+func newFileWriter(c *FileSinkConfig) (w *fileWriter, err error) {
+	if c.Path == "" {
+		return nil, errors.Error("file: empty path")
+	}
+
+	return &fileWriter{
+		path: c.Path,
+	}, nil
+}
+
+// type check
+var _ sinkWriter = (*fileWriter)(nil)
+
+// write implements the [sinkWriter] interface for *fileWriter.
+func (w *fileWriter) write(_ context.Context, recs []*sinkRecord) (err error) {
+	b := &bytes.Buffer{}
+	var msg []byte
+	for _, r := range recs {
+		msg = r.appendProto(msg[:0])
+		b.Write(aghproto.AppendVarint(nil, uint64(len(msg))))
+		b.Write(msg)
+	}
+
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	_, err = f.Write(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+
+	return nil
+}
+
+// Close implements the [sinkWriter] interface for *fileWriter.
+func (w *fileWriter) Close() (err error) {
+	return nil
+}
+
+// appendProto appends the selected fields of the record encoded as a protobuf
+// message to b.
+func (r *sinkRecord) appendProto(b []byte) (res []byte) {
+	e := r.entry
+	for _, f := range r.fields {
+		switch f {
+		case SinkFieldTime:
+			b = aghproto.AppendUint(b, pbFieldTimeSec, uint64(e.Time.Unix()))
+			b = aghproto.AppendFixed32(b, pbFieldTimeNsec, uint32(e.Time.Nanosecond()))
+		case SinkFieldClientIP:
+			b = appendProtoBytes(b, pbFieldClientIP, compactIP(r.clientIP))
+		case SinkFieldClientID:
+			b = appendProtoString(b, pbFieldClientID, e.ClientID)
+		case SinkFieldClientProto:
+			b = appendProtoString(b, pbFieldClientProto, string(e.ClientProto))
+		case SinkFieldQName:
+			b = appendProtoString(b, pbFieldQName, e.QHost)
+		case SinkFieldQType:
+			b = appendProtoString(b, pbFieldQType, e.QType)
+		case SinkFieldQClass:
+			b = appendProtoString(b, pbFieldQClass, e.QClass)
+		case SinkFieldRCode:
+			b = appendProtoString(b, pbFieldRCode, r.rcode())
+		case SinkFieldAnswer:
+			b = appendProtoBytes(b, pbFieldResponseMessage, e.Answer)
+		case SinkFieldReason:
+			b = appendProtoString(b, pbFieldReason, e.Result.Reason.String())
+		case SinkFieldRule:
+			text, _ := r.rule()
+			b = appendProtoString(b, pbFieldRule, text)
+		case SinkFieldFilterListID:
+			if _, id := r.rule(); id != 0 {
+				b = aghproto.AppendInt(b, pbFieldFilterListID, int64(id))
+			}
+		case SinkFieldUpstream:
+			b = appendProtoString(b, pbFieldUpstream, e.Upstream)
+		case SinkFieldElapsedMs:
+			if e.Elapsed > 0 {
+				b = aghproto.AppendUint(b, pbFieldElapsedNs, uint64(e.Elapsed/time.Nanosecond))
+			}
+		case SinkFieldCached:
+			if e.Cached {
+				b = aghproto.AppendBool(b, pbFieldCached, true)
+			}
+		case SinkFieldDNSSEC:
+			if r.dnssec() {
+				b = aghproto.AppendBool(b, pbFieldDNSSEC, true)
+			}
+		case SinkFieldECS:
+			b = appendProtoString(b, pbFieldECS, r.ecs)
+		}
+	}
+
+	return b
+}
+
+// appendProtoString appends the string field to b unless s is empty.
+func appendProtoString(b []byte, num uint32, s string) (res []byte) {
+	if s == "" {
+		return b
+	}
+
+	return aghproto.AppendString(b, num, s)
+}
+
+// appendProtoBytes appends the bytes field to b unless v is empty.
+func appendProtoBytes(b []byte, num uint32, v []byte) (res []byte) {
+	if len(v) == 0 {
+		return b
+	}
+
+	return aghproto.AppendBytes(b, num, v)
+}
+
+// compactIP returns the 4-byte form of ip if it's an IPv4 address.
+func compactIP(ip net.IP) (res net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
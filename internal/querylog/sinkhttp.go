@@ -0,0 +1,201 @@
+package querylog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+)
+
+// HTTPSinkConfig is the configuration of a sink sending batches of entries as
+// newline-delimited JSON in HTTP POST requests.
+type HTTPSinkConfig struct {
+	// Headers are the additional headers of the requests, for example
+	// Authorization.
+	Headers map[string]string `yaml:"headers"`
+
+	// URL is the URL of the collector.  It must have the http or https scheme.
+	URL string `yaml:"url"`
+
+	// FlushInterval is the maximum time an entry waits for the batch to fill
+	// up.  If zero, 5 seconds is used.
+	FlushInterval timeutil.Duration `yaml:"flush_interval"`
+
+	// BatchSize is the maximum number of entries in a request.  If zero, 100
+	// is used.
+	BatchSize int `yaml:"batch_size"`
+
+	// MaxRetries is the maximum number of retries of a failed request.  If
+	// zero, 3 is used.  If negative, failed requests aren't retried.
+	MaxRetries int `yaml:"max_retries"`
+}
+
+// HTTP sink defaults.
+const (
+	httpSinkDefaultFlushIvl   = 5 * time.Second
+	httpSinkDefaultBatchSize  = 100
+	httpSinkDefaultMaxRetries = 3
+)
+
+// HTTP sink constants.
+const (
+	// httpSinkTimeout is the timeout of a single request.
+	httpSinkTimeout = 30 * time.Second
+
+	// httpSinkMaxBackoff is the maximum time between the retries.
+	httpSinkMaxBackoff = 1 * time.Minute
+
+	// ndjsonContentType is the media type of newline-delimited JSON.
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// batchSize returns the maximum number of entries in a request.
+func (c *HTTPSinkConfig) batchSize() (n int) {
+	if c.BatchSize <= 0 {
+		return httpSinkDefaultBatchSize
+	}
+
+	return c.BatchSize
+}
+
+// flushIvl returns the maximum time an entry waits for the batch to fill up.
+func (c *HTTPSinkConfig) flushIvl() (ivl time.Duration) {
+	if c.FlushInterval.Duration <= 0 {
+		return httpSinkDefaultFlushIvl
+	}
+
+	return c.FlushInterval.Duration
+}
+
+// httpWriter is a [sinkWriter] posting newline-delimited JSON.
+type httpWriter struct {
+	client  *http.Client
+	headers http.Header
+	url     string
+
+	// initialBackoff is the time before the first retry.  It's doubled after
+	// each retry.
+	initialBackoff time.Duration
+
+	maxRetries int
+}
+
+// newHTTPWriter returns a new HTTP writer.
+func newHTTPWriter(c *HTTPSinkConfig) (w *httpWriter, err error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("http: url: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("http: bad url scheme %q", u.Scheme)
+	}
+
+	maxRetries := c.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = httpSinkDefaultMaxRetries
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	headers := http.Header{}
+	for k, v := range c.Headers {
+		headers.Set(k, v)
+	}
+
+	headers.Set(httphdr.ContentType, ndjsonContentType)
+	headers.Set(httphdr.UserAgent, aghhttp.UserAgent())
+
+	return &httpWriter{
+		client: &http.Client{
+			Timeout: httpSinkTimeout,
+		},
+		headers:        headers,
+		url:            u.String(),
+		initialBackoff: 1 * time.Second,
+		maxRetries:     maxRetries,
+	}, nil
+}
+
+// type check
+var _ sinkWriter = (*httpWriter)(nil)
+
+// write implements the [sinkWriter] interface for *httpWriter.  Failed
+// requests are retried with exponential backoff unless the server rejects the
+// batch with a client error status.
+func (w *httpWriter) write(ctx context.Context, recs []*sinkRecord) (err error) {
+	b := &bytes.Buffer{}
+	enc := json.NewEncoder(b)
+	for _, r := range recs {
+		err = enc.Encode(r.toJSON())
+		if err != nil {
+			return fmt.Errorf("encoding entry: %w", err)
+		}
+	}
+
+	backoff := w.initialBackoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = w.post(ctx, b.Bytes())
+		if err == nil || !retry || attempt >= w.maxRetries {
+			return err
+		}
+
+		log.Debug("querylog: http sink: attempt %d: %s; retrying in %s", attempt+1, err, backoff)
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+
+			return errors.WithDeferred(err, ctx.Err())
+		}
+
+		backoff = min(backoff*2, httpSinkMaxBackoff)
+	}
+}
+
+// post sends a single request.  retry is true if the request may succeed
+// later.
+func (w *httpWriter) post(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header = w.headers.Clone()
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("sending request: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	code := resp.StatusCode
+	switch {
+	case code >= 200 && code < 300:
+		return false, nil
+	case code == http.StatusTooManyRequests, code >= 500:
+		return true, fmt.Errorf("unexpected status %d", code)
+	default:
+		return false, fmt.Errorf("batch rejected with status %d", code)
+	}
+}
+
+// Close implements the [sinkWriter] interface for *httpWriter.
+func (w *httpWriter) Close() (err error) {
+	w.client.CloseIdleConnections()
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package querylog
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// Syslog network names.
+const (
+	SyslogNetworkUDP = "udp"
+	SyslogNetworkTCP = "tcp"
+	SyslogNetworkTLS = "tls"
+)
+
+// SyslogSinkConfig is the configuration of a sink sending the entries as
+// RFC 5424 syslog messages.  The selected fields are sent as a JSON object in
+// the message body.
+type SyslogSinkConfig struct {
+	// Network is the transport of the messages: "udp", "tcp", or "tls".  The
+	// messages sent over TCP and TLS are framed using octet counting as per
+	// RFC 6587 and RFC 5425.
+	Network string `yaml:"network"`
+
+	// Address is the address of the syslog server in the host:port form.
+	Address string `yaml:"address"`
+
+	// AppName is the APP-NAME field of the messages.  If empty,
+	// "AdGuardHome" is used.
+	AppName string `yaml:"app_name"`
+
+	// Facility is the facility code of the messages.  If zero, 16, local0, is
+	// used.
+	Facility uint8 `yaml:"facility"`
+}
+
+// Syslog sink constants.
+const (
+	// syslogDefaultAppName is the default APP-NAME of the messages.
+	syslogDefaultAppName = "AdGuardHome"
+
+	// syslogDefaultFacility is the local0 facility.
+	syslogDefaultFacility = 16
+
+	// syslogMaxFacility is the maximum facility code as per RFC 5424.
+	syslogMaxFacility = 23
+
+	// syslogSeverityInfo is the informational severity of the messages.
+	syslogSeverityInfo = 6
+
+	// syslogMsgID is the MSGID field of the messages.
+	syslogMsgID = "query"
+
+	// syslogTimeFormat is the TIMESTAMP format allowed by RFC 5424.
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+	// syslogTimeout is the timeout for connecting and writing.
+	syslogTimeout = 10 * time.Second
+)
+
+// syslogWriter is a [sinkWriter] sending RFC 5424 messages.
+type syslogWriter struct {
+	// conn is the current connection, if any.  It's only accessed from the
+	// sink goroutine and on close.
+	conn net.Conn
+
+	// tlsConf is the TLS configuration used for the "tls" network.
+	tlsConf *tls.Config
+
+	network  string
+	address  string
+	hostname string
+	appName  string
+	pri      int
+}
+
+// newSyslogWriter returns a new syslog writer.
+func newSyslogWriter(c *SyslogSinkConfig) (w *syslogWriter, err error) {
+	switch c.Network {
+	case SyslogNetworkUDP, SyslogNetworkTCP, SyslogNetworkTLS:
+		// Go on.
+	default:
+		return nil, fmt.Errorf("syslog: bad network %q", c.Network)
+	}
+
+	host, _, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("syslog: address: %w", err)
+	}
+
+	facility := c.Facility
+	if facility == 0 {
+		facility = syslogDefaultFacility
+	} else if facility > syslogMaxFacility {
+		return nil, fmt.Errorf("syslog: facility %d is out of range", facility)
+	}
+
+	appName := c.AppName
+	if appName == "" {
+		appName = syslogDefaultAppName
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogWriter{
+		tlsConf: &tls.Config{
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		},
+		network:  c.Network,
+		address:  c.Address,
+		hostname: hostname,
+		appName:  appName,
+		pri:      int(facility)*8 + syslogSeverityInfo,
+	}, nil
+}
+
+// type check
+var _ sinkWriter = (*syslogWriter)(nil)
+
+// write implements the [sinkWriter] interface for *syslogWriter.  The
+// connection is reestablished once if writing fails.
+func (w *syslogWriter) write(ctx context.Context, recs []*sinkRecord) (err error) {
+	b := &bytes.Buffer{}
+	for _, r := range recs {
+		if w.network == SyslogNetworkUDP {
+			b.Reset()
+		}
+
+		err = w.appendMessage(b, r)
+		if err != nil {
+			return err
+		}
+
+		if w.network == SyslogNetworkUDP {
+			err = w.send(ctx, b.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if w.network == SyslogNetworkUDP {
+		return nil
+	}
+
+	return w.send(ctx, b.Bytes())
+}
+
+// send writes data to the connection, reconnecting once on error.
+func (w *syslogWriter) send(ctx context.Context, data []byte) (err error) {
+	for attempt := 0; ; attempt++ {
+		if w.conn == nil {
+			w.conn, err = w.dial(ctx)
+			if err != nil {
+				return fmt.Errorf("connecting: %w", err)
+			}
+		}
+
+		err = w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if err == nil {
+			_, err = w.conn.Write(data)
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		err = errors.WithDeferred(err, w.conn.Close())
+		w.conn = nil
+		if attempt > 0 {
+			return fmt.Errorf("writing: %w", err)
+		}
+	}
+}
+
+// dial connects to the syslog server.
+func (w *syslogWriter) dial(ctx context.Context) (conn net.Conn, err error) {
+	d := &net.Dialer{
+		Timeout: syslogTimeout,
+	}
+
+	if w.network == SyslogNetworkTLS {
+		td := &tls.Dialer{
+			NetDialer: d,
+			Config:    w.tlsConf,
+		}
+
+		return td.DialContext(ctx, "tcp", w.address)
+	}
+
+	return d.DialContext(ctx, w.network, w.address)
+}
+
+// appendMessage appends the RFC 5424 message for r to b.  The message is
+// prefixed with its length for stream transports.
+func (w *syslogWriter) appendMessage(b *bytes.Buffer, r *sinkRecord) (err error) {
+	data, err := json.Marshal(r.toJSON())
+	if err != nil {
+		return fmt.Errorf("encoding entry: %w", err)
+	}
+
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+	msg := fmt.Sprintf(
+		"<%d>1 %s %s %s %d %s - %s",
+		w.pri,
+		r.entry.Time.UTC().Format(syslogTimeFormat),
+		w.hostname,
+		w.appName,
+		os.Getpid(),
+		syslogMsgID,
+		data,
+	)
+
+	if w.network != SyslogNetworkUDP {
+		b.WriteString(strconv.Itoa(len(msg)))
+		b.WriteByte(' ')
+	}
+
+	b.WriteString(msg)
+
+	return nil
+}
+
+// Close implements the [sinkWriter] interface for *syslogWriter.
+func (w *syslogWriter) Close() (err error) {
+	if w.conn == nil {
+		return nil
+	}
+
+	return w.conn.Close()
+}
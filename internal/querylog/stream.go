@@ -0,0 +1,201 @@
+package querylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
+)
+
+// Live tail parameters.
+const (
+	// subscriberBufSize is the number of entries buffered for each subscriber.
+	// The entries are dropped for the subscribers which can't keep up.
+	subscriberBufSize = 256
+
+	// streamKeepAliveIvl is the interval between the keep-alive comments sent
+	// to the live tail clients to prevent proxies from closing idle
+	// connections.
+	streamKeepAliveIvl = 15 * time.Second
+)
+
+// subscriber is a live tail client.
+type subscriber struct {
+	// params are the search criteria the entries are matched against.
+	params *searchParams
+
+	// entries receives the matched entries.  It's closed when the query log
+	// is closed.
+	entries chan *logEntry
+
+	// dropped is the number of entries dropped since the last successful
+	// send.  It's only accessed under [queryLog.subsMu].
+	dropped uint64
+}
+
+// subscribe registers a new live tail client with the given search criteria.
+// It must be unsubscribed with [queryLog.unsubscribe].
+func (l *queryLog) subscribe(params *searchParams) (sub *subscriber) {
+	sub = &subscriber{
+		params:  params,
+		entries: make(chan *logEntry, subscriberBufSize),
+	}
+
+	l.subsMu.Lock()
+	defer l.subsMu.Unlock()
+
+	l.subs[sub] = struct{}{}
+
+	return sub
+}
+
+// unsubscribe removes the live tail client.
+func (l *queryLog) unsubscribe(sub *subscriber) {
+	l.subsMu.Lock()
+	defer l.subsMu.Unlock()
+
+	delete(l.subs, sub)
+}
+
+// publish sends the entry to each live tail client with matching search
+// criteria.  It never blocks.
+func (l *queryLog) publish(entry *logEntry) {
+	l.subsMu.Lock()
+	defer l.subsMu.Unlock()
+
+	if len(l.subs) == 0 {
+		return
+	}
+
+	// A shallow clone is enough, since only the client field is modified.
+	// The entry is shared between all the subscribers and must not be
+	// modified afterwards.
+	e := entry.shallowClone()
+
+	var err error
+	e.client, err = l.client(e.ClientID, e.IP.String(), clientCache{})
+	if err != nil {
+		log.Debug("querylog: enriching streamed entry for client %q: %s", e.IP, err)
+	}
+
+	for sub := range l.subs {
+		if !sub.params.match(e) {
+			continue
+		}
+
+		select {
+		case sub.entries <- e:
+			sub.dropped = 0
+		default:
+			sub.dropped++
+			log.Debug("querylog: live tail client is too slow, %d entries dropped", sub.dropped)
+		}
+	}
+}
+
+// closeSubscribers closes the channels of all live tail clients, so that their
+// handlers return.
+func (l *queryLog) closeSubscribers() {
+	l.subsMu.Lock()
+	defer l.subsMu.Unlock()
+
+	for sub := range l.subs {
+		close(sub.entries)
+		delete(l.subs, sub)
+	}
+}
+
+// handleQueryLogStream is the handler for the GET /control/querylog/stream
+// HTTP API.  It sends the new entries matching the search parameters as
+// Server-Sent Events until the client disconnects.
+func (l *queryLog) handleQueryLogStream(w http.ResponseWriter, r *http.Request) {
+	params, err := parseSearchParams(r)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "parsing params: %s", err)
+
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// The stream is expected to last longer than the write timeout of the
+	// server.
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		log.Debug("querylog: live tail: resetting write deadline: %s", err)
+	}
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, "text/event-stream")
+	h.Set(httphdr.CacheControl, "no-cache")
+	// Prevent nginx and similar reverse proxies from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	err = rc.Flush()
+	if err != nil {
+		log.Error("querylog: live tail: streaming is not supported: %s", err)
+
+		return
+	}
+
+	sub := l.subscribe(params)
+	defer l.unsubscribe(sub)
+
+	ticker := time.NewTicker(streamKeepAliveIvl)
+	defer ticker.Stop()
+
+	for {
+		var msg []byte
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			msg = []byte(": keep-alive\n\n")
+		case e, ok := <-sub.entries:
+			if !ok {
+				return
+			}
+
+			msg, err = l.streamEvent(e)
+			if err != nil {
+				log.Error("querylog: live tail: encoding entry: %s", err)
+
+				continue
+			}
+		}
+
+		_, err = w.Write(msg)
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			log.Debug("querylog: live tail: writing: %s", err)
+
+			return
+		}
+	}
+}
+
+// streamEvent returns the Server-Sent Event with the entry in the same format
+// as the one used by the GET /control/querylog HTTP API.  The client IP
+// address is anonymized, if necessary.
+func (l *queryLog) streamEvent(e *logEntry) (msg []byte, err error) {
+	data, err := json.Marshal(entryToJSON(e, l.anonymizer.Load()))
+	if err != nil {
+		return nil, fmt.Errorf("encoding entry: %w", err)
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteString("event: entry\ndata: ")
+	b.Write(data)
+	b.WriteString("\n\n")
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,80 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghnet"
+)
+
+func TestQueryLog_handleQueryLogStream(t *testing.T) {
+	l, err := newQueryLog(Config{
+		Anonymizer:  aghnet.NewIPMut(AnonymizeIP),
+		Enabled:     true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(l.handleQueryLogStream))
+	t.Cleanup(srv.Close)
+
+	resp, err := srv.Client().Get(srv.URL + "?search=example.org")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get(httphdr.ContentType))
+
+	// Wait for the handler to subscribe.
+	require.Eventually(t, func() (ok bool) {
+		l.subsMu.Lock()
+		defer l.subsMu.Unlock()
+
+		return len(l.subs) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	addEntry(l, "example.com", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+
+	s := bufio.NewScanner(resp.Body)
+
+	require.True(t, s.Scan())
+	assert.Equal(t, "event: entry", s.Text())
+
+	require.True(t, s.Scan())
+	data, ok := strings.CutPrefix(s.Text(), "data: ")
+	require.True(t, ok)
+
+	e := map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(data), &e))
+
+	q, ok := e["question"].(map[string]any)
+	require.True(t, ok)
+
+	assert.Equal(t, "example.org", q["name"])
+	assert.Equal(t, "2.2.0.0", e["client"])
+
+	l.Close()
+
+	// The handler must return after the query log is closed.
+	for s.Scan() {
+		// Go on.
+	}
+
+	l.subsMu.Lock()
+	defer l.subsMu.Unlock()
+
+	assert.Empty(t, l.subs)
+}
@@ -0,0 +1,237 @@
+// Package ratelimit contains the rate limiting policies of DNS clients and the
+// limiter applying them.
+package ratelimit
+
+import (
+	"cmp"
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Action is the action applied to the requests exceeding the rate limit.
+type Action string
+
+const (
+	// ActionDrop means that the requests are dropped without a response.
+	ActionDrop Action = "drop"
+
+	// ActionRefuse means that the requests are responded with REFUSED.
+	ActionRefuse Action = "refuse"
+
+	// ActionTruncate means that the plain DNS-over-UDP requests are responded
+	// with truncated responses to make the clients retry over TCP.  The
+	// requests over the other protocols aren't limited with this action, since
+	// they can't be truncated.
+	ActionTruncate Action = "truncate"
+)
+
+// Policy is the rate limiting policy of a client.
+type Policy struct {
+	// Action is the action applied to the requests exceeding the limit.  An
+	// empty action is [ActionDrop].
+	Action Action `yaml:"action" json:"action"`
+
+	// QPS is the number of requests per second allowed in the long run.  Zero
+	// means that the requests are never limited.
+	QPS uint32 `yaml:"qps" json:"qps"`
+
+	// Burst is the maximum number of requests allowed at once.  Zero means
+	// that it's the same as QPS.
+	Burst uint32 `yaml:"burst" json:"burst"`
+}
+
+// Validate returns an error if p contains errors.  p may be nil.
+func (p *Policy) Validate() (err error) {
+	if p == nil {
+		return nil
+	}
+
+	switch p.Action {
+	case "", ActionDrop, ActionRefuse, ActionTruncate:
+		// Go on.
+	default:
+		return fmt.Errorf("action: bad value %q", p.Action)
+	}
+
+	if p.QPS != 0 && p.Burst != 0 && p.Burst < p.QPS {
+		return fmt.Errorf("burst: %d is less than qps %d", p.Burst, p.QPS)
+	}
+
+	return nil
+}
+
+// Clone returns a deep copy of p.  p may be nil.
+func (p *Policy) Clone() (c *Policy) {
+	if p == nil {
+		return nil
+	}
+
+	clone := *p
+
+	return &clone
+}
+
+// DefaultLimiterSize is the default maximum number of token buckets kept by a
+// [Limiter].
+const DefaultLimiterSize = 1 << 16
+
+// sweepInterval is the interval between the removals of the token buckets
+// which have been refilled.
+const sweepInterval = 1 * time.Minute
+
+// bucket is a token bucket.
+type bucket struct {
+	// last is the time of the last request.
+	last time.Time
+
+	// key is the key of the client the bucket belongs to.
+	key string
+
+	// tokens is the number of tokens currently available.
+	tokens float64
+
+	// rate is the number of tokens added per second.
+	rate float64
+
+	// burst is the maximum number of tokens.
+	burst float64
+
+	// limited is the number of requests limited since the last allowed one.
+	limited uint64
+}
+
+// check takes a token if there is one available at now.  limited is the number
+// of requests limited in a row including this one, or zero if a token has been
+// taken.
+func (b *bucket) check(now time.Time) (limited uint64) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		b.limited++
+
+		return b.limited
+	}
+
+	b.tokens--
+	b.limited = 0
+
+	return 0
+}
+
+// isFull returns true if b has been refilled by now, so that it's the same as a
+// new one.
+func (b *bucket) isFull(now time.Time) (ok bool) {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// Limiter limits the rates of requests of clients using a token bucket per
+// client.  The number of buckets is limited, and the buckets of the least
+// recently seen clients are evicted first.  It's safe for concurrent use.
+type Limiter struct {
+	// mu protects all the fields below.
+	mu *sync.Mutex
+
+	// buckets are the elements of lru mapped by the keys of their buckets.
+	buckets map[string]*list.Element
+
+	// lru contains *bucket values, the most recently used first.
+	lru *list.List
+
+	// lastSweep is the time the refilled buckets have been last removed.
+	lastSweep time.Time
+
+	// maxSize is the maximum number of buckets.
+	maxSize int
+}
+
+// NewLimiter returns a new properly initialized *Limiter keeping at most
+// maxSize token buckets.  The eviction of a bucket resets the limit of its
+// client, so maxSize should exceed the expected number of the clients.  maxSize
+// must be greater than zero.
+func NewLimiter(maxSize int) (l *Limiter) {
+	return &Limiter{
+		mu:      &sync.Mutex{},
+		buckets: map[string]*list.Element{},
+		lru:     list.New(),
+		maxSize: maxSize,
+	}
+}
+
+// Allow returns true if the request of the client identified by key is allowed
+// by p at now.  p must not be nil and p.QPS must not be zero.  The bucket of the
+// client is reset if its policy has changed.
+func (l *Limiter) Allow(key string, p *Policy, now time.Time) (ok bool) {
+	return l.Check(key, p, now) == 0
+}
+
+// Check is like [Limiter.Allow] but returns the number of requests of the
+// client identified by key limited in a row including this one.  limited is
+// zero if the request is allowed.
+func (l *Limiter) Check(key string, p *Policy, now time.Time) (limited uint64) {
+	rate, burst := float64(p.QPS), float64(cmp.Or(p.Burst, p.QPS))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweepLocked(now)
+	}
+
+	if elem, ok := l.buckets[key]; ok {
+		b := elem.Value.(*bucket)
+		if b.rate == rate && b.burst == burst {
+			l.lru.MoveToFront(elem)
+
+			return b.check(now)
+		}
+
+		l.removeLocked(elem)
+	}
+
+	b := &bucket{
+		last:   now,
+		key:    key,
+		tokens: burst,
+		rate:   rate,
+		burst:  burst,
+	}
+
+	l.buckets[key] = l.lru.PushFront(b)
+	if l.lru.Len() > l.maxSize {
+		l.removeLocked(l.lru.Back())
+	}
+
+	return b.check(now)
+}
+
+// Len returns the number of token buckets currently kept by l.
+func (l *Limiter) Len() (n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lru.Len()
+}
+
+// sweepLocked removes the buckets which have been refilled by now.  l.mu is
+// expected to be locked.
+func (l *Limiter) sweepLocked(now time.Time) {
+	for elem := l.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*bucket).isFull(now) {
+			l.removeLocked(elem)
+		}
+
+		elem = next
+	}
+
+	l.lastSweep = now
+}
+
+// removeLocked removes elem from l.  l.mu is expected to be locked.
+func (l *Limiter) removeLocked(elem *list.Element) {
+	b := l.lru.Remove(elem).(*bucket)
+	delete(l.buckets, b.key)
+}
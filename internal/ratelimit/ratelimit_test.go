@@ -0,0 +1,155 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/ratelimit"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	testCases := []struct {
+		policy     *ratelimit.Policy
+		name       string
+		wantErrMsg string
+	}{{
+		policy:     nil,
+		name:       "nil",
+		wantErrMsg: "",
+	}, {
+		policy: &ratelimit.Policy{
+			QPS: 10,
+		},
+		name:       "default_action",
+		wantErrMsg: "",
+	}, {
+		policy: &ratelimit.Policy{
+			Action: ratelimit.ActionTruncate,
+			QPS:    10,
+			Burst:  20,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		policy: &ratelimit.Policy{
+			Action: "block",
+			QPS:    10,
+		},
+		name:       "bad_action",
+		wantErrMsg: `action: bad value "block"`,
+	}, {
+		policy: &ratelimit.Policy{
+			Action: ratelimit.ActionDrop,
+			QPS:    10,
+			Burst:  5,
+		},
+		name:       "bad_burst",
+		wantErrMsg: "burst: 5 is less than qps 10",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.policy.Validate())
+		})
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	const key = "client"
+
+	start := time.Now()
+
+	t.Run("burst", func(t *testing.T) {
+		l := ratelimit.NewLimiter(ratelimit.DefaultLimiterSize)
+		p := &ratelimit.Policy{QPS: 1, Burst: 3}
+
+		for range 3 {
+			assert.True(t, l.Allow(key, p, start))
+		}
+
+		assert.False(t, l.Allow(key, p, start))
+		assert.False(t, l.Allow(key, p, start.Add(time.Second/2)))
+		assert.True(t, l.Allow(key, p, start.Add(time.Second)))
+	})
+
+	t.Run("separate_keys", func(t *testing.T) {
+		l := ratelimit.NewLimiter(ratelimit.DefaultLimiterSize)
+		p := &ratelimit.Policy{QPS: 1}
+
+		assert.True(t, l.Allow(key, p, start))
+		assert.False(t, l.Allow(key, p, start))
+		assert.True(t, l.Allow("other", p, start))
+	})
+
+	t.Run("policy_change", func(t *testing.T) {
+		l := ratelimit.NewLimiter(ratelimit.DefaultLimiterSize)
+		p := &ratelimit.Policy{QPS: 1}
+
+		assert.True(t, l.Allow(key, p, start))
+		assert.False(t, l.Allow(key, p, start))
+
+		p = &ratelimit.Policy{QPS: 2}
+		assert.True(t, l.Allow(key, p, start))
+		assert.True(t, l.Allow(key, p, start))
+		assert.False(t, l.Allow(key, p, start))
+	})
+}
+
+func TestLimiter_Check(t *testing.T) {
+	const key = "client"
+
+	start := time.Now()
+
+	l := ratelimit.NewLimiter(ratelimit.DefaultLimiterSize)
+	p := &ratelimit.Policy{QPS: 1}
+
+	assert.Zero(t, l.Check(key, p, start))
+	assert.Equal(t, uint64(1), l.Check(key, p, start))
+	assert.Equal(t, uint64(2), l.Check(key, p, start))
+
+	assert.Zero(t, l.Check(key, p, start.Add(time.Second)))
+	assert.Equal(t, uint64(1), l.Check(key, p, start.Add(time.Second)))
+}
+
+func TestLimiter_evict(t *testing.T) {
+	const maxSize = 2
+
+	start := time.Now()
+
+	l := ratelimit.NewLimiter(maxSize)
+	p := &ratelimit.Policy{QPS: 1}
+
+	require.True(t, l.Allow("a", p, start))
+	require.False(t, l.Allow("a", p, start))
+
+	require.True(t, l.Allow("b", p, start))
+	require.True(t, l.Allow("c", p, start))
+
+	assert.Equal(t, maxSize, l.Len())
+
+	// The bucket of the least recently seen client is evicted, so its limit is
+	// reset.
+	assert.True(t, l.Allow("a", p, start))
+	assert.Equal(t, maxSize, l.Len())
+}
+
+func TestLimiter_sweep(t *testing.T) {
+	start := time.Now()
+
+	l := ratelimit.NewLimiter(ratelimit.DefaultLimiterSize)
+	p := &ratelimit.Policy{QPS: 1, Burst: 120}
+
+	require.True(t, l.Allow("a", p, start))
+	for range 100 {
+		require.True(t, l.Allow("b", p, start.Add(30*time.Second)))
+	}
+
+	// The bucket of "a" is refilled after two minutes, but the one of "b"
+	// isn't yet.
+	require.True(t, l.Allow("c", p, start.Add(2*time.Minute)))
+
+	assert.Equal(t, 2, l.Len())
+}
@@ -4,7 +4,9 @@ package stats
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/AdguardTeam/golibs/log"
@@ -12,6 +14,7 @@ import (
 	"github.com/tukimoto/AdGuardHome/internal/aghalg"
 	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
 	"github.com/tukimoto/AdGuardHome/internal/aghnet"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
 )
 
 // topAddrs is an alias for the types of the TopFoo fields of statsResponse.
@@ -37,12 +40,14 @@ type StatsResp struct {
 	BlockedFiltering     []uint64 `json:"blocked_filtering"`
 	ReplacedSafebrowsing []uint64 `json:"replaced_safebrowsing"`
 	ReplacedParental     []uint64 `json:"replaced_parental"`
+	Ratelimited          []uint64 `json:"ratelimited"`
 
 	NumDNSQueries           uint64 `json:"num_dns_queries"`
 	NumBlockedFiltering     uint64 `json:"num_blocked_filtering"`
 	NumReplacedSafebrowsing uint64 `json:"num_replaced_safebrowsing"`
 	NumReplacedSafesearch   uint64 `json:"num_replaced_safesearch"`
 	NumReplacedParental     uint64 `json:"num_replaced_parental"`
+	NumRatelimited          uint64 `json:"num_ratelimited"`
 
 	AvgProcessingTime float64 `json:"avg_processing_time"`
 }
@@ -230,8 +235,126 @@ func (s *StatsCtx) initWeb() {
 	s.httpRegister(http.MethodPost, "/control/stats_reset", s.handleStatsReset)
 	s.httpRegister(http.MethodGet, "/control/stats/config", s.handleGetStatsConfig)
 	s.httpRegister(http.MethodPut, "/control/stats/config/update", s.handlePutStatsConfig)
+	s.httpRegister(http.MethodGet, "/control/stats/rules", s.handleRules)
+	s.httpRegister(http.MethodGet, "/control/stats/rules/unused", s.handleUnusedRules)
 
 	// Deprecated handlers.
 	s.httpRegister(http.MethodGet, "/control/stats_info", s.handleStatsInfo)
 	s.httpRegister(http.MethodPost, "/control/stats_config", s.handleStatsConfig)
 }
+
+const (
+	// defaultTopRulesLimit is the default number of the rules with the most
+	// hits returned for each filter list.
+	defaultTopRulesLimit = 10
+
+	// defaultUnusedRulesDays is the default number of days since which the
+	// unused rules haven't been matched.
+	defaultUnusedRulesDays = 30
+)
+
+// listRulesJSON are the rules of a filter list.
+type listRulesJSON struct {
+	// Rules are the rules of the filter list.
+	Rules []*ruleHitsJSON `json:"rules"`
+
+	// FilterListID is the ID of the filter list.
+	FilterListID rulelist.URLFilterID `json:"filter_list_id"`
+}
+
+// rulesResp is the response to the GET /control/stats/rules HTTP API.
+type rulesResp struct {
+	// FilterLists are the rules with the most hits of each filter list.
+	FilterLists []*listRulesJSON `json:"filter_lists"`
+}
+
+// handleRules is the handler for the GET /control/stats/rules HTTP API.
+func (s *StatsCtx) handleRules(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultTopRulesLimit)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	tops := topRules(s.loadRuleHits(-1), limit)
+
+	resp := &rulesResp{
+		FilterLists: []*listRulesJSON{},
+	}
+	for _, id := range sortedListIDs(tops) {
+		resp.FilterLists = append(resp.FilterLists, &listRulesJSON{
+			Rules:        tops[id],
+			FilterListID: id,
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// unusedRulesResp is the response to the GET /control/stats/rules/unused HTTP
+// API.
+type unusedRulesResp struct {
+	listRulesJSON
+
+	// Days is the number of days since which the rules haven't been matched.
+	Days int `json:"days"`
+}
+
+// handleUnusedRules is the handler for the GET /control/stats/rules/unused HTTP
+// API.
+func (s *StatsCtx) handleUnusedRules(w http.ResponseWriter, r *http.Request) {
+	if s.filterListRules == nil {
+		aghhttp.Error(r, w, http.StatusNotImplemented, "filter list rules are unavailable")
+
+		return
+	}
+
+	days, err := queryInt(r, "days", defaultUnusedRulesDays)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	id, err := queryInt(r, "filter_list_id", rulelist.URLFilterIDCustom)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	listRules, err := s.filterListRules(id)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "filter list %d: %s", id, err)
+
+		return
+	}
+
+	since := time.Now().Add(-time.Duration(days) * timeutil.Day)
+	resp := &unusedRulesResp{
+		listRulesJSON: listRulesJSON{
+			Rules:        unusedRules(s.loadRuleHits(id), id, listRules, since),
+			FilterListID: id,
+		},
+		Days: days,
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// queryInt returns the non-negative integer value of the query parameter with
+// name from r or defaultVal if there is none.
+func queryInt(r *http.Request, name string, defaultVal int) (val int, err error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return defaultVal, nil
+	}
+
+	val, err = strconv.Atoi(str)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("bad %s %q", name, str)
+	}
+
+	return val, nil
+}
@@ -0,0 +1,318 @@
+package stats
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
+	"go.etcd.io/bbolt"
+	"golang.org/x/exp/maps"
+)
+
+// Rule is a filtering rule matched by a request.
+type Rule struct {
+	// Text is the text of the rule.
+	Text string
+
+	// FilterListID is the ID of the rule's filter list.  Only the rules of the
+	// filter lists and the custom filtering rules, which have non-negative IDs,
+	// are counted.
+	FilterListID rulelist.URLFilterID
+}
+
+// ruleHits is the number of requests matching a rule.
+type ruleHits struct {
+	// last is the time of the latest request matching the rule.
+	last time.Time
+
+	// count is the number of requests matching the rule.
+	count uint64
+}
+
+// add accounts other in h.
+func (h *ruleHits) add(other *ruleHits) {
+	h.count += other.count
+	if other.last.After(h.last) {
+		h.last = other.last
+	}
+}
+
+const (
+	// ruleHitsBucket is the name of the database bucket containing the
+	// numbers of requests matching each rule.  Since it's shorter than
+	// [bucketNameLen], it's never taken for a unit.
+	ruleHitsBucket = "rules"
+
+	// ruleHitsLen is the length of a serialized [ruleHits].
+	ruleHitsLen = 16
+
+	// ruleHitsMaxAge is the time after the latest request matching a rule
+	// after which the rule is removed from the database, so that the rules
+	// removed from the filter lists don't stay there forever.
+	ruleHitsMaxAge = 365 * timeutil.Day
+)
+
+// ruleKey returns the database key of r.
+func ruleKey(r Rule) (key []byte) {
+	key = make([]byte, 8, 8+len(r.Text))
+	binary.BigEndian.PutUint64(key, uint64(r.FilterListID))
+
+	return append(key, r.Text...)
+}
+
+// ruleFromKey returns the rule from the database key.  ok is false if key is
+// invalid.
+func ruleFromKey(key []byte) (r Rule, ok bool) {
+	if len(key) <= 8 {
+		return Rule{}, false
+	}
+
+	return Rule{
+		Text:         string(key[8:]),
+		FilterListID: rulelist.URLFilterID(binary.BigEndian.Uint64(key)),
+	}, true
+}
+
+// marshalRuleHits returns the database value of h.
+func marshalRuleHits(h *ruleHits) (val []byte) {
+	val = make([]byte, ruleHitsLen)
+	binary.BigEndian.PutUint64(val, h.count)
+	binary.BigEndian.PutUint64(val[8:], uint64(h.last.Unix()))
+
+	return val
+}
+
+// unmarshalRuleHits returns the rule hits from the database value.  ok is false
+// if val is invalid.
+func unmarshalRuleHits(val []byte) (h *ruleHits, ok bool) {
+	if len(val) != ruleHitsLen {
+		return nil, false
+	}
+
+	return &ruleHits{
+		count: binary.BigEndian.Uint64(val),
+		last:  time.Unix(int64(binary.BigEndian.Uint64(val[8:])), 0),
+	}, true
+}
+
+// addRuleHits accounts the rules matched by the request made at now.
+// s.currMu is expected to be locked.
+func (s *StatsCtx) addRuleHits(rules []Rule, now time.Time) {
+	for _, r := range rules {
+		if r.Text == "" || r.FilterListID < 0 {
+			continue
+		}
+
+		h := s.ruleHits[r]
+		if h == nil {
+			h = &ruleHits{}
+			s.ruleHits[r] = h
+		}
+
+		h.add(&ruleHits{count: 1, last: now})
+	}
+}
+
+// flushRuleHits writes the rule hits collected since the latest flush to the
+// database and removes the ones older than [ruleHitsMaxAge].  It doesn't reset
+// the collected hits, since tx may be rolled back, so the caller should call
+// [StatsCtx.resetRuleHits] once tx is committed.  s.currMu is expected to be
+// locked at least for reading.
+func (s *StatsCtx) flushRuleHits(tx *bbolt.Tx, now time.Time) (err error) {
+	bkt, err := tx.CreateBucketIfNotExists([]byte(ruleHitsBucket))
+	if err != nil {
+		return fmt.Errorf("creating bucket: %w", err)
+	}
+
+	for r, h := range s.ruleHits {
+		key := ruleKey(r)
+		merged := *h
+		if stored, ok := unmarshalRuleHits(bkt.Get(key)); ok {
+			merged.add(stored)
+		}
+
+		err = bkt.Put(key, marshalRuleHits(&merged))
+		if err != nil {
+			return fmt.Errorf("putting rule hits: %w", err)
+		}
+	}
+
+	var stale [][]byte
+	oldest := now.Add(-ruleHitsMaxAge)
+	err = bkt.ForEach(func(k, v []byte) (err error) {
+		if h, ok := unmarshalRuleHits(v); !ok || h.last.Before(oldest) {
+			stale = append(stale, bytes.Clone(k))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("iterating rule hits: %w", err)
+	}
+
+	for _, k := range stale {
+		err = bkt.Delete(k)
+		if err != nil {
+			return fmt.Errorf("deleting rule hits: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// resetRuleHits removes the rule hits collected since the latest flush.
+// s.currMu is expected to be locked.
+func (s *StatsCtx) resetRuleHits() {
+	s.ruleHits = map[Rule]*ruleHits{}
+}
+
+// loadRuleHits returns the rule hits from the database and the ones collected
+// since the latest flush.  If listID is non-negative, only the rules of the
+// filter list with listID are returned.
+func (s *StatsCtx) loadRuleHits(listID rulelist.URLFilterID) (hits map[Rule]*ruleHits) {
+	hits = map[Rule]*ruleHits{}
+	add := func(r Rule, h *ruleHits) {
+		if listID >= 0 && r.FilterListID != listID {
+			return
+		}
+
+		acc := hits[r]
+		if acc == nil {
+			acc = &ruleHits{}
+			hits[r] = acc
+		}
+
+		acc.add(h)
+	}
+
+	s.currMu.RLock()
+	defer s.currMu.RUnlock()
+
+	for r, h := range s.ruleHits {
+		add(r, h)
+	}
+
+	db := s.db.Load()
+	if db == nil {
+		return hits
+	}
+
+	err := db.View(func(tx *bbolt.Tx) (err error) {
+		bkt := tx.Bucket([]byte(ruleHitsBucket))
+		if bkt == nil {
+			return nil
+		}
+
+		return bkt.ForEach(func(k, v []byte) (err error) {
+			r, ok := ruleFromKey(k)
+			if !ok {
+				return nil
+			}
+
+			if h, ok := unmarshalRuleHits(v); ok {
+				add(r, h)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		log.Error("stats: loading rule hits: %s", err)
+	}
+
+	return hits
+}
+
+// ruleHitsJSON is the number of requests matching a rule.
+type ruleHitsJSON struct {
+	// LastHit is the time of the latest request matching the rule, if any.
+	LastHit *time.Time `json:"last_hit,omitempty"`
+
+	// Rule is the text of the rule.
+	Rule string `json:"rule"`
+
+	// Hits is the number of requests matching the rule.
+	Hits uint64 `json:"hits"`
+}
+
+// newRuleHitsJSON returns the JSON representation of h for the rule with text.
+// h may be nil.
+func newRuleHitsJSON(text string, h *ruleHits) (j *ruleHitsJSON) {
+	j = &ruleHitsJSON{
+		Rule: text,
+	}
+
+	if h != nil {
+		last := h.last
+		j.LastHit = &last
+		j.Hits = h.count
+	}
+
+	return j
+}
+
+// compareRuleHits is used to sort the rules by the number of hits in
+// descending order and then by the text.
+func compareRuleHits(a, b *ruleHitsJSON) (res int) {
+	if res = cmp.Compare(b.Hits, a.Hits); res != 0 {
+		return res
+	}
+
+	return cmp.Compare(a.Rule, b.Rule)
+}
+
+// topRules returns at most limit rules with the most hits for each filter
+// list.
+func topRules(hits map[Rule]*ruleHits, limit int) (tops map[rulelist.URLFilterID][]*ruleHitsJSON) {
+	tops = map[rulelist.URLFilterID][]*ruleHitsJSON{}
+	for r, h := range hits {
+		tops[r.FilterListID] = append(tops[r.FilterListID], newRuleHitsJSON(r.Text, h))
+	}
+
+	for id, rules := range tops {
+		slices.SortFunc(rules, compareRuleHits)
+		tops[id] = rules[:min(limit, len(rules))]
+	}
+
+	return tops
+}
+
+// unusedRules returns the rules from listRules of the filter list with listID
+// not matched since since.
+func unusedRules(
+	hits map[Rule]*ruleHits,
+	listID rulelist.URLFilterID,
+	listRules []string,
+	since time.Time,
+) (unused []*ruleHitsJSON) {
+	unused = []*ruleHitsJSON{}
+	seen := make(map[string]struct{}, len(listRules))
+	for _, text := range listRules {
+		if _, ok := seen[text]; ok {
+			continue
+		}
+
+		seen[text] = struct{}{}
+
+		h := hits[Rule{Text: text, FilterListID: listID}]
+		if h == nil || h.last.Before(since) {
+			unused = append(unused, newRuleHitsJSON(text, h))
+		}
+	}
+
+	return unused
+}
+
+// sortedListIDs returns the filter list IDs of tops in ascending order.
+func sortedListIDs(tops map[rulelist.URLFilterID][]*ruleHitsJSON) (ids []rulelist.URLFilterID) {
+	ids = maps.Keys(tops)
+	slices.Sort(ids)
+
+	return ids
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/tukimoto/AdGuardHome/internal/aghhttp"
 	"github.com/tukimoto/AdGuardHome/internal/aghnet"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
 	"go.etcd.io/bbolt"
 )
 
@@ -62,6 +63,10 @@ type Config struct {
 	// and matches them.
 	Ignored *aghnet.IgnoreEngine
 
+	// FilterListRules returns the rules of the filter list with the ID.  It's
+	// used to find the rules which haven't been matched recently.
+	FilterListRules func(id rulelist.URLFilterID) (rules []string, err error)
+
 	// Filename is the name of the database file.
 	Filename string
 
@@ -96,11 +101,15 @@ type Interface interface {
 // StatsCtx collects the statistics and flushes it to the database.  Its default
 // flushing interval is one hour.
 type StatsCtx struct {
-	// currMu protects curr.
+	// currMu protects curr and ruleHits.
 	currMu *sync.RWMutex
 	// curr is the actual statistics collection result.
 	curr *unit
 
+	// ruleHits are the numbers of requests matching each rule collected since
+	// the latest flush.
+	ruleHits map[Rule]*ruleHits
+
 	// db is the opened statistics database, if any.
 	db atomic.Pointer[bbolt.DB]
 
@@ -125,6 +134,9 @@ type StatsCtx struct {
 	// shouldCountClient returns client's ignore setting.
 	shouldCountClient func([]string) bool
 
+	// filterListRules returns the rules of the filter list with the ID.
+	filterListRules func(id rulelist.URLFilterID) (rules []string, err error)
+
 	// filename is the name of database file.
 	filename string
 
@@ -150,10 +162,12 @@ func New(conf Config) (s *StatsCtx, err error) {
 	}
 
 	s = &StatsCtx{
-		currMu:         &sync.RWMutex{},
-		httpRegister:   conf.HTTPRegister,
-		configModified: conf.ConfigModified,
-		filename:       conf.Filename,
+		currMu:          &sync.RWMutex{},
+		ruleHits:        map[Rule]*ruleHits{},
+		httpRegister:    conf.HTTPRegister,
+		configModified:  conf.ConfigModified,
+		filterListRules: conf.FilterListRules,
+		filename:        conf.Filename,
 
 		confMu:            &sync.RWMutex{},
 		ignored:           conf.Ignored,
@@ -253,8 +267,13 @@ func (s *StatsCtx) Close() (err error) {
 	defer s.currMu.RUnlock()
 
 	udb := s.curr.serialize()
+	err = udb.flushUnitToDB(tx, s.curr.id)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
 
-	return udb.flushUnitToDB(tx, s.curr.id)
+	return errors.Annotate(s.flushRuleHits(tx, time.Now()), "flushing rule hits: %w")
 }
 
 // Update implements the [Interface] interface for *StatsCtx.  e must not be
@@ -284,6 +303,7 @@ func (s *StatsCtx) Update(e *Entry) {
 	}
 
 	s.curr.add(e)
+	s.addRuleHits(e.Rules, time.Now())
 }
 
 // WriteDiskConfig implements the [Interface] interface for *StatsCtx.
@@ -342,7 +362,10 @@ func deleteOldUnits(tx *bbolt.Tx, firstID uint32) (deleted int) {
 
 	walk := func(name []byte, _ *bbolt.Bucket) (err error) {
 		nameID, ok := unitNameToID(name)
-		if ok && nameID >= firstID {
+		if !ok {
+			// Keep the buckets which aren't units, for example the rule hits.
+			return nil
+		} else if nameID >= firstID {
 			return errStop
 		}
 
@@ -431,6 +454,8 @@ func (s *StatsCtx) flushDB(id, limit uint32, ptr *unit) (cont bool, sleepFor tim
 	defer func() {
 		if err = finishTxn(tx, isCommitable); err != nil {
 			log.Error("stats: %s", err)
+		} else if isCommitable {
+			s.resetRuleHits()
 		}
 	}()
 
@@ -442,6 +467,12 @@ func (s *StatsCtx) flushDB(id, limit uint32, ptr *unit) (cont bool, sleepFor tim
 		isCommitable = false
 	}
 
+	flushErr = s.flushRuleHits(tx, time.Now())
+	if flushErr != nil {
+		log.Error("stats: flushing rule hits: %s", flushErr)
+		isCommitable = false
+	}
+
 	delErr := tx.DeleteBucket(idToUnitName(id - limit))
 	if delErr != nil {
 		// TODO(e.burkov):  Improve the algorithm of deleting the oldest bucket
@@ -533,6 +564,7 @@ func (s *StatsCtx) clear() (err error) {
 	defer s.currMu.Unlock()
 
 	s.curr = newUnit(s.unitIDGen())
+	s.resetRuleHits()
 
 	return nil
 }
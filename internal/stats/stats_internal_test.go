@@ -168,3 +168,55 @@ func TestStatsCtx_DataFromUnits_month(t *testing.T) {
 		require.NotNil(t, data)
 	}
 }
+
+func TestStatsCtx_flushRuleHits(t *testing.T) {
+	var unitID atomic.Uint32
+	s, err := New(Config{
+		ShouldCountClient: func([]string) bool { return true },
+		UnitID:            unitID.Load,
+		Filename:          filepath.Join(t.TempDir(), "./stats.db"),
+		Limit:             timeutil.Day,
+		Enabled:           true,
+	})
+	require.NoError(t, err)
+
+	testutil.CleanupAndRequireSuccess(t, s.Close)
+
+	rule := Rule{Text: "||example.org^"}
+	s.Update(&Entry{
+		Domain: "example.org",
+		Client: "192.0.2.1",
+		Result: RFiltered,
+		Rules:  []Rule{rule},
+	})
+
+	db := s.db.Load()
+	require.NotNil(t, db)
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+
+	s.currMu.RLock()
+	err = s.flushRuleHits(tx, time.Now())
+	s.currMu.RUnlock()
+	require.NoError(t, err)
+
+	// The hits must not be lost when the transaction is rolled back.
+	require.NoError(t, tx.Rollback())
+
+	hits := s.loadRuleHits(-1)
+	require.Contains(t, hits, rule)
+
+	assert.Equal(t, uint64(1), hits[rule].count)
+
+	unitID.Store(1)
+	cont, _ := s.flush()
+	require.True(t, cont)
+
+	// The hits must not be counted twice once they're committed.
+	hits = s.loadRuleHits(-1)
+	require.Contains(t, hits, rule)
+
+	assert.Equal(t, uint64(1), hits[rule].count)
+	assert.Empty(t, s.ruleHits)
+}
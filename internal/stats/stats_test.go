@@ -18,6 +18,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tukimoto/AdGuardHome/internal/aghnet"
+	"github.com/tukimoto/AdGuardHome/internal/filtering/rulelist"
 	"github.com/tukimoto/AdGuardHome/internal/stats"
 )
 
@@ -89,18 +90,24 @@ func TestStats(t *testing.T) {
 			ProcessingTime: time.Microsecond * 123456,
 			Upstream:       respUpstream,
 			UpstreamTime:   time.Microsecond * 222222,
+		}, {
+			// The ratelimited requests aren't counted in the top domains.
+			Domain:         "limited",
+			Client:         cliIPStr,
+			Result:         stats.RRatelimited,
+			ProcessingTime: time.Microsecond * 123456,
 		}}
 
 		wantData := &stats.StatsResp{
 			TimeUnits:             "hours",
 			TopQueried:            []map[string]uint64{0: {reqDomain: 1}},
-			TopClients:            []map[string]uint64{0: {cliIPStr: 2}},
+			TopClients:            []map[string]uint64{0: {cliIPStr: 3}},
 			TopBlocked:            []map[string]uint64{0: {reqDomain: 1}},
 			TopUpstreamsResponses: []map[string]uint64{0: {respUpstream: 2}},
 			TopUpstreamsAvgTime:   []map[string]float64{0: {respUpstream: 0.222222}},
 			DNSQueries: []uint64{
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3,
 			},
 			BlockedFiltering: []uint64{
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
@@ -114,11 +121,16 @@ func TestStats(t *testing.T) {
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 			},
-			NumDNSQueries:           2,
+			Ratelimited: []uint64{
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+			},
+			NumDNSQueries:           3,
 			NumBlockedFiltering:     1,
 			NumReplacedSafebrowsing: 0,
 			NumReplacedSafesearch:   0,
 			NumReplacedParental:     0,
+			NumRatelimited:          1,
 			AvgProcessingTime:       0.123456,
 		}
 
@@ -156,6 +168,7 @@ func TestStats(t *testing.T) {
 			BlockedFiltering:      _24zeroes[:],
 			ReplacedSafebrowsing:  _24zeroes[:],
 			ReplacedParental:      _24zeroes[:],
+			Ratelimited:           _24zeroes[:],
 		}
 
 		req = httptest.NewRequest(http.MethodGet, "/control/stats", nil)
@@ -270,3 +283,109 @@ func TestShouldCount(t *testing.T) {
 		})
 	}
 }
+
+func TestStats_rules(t *testing.T) {
+	const (
+		ruleUsed   = "||used.example^"
+		ruleOther  = "||other.example^"
+		ruleUnused = "||unused.example^"
+
+		listID = 1
+	)
+
+	handlers := map[string]http.Handler{}
+	conf := stats.Config{
+		ShouldCountClient: func([]string) bool { return true },
+		FilterListRules: func(id rulelist.URLFilterID) (rules []string, err error) {
+			require.Equal(testutil.PanicT{}, rulelist.URLFilterIDCustom, id)
+
+			return []string{ruleUsed, ruleOther, ruleUnused}, nil
+		},
+		Filename: filepath.Join(t.TempDir(), "stats.db"),
+		Limit:    timeutil.Day,
+		Enabled:  true,
+		UnitID:   constUnitID,
+		HTTPRegister: func(_, url string, handler http.HandlerFunc) {
+			handlers[url] = handler
+		},
+	}
+
+	s, err := stats.New(conf)
+	require.NoError(t, err)
+
+	s.Start()
+
+	newEntry := func(rules ...stats.Rule) (e *stats.Entry) {
+		return &stats.Entry{
+			Domain: "example.org",
+			Client: "192.0.2.1",
+			Result: stats.RFiltered,
+			Rules:  rules,
+		}
+	}
+
+	s.Update(newEntry(stats.Rule{Text: ruleUsed}))
+	s.Update(newEntry(stats.Rule{Text: ruleUsed}))
+	s.Update(newEntry(stats.Rule{Text: ruleOther}))
+	s.Update(newEntry(stats.Rule{Text: "||list.example^", FilterListID: listID}))
+	s.Update(newEntry(stats.Rule{Text: "||svc.example^", FilterListID: -2}))
+
+	// Reopen the database to make sure the hits are persisted.
+	require.NoError(t, s.Close())
+
+	s, err = stats.New(conf)
+	require.NoError(t, err)
+
+	s.Start()
+	testutil.CleanupAndRequireSuccess(t, s.Close)
+
+	s.Update(newEntry(stats.Rule{Text: ruleOther}))
+	s.Update(newEntry(stats.Rule{Text: ruleOther}))
+
+	type ruleHits struct {
+		Rule    string     `json:"rule"`
+		LastHit *time.Time `json:"last_hit"`
+		Hits    uint64     `json:"hits"`
+	}
+
+	type listRules struct {
+		Rules        []*ruleHits `json:"rules"`
+		FilterListID int         `json:"filter_list_id"`
+	}
+
+	t.Run("top", func(t *testing.T) {
+		resp := &struct {
+			FilterLists []*listRules `json:"filter_lists"`
+		}{}
+
+		req := httptest.NewRequest(http.MethodGet, "/control/stats/rules?limit=1", nil)
+		assertSuccessAndUnmarshal(t, resp, handlers["/control/stats/rules"], req)
+
+		require.Len(t, resp.FilterLists, 2)
+
+		custom := resp.FilterLists[0]
+		assert.Equal(t, rulelist.URLFilterIDCustom, custom.FilterListID)
+		require.Len(t, custom.Rules, 1)
+
+		assert.Equal(t, ruleOther, custom.Rules[0].Rule)
+		assert.Equal(t, uint64(3), custom.Rules[0].Hits)
+		assert.NotNil(t, custom.Rules[0].LastHit)
+
+		list := resp.FilterLists[1]
+		assert.Equal(t, listID, list.FilterListID)
+		require.Len(t, list.Rules, 1)
+
+		assert.Equal(t, uint64(1), list.Rules[0].Hits)
+	})
+
+	t.Run("unused", func(t *testing.T) {
+		resp := &listRules{}
+
+		req := httptest.NewRequest(http.MethodGet, "/control/stats/rules/unused?days=30", nil)
+		assertSuccessAndUnmarshal(t, resp, handlers["/control/stats/rules/unused"], req)
+
+		assert.Equal(t, []*ruleHits{{
+			Rule: ruleUnused,
+		}}, resp.Rules)
+	})
+}
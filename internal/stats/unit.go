@@ -48,10 +48,34 @@ const (
 	RSafeBrowsing
 	RSafeSearch
 	RParental
+	RRatelimited
 
-	resultLast = RParental + 1
+	resultLast = RRatelimited + 1
 )
 
+// type check
+var _ fmt.Stringer = Result(0)
+
+// String returns the name of r, which is used as a label in the metrics.
+func (r Result) String() (s string) {
+	switch r {
+	case RNotFiltered:
+		return "not_filtered"
+	case RFiltered:
+		return "filtered"
+	case RSafeBrowsing:
+		return "safe_browsing"
+	case RSafeSearch:
+		return "safe_search"
+	case RParental:
+		return "parental"
+	case RRatelimited:
+		return "ratelimited"
+	default:
+		return ""
+	}
+}
+
 // Entry is a statistics data entry.
 type Entry struct {
 	// Clients is the client's primary ID.
@@ -74,6 +98,9 @@ type Entry struct {
 
 	// UpstreamTime is the duration of the successful request to the upstream.
 	UpstreamTime time.Duration
+
+	// Rules are the filtering rules matched by the request.
+	Rules []Rule
 }
 
 // validate returns an error if entry is not valid.
@@ -296,6 +323,12 @@ func loadUnitFromDB(tx *bbolt.Tx, id uint32) (udb *unitDB) {
 		return nil
 	}
 
+	if n := len(udb.NResult); n < int(resultLast) {
+		// The units stored by the previous versions may lack the newer
+		// results.
+		udb.NResult = append(udb.NResult, make([]uint64, int(resultLast)-n)...)
+	}
+
 	return udb
 }
 
@@ -317,12 +350,16 @@ func (u *unit) deserialize(udb *unitDB) {
 	u.timeSum = uint64(udb.TimeAvg) * udb.NTotal
 }
 
-// add adds new data to u.  It's safe for concurrent use.
+// add adds new data to u.  The ratelimited requests are counted neither in the
+// top queried nor in the top blocked domains.  It's safe for concurrent use.
 func (u *unit) add(e *Entry) {
 	u.nResult[e.Result]++
-	if e.Result == RNotFiltered {
+	switch e.Result {
+	case RNotFiltered:
 		u.domains[e.Domain]++
-	} else {
+	case RRatelimited:
+		// Go on.
+	default:
 		u.blockedDomains[e.Domain]++
 	}
 
@@ -423,6 +460,7 @@ func (s *StatsCtx) getData(limit uint32) (resp *StatsResp, ok bool) {
 			DNSQueries:           []uint64{},
 			ReplacedParental:     []uint64{},
 			ReplacedSafebrowsing: []uint64{},
+			Ratelimited:          []uint64{},
 		}, true
 	}
 
@@ -463,6 +501,7 @@ func (s *StatsCtx) dataFromUnits(units []*unitDB, curID uint32) (resp *StatsResp
 		sum.NResult[RSafeBrowsing] += u.NResult[RSafeBrowsing]
 		sum.NResult[RSafeSearch] += u.NResult[RSafeSearch]
 		sum.NResult[RParental] += u.NResult[RParental]
+		sum.NResult[RRatelimited] += u.NResult[RRatelimited]
 	}
 
 	resp.NumDNSQueries = sum.NTotal
@@ -470,6 +509,7 @@ func (s *StatsCtx) dataFromUnits(units []*unitDB, curID uint32) (resp *StatsResp
 	resp.NumReplacedSafebrowsing = sum.NResult[RSafeBrowsing]
 	resp.NumReplacedSafesearch = sum.NResult[RSafeSearch]
 	resp.NumReplacedParental = sum.NResult[RParental]
+	resp.NumRatelimited = sum.NResult[RRatelimited]
 
 	if timeN != 0 {
 		resp.AvgProcessingTime = microsecondsToSeconds(float64(sum.TimeAvg / timeN))
@@ -493,6 +533,7 @@ func (s *StatsCtx) fillCollectedStats(data *StatsResp, units []*unitDB, curID ui
 	data.BlockedFiltering = make([]uint64, size)
 	data.ReplacedSafebrowsing = make([]uint64, size)
 	data.ReplacedParental = make([]uint64, size)
+	data.Ratelimited = make([]uint64, size)
 
 	if data.TimeUnits == timeUnitsDays {
 		s.fillCollectedStatsDaily(data, units, curID, size)
@@ -505,6 +546,7 @@ func (s *StatsCtx) fillCollectedStats(data *StatsResp, units []*unitDB, curID ui
 		data.BlockedFiltering[i] += u.NResult[RFiltered]
 		data.ReplacedSafebrowsing[i] += u.NResult[RSafeBrowsing]
 		data.ReplacedParental[i] += u.NResult[RParental]
+		data.Ratelimited[i] += u.NResult[RRatelimited]
 	}
 }
 
@@ -532,6 +574,7 @@ func (s *StatsCtx) fillCollectedStatsDaily(
 		data.BlockedFiltering[day] += u.NResult[RFiltered]
 		data.ReplacedSafebrowsing[day] += u.NResult[RSafeBrowsing]
 		data.ReplacedParental[day] += u.NResult[RParental]
+		data.Ratelimited[day] += u.NResult[RRatelimited]
 	}
 }
 
@@ -0,0 +1,216 @@
+package upstreamhealth
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// sample is the result of a single exchange.
+type sample struct {
+	// dur is the duration of the exchange.
+	dur time.Duration
+
+	// ok is true if the exchange has succeeded.
+	ok bool
+}
+
+// health is the state of a single upstream.
+type health struct {
+	// lastErrTime is the time of the latest failed exchange.
+	lastErrTime time.Time
+
+	// downUntil is the time when the upstream returns into rotation.  It's
+	// zero if the upstream is in rotation.
+	downUntil time.Time
+
+	// mu protects the fields below.
+	mu *sync.Mutex
+
+	// ups is the unwrapped upstream used for the probes.
+	ups upstream.Upstream
+
+	// addr is the address of the upstream.
+	addr string
+
+	// lastErr is the error of the latest failed exchange.
+	lastErr string
+
+	// samples is the ring buffer of the latest exchanges.
+	samples []sample
+
+	// next is the index of samples to write the next result to.
+	next int
+
+	// filled is the number of the written elements of samples.
+	filled int
+
+	// failures is the number of the latest consecutive failed exchanges.
+	failures int
+
+	// backoff is the duration of the latest period the upstream has been out
+	// of rotation for.  It's zero if the upstream has succeeded since then.
+	backoff time.Duration
+
+	// probing is true if there is a probe in progress.
+	probing bool
+}
+
+// newHealth returns a new state of the upstream with the given address.
+// window is the number of the latest exchanges to keep.
+func newHealth(addr string, window int) (h *health) {
+	return &health{
+		mu:      &sync.Mutex{},
+		addr:    addr,
+		samples: make([]sample, window),
+	}
+}
+
+// setUpstream sets the upstream used for the probes.
+func (h *health) setUpstream(u upstream.Upstream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.ups = u
+}
+
+// upstream returns the upstream used for the probes.
+func (h *health) upstream() (u upstream.Upstream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.ups
+}
+
+// available returns true if the upstream is in rotation at now.
+func (h *health) available(now time.Time) (ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return !now.Before(h.downUntil)
+}
+
+// startProbe marks the probe as being in progress.  It returns false if there
+// is one already.
+func (h *health) startProbe() (ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.probing {
+		return false
+	}
+
+	h.probing = true
+
+	return true
+}
+
+// finishProbe marks the probe as finished.
+func (h *health) finishProbe() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.probing = false
+}
+
+// record accounts the result of an exchange which has finished at now and
+// taken dur.  The upstream is taken out of rotation if it has failed c's
+// threshold number of times in a row.
+func (h *health) record(c *Config, now time.Time, dur time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples[h.next] = sample{
+		dur: dur,
+		ok:  err == nil,
+	}
+	h.next = (h.next + 1) % len(h.samples)
+	h.filled = min(h.filled+1, len(h.samples))
+
+	if err == nil {
+		if !h.downUntil.IsZero() {
+			log.Info("upstreamhealth: %s is back in rotation", h.addr)
+		}
+
+		h.failures = 0
+		h.backoff = 0
+		h.downUntil = time.Time{}
+
+		return
+	}
+
+	h.lastErr = err.Error()
+	h.lastErrTime = now
+	h.failures++
+
+	if h.failures < c.FailureThreshold || now.Before(h.downUntil) {
+		return
+	}
+
+	// Either the threshold is reached or the upstream has failed right after
+	// returning into rotation.
+	if h.backoff == 0 {
+		h.backoff = c.Backoff
+	} else {
+		h.backoff = min(2*h.backoff, c.MaxBackoff)
+	}
+
+	h.downUntil = now.Add(h.backoff)
+
+	log.Info("upstreamhealth: taking %s out of rotation for %s: %s", h.addr, h.backoff, err)
+}
+
+// status returns the health status of the upstream at now.
+func (h *health) status(now time.Time) (st *Status) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st = &Status{
+		LastErrorTime: h.lastErrTime,
+		Address:       h.addr,
+		LastError:     h.lastErr,
+		SuccessRate:   1,
+		Exchanges:     h.filled,
+		State:         StateHealthy,
+	}
+
+	durs := make([]time.Duration, 0, h.filled)
+	for _, s := range h.samples[:h.filled] {
+		if s.ok {
+			durs = append(durs, s.dur)
+		}
+	}
+
+	if h.filled > 0 {
+		st.SuccessRate = float64(len(durs)) / float64(h.filled)
+	}
+
+	slices.Sort(durs)
+	st.LatencyP50 = percentile(durs, 50)
+	st.LatencyP95 = percentile(durs, 95)
+
+	if now.Before(h.downUntil) {
+		st.State = StateDown
+		st.DownUntil = h.downUntil
+	} else if st.SuccessRate < degradedRate {
+		st.State = StateDegraded
+	}
+
+	return st
+}
+
+// percentile returns the p-th percentile of the sorted durs using the
+// nearest-rank method.  It returns zero if durs is empty.
+func percentile(durs []time.Duration, p int) (d time.Duration) {
+	if len(durs) == 0 {
+		return 0
+	}
+
+	// Calculate the ceiling of p*len/100 without using floats.
+	rank := (p*len(durs) + 99) / 100
+
+	return durs[max(rank, 1)-1]
+}
@@ -0,0 +1,70 @@
+package upstreamhealth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth_record(t *testing.T) {
+	c := &Config{
+		Backoff:          time.Minute,
+		MaxBackoff:       3 * time.Minute,
+		FailureThreshold: 3,
+		Window:           4,
+	}
+
+	const testErr errors.Error = "test error"
+
+	h := newHealth("test", c.Window)
+	now := time.Unix(0, 0)
+
+	// Two failures aren't enough.
+	h.record(c, now, time.Second, testErr)
+	h.record(c, now, time.Second, testErr)
+	assert.True(t, h.available(now))
+
+	h.record(c, now, time.Second, testErr)
+	assert.False(t, h.available(now))
+	assert.True(t, h.available(now.Add(time.Minute)))
+
+	// A failure right after returning into rotation doubles the backoff.
+	now = now.Add(time.Minute)
+	h.record(c, now, time.Second, testErr)
+	assert.False(t, h.available(now.Add(time.Minute)))
+	assert.True(t, h.available(now.Add(2*time.Minute)))
+
+	// The backoff doesn't exceed the maximum one.
+	now = now.Add(2 * time.Minute)
+	h.record(c, now, time.Second, testErr)
+	assert.False(t, h.available(now.Add(2*time.Minute)))
+	assert.True(t, h.available(now.Add(3*time.Minute)))
+
+	st := h.status(now)
+	assert.Equal(t, StateDown, st.State)
+	assert.Equal(t, 4, st.Exchanges)
+
+	// A success returns the upstream into rotation and resets the backoff.
+	h.record(c, now, 10*time.Millisecond, nil)
+	assert.True(t, h.available(now))
+
+	st = h.status(now)
+	assert.Equal(t, StateDegraded, st.State)
+	assert.Equal(t, 0.25, st.SuccessRate)
+	assert.Equal(t, 10*time.Millisecond, st.LatencyP50)
+	assert.Equal(t, 10*time.Millisecond, st.LatencyP95)
+}
+
+func TestPercentile(t *testing.T) {
+	durs := make([]time.Duration, 0, 20)
+	for i := range 20 {
+		durs = append(durs, time.Duration(i+1)*time.Millisecond)
+	}
+
+	assert.Equal(t, 10*time.Millisecond, percentile(durs, 50))
+	assert.Equal(t, 19*time.Millisecond, percentile(durs, 95))
+	assert.Equal(t, time.Millisecond, percentile(durs[:1], 95))
+	assert.Zero(t, percentile(nil, 50))
+}
@@ -0,0 +1,329 @@
+// Package upstreamhealth monitors the health of the upstream DNS servers and
+// takes the consistently failing ones out of rotation for a backoff period.
+package upstreamhealth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// ErrUnavailable is returned, possibly wrapped, by the monitored upstreams
+// which are out of rotation.
+const ErrUnavailable errors.Error = "upstream is temporarily out of rotation"
+
+// degradedRate is the success rate below which an upstream is considered
+// degraded.
+const degradedRate = 0.9
+
+// probeHost is the special-use fully-qualified domain name used in the
+// background probes.
+//
+// See https://datatracker.ietf.org/doc/html/rfc6761#section-6.2.
+const probeHost = "test."
+
+// State is the health state of an upstream.
+type State uint8
+
+// State values.
+const (
+	// StateHealthy means that the upstream mostly answers successfully.
+	StateHealthy State = iota
+
+	// StateDegraded means that a noticeable share of the latest exchanges
+	// with the upstream have failed.
+	StateDegraded
+
+	// StateDown means that the upstream is out of rotation.
+	StateDown
+)
+
+// String implements the [fmt.Stringer] interface for State.
+func (s State) String() (str string) {
+	switch s {
+	case StateHealthy:
+		return "healthy"
+	case StateDegraded:
+		return "degraded"
+	case StateDown:
+		return "down"
+	default:
+		return fmt.Sprintf("!bad_state_%d", s)
+	}
+}
+
+// Config is the configuration structure for Monitor.
+type Config struct {
+	// ProbeInterval is the interval between the background probes of each
+	// upstream.  If zero, the upstreams are only checked by the actual
+	// exchanges.
+	ProbeInterval time.Duration
+
+	// Backoff is the duration for which a failing upstream is taken out of
+	// rotation for the first time.  It's doubled each time the upstream fails
+	// right after returning into rotation.  It must be greater than zero.
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum duration for which a failing upstream is
+	// taken out of rotation.  It must not be less than Backoff.
+	MaxBackoff time.Duration
+
+	// FailureThreshold is the number of consecutive failed exchanges after
+	// which an upstream is taken out of rotation.  It must be greater than
+	// zero.
+	FailureThreshold int
+
+	// Window is the number of the latest exchanges used to calculate the
+	// success rate and the latencies.  It must be greater than zero.
+	Window int
+}
+
+// Status is the health status of a single upstream.
+type Status struct {
+	// LastErrorTime is the time of the latest failed exchange.  It's zero if
+	// there were none.
+	LastErrorTime time.Time
+
+	// DownUntil is the time when the upstream returns into rotation.  It's
+	// only set if State is [StateDown].
+	DownUntil time.Time
+
+	// Address is the address of the upstream.
+	Address string
+
+	// LastError is the error of the latest failed exchange.
+	LastError string
+
+	// SuccessRate is the share of the successful exchanges among the latest
+	// ones, from 0 to 1.  It's 1 if there were no exchanges.
+	SuccessRate float64
+
+	// LatencyP50 is the median latency of the latest successful exchanges.
+	LatencyP50 time.Duration
+
+	// LatencyP95 is the 95th percentile of the latency of the latest
+	// successful exchanges.
+	LatencyP95 time.Duration
+
+	// Exchanges is the number of the latest exchanges the statistics are
+	// calculated from.
+	Exchanges int
+
+	// State is the current health state of the upstream.
+	State State
+}
+
+// Monitor tracks the health of the upstreams wrapped with [Monitor.Wrap].  The
+// state of an upstream is kept by its address, so it survives the
+// reconfiguration.  It is safe for concurrent use.
+type Monitor struct {
+	// conf is the configuration of the monitor.
+	conf *Config
+
+	// mu protects the fields below.
+	mu *sync.Mutex
+
+	// upstreams are the states of the upstreams mapped by their addresses.
+	upstreams map[string]*health
+
+	// wrapped are the addresses of the upstreams wrapped since the latest
+	// call to [Monitor.Prune].
+	wrapped map[string]struct{}
+
+	// done is closed to stop the background probes.  It's nil if the probes
+	// aren't running.
+	done chan struct{}
+}
+
+// New returns a new properly initialized monitor.  c must not be nil.
+func New(c *Config) (m *Monitor) {
+	return &Monitor{
+		conf:      c,
+		mu:        &sync.Mutex{},
+		upstreams: map[string]*health{},
+		wrapped:   map[string]struct{}{},
+	}
+}
+
+// Wrap returns u wrapped so that its exchanges are tracked and so that it
+// fails immediately with [ErrUnavailable] while it's out of rotation.
+func (m *Monitor) Wrap(u upstream.Upstream) (wrapped upstream.Upstream) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	addr := u.Address()
+	h, ok := m.upstreams[addr]
+	if !ok {
+		h = newHealth(addr, m.conf.Window)
+		m.upstreams[addr] = h
+	}
+
+	h.setUpstream(u)
+	m.wrapped[addr] = struct{}{}
+
+	return &monitoredUpstream{
+		Upstream: u,
+		monitor:  m,
+		health:   h,
+	}
+}
+
+// Prune removes the states of the upstreams which haven't been wrapped since
+// the previous call to Prune.  It's intended to be called after wrapping the
+// upstreams of the new configuration.
+func (m *Monitor) Prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for addr := range m.upstreams {
+		if _, ok := m.wrapped[addr]; !ok {
+			delete(m.upstreams, addr)
+		}
+	}
+
+	m.wrapped = map[string]struct{}{}
+}
+
+// Start begins the background probes, if configured.  It does nothing if
+// they're already running.
+func (m *Monitor) Start() {
+	if m.conf.ProbeInterval <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.done != nil {
+		return
+	}
+
+	m.done = make(chan struct{})
+	go m.probeLoop(m.done)
+}
+
+// Stop stops the background probes.  It does nothing if they aren't running.
+func (m *Monitor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.done != nil {
+		close(m.done)
+		m.done = nil
+	}
+}
+
+// Status returns the health statuses of the monitored upstreams sorted by
+// their addresses.
+func (m *Monitor) Status() (statuses []*Status) {
+	now := time.Now()
+	for _, h := range m.healths() {
+		statuses = append(statuses, h.status(now))
+	}
+
+	slices.SortFunc(statuses, func(a, b *Status) (res int) {
+		return strings.Compare(a.Address, b.Address)
+	})
+
+	return statuses
+}
+
+// healths returns the states of the currently monitored upstreams.
+func (m *Monitor) healths() (hs []*health) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hs = make([]*health, 0, len(m.upstreams))
+	for _, h := range m.upstreams {
+		hs = append(hs, h)
+	}
+
+	return hs
+}
+
+// record accounts the result of an exchange with the upstream of h.
+func (m *Monitor) record(h *health, dur time.Duration, err error) {
+	h.record(m.conf, time.Now(), dur, err)
+}
+
+// probeLoop probes the upstreams until done is closed.  It's intended to be
+// used as a goroutine.
+func (m *Monitor) probeLoop(done chan struct{}) {
+	defer log.OnPanic("upstreamhealth: probing")
+
+	ticker := time.NewTicker(m.conf.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, h := range m.healths() {
+				if h.startProbe() {
+					go m.probe(h)
+				}
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// probe exchanges with the upstream of h bypassing the rotation and records
+// the result.  It's intended to be used as a goroutine.
+func (m *Monitor) probe(h *health) {
+	defer log.OnPanic("upstreamhealth: probing")
+	defer h.finishProbe()
+
+	req := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Id:               dns.Id(),
+			RecursionDesired: true,
+		},
+		Question: []dns.Question{{
+			Name:   probeHost,
+			Qtype:  dns.TypeA,
+			Qclass: dns.ClassINET,
+		}},
+	}
+
+	u := h.upstream()
+	start := time.Now()
+	_, err := u.Exchange(req)
+	m.record(h, time.Since(start), err)
+}
+
+// monitoredUpstream is an [upstream.Upstream] the exchanges of which are
+// tracked by a [Monitor].
+type monitoredUpstream struct {
+	upstream.Upstream
+
+	// monitor is the monitor tracking the upstream.
+	monitor *Monitor
+
+	// health is the state of the upstream.
+	health *health
+}
+
+// type check
+var _ upstream.Upstream = (*monitoredUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for
+// *monitoredUpstream.
+func (u *monitoredUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	start := time.Now()
+	if !u.health.available(start) {
+		return nil, fmt.Errorf("%s: %w", u.Address(), ErrUnavailable)
+	}
+
+	resp, err = u.Upstream.Exchange(req)
+	u.monitor.record(u.health, time.Since(start), err)
+
+	return resp, err
+}
@@ -0,0 +1,98 @@
+package upstreamhealth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tukimoto/AdGuardHome/internal/aghtest"
+	"github.com/tukimoto/AdGuardHome/internal/upstreamhealth"
+)
+
+func TestMain(m *testing.M) {
+	testutil.DiscardLogOutput(m)
+}
+
+// newTestUpstream returns a new upstream with the given address which fails
+// if *fail is true.
+func newTestUpstream(addr string, fail *bool) (u *aghtest.UpstreamMock) {
+	return &aghtest.UpstreamMock{
+		OnAddress: func() (a string) { return addr },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			if *fail {
+				return nil, errors.Error("test error")
+			}
+
+			return (&dns.Msg{}).SetReply(req), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	const (
+		addrGood = "1.1.1.1:53"
+		addrBad  = "2.2.2.2:53"
+	)
+
+	m := upstreamhealth.New(&upstreamhealth.Config{
+		Backoff:          time.Hour,
+		MaxBackoff:       time.Hour,
+		FailureThreshold: 2,
+		Window:           10,
+	})
+
+	good, bad := false, true
+	upsGood := m.Wrap(newTestUpstream(addrGood, &good))
+	upsBad := m.Wrap(newTestUpstream(addrBad, &bad))
+	m.Prune()
+
+	req := (&dns.Msg{}).SetQuestion("example.", dns.TypeA)
+	for range 3 {
+		_, err := upsGood.Exchange(req)
+		require.NoError(t, err)
+	}
+
+	_, err := upsBad.Exchange(req)
+	testutil.AssertErrorMsg(t, "test error", err)
+
+	_, err = upsBad.Exchange(req)
+	testutil.AssertErrorMsg(t, "test error", err)
+
+	// The upstream is out of rotation now, so it isn't even called.
+	bad = false
+	_, err = upsBad.Exchange(req)
+	assert.ErrorIs(t, err, upstreamhealth.ErrUnavailable)
+
+	statuses := m.Status()
+	require.Len(t, statuses, 2)
+
+	stGood, stBad := statuses[0], statuses[1]
+
+	assert.Equal(t, addrGood, stGood.Address)
+	assert.Equal(t, upstreamhealth.StateHealthy, stGood.State)
+	assert.Equal(t, 3, stGood.Exchanges)
+	assert.Equal(t, 1.0, stGood.SuccessRate)
+	assert.Empty(t, stGood.LastError)
+
+	assert.Equal(t, addrBad, stBad.Address)
+	assert.Equal(t, upstreamhealth.StateDown, stBad.State)
+	assert.Equal(t, 2, stBad.Exchanges)
+	assert.Zero(t, stBad.SuccessRate)
+	assert.Equal(t, "test error", stBad.LastError)
+	assert.False(t, stBad.DownUntil.IsZero())
+
+	// Only keep the good upstream after the reconfiguration.
+	_ = m.Wrap(newTestUpstream(addrGood, &good))
+	m.Prune()
+
+	statuses = m.Status()
+	require.Len(t, statuses, 1)
+
+	assert.Equal(t, addrGood, statuses[0].Address)
+	assert.Equal(t, 3, statuses[0].Exchanges)
+}